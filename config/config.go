@@ -1,17 +1,152 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/docker/go-units"
+
+	"youtube_serverless/models"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig
-	Docker   DockerConfig
-	FileOps  FileOpsConfig
-	LogLevel string
+	Server    ServerConfig
+	Docker    DockerConfig
+	FileOps   FileOpsConfig
+	Store     StoreConfig
+	Auth      AuthConfig
+	CORS      CORSConfig
+	RateLimit RateLimitConfig
+	Async     AsyncConfig
+	Batch     BatchConfig
+	Callback  CallbackConfig
+	Events    EventsConfig
+	Scheduler SchedulerConfig
+	Outbound  OutboundConfig
+	Git       GitConfig
+	Tracing   TracingConfig
+	LogLevel  string
+	LogFormat string // "console" for human-readable lines, "json" for log aggregation
+	LogOutput string // "stdout" or "stderr"
+
+	// Request and response bodies are logged only when LogBodies is set and
+	// LogLevel is debug, up to LogBodyLimit bytes each
+	LogBodies    bool
+	LogBodyLimit int
+
+	// parseErrors lists environment variables that failed to parse in strict mode
+	parseErrors []string
+}
+
+// SupportedLanguages lists the function languages the platform can build
+var SupportedLanguages = Languages{"python", "golang", "ruby"}
+
+// CustomLanguage is the language of functions built from a Dockerfile of
+// their own instead of a template
+const CustomLanguage = "custom"
+
+// Languages is a list of function languages
+type Languages []string
+
+// ErrUnsupportedRuntime is returned for runtime versions a language's
+// allowlist doesn't include
+var ErrUnsupportedRuntime = errors.New("unsupported runtime version")
+
+// RuntimeVersions maps each language to the base image versions functions may
+// request, the first of which is the default
+type RuntimeVersions map[string][]string
+
+// runtimeVersionEnv names the variable listing each language's runtime versions
+var runtimeVersionEnv = map[string]string{"python": "PYTHON_VERSIONS", "golang": "GO_VERSIONS", "ruby": "RUBY_VERSIONS"}
+
+// runtimeVersionPattern matches versions usable in a base image tag
+var runtimeVersionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Resolve returns the runtime version a function in language gets when it
+// requests version, where empty means the language's default
+func (rv RuntimeVersions) Resolve(language, version string) (string, error) {
+	versions := rv[language]
+	if len(versions) == 0 {
+		return "", fmt.Errorf("%w: no versions are configured for %s", ErrUnsupportedRuntime, language)
+	}
+	if version == "" {
+		return versions[0], nil
+	}
+	for _, allowed := range versions {
+		if allowed == version {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s %s is not one of %s", ErrUnsupportedRuntime, language, version, strings.Join(versions, ", "))
+}
+
+// ResourceProfile is the memory and CPU allocation of a language's function
+// containers, for functions that don't set their own. Zero fields use the
+// platform defaults.
+type ResourceProfile struct {
+	MemoryLimit int64   // bytes
+	CPULimit    float64 // CPUs
+}
+
+// ResourceProfiles maps languages to their resource profiles
+type ResourceProfiles map[string]ResourceProfile
+
+// defaultBlockedInputEnv lists the environment variables that change how a
+// container's programs, interpreters or dynamic linker behave, and the
+// variables the platform sets itself
+var defaultBlockedInputEnv = []string{
+	"PATH", "HOME", "USER", "SHELL", "HOSTNAME", "PWD", "TMPDIR", "IFS", "ENV", "BASH_ENV",
+	"LD_*", "DYLD_*", "PYTHON*", "RUBY*", "GEM_*", "BUNDLE_*",
+	"GODEBUG", "GOGC", "GOMAXPROCS", "GOMEMLIMIT", "GOTRACEBACK",
+	"NODE_OPTIONS", "PERL5OPT", "JAVA_TOOL_OPTIONS",
+	"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "NO_PROXY",
+	"SSL_CERT_FILE", "SSL_CERT_DIR", models.InputFileEnv,
+}
+
+// resourceProfileEnv returns the prefix of the variables setting a language's
+// resource profile, such as PROFILE_PYTHON
+func resourceProfileEnv(language string) string {
+	return "PROFILE_" + strings.ToUpper(language)
+}
+
+// containerUserEnv returns the variable overriding DOCKER_USER for a
+// language, such as DOCKER_USER_PYTHON
+func containerUserEnv(language string) string {
+	return "DOCKER_USER_" + strings.ToUpper(language)
+}
+
+// containerUserPattern matches a user or UID, optionally followed by a group
+// or GID, as docker run --user takes them
+var containerUserPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(:[A-Za-z0-9_][A-Za-z0-9_.-]*)?$`)
+
+// UserFor returns the user containers of functions in language run as
+func (c *DockerConfig) UserFor(language string) string {
+	if user, ok := c.LanguageUsers[language]; ok {
+		return user
+	}
+	return c.User
+}
+
+// Allows reports whether functions in language may be deployed. An empty
+// list allows every supported language.
+func (l Languages) Allows(language string) bool {
+	if len(l) == 0 {
+		l = SupportedLanguages
+	}
+	for _, allowed := range l {
+		if allowed == language {
+			return true
+		}
+	}
+	return false
 }
 
 // ServerConfig holds server-specific configuration
@@ -20,76 +155,719 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration // Time between failing readiness probes and closing the listener
+	MaxRequestBody  int64         // Largest request body accepted, in bytes; multipart forms may add MaxFileSize
+	IdempotencyTTL  time.Duration // How long submission responses are kept for Idempotency-Key replays; 0 disables
+	AllowBulkDelete bool          // Enable DELETE /api/functions?all=true
+	GatewayHeaders  []string      // Request headers passed to functions invoked through /fn/
+
+	MinRequestTimeout time.Duration // Shortest timeout a client may ask for with X-Timeout-Seconds
+
+	IdleTimeout    time.Duration // How long a keep-alive connection may wait for its next request
+	MaxHeaderBytes int           // Largest request header block accepted, in bytes
+
+	// HTTP/2 is negotiated over TLS when a certificate is set; H2C also serves
+	// it in cleartext, for clients and proxies that speak it without TLS
+	TLSCertFile          string
+	TLSKeyFile           string
+	H2C                  bool
+	MaxConcurrentStreams int // Streams each HTTP/2 connection may have open at once
 }
 
 // DockerConfig holds Docker-specific configuration
 type DockerConfig struct {
-	ImagePrefix    string
-	ContainerLimit int
-	RunTimeout     time.Duration
-	BuildTimeout   time.Duration
+	Host            string // Docker daemon address; empty uses the environment defaults
+	ImagePrefix     string
+	ContainerLimit  int
+	LimitPolicy     string // "block" waits for a free slot, "reject" fails immediately
+	RunTimeout      time.Duration
+	StopGracePeriod time.Duration // Time a timed-out container gets to exit after SIGTERM before it is killed
+	BuildTimeout    time.Duration
+	BuildLogLimit   int    // Maximum build log length returned to clients, in bytes
+	TemplatesDir    string // Templates here override the embedded defaults; none are read when empty
+	BuildRetries    int    // Retries of builds that fail transiently, within BuildTimeout
+	DefaultIgnores  bool   // Leave .git, __pycache__ and *.pyc out of build contexts
+	BuildBackoff    time.Duration
+	MaxOutputSize   int // Stdout and stderr kept from each execution, in bytes each
+	WarmPoolSize    int // Warm containers kept per image; 0 disables warm starts
+	WarmPoolTTL     time.Duration
+	MaxMemory       int64     // Highest memory limit a function may request, in bytes
+	MaxCPUs         float64   // Highest CPU limit a function may request
+	Languages       Languages // Languages that may be built; all supported languages when empty
+	RuntimeVersions RuntimeVersions
+
+	// Build code that includes a Dockerfile from it instead of a template
+	CustomDockerfiles bool
+
+	// How often images no function uses are removed; 0 disables the cleanup
+	ImageCleanupInterval time.Duration
+
+	// Per-language container resources for functions that set none
+	ResourceProfiles ResourceProfiles
+
+	MaxConcurrentPerImage int // Executions of one image running at once; 0 is unlimited
+	ImageQueueTimeout     time.Duration
+
+	// Host pressure above which executions are delayed or rejected
+	MaxLoadPerCPU      float64       // One-minute load average per CPU; 0 disables the check
+	MinAvailableMemory int64         // Memory that must stay available, in bytes; 0 disables the check
+	PressurePolicy     string        // "delay" rechecks with backoff until PressureTimeout, "reject" fails immediately
+	PressureBackoff    time.Duration // Delay before the first recheck, doubled for each one after
+	PressureTimeout    time.Duration
+
+	// Isolation settings for function containers
+	Network        string   // Default network mode; functions may override it with none or bridge
+	DNS            []string // DNS servers; ignored when networking is disabled
+	DropAllCaps    bool     // Drop every Linux capability
+	ReadOnlyRootfs bool     // Mount the root filesystem read-only, with a writable /tmp
+
+	// User function containers run as, such as 1000:1000; empty keeps the
+	// user the image sets
+	User          string
+	LanguageUsers map[string]string // Per-language overrides of User
+
+	// Environment variables execution input may not set; a trailing * matches any suffix
+	BlockedInputEnv []string
+	InputEnvPolicy  string // "reject" fails executions whose input sets a blocked variable, "drop" leaves it out
+
+	// Limits on input delivered as environment variables, which the kernel
+	// caps in total and per variable; 0 means no limit
+	MaxInputKeys        int
+	MaxInputKeyLength   int // bytes, after sanitizing
+	MaxInputValueLength int // bytes, after encoding
+	MaxInputBytes       int // all keys and values together
+
+	Builder string // "classic" or "buildkit"
+
+	// Credentials for pulling base images from a private registry
+	RegistryURL  string
+	RegistryUser string
+	RegistryPass string // Never logged
 }
 
 // FileOpsConfig holds file operation configuration
 type FileOpsConfig struct {
-	MaxFileSize int64
-	TempDirBase string
+	MaxFileSize       int64
+	MaxExtractedSize  int64 // Total uncompressed size an archive may extract to, in bytes
+	MaxArchiveEntries int   // Files and directories an archive may contain
+	TempDirBase       string
+	ExtractDirMode    os.FileMode   // Most permissive mode of extracted directories and executable files
+	ExtractFileMode   os.FileMode   // Most permissive mode of other extracted files
+	TempSweepInterval time.Duration // How often orphaned temp directories are removed; 0 disables the sweeper
+	TempMaxAge        time.Duration // How long a temp directory may go unmodified before it counts as orphaned
+	Languages         Languages     // Languages that may be detected; all supported languages when empty
+	CustomDockerfiles bool          // Detect code that includes a Dockerfile as CustomLanguage
+	RetainSource      bool          // Keep a copy of each deployed function's code for GET /api/functions/{id}/source
+	SourceDir         string        // Directory holding retained code, one subdirectory per function
+}
+
+// StoreConfig holds function metadata store configuration
+type StoreConfig struct {
+	Backend          string // "memory", "sqlite" or "bolt"
+	Path             string
+	ExecutionHistory int  // Executions retained per function; 0 disables history
+	ExecutionLogs    int  // Executions whose full logs are retained per function; 0 disables log retention
+	ExecutionLogSize int  // Stdout and stderr retained per execution, in bytes each
+	UniqueNames      bool // Reject functions whose name another function already has
+	CacheSize        int  // Function lookups cached in front of the backend; 0 disables the cache
+
+	MaxFunctions int    // Functions that may be deployed at once; 0 means no limit
+	QuotaPolicy  string // "reject" fails submissions over the limit, "evict" deletes the least recently executed function
+
+	Retries          int           // Times a store operation that fails transiently is retried; sqlite and bolt only
+	RetryBackoff     time.Duration // Delay before the first retry, doubled for each one after
+	BreakerThreshold int           // Consecutive failed operations that open the circuit breaker; 0 disables it
+	BreakerCooldown  time.Duration // How long the breaker stays open before the store is tried again
+}
+
+// AuthConfig holds API authentication configuration
+type AuthConfig struct {
+	APIKeys []string // Authentication is disabled when empty
+}
+
+// CORSConfig holds cross-origin request configuration
+type CORSConfig struct {
+	AllowedOrigins []string // CORS is disabled when empty; "*" allows any origin
 }
 
-// LoadConfig loads configuration from environment variables with defaults
+// RateLimitConfig holds per-client request rate limiting configuration
+type RateLimitConfig struct {
+	RPS   float64 // Sustained requests per second; rate limiting is disabled when zero
+	Burst int
+}
+
+// AsyncConfig holds asynchronous execution configuration
+type AsyncConfig struct {
+	Workers   int
+	QueueSize int
+}
+
+// BatchConfig holds batch execution configuration
+type BatchConfig struct {
+	Concurrency int // Executions of one batch running at once, capped by the container limit
+	MaxSize     int // Inputs a batch may contain
+}
+
+// CallbackConfig holds configuration for webhook callbacks sent when
+// asynchronous executions complete
+type CallbackConfig struct {
+	Secret       string        // Key callbacks are signed with; callbacks are disabled when empty
+	MaxRetries   int           // Deliveries retried after the first attempt fails
+	RetryBackoff time.Duration // Delay before the first retry, doubled for each one after
+	Timeout      time.Duration // Bound on each delivery attempt
+	AllowPrivate bool          // Allow callbacks to loopback, private and link-local addresses
+}
+
+// EventsConfig holds configuration for the notifications sent on platform
+// events, such as deployments and executions
+type EventsConfig struct {
+	Sink         string        // "none" or "webhook"
+	URL          string        // Where the webhook sink POSTs events
+	Secret       string        // Key events are signed with; unsigned when empty
+	MaxRetries   int           // Deliveries retried after the first attempt fails
+	RetryBackoff time.Duration // Delay before the first retry, doubled for each one after
+	Timeout      time.Duration // Bound on each delivery attempt
+	QueueSize    int           // Events waiting for delivery; further ones are dropped
+	AllowPrivate bool          // Allow events to loopback, private and link-local addresses
+}
+
+// SchedulerConfig holds configuration for cron-triggered executions
+type SchedulerConfig struct {
+	MaxRetries     int // Retries a schedule may ask for after a failed run
+	FailureHistory int // Failed scheduled runs retained per function; 0 disables the record
+}
+
+// OutboundConfig holds configuration for requests the platform makes to
+// addresses given by clients, such as callbacks
+type OutboundConfig struct {
+	// Networks outbound requests may reach even though they are loopback,
+	// private or link-local, each a CIDR or a single IP address
+	AllowedNetworks []string
+}
+
+// GitConfig holds configuration for deploying functions from Git repositories
+type GitConfig struct {
+	Enabled      bool
+	CloneTimeout time.Duration // Bound on fetching a repository
+	MaxSize      int64         // Bytes a clone may download
+	Token        string        // Token used for repositories on TokenHosts when a request gives none
+	TokenHosts   []string      // Hosts Token may be sent to
+}
+
+// TracingConfig holds OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Endpoint    string // OTLP/HTTP collector address; tracing is disabled when empty
+	ServiceName string
+}
+
+// LoadConfig loads configuration from environment variables with defaults.
+// Values that fail to parse silently fall back to their defaults.
 func LoadConfig() *Config {
-	return &Config{
+	return load(&envReader{})
+}
+
+// LoadConfigStrict loads configuration like LoadConfig, but records values
+// that are set but fail to parse so that Validate reports them
+func LoadConfigStrict() *Config {
+	return load(&envReader{strict: true})
+}
+
+func load(env *envReader) *Config {
+	// Detection and builds both enforce the language allowlist
+	languages := Languages(env.getListEnv("ALLOWED_LANGUAGES"))
+	customDockerfiles := env.getBoolEnv("ALLOW_CUSTOM_DOCKERFILE", false)
+
+	cfg := &Config{
 		Server: ServerConfig{
-			Port:            getEnv("SERVER_PORT", "8080"),
-			ReadTimeout:     getDurationEnv("SERVER_READ_TIMEOUT", 10*time.Second),
-			WriteTimeout:    getDurationEnv("SERVER_WRITE_TIMEOUT", 10*time.Second),
-			ShutdownTimeout: getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 5*time.Second),
+			Port:            env.getEnv("SERVER_PORT", "8080"),
+			ReadTimeout:     env.getDurationEnv("SERVER_READ_TIMEOUT", 10*time.Second),
+			WriteTimeout:    env.getDurationEnv("SERVER_WRITE_TIMEOUT", 10*time.Second),
+			ShutdownTimeout: env.getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 5*time.Second),
+			ShutdownDelay:   env.getDurationEnv("SERVER_SHUTDOWN_DELAY", 0),
+			MaxRequestBody:  env.getInt64Env("MAX_REQUEST_BODY", 1<<20), // 1 MB
+			IdempotencyTTL:  env.getDurationEnv("IDEMPOTENCY_TTL", 10*time.Minute),
+			AllowBulkDelete: env.getBoolEnv("ALLOW_BULK_DELETE", false),
+			GatewayHeaders:  env.getListEnvDefault("GATEWAY_FORWARD_HEADERS", []string{"Accept", "Content-Type", "User-Agent"}),
+
+			MinRequestTimeout: env.getDurationEnv("MIN_REQUEST_TIMEOUT", time.Second),
+
+			IdleTimeout:    env.getDurationEnv("SERVER_IDLE_TIMEOUT", 120*time.Second),
+			MaxHeaderBytes: env.getIntEnv("SERVER_MAX_HEADER_BYTES", 1<<20), // 1 MB
+
+			TLSCertFile:          env.getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:           env.getEnv("TLS_KEY_FILE", ""),
+			H2C:                  env.getBoolEnv("HTTP2_CLEARTEXT", false),
+			MaxConcurrentStreams: env.getIntEnv("HTTP2_MAX_CONCURRENT_STREAMS", 250),
 		},
 		Docker: DockerConfig{
-			ImagePrefix:    getEnv("DOCKER_IMAGE_PREFIX", "youtube-serverless"),
-			ContainerLimit: getIntEnv("DOCKER_CONTAINER_LIMIT", 100),
-			RunTimeout:     getDurationEnv("DOCKER_RUN_TIMEOUT", 30*time.Second),
-			BuildTimeout:   getDurationEnv("DOCKER_BUILD_TIMEOUT", 120*time.Second),
+			Host:            env.getEnv("DOCKER_HOST", ""),
+			ImagePrefix:     env.getEnv("DOCKER_IMAGE_PREFIX", "youtube-serverless"),
+			ContainerLimit:  env.getIntEnv("DOCKER_CONTAINER_LIMIT", 100),
+			LimitPolicy:     env.getEnv("DOCKER_LIMIT_POLICY", "block"),
+			RunTimeout:      env.getDurationEnv("DOCKER_RUN_TIMEOUT", 30*time.Second),
+			StopGracePeriod: env.getDurationEnv("DOCKER_STOP_GRACE_PERIOD", 5*time.Second),
+			BuildTimeout:    env.getDurationEnv("DOCKER_BUILD_TIMEOUT", 120*time.Second),
+			BuildLogLimit:   env.getIntEnv("DOCKER_BUILD_LOG_LIMIT", 64<<10), // 64 KB
+			TemplatesDir:    env.getEnv("TEMPLATES_DIR", "templates"),
+			BuildRetries:    env.getIntEnv("BUILD_RETRIES", 0),
+			DefaultIgnores:  env.getBoolEnv("BUILD_DEFAULT_IGNORES", true),
+			BuildBackoff:    env.getDurationEnv("BUILD_RETRY_BACKOFF", 2*time.Second),
+			MaxOutputSize:   env.getIntEnv("MAX_OUTPUT_SIZE", 1<<20), // 1 MB
+			WarmPoolSize:    env.getIntEnv("WARM_POOL_SIZE", 0),
+			WarmPoolTTL:     env.getDurationEnv("WARM_POOL_TTL", 5*time.Minute),
+			MaxMemory:       env.getInt64Env("MAX_MEMORY", 1<<30), // 1 GB
+			MaxCPUs:         env.getFloatEnv("MAX_CPUS", 2),
+			Languages:       languages,
+			RuntimeVersions: RuntimeVersions{
+				"python": env.getListEnvDefault(runtimeVersionEnv["python"], []string{"3.9", "3.10", "3.11", "3.12"}),
+				"golang": env.getListEnvDefault(runtimeVersionEnv["golang"], []string{"1.23", "1.22"}),
+				"ruby":   env.getListEnvDefault(runtimeVersionEnv["ruby"], []string{"3.3", "3.2"}),
+			},
+
+			CustomDockerfiles: customDockerfiles,
+
+			ImageCleanupInterval: env.getDurationEnv("IMAGE_CLEANUP_INTERVAL", 0),
+
+			ResourceProfiles: env.getResourceProfiles(append(Languages{CustomLanguage}, SupportedLanguages...)),
+
+			MaxConcurrentPerImage: env.getIntEnv("MAX_CONCURRENT_PER_IMAGE", 0),
+			ImageQueueTimeout:     env.getDurationEnv("IMAGE_QUEUE_TIMEOUT", 30*time.Second),
+
+			MaxLoadPerCPU:      env.getFloatEnv("HOST_MAX_LOAD", 0),
+			MinAvailableMemory: env.getMemoryEnv("HOST_MIN_AVAILABLE_MEMORY", 0),
+			PressurePolicy:     env.getEnv("HOST_PRESSURE_POLICY", "delay"),
+			PressureBackoff:    env.getDurationEnv("HOST_PRESSURE_BACKOFF", 250*time.Millisecond),
+			PressureTimeout:    env.getDurationEnv("HOST_PRESSURE_TIMEOUT", 10*time.Second),
+
+			Network:        env.getEnv("DOCKER_NETWORK", models.NetworkModeBridge),
+			DNS:            env.getListEnvDefault("DOCKER_DNS", []string{"8.8.8.8"}),
+			DropAllCaps:    env.getBoolEnv("DOCKER_DROP_ALL_CAPS", true),
+			ReadOnlyRootfs: env.getBoolEnv("DOCKER_READONLY_ROOTFS", false),
+
+			User:          env.getEnv("DOCKER_USER", "1000:1000"),
+			LanguageUsers: env.getLanguageUsers(append(Languages{CustomLanguage}, SupportedLanguages...)),
+
+			BlockedInputEnv: env.getListEnvDefault("BLOCKED_INPUT_ENV", defaultBlockedInputEnv),
+			InputEnvPolicy:  env.getEnv("INPUT_ENV_POLICY", "reject"),
+
+			MaxInputKeys:        env.getIntEnv("INPUT_MAX_KEYS", 256),
+			MaxInputKeyLength:   env.getIntEnv("INPUT_MAX_KEY_LENGTH", 128),
+			MaxInputValueLength: env.getIntEnv("INPUT_MAX_VALUE_LENGTH", 32<<10), // 32 KB
+			MaxInputBytes:       env.getIntEnv("INPUT_MAX_BYTES", 256<<10),       // 256 KB
+
+			Builder: env.getEnv("DOCKER_BUILDER", "classic"),
+
+			RegistryURL:  env.getEnv("REGISTRY_URL", ""),
+			RegistryUser: env.getEnv("REGISTRY_USER", ""),
+			RegistryPass: env.getEnv("REGISTRY_PASS", ""),
 		},
 		FileOps: FileOpsConfig{
-			MaxFileSize: getInt64Env("MAX_FILE_SIZE", 10<<20), // 10 MB
-			TempDirBase: getEnv("TEMP_DIR_BASE", ""),          // Empty means use system default
+			MaxFileSize:       env.getInt64Env("MAX_FILE_SIZE", 10<<20),       // 10 MB
+			MaxExtractedSize:  env.getInt64Env("MAX_EXTRACTED_SIZE", 100<<20), // 100 MB
+			MaxArchiveEntries: env.getIntEnv("MAX_ARCHIVE_ENTRIES", 10000),
+			TempDirBase:       env.getEnv("TEMP_DIR_BASE", ""), // Empty means use system default
+			ExtractDirMode:    env.getModeEnv("EXTRACT_DIR_MODE", 0755),
+			ExtractFileMode:   env.getModeEnv("EXTRACT_FILE_MODE", 0644),
+			TempSweepInterval: env.getDurationEnv("TEMP_SWEEP_INTERVAL", 10*time.Minute),
+			TempMaxAge:        env.getDurationEnv("TEMP_MAX_AGE", time.Hour),
+			Languages:         languages,
+			CustomDockerfiles: customDockerfiles,
+			RetainSource:      env.getBoolEnv("RETAIN_SOURCE", true),
+			SourceDir:         env.getEnv("SOURCE_DIR", "sources"),
+		},
+		Store: StoreConfig{
+			Backend:          env.getEnv("STORE_BACKEND", "memory"),
+			Path:             env.getEnv("STORE_PATH", "serverless.db"),
+			ExecutionHistory: env.getIntEnv("EXECUTION_HISTORY_SIZE", 100),
+			ExecutionLogs:    env.getIntEnv("EXECUTION_LOGS_SIZE", 10),
+			ExecutionLogSize: env.getIntEnv("EXECUTION_LOG_MAX_SIZE", 64<<10), // 64 KB
+			UniqueNames:      env.getBoolEnv("ENFORCE_UNIQUE_NAMES", false),
+			CacheSize:        env.getIntEnv("STORE_CACHE_SIZE", 0),
+
+			MaxFunctions: env.getIntEnv("MAX_FUNCTIONS", 0),
+			QuotaPolicy:  env.getEnv("QUOTA_POLICY", "reject"),
+
+			Retries:          env.getIntEnv("STORE_RETRIES", 2),
+			RetryBackoff:     env.getDurationEnv("STORE_RETRY_BACKOFF", 50*time.Millisecond),
+			BreakerThreshold: env.getIntEnv("STORE_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:  env.getDurationEnv("STORE_BREAKER_COOLDOWN", 30*time.Second),
+		},
+		Auth: AuthConfig{
+			APIKeys: env.getListEnv("API_KEYS"),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: env.getListEnv("CORS_ALLOWED_ORIGINS"),
+		},
+		RateLimit: RateLimitConfig{
+			RPS:   env.getFloatEnv("RATE_LIMIT_RPS", 0),
+			Burst: env.getIntEnv("RATE_LIMIT_BURST", 20),
+		},
+		Async: AsyncConfig{
+			Workers:   env.getIntEnv("ASYNC_WORKERS", 4),
+			QueueSize: env.getIntEnv("ASYNC_QUEUE_SIZE", 100),
+		},
+		Batch: BatchConfig{
+			Concurrency: env.getIntEnv("BATCH_CONCURRENCY", 4),
+			MaxSize:     env.getIntEnv("MAX_BATCH_SIZE", 100),
 		},
-		LogLevel: getEnv("LOG_LEVEL", "info"),
+		Callback: CallbackConfig{
+			Secret:       env.getEnv("CALLBACK_SECRET", ""),
+			MaxRetries:   env.getIntEnv("CALLBACK_MAX_RETRIES", 3),
+			RetryBackoff: env.getDurationEnv("CALLBACK_RETRY_BACKOFF", time.Second),
+			Timeout:      env.getDurationEnv("CALLBACK_TIMEOUT", 10*time.Second),
+			AllowPrivate: env.getBoolEnv("CALLBACK_ALLOW_PRIVATE", false),
+		},
+		Events: EventsConfig{
+			Sink:         env.getEnv("EVENTS_SINK", "none"),
+			URL:          env.getEnv("EVENTS_WEBHOOK_URL", ""),
+			Secret:       env.getEnv("EVENTS_WEBHOOK_SECRET", ""),
+			MaxRetries:   env.getIntEnv("EVENTS_MAX_RETRIES", 3),
+			RetryBackoff: env.getDurationEnv("EVENTS_RETRY_BACKOFF", time.Second),
+			Timeout:      env.getDurationEnv("EVENTS_TIMEOUT", 10*time.Second),
+			QueueSize:    env.getIntEnv("EVENTS_QUEUE_SIZE", 1000),
+			AllowPrivate: env.getBoolEnv("EVENTS_ALLOW_PRIVATE", false),
+		},
+		Scheduler: SchedulerConfig{
+			MaxRetries:     env.getIntEnv("SCHEDULE_MAX_RETRIES", 5),
+			FailureHistory: env.getIntEnv("SCHEDULE_FAILURE_HISTORY", 20),
+		},
+		Outbound: OutboundConfig{
+			AllowedNetworks: env.getListEnv("OUTBOUND_ALLOWED_NETWORKS"),
+		},
+		Git: GitConfig{
+			Enabled:      env.getBoolEnv("GIT_DEPLOY_ENABLED", true),
+			CloneTimeout: env.getDurationEnv("GIT_CLONE_TIMEOUT", time.Minute),
+			MaxSize:      env.getInt64Env("GIT_MAX_CLONE_SIZE", 50<<20), // 50 MB
+			Token:        env.getEnv("GIT_TOKEN", ""),
+			TokenHosts:   env.getListEnvDefault("GIT_TOKEN_HOSTS", []string{"github.com"}),
+		},
+		Tracing: TracingConfig{
+			Endpoint:    env.getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+			ServiceName: env.getEnv("OTEL_SERVICE_NAME", "youtube-serverless"),
+		},
+		LogLevel:     env.getEnv("LOG_LEVEL", "info"),
+		LogFormat:    env.getEnv("LOG_FORMAT", "console"),
+		LogOutput:    env.getEnv("LOG_OUTPUT", "stdout"),
+		LogBodies:    env.getBoolEnv("LOG_BODIES", false),
+		LogBodyLimit: env.getIntEnv("LOG_BODY_LIMIT", 4<<10), // 4 KB
+	}
+	cfg.parseErrors = env.errs
+	return cfg
+}
+
+// Validate checks the configuration for invalid values and returns a single
+// error describing every problem found, or nil if there are none
+func (c *Config) Validate() error {
+	problems := append([]string(nil), c.parseErrors...)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port >= 1 && port <= 65535, "SERVER_PORT must be a number between 1 and 65535, got %q", c.Server.Port)
+	check(c.Server.ReadTimeout > 0, "SERVER_READ_TIMEOUT must be positive, got %s", c.Server.ReadTimeout)
+	check(c.Server.WriteTimeout > 0, "SERVER_WRITE_TIMEOUT must be positive, got %s", c.Server.WriteTimeout)
+	check(c.Server.MinRequestTimeout > 0 && c.Server.MinRequestTimeout <= c.Server.WriteTimeout,
+		"MIN_REQUEST_TIMEOUT must be positive and at most SERVER_WRITE_TIMEOUT, got %s", c.Server.MinRequestTimeout)
+	check(c.Server.ShutdownTimeout > 0, "SERVER_SHUTDOWN_TIMEOUT must be positive, got %s", c.Server.ShutdownTimeout)
+	check(c.Server.ShutdownDelay >= 0, "SERVER_SHUTDOWN_DELAY must not be negative, got %s", c.Server.ShutdownDelay)
+	check(c.Server.MaxRequestBody > 0, "MAX_REQUEST_BODY must be positive, got %d", c.Server.MaxRequestBody)
+	check(c.Server.IdempotencyTTL >= 0, "IDEMPOTENCY_TTL must not be negative, got %s", c.Server.IdempotencyTTL)
+	check(c.Server.IdleTimeout > 0, "SERVER_IDLE_TIMEOUT must be positive, got %s", c.Server.IdleTimeout)
+	check(c.Server.MaxHeaderBytes >= 4<<10, "SERVER_MAX_HEADER_BYTES must be at least 4096, got %d", c.Server.MaxHeaderBytes)
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(!c.Server.H2C || c.Server.TLSCertFile == "", "HTTP2_CLEARTEXT can't be combined with TLS_CERT_FILE; TLS connections negotiate HTTP/2 themselves")
+	check(c.Server.MaxConcurrentStreams >= 1, "HTTP2_MAX_CONCURRENT_STREAMS must be at least 1, got %d", c.Server.MaxConcurrentStreams)
+
+	check(c.Docker.ImagePrefix != "", "DOCKER_IMAGE_PREFIX must not be empty")
+	check(c.Docker.ContainerLimit >= 1, "DOCKER_CONTAINER_LIMIT must be at least 1, got %d", c.Docker.ContainerLimit)
+	check(c.Docker.LimitPolicy == "block" || c.Docker.LimitPolicy == "reject", "DOCKER_LIMIT_POLICY must be block or reject, got %q", c.Docker.LimitPolicy)
+	check(c.Docker.InputEnvPolicy == "reject" || c.Docker.InputEnvPolicy == "drop", "INPUT_ENV_POLICY must be reject or drop, got %q", c.Docker.InputEnvPolicy)
+	check(c.Docker.MaxInputKeys >= 0, "INPUT_MAX_KEYS must not be negative, got %d", c.Docker.MaxInputKeys)
+	check(c.Docker.MaxInputKeyLength >= 0, "INPUT_MAX_KEY_LENGTH must not be negative, got %d", c.Docker.MaxInputKeyLength)
+	check(c.Docker.MaxInputValueLength >= 0, "INPUT_MAX_VALUE_LENGTH must not be negative, got %d", c.Docker.MaxInputValueLength)
+	check(c.Docker.MaxInputBytes >= 0, "INPUT_MAX_BYTES must not be negative, got %d", c.Docker.MaxInputBytes)
+	check(c.Docker.RunTimeout > 0, "DOCKER_RUN_TIMEOUT must be positive, got %s", c.Docker.RunTimeout)
+	check(c.Docker.StopGracePeriod >= 0, "DOCKER_STOP_GRACE_PERIOD must not be negative, got %s", c.Docker.StopGracePeriod)
+	check(c.Docker.BuildTimeout > 0, "DOCKER_BUILD_TIMEOUT must be positive, got %s", c.Docker.BuildTimeout)
+	check(c.Docker.BuildLogLimit >= 0, "DOCKER_BUILD_LOG_LIMIT must not be negative, got %d", c.Docker.BuildLogLimit)
+	check(c.Docker.BuildRetries >= 0, "BUILD_RETRIES must not be negative, got %d", c.Docker.BuildRetries)
+	check(c.Docker.BuildBackoff > 0, "BUILD_RETRY_BACKOFF must be positive, got %s", c.Docker.BuildBackoff)
+	check(c.Docker.ImageCleanupInterval >= 0, "IMAGE_CLEANUP_INTERVAL must not be negative, got %s", c.Docker.ImageCleanupInterval)
+	check(c.Docker.MaxOutputSize > 0, "MAX_OUTPUT_SIZE must be positive, got %d", c.Docker.MaxOutputSize)
+	check(c.Docker.WarmPoolSize >= 0, "WARM_POOL_SIZE must not be negative, got %d", c.Docker.WarmPoolSize)
+	check(c.Docker.WarmPoolTTL > 0, "WARM_POOL_TTL must be positive, got %s", c.Docker.WarmPoolTTL)
+	check(c.Docker.MaxMemory > 0, "MAX_MEMORY must be positive, got %d", c.Docker.MaxMemory)
+	check(c.Docker.MaxCPUs > 0, "MAX_CPUS must be positive, got %g", c.Docker.MaxCPUs)
+	check(c.Docker.MaxConcurrentPerImage >= 0, "MAX_CONCURRENT_PER_IMAGE must not be negative, got %d", c.Docker.MaxConcurrentPerImage)
+	check(c.Docker.ImageQueueTimeout > 0, "IMAGE_QUEUE_TIMEOUT must be positive, got %s", c.Docker.ImageQueueTimeout)
+	check(c.Docker.MaxLoadPerCPU >= 0, "HOST_MAX_LOAD must not be negative, got %g", c.Docker.MaxLoadPerCPU)
+	check(c.Docker.MinAvailableMemory >= 0, "HOST_MIN_AVAILABLE_MEMORY must not be negative, got %d bytes", c.Docker.MinAvailableMemory)
+	check(c.Docker.PressurePolicy == "delay" || c.Docker.PressurePolicy == "reject", "HOST_PRESSURE_POLICY must be delay or reject, got %q", c.Docker.PressurePolicy)
+	check(c.Docker.PressureBackoff > 0, "HOST_PRESSURE_BACKOFF must be positive, got %s", c.Docker.PressureBackoff)
+	check(c.Docker.PressureTimeout > 0, "HOST_PRESSURE_TIMEOUT must be positive, got %s", c.Docker.PressureTimeout)
+	check(models.ValidNetworkMode(c.Docker.Network), "DOCKER_NETWORK must be none or bridge, got %q", c.Docker.Network)
+	for _, server := range c.Docker.DNS {
+		check(net.ParseIP(server) != nil, "DOCKER_DNS must be a comma-separated list of IP addresses, got %q", server)
+	}
+	registry := c.Docker.RegistryURL != "" || c.Docker.RegistryUser != "" || c.Docker.RegistryPass != ""
+	check(!registry || (c.Docker.RegistryURL != "" && c.Docker.RegistryUser != "" && c.Docker.RegistryPass != ""),
+		"REGISTRY_URL, REGISTRY_USER and REGISTRY_PASS must be set together")
+	check(c.Docker.Builder == "classic" || c.Docker.Builder == "buildkit", "DOCKER_BUILDER must be classic or buildkit, got %q", c.Docker.Builder)
+	// BuildKit takes registry credentials from a client session, which builds
+	// through the API don't have
+	check(c.Docker.Builder != "buildkit" || !registry, "DOCKER_BUILDER=buildkit can't pull base images with REGISTRY_URL credentials; use the classic builder")
+
+	check(c.FileOps.MaxFileSize > 0, "MAX_FILE_SIZE must be positive, got %d", c.FileOps.MaxFileSize)
+	check(c.FileOps.MaxExtractedSize > 0, "MAX_EXTRACTED_SIZE must be positive, got %d", c.FileOps.MaxExtractedSize)
+	check(c.FileOps.MaxArchiveEntries > 0, "MAX_ARCHIVE_ENTRIES must be positive, got %d", c.FileOps.MaxArchiveEntries)
+	check(!c.FileOps.RetainSource || c.FileOps.SourceDir != "", "SOURCE_DIR must be set when RETAIN_SOURCE is enabled")
+	// The server must still be able to read extracted code and remove it
+	check(c.FileOps.ExtractDirMode&^0777 == 0 && c.FileOps.ExtractDirMode&0700 == 0700,
+		"EXTRACT_DIR_MODE must be permission bits that give the owner full access, such as 0755, got %#o", c.FileOps.ExtractDirMode)
+	check(c.FileOps.ExtractFileMode&^0777 == 0 && c.FileOps.ExtractFileMode&0400 == 0400,
+		"EXTRACT_FILE_MODE must be permission bits that let the owner read, such as 0644, got %#o", c.FileOps.ExtractFileMode)
+	check(c.FileOps.TempSweepInterval >= 0, "TEMP_SWEEP_INTERVAL must not be negative, got %s", c.FileOps.TempSweepInterval)
+	// Directories of requests still building or running must never look orphaned
+	check(c.FileOps.TempMaxAge > c.Docker.BuildTimeout && c.FileOps.TempMaxAge > c.Docker.RunTimeout,
+		"TEMP_MAX_AGE must be longer than DOCKER_BUILD_TIMEOUT and DOCKER_RUN_TIMEOUT, got %s", c.FileOps.TempMaxAge)
+	for _, language := range c.FileOps.Languages {
+		check(SupportedLanguages.Allows(language), "ALLOWED_LANGUAGES must only list supported languages (%s), got %q",
+			strings.Join(SupportedLanguages, ", "), language)
+	}
+	for _, language := range SupportedLanguages {
+		// Versions become image tags, so keep them to characters tags allow
+		name := runtimeVersionEnv[language]
+		check(len(c.Docker.RuntimeVersions[language]) > 0, "%s must list at least one version", name)
+		for _, version := range c.Docker.RuntimeVersions[language] {
+			check(runtimeVersionPattern.MatchString(version), "%s must only list image tags such as 3.12, got %q", name, version)
+		}
+	}
+
+	for _, language := range append(Languages{CustomLanguage}, SupportedLanguages...) {
+		profile := c.Docker.ResourceProfiles[language]
+		name := resourceProfileEnv(language)
+		// Docker rejects containers with less than 6 MiB of memory
+		check(profile.MemoryLimit == 0 || (profile.MemoryLimit >= 6<<20 && profile.MemoryLimit <= c.Docker.MaxMemory),
+			"%s_MEMORY must be at least 6m and not exceed MAX_MEMORY, got %d bytes", name, profile.MemoryLimit)
+		check(profile.CPULimit >= 0 && profile.CPULimit <= c.Docker.MaxCPUs,
+			"%s_CPUS must not be negative or exceed MAX_CPUS, got %g", name, profile.CPULimit)
+	}
+
+	check(c.Docker.User == "" || containerUserPattern.MatchString(c.Docker.User),
+		"DOCKER_USER must be a user or UID with an optional group or GID, such as 1000:1000, got %q", c.Docker.User)
+	for language, user := range c.Docker.LanguageUsers {
+		check(user == "" || containerUserPattern.MatchString(user),
+			"%s must be a user or UID with an optional group or GID, such as 1000:1000, got %q", containerUserEnv(language), user)
+	}
+
+	check(c.Store.Backend == "memory" || c.Store.Backend == "sqlite" || c.Store.Backend == "bolt", "STORE_BACKEND must be memory, sqlite or bolt, got %q", c.Store.Backend)
+	check(c.Store.Backend == "memory" || c.Store.Path != "", "STORE_PATH must be set for the %s backend", c.Store.Backend)
+	check(c.Store.ExecutionHistory >= 0, "EXECUTION_HISTORY_SIZE must not be negative, got %d", c.Store.ExecutionHistory)
+	check(c.Store.ExecutionLogs >= 0, "EXECUTION_LOGS_SIZE must not be negative, got %d", c.Store.ExecutionLogs)
+	check(c.Store.ExecutionLogSize > 0, "EXECUTION_LOG_MAX_SIZE must be positive, got %d", c.Store.ExecutionLogSize)
+	check(c.Store.CacheSize >= 0, "STORE_CACHE_SIZE must not be negative, got %d", c.Store.CacheSize)
+	check(c.Store.MaxFunctions >= 0, "MAX_FUNCTIONS must not be negative, got %d", c.Store.MaxFunctions)
+	check(c.Store.QuotaPolicy == "reject" || c.Store.QuotaPolicy == "evict", "QUOTA_POLICY must be reject or evict, got %q", c.Store.QuotaPolicy)
+	check(c.Store.Retries >= 0, "STORE_RETRIES must not be negative, got %d", c.Store.Retries)
+	check(c.Store.RetryBackoff > 0, "STORE_RETRY_BACKOFF must be positive, got %s", c.Store.RetryBackoff)
+	check(c.Store.BreakerThreshold >= 0, "STORE_BREAKER_THRESHOLD must not be negative, got %d", c.Store.BreakerThreshold)
+	check(c.Store.BreakerCooldown > 0, "STORE_BREAKER_COOLDOWN must be positive, got %s", c.Store.BreakerCooldown)
+
+	check(c.RateLimit.RPS >= 0, "RATE_LIMIT_RPS must not be negative, got %g", c.RateLimit.RPS)
+	check(c.RateLimit.RPS == 0 || c.RateLimit.Burst >= 1, "RATE_LIMIT_BURST must be at least 1, got %d", c.RateLimit.Burst)
+
+	check(c.Async.Workers >= 1, "ASYNC_WORKERS must be at least 1, got %d", c.Async.Workers)
+	check(c.Async.QueueSize >= 0, "ASYNC_QUEUE_SIZE must not be negative, got %d", c.Async.QueueSize)
+	check(c.Batch.Concurrency >= 1, "BATCH_CONCURRENCY must be at least 1, got %d", c.Batch.Concurrency)
+	check(c.Batch.MaxSize >= 1, "MAX_BATCH_SIZE must be at least 1, got %d", c.Batch.MaxSize)
+	check(c.Callback.MaxRetries >= 0, "CALLBACK_MAX_RETRIES must not be negative, got %d", c.Callback.MaxRetries)
+	check(c.Callback.RetryBackoff > 0, "CALLBACK_RETRY_BACKOFF must be positive, got %s", c.Callback.RetryBackoff)
+	check(c.Callback.Timeout > 0, "CALLBACK_TIMEOUT must be positive, got %s", c.Callback.Timeout)
+	for _, network := range c.Outbound.AllowedNetworks {
+		_, _, err := net.ParseCIDR(network)
+		check(err == nil || net.ParseIP(network) != nil, "OUTBOUND_ALLOWED_NETWORKS must be a comma-separated list of CIDRs or IP addresses, got %q", network)
+	}
+	check(c.Events.Sink == "none" || c.Events.Sink == "webhook", "EVENTS_SINK must be none or webhook, got %q", c.Events.Sink)
+	if c.Events.Sink == "webhook" {
+		webhookURL, err := url.Parse(c.Events.URL)
+		check(err == nil && (webhookURL.Scheme == "http" || webhookURL.Scheme == "https") && webhookURL.Host != "",
+			"EVENTS_WEBHOOK_URL must be an http or https URL when EVENTS_SINK is webhook, got %q", c.Events.URL)
+	}
+	check(c.Events.MaxRetries >= 0, "EVENTS_MAX_RETRIES must not be negative, got %d", c.Events.MaxRetries)
+	check(c.Events.RetryBackoff > 0, "EVENTS_RETRY_BACKOFF must be positive, got %s", c.Events.RetryBackoff)
+	check(c.Events.Timeout > 0, "EVENTS_TIMEOUT must be positive, got %s", c.Events.Timeout)
+	check(c.Events.QueueSize >= 1, "EVENTS_QUEUE_SIZE must be at least 1, got %d", c.Events.QueueSize)
+	check(c.Scheduler.MaxRetries >= 0, "SCHEDULE_MAX_RETRIES must not be negative, got %d", c.Scheduler.MaxRetries)
+	check(c.Scheduler.FailureHistory >= 0, "SCHEDULE_FAILURE_HISTORY must not be negative, got %d", c.Scheduler.FailureHistory)
+	check(c.Git.CloneTimeout > 0, "GIT_CLONE_TIMEOUT must be positive, got %s", c.Git.CloneTimeout)
+	check(c.Git.MaxSize > 0, "GIT_MAX_CLONE_SIZE must be positive, got %d", c.Git.MaxSize)
+	check(c.Git.Token == "" || len(c.Git.TokenHosts) > 0, "GIT_TOKEN_HOSTS must not be empty when GIT_TOKEN is set")
+	if c.Tracing.Endpoint != "" {
+		endpoint, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+			"OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL, got %q", c.Tracing.Endpoint)
+		check(c.Tracing.ServiceName != "", "OTEL_SERVICE_NAME must not be empty when tracing is enabled")
+	}
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL must be one of debug, info, warn or error, got %q", c.LogLevel))
+	}
+	check(c.LogFormat == "console" || c.LogFormat == "json", "LOG_FORMAT must be console or json, got %q", c.LogFormat)
+	check(c.LogOutput == "stdout" || c.LogOutput == "stderr", "LOG_OUTPUT must be stdout or stderr, got %q", c.LogOutput)
+	check(c.LogBodyLimit > 0, "LOG_BODY_LIMIT must be positive, got %d", c.LogBodyLimit)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// envReader reads typed environment variables with defaults. In strict mode,
+// values that are set but fail to parse are recorded in errs instead of being
+// silently replaced by the default.
+type envReader struct {
+	strict bool
+	errs   []string
+}
+
+// invalid records a value that failed to parse, if in strict mode
+func (e *envReader) invalid(key, value, kind string) {
+	if e.strict {
+		e.errs = append(e.errs, fmt.Sprintf("%s must be a valid %s, got %q", key, kind, value))
 	}
 }
 
 // Helper functions to get environment variables with defaults
-func getEnv(key, defaultValue string) string {
+func (e *envReader) getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return defaultValue
 }
 
-func getIntEnv(key string, defaultValue int) int {
+// getListEnv splits a comma-separated variable, dropping empty entries
+func (e *envReader) getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getListEnvDefault is like getListEnv, but returns defaultValue when the
+// variable is unset. Setting it to an empty string yields an empty list.
+func (e *envReader) getListEnvDefault(key string, defaultValue []string) []string {
+	if _, exists := os.LookupEnv(key); exists {
+		return e.getListEnv(key)
+	}
+	return defaultValue
+}
+
+func (e *envReader) getBoolEnv(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+		e.invalid(key, value, "boolean")
+	}
+	return defaultValue
+}
+
+func (e *envReader) getIntEnv(key string, defaultValue int) int {
 	if value, exists := os.LookupEnv(key); exists {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
 		}
+		e.invalid(key, value, "integer")
 	}
 	return defaultValue
 }
 
-func getInt64Env(key string, defaultValue int64) int64 {
+func (e *envReader) getInt64Env(key string, defaultValue int64) int64 {
 	if value, exists := os.LookupEnv(key); exists {
 		if int64Value, err := strconv.ParseInt(value, 10, 64); err == nil {
 			return int64Value
 		}
+		e.invalid(key, value, "integer")
+	}
+	return defaultValue
+}
+
+func (e *envReader) getFloatEnv(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+		e.invalid(key, value, "number")
+	}
+	return defaultValue
+}
+
+// getModeEnv reads file permissions written in octal, such as 0755
+func (e *envReader) getModeEnv(key string, defaultValue os.FileMode) os.FileMode {
+	if value, exists := os.LookupEnv(key); exists {
+		if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+			return os.FileMode(mode)
+		}
+		e.invalid(key, value, "octal file mode (e.g. 0755)")
+	}
+	return defaultValue
+}
+
+// getMemoryEnv reads a memory size such as 256m or 1g
+func (e *envReader) getMemoryEnv(key string, defaultValue int64) int64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if size, err := units.RAMInBytes(value); err == nil {
+			return size
+		}
+		e.invalid(key, value, "memory size (e.g. 256m)")
 	}
 	return defaultValue
 }
 
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+// getResourceProfiles reads the <prefix>_MEMORY and <prefix>_CPUS variables of
+// each language, leaving out languages that set neither
+func (e *envReader) getResourceProfiles(languages []string) ResourceProfiles {
+	profiles := make(ResourceProfiles)
+	for _, language := range languages {
+		name := resourceProfileEnv(language)
+		profile := ResourceProfile{
+			MemoryLimit: e.getMemoryEnv(name+"_MEMORY", 0),
+			CPULimit:    e.getFloatEnv(name+"_CPUS", 0),
+		}
+		if profile != (ResourceProfile{}) {
+			profiles[language] = profile
+		}
+	}
+	return profiles
+}
+
+// getLanguageUsers reads the DOCKER_USER_<LANGUAGE> variables, leaving out
+// languages whose variable isn't set. An empty value is kept, so a language
+// can run as the user its image sets.
+func (e *envReader) getLanguageUsers(languages []string) map[string]string {
+	users := make(map[string]string)
+	for _, language := range languages {
+		if user, exists := os.LookupEnv(containerUserEnv(language)); exists {
+			users[language] = user
+		}
+	}
+	return users
+}
+
+func (e *envReader) getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value, exists := os.LookupEnv(key); exists {
 		if durationValue, err := time.ParseDuration(value); err == nil {
 			return durationValue
 		}
+		e.invalid(key, value, "duration (e.g. 30s)")
 	}
 	return defaultValue
 }
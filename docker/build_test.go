@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"youtube_serverless/config"
+)
+
+// benchRequirements are heavy Python dependencies, which take long enough to
+// install that rebuilding them dominates the build time
+const benchRequirements = "numpy\npandas\nscipy\n"
+
+// naiveDockerfile builds a Python function the way the templates did before
+// they installed dependencies ahead of the code: any change to the code
+// invalidates the install layer
+const naiveDockerfile = `ARG RUNTIME_VERSION
+FROM python:${RUNTIME_VERSION}-slim
+WORKDIR /app
+COPY . .
+RUN pip install -r requirements.txt
+ENTRYPOINT ["python", "main.py"]
+`
+
+// benchmarkRebuild measures rebuilding a function with heavy dependencies
+// after a change to its handler only, with the Dockerfile the language's
+// template renders or, if dockerfile is set, with that one. The first build
+// is not timed, so every timed build can reuse the cached layers of the one
+// before it.
+func benchmarkRebuild(b *testing.B, dockerfile string) {
+	dm := newBenchManager(b, func(cfg *config.DockerConfig) {
+		cfg.CustomDockerfiles = true
+	})
+	ctx := context.Background()
+
+	language := "python"
+	runtimeVersion, err := dm.config.RuntimeVersions.Resolve(language, "")
+	if err != nil {
+		b.Fatalf("failed to resolve the python version: %v", err)
+	}
+
+	dir := b.TempDir()
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			b.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	writeFile("requirements.txt", benchRequirements)
+	var buildArgs map[string]string
+	if dockerfile != "" {
+		language = config.CustomLanguage
+		buildArgs = map[string]string{RuntimeVersionArg: runtimeVersion}
+		writeFile("Dockerfile", dockerfile)
+	}
+
+	var size int64
+	build := func(i int) {
+		writeFile("main.py", fmt.Sprintf("import pandas\nprint('build %d')\n", i))
+		result, err := dm.BuildDockerImage(ctx, "", dir, language, "main.py", runtimeVersion, buildArgs)
+		if err != nil {
+			b.Fatalf("BuildDockerImage: %v", err)
+		}
+		b.Cleanup(func() { dm.RemoveImage(context.Background(), result.ImageID) })
+
+		image, _, err := dm.client.ImageInspectWithRaw(ctx, result.ImageID)
+		if err != nil {
+			b.Fatalf("failed to inspect the image: %v", err)
+		}
+		size = image.Size
+	}
+
+	build(-1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		build(i)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(size)/(1<<20), "image-MB")
+}
+
+// BenchmarkRebuildLayered measures a rebuild with the python template, which
+// installs dependencies before copying the code
+func BenchmarkRebuildLayered(b *testing.B) {
+	benchmarkRebuild(b, "")
+}
+
+// BenchmarkRebuildNaive measures the same rebuild with a Dockerfile that
+// copies the code before installing dependencies
+func BenchmarkRebuildNaive(b *testing.B) {
+	benchmarkRebuild(b, naiveDockerfile)
+}
@@ -0,0 +1,198 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Builders that can build function images
+const (
+	BuilderClassic  = "classic"
+	BuilderBuildKit = "buildkit"
+)
+
+// buildkitTraceID is the ID of build response messages carrying BuildKit
+// progress
+const buildkitTraceID = "moby.buildkit.trace"
+
+// builderVersion returns the API builder version for the configured builder
+func (dm *Manager) builderVersion() types.BuilderVersion {
+	if dm.config.Builder == BuilderBuildKit {
+		return types.BuilderBuildKit
+	}
+	return types.BuilderV1
+}
+
+// buildkitProgress renders the progress BuildKit reports in build responses
+// as the text docker build --progress=plain prints, so BuildKit build logs
+// read like classic ones and can be searched for errors the same way
+type buildkitProgress struct {
+	steps map[string]*buildkitStep // by vertex digest
+}
+
+// buildkitStep is the rendered state of one BuildKit vertex
+type buildkitStep struct {
+	number              int
+	named, cached, done bool
+	failed              bool
+}
+
+// buildkitVertex is the part of a BuildKit vertex the progress shows
+type buildkitVertex struct {
+	digest    string
+	name      string
+	cached    bool
+	completed bool
+	err       string
+}
+
+// write decodes the Aux of a trace message, a JSON string holding a
+// protobuf-encoded BuildKit StatusResponse, and appends its progress to log
+func (p *buildkitProgress) write(log *strings.Builder, aux json.RawMessage) error {
+	var trace []byte
+	if err := json.Unmarshal(aux, &trace); err != nil {
+		return fmt.Errorf("failed to decode BuildKit progress: %v", err)
+	}
+
+	return walkProto(trace, func(field protowire.Number, value []byte) error {
+		switch field {
+		case 1: // vertexes
+			vertex, err := parseBuildkitVertex(value)
+			if err != nil {
+				return err
+			}
+			p.writeVertex(log, vertex)
+		case 3: // logs
+			return walkProto(value, p.logWriter(log, ""))
+		case 4: // warnings
+			return walkProto(value, p.logWriter(log, "WARN: "))
+		}
+		return nil
+	})
+}
+
+// writeVertex appends the changes in a vertex's state since it was last seen
+func (p *buildkitProgress) writeVertex(log *strings.Builder, vertex buildkitVertex) {
+	step := p.step(vertex.digest)
+	if !step.named && vertex.name != "" {
+		step.named = true
+		fmt.Fprintf(log, "#%d %s\n", step.number, vertex.name)
+	}
+	switch {
+	case vertex.err != "" && !step.failed:
+		step.failed = true
+		fmt.Fprintf(log, "#%d ERROR: %s\n", step.number, vertex.err)
+	case vertex.cached && !step.cached:
+		step.cached = true
+		fmt.Fprintf(log, "#%d CACHED\n", step.number)
+	case vertex.completed && vertex.err == "" && !step.done && !step.cached:
+		step.done = true
+		fmt.Fprintf(log, "#%d DONE\n", step.number)
+	}
+}
+
+// logWriter returns a walkProto callback for a VertexLog, or a VertexWarning
+// when prefix is set. Both give the vertex in field 1, and the text in field 4
+// and 3 respectively. Each line of the text is appended with the vertex's
+// number and prefix.
+func (p *buildkitProgress) logWriter(log *strings.Builder, prefix string) func(protowire.Number, []byte) error {
+	var digest, text string
+	textField := protowire.Number(4)
+	if prefix != "" {
+		textField = 3
+	}
+	return func(field protowire.Number, value []byte) error {
+		switch field {
+		case 1:
+			digest = string(value)
+		case textField:
+			text = string(value)
+		default:
+			return nil
+		}
+		if digest == "" || text == "" {
+			return nil
+		}
+		step := p.step(digest)
+		for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			fmt.Fprintf(log, "#%d %s%s\n", step.number, prefix, line)
+		}
+		text = ""
+		return nil
+	}
+}
+
+// step returns the state of a vertex, numbering it if it is new
+func (p *buildkitProgress) step(digest string) *buildkitStep {
+	if p.steps == nil {
+		p.steps = make(map[string]*buildkitStep)
+	}
+	step, ok := p.steps[digest]
+	if !ok {
+		step = &buildkitStep{number: len(p.steps) + 1}
+		p.steps[digest] = step
+	}
+	return step
+}
+
+// parseBuildkitVertex decodes the fields of a BuildKit Vertex the progress
+// shows
+func parseBuildkitVertex(data []byte) (buildkitVertex, error) {
+	var vertex buildkitVertex
+	err := walkProto(data, func(field protowire.Number, value []byte) error {
+		switch field {
+		case 1:
+			vertex.digest = string(value)
+		case 3:
+			vertex.name = string(value)
+		case 4:
+			vertex.cached = len(value) > 0 && value[0] != 0
+		case 6:
+			vertex.completed = true
+		case 7:
+			vertex.err = string(value)
+		}
+		return nil
+	})
+	return vertex, err
+}
+
+// walkProto calls fn with each field of a protobuf message. Length-delimited
+// values are passed as they are and a varint as its single low byte, which
+// is all a bool needs; other wire types are skipped.
+func walkProto(data []byte, fn func(field protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		field, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("malformed BuildKit progress: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var value []byte
+		switch wireType {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			value = []byte{byte(v)}
+		default:
+			n = protowire.ConsumeFieldValue(field, wireType, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("malformed BuildKit progress: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if value != nil {
+			if err := fn(field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
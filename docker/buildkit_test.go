@@ -0,0 +1,194 @@
+package docker
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// buildkitVertexProto encodes a BuildKit Vertex with the fields the progress
+// shows
+func buildkitVertexProto(digest, name string, cached, completed bool, err string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, digest)
+	if name != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+	}
+	if cached {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if completed {
+		// A Timestamp with one second set
+		var timestamp []byte
+		timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+		timestamp = protowire.AppendVarint(timestamp, 1)
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, timestamp)
+	}
+	if err != "" {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendString(b, err)
+	}
+	return b
+}
+
+// buildkitLogProto encodes a BuildKit VertexLog
+func buildkitLogProto(digest, text string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, digest)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendString(b, text)
+	return b
+}
+
+// buildkitTrace returns a build response line carrying a BuildKit
+// StatusResponse with the vertexes and logs
+func buildkitTrace(t *testing.T, vertexes, logs [][]byte) string {
+	t.Helper()
+
+	var status []byte
+	for _, vertex := range vertexes {
+		status = protowire.AppendTag(status, 1, protowire.BytesType)
+		status = protowire.AppendBytes(status, vertex)
+	}
+	for _, log := range logs {
+		status = protowire.AppendTag(status, 3, protowire.BytesType)
+		status = protowire.AppendBytes(status, log)
+	}
+	// The trace is sent as a JSON string, base64-encoding the bytes
+	aux, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("failed to encode trace: %v", err)
+	}
+	return `{"id": "moby.buildkit.trace", "aux": ` + string(aux) + `}`
+}
+
+func TestReadBuildResponse(t *testing.T) {
+	const imageID = "sha256:4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+	tests := []struct {
+		name    string
+		lines   func(t *testing.T) []string
+		imageID string
+		log     []string // lines the build log must contain, in order
+		err     string
+	}{
+		{
+			name: "classic build",
+			lines: func(t *testing.T) []string {
+				return []string{
+					`{"stream": "Step 1/2 : FROM python:3.12-slim\n"}`,
+					`{"stream": " ---> 1a2b3c4d5e6f\n"}`,
+					`{"stream": "Step 2/2 : COPY . /app\n"}`,
+					`{"aux": {"ID": "` + imageID + `"}}`,
+					`{"stream": "Successfully built 4b825dc642cb\n"}`,
+				}
+			},
+			imageID: imageID,
+			log:     []string{"Step 1/2 : FROM python:3.12-slim", "Step 2/2 : COPY . /app", "Successfully built 4b825dc642cb"},
+		},
+		{
+			name: "BuildKit build",
+			lines: func(t *testing.T) []string {
+				return []string{
+					buildkitTrace(t, [][]byte{buildkitVertexProto("sha256:from", "[1/2] FROM python:3.12-slim", false, false, "")}, nil),
+					buildkitTrace(t, [][]byte{buildkitVertexProto("sha256:from", "[1/2] FROM python:3.12-slim", true, true, "")}, nil),
+					buildkitTrace(t, [][]byte{buildkitVertexProto("sha256:run", "[2/2] RUN pip install -r requirements.txt", false, false, "")}, nil),
+					buildkitTrace(t, nil, [][]byte{buildkitLogProto("sha256:run", "Collecting requests\nInstalling requests\n")}),
+					buildkitTrace(t, [][]byte{buildkitVertexProto("sha256:run", "", false, true, "")}, nil),
+					`{"id": "moby.image.id", "aux": {"ID": "` + imageID + `"}}`,
+				}
+			},
+			imageID: imageID,
+			log: []string{
+				"#1 [1/2] FROM python:3.12-slim",
+				"#1 CACHED",
+				"#2 [2/2] RUN pip install -r requirements.txt",
+				"#2 Collecting requests",
+				"#2 Installing requests",
+				"#2 DONE",
+			},
+		},
+		{
+			name: "BuildKit progress that can't be decoded is skipped",
+			lines: func(t *testing.T) []string {
+				return []string{
+					`{"id": "moby.buildkit.trace", "aux": "bm90IHByb3RvYnVm"}`,
+					`{"id": "moby.image.id", "aux": {"ID": "` + imageID + `"}}`,
+				}
+			},
+			imageID: imageID,
+		},
+		{
+			name: "failed BuildKit step",
+			lines: func(t *testing.T) []string {
+				return []string{
+					buildkitTrace(t, [][]byte{buildkitVertexProto("sha256:run", "[2/2] RUN pip install nope", false, true, "exit code: 1")}, nil),
+					`{"errorDetail": {"message": "process did not complete successfully"}, "error": "process did not complete successfully"}`,
+				}
+			},
+			log: []string{"#1 [2/2] RUN pip install nope", "#1 ERROR: exit code: 1"},
+			err: "process did not complete successfully",
+		},
+		{
+			name: "failed classic build",
+			lines: func(t *testing.T) []string {
+				return []string{
+					`{"stream": "Step 1/1 : FROM missing\n"}`,
+					`{"errorDetail": {"message": "pull access denied"}, "error": "pull access denied"}`,
+				}
+			},
+			log: []string{"Step 1/1 : FROM missing"},
+			err: "pull access denied",
+		},
+		{
+			name: "no image ID",
+			lines: func(t *testing.T) []string {
+				return []string{`{"stream": "Step 1/1 : FROM scratch\n"}`}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Join(tt.lines(t), "\n") + "\n"
+			gotID, buildLog, err := readBuildResponse(strings.NewReader(body))
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("error = %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatalf("readBuildResponse: %v", err)
+			}
+			if gotID != tt.imageID {
+				t.Errorf("image ID = %q, want %q", gotID, tt.imageID)
+			}
+
+			rest := buildLog
+			for _, line := range tt.log {
+				i := strings.Index(rest, line+"\n")
+				if i < 0 {
+					t.Fatalf("build log lacks %q after the lines before it:\n%s", line, buildLog)
+				}
+				rest = rest[i+len(line)+1:]
+			}
+		})
+	}
+}
+
+func TestReadBuildResponseMalformedStream(t *testing.T) {
+	_, buildLog, err := readBuildResponse(strings.NewReader(`{"stream": "Step 1/1 : FROM scratch\n"}` + "\n{not json"))
+	if err == nil {
+		t.Fatal("malformed build output was accepted")
+	}
+	if !strings.Contains(buildLog, "Step 1/1") {
+		t.Errorf("build log before the malformed output was lost: %q", buildLog)
+	}
+}
@@ -0,0 +1,52 @@
+package docker
+
+import "strings"
+
+// permanentBuildPatterns mark build failures that retrying can't fix, such as
+// Dockerfile syntax errors. They are checked before transientBuildPatterns.
+var permanentBuildPatterns = []string{
+	"dockerfile parse error",
+	"unknown instruction",
+	"unknown flag",
+	"failed to parse dockerfile",
+}
+
+// transientBuildPatterns mark build failures caused by the network, the
+// registry or a busy daemon, which may succeed on a retry
+var transientBuildPatterns = []string{
+	"i/o timeout",
+	"tls handshake timeout",
+	"connection reset by peer",
+	"connection refused",
+	"connection timed out",
+	"network is unreachable",
+	"temporary failure in name resolution",
+	"no such host",
+	"unexpected eof",
+	"toomanyrequests",
+	"too many requests",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"net/http: request canceled while waiting for connection",
+	"cannot connect to the docker daemon",
+	"resource temporarily unavailable",
+}
+
+// isTransientBuildError reports whether a failed build is worth retrying,
+// judging by its error and the end of its build log
+func isTransientBuildError(err *BuildError) bool {
+	output := strings.ToLower(err.Err.Error() + "\n" + err.Log)
+
+	for _, pattern := range permanentBuildPatterns {
+		if strings.Contains(output, pattern) {
+			return false
+		}
+	}
+	for _, pattern := range transientBuildPatterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
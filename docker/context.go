@@ -0,0 +1,248 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/requestctx"
+)
+
+// DepsDir is the build context directory holding copies of the function's
+// dependency manifests, so templates can install dependencies before copying
+// the rest of the code and the install layer stays cached until they change
+const DepsDir = ".serverless-deps"
+
+// dependencyFiles are the dependency manifests copied into DepsDir when found
+// at the root of the function's code
+var dependencyFiles = []string{"requirements.txt", "go.mod", "go.sum", "Gemfile", "Gemfile.lock"}
+
+// defaultIgnores are left out of every build context unless disabled, ahead
+// of the patterns in the function's own .dockerignore
+var defaultIgnores = []string{".git", "**/.git", "**/__pycache__", "**/*.pyc"}
+
+// alwaysIncluded are sent to Docker even when ignored, as docker build does
+var alwaysIncluded = map[string]bool{"Dockerfile": true, ".dockerignore": true}
+
+// contextSize tallies the regular files considered for a build context
+type contextSize struct {
+	files int
+	bytes int64
+}
+
+func (s *contextSize) add(info os.FileInfo) {
+	s.files++
+	s.bytes += info.Size()
+}
+
+// digestWriter writes a tar stream while hashing the name, type, mode, size
+// and contents of each entry. Timestamps and ownership are left out, so the
+// same files digest the same wherever and whenever they were extracted.
+type digestWriter struct {
+	*tar.Writer
+	hash hash.Hash
+}
+
+func newDigestWriter(w io.Writer) *digestWriter {
+	return &digestWriter{Writer: tar.NewWriter(w), hash: sha256.New()}
+}
+
+func (d *digestWriter) WriteHeader(header *tar.Header) error {
+	fmt.Fprintf(d.hash, "%s\x00%c\x00%o\x00%d\x00", header.Name, header.Typeflag, header.Mode, header.Size)
+	return d.Writer.WriteHeader(header)
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	d.hash.Write(p)
+	return d.Writer.Write(p)
+}
+
+// digest returns the hex digest of everything written so far
+func (d *digestWriter) digest() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// ignoreMatcher returns a matcher for the paths left out of dir's build
+// context: defaultIgnores when useDefaults is set, followed by the patterns
+// of a .dockerignore at the root of dir
+func ignoreMatcher(dir string, useDefaults bool) (*patternmatcher.PatternMatcher, error) {
+	var patterns []string
+	if useDefaults {
+		patterns = append(patterns, defaultIgnores...)
+	}
+
+	path := filepath.Join(dir, ".dockerignore")
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		filePatterns, err := ignorefile.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore: %v", err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+
+	matcher, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .dockerignore: %v", err)
+	}
+	return matcher, nil
+}
+
+// createBuildContext packs a directory into an uncompressed tar stream
+// suitable for the Docker image build API, adding DepsDir, and returns it
+// with a digest of its contents. Paths matched by the function's
+// .dockerignore, and by defaultIgnores when useDefaults is set, are left out.
+func createBuildContext(ctx context.Context, dir string, useDefaults bool) ([]byte, string, error) {
+	matcher, err := ignoreMatcher(dir, useDefaults)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	tw := newDigestWriter(&buf)
+	var total, included contextSize
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if relPath == DepsDir {
+			// Added below with the manifests found at the root
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Only regular files and directories are added; links are skipped
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			total.add(info)
+		}
+
+		// Ignored directories are still walked, both to count what they hold
+		// and because a later "!" pattern may bring back files inside them
+		ignored, err := matcher.MatchesOrParentMatches(relPath)
+		if err != nil {
+			return err
+		}
+		if ignored && !alwaysIncluded[relPath] {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			included.add(info)
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := addDependencyFiles(tw, dir, matcher); err != nil {
+		return nil, "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	log.Info().
+		Str("request_id", requestctx.ID(ctx)).
+		Int("files", total.files).
+		Int64("bytes", total.bytes).
+		Int("included_files", included.files).
+		Int64("included_bytes", included.bytes).
+		Int("context_bytes", buf.Len()).
+		Msg("Created build context")
+
+	return buf.Bytes(), tw.digest(), nil
+}
+
+// addDependencyFiles adds DepsDir to the build context, holding a copy of each
+// of dependencyFiles found at the root of dir and not ignored by matcher. The
+// directory is added even when empty so templates can always copy it.
+func addDependencyFiles(tw *digestWriter, dir string, matcher *patternmatcher.PatternMatcher) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     DepsDir + "/",
+		Mode:     0755,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range dependencyFiles {
+		path := filepath.Join(dir, name)
+		// Like the walk above, skip anything that isn't a regular file
+		if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if ignored, err := matcher.MatchesOrParentMatches(name); err != nil || ignored {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     DepsDir + "/" + name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
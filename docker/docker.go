@@ -1,39 +1,403 @@
 package docker
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
 	"youtube_serverless/config"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/templates"
+	"youtube_serverless/tracing"
 )
 
-// Template represents a Docker template configuration
-type Template struct {
-	Dockerfile string `yaml:"dockerfile"`
+// Default resource limits applied to every function container
+const (
+	defaultMemoryLimit = 128 * 1024 * 1024 // 128 MiB
+	defaultNanoCPUs    = 500_000_000       // 0.5 CPUs
+)
+
+// ErrContainerLimitReached is returned when no container slot is available
+// and the limit policy is set to reject
+var ErrContainerLimitReached = errors.New("container limit reached")
+
+// RuntimeVersionArg is the build argument carrying the function's runtime
+// version, which templates use to pick their base image tag
+const RuntimeVersionArg = "RUNTIME_VERSION"
+
+// Labels set on every image the platform builds. ManagedLabel marks them so
+// they can be told apart even once they have lost their tag. FunctionLabel
+// names the function the image was built for, and is absent from validation
+// builds; other functions with identical code may share the image.
+const (
+	ManagedLabel        = "serverless.managed"
+	LanguageLabel       = "serverless.language"
+	RuntimeVersionLabel = "serverless.runtime-version"
+	CreatedLabel        = "serverless.created"
+	FunctionLabel       = "serverless.function"
+)
+
+// imageCleanupGrace is how old an image must be before CleanupImages removes
+// it, since a newer one may belong to a deployment that hasn't stored its
+// function yet
+const imageCleanupGrace = 10 * time.Minute
+
+// ErrDaemonUnavailable is returned when the Docker daemon can't be reached
+var ErrDaemonUnavailable = errors.New("docker daemon is unavailable")
+
+// ErrRunTimeout is returned when an execution runs past its timeout
+var ErrRunTimeout = errors.New("container execution timed out")
+
+// stopMargin is how long after the grace period a stopped container is waited
+// for before its output is abandoned
+const stopMargin = 5 * time.Second
+
+// RunOptions holds per-execution settings for RunDockerContainer
+type RunOptions struct {
+	// InputMode selects how input is delivered: models.InputModeEnv (default) or models.InputModeStdin
+	InputMode string
+	// Language is the function's language, whose resource profile applies
+	// to the limits that are zero
+	Language string
+	// MemoryLimit is the container memory limit in bytes; zero uses the
+	// language's profile or the default
+	MemoryLimit int64
+	// CPULimit is the number of CPUs the container may use; zero uses the
+	// language's profile or the default
+	CPULimit float64
+	// InputFile is the host path of a file to mount read-only under
+	// models.InputFileDir. Executions with an input file always start a fresh
+	// container, since mounts can't be added to a warm one.
+	InputFile string
+	// Env holds the function's own environment variables. Input passed as
+	// environment variables takes precedence over them.
+	Env map[string]string
+	// NetworkMode is models.NetworkModeNone or models.NetworkModeBridge; empty
+	// uses the configured default
+	NetworkMode string
+	// Timeout shortens the run timeout for this execution; zero or anything
+	// longer than the configured run timeout uses the configured one
+	Timeout time.Duration
+	// AllowedEnv lists the environment variables input may set, on top of
+	// the configured blocklist; any when empty
+	AllowedEnv []string
+}
+
+// resources is the memory and CPU allocation of a function container
+type resources struct {
+	memory   int64 // bytes
+	nanoCPUs int64
+}
+
+// resources returns the container resources for the options. Each limit is
+// the function's own if set, else its language's profile, else the default.
+func (dm *Manager) resources(opts RunOptions) resources {
+	profile := dm.config.ResourceProfiles[opts.Language]
+
+	res := resources{memory: defaultMemoryLimit, nanoCPUs: defaultNanoCPUs}
+	switch {
+	case opts.MemoryLimit > 0:
+		res.memory = opts.MemoryLimit
+	case profile.MemoryLimit > 0:
+		res.memory = profile.MemoryLimit
+	}
+	switch {
+	case opts.CPULimit > 0:
+		res.nanoCPUs = int64(opts.CPULimit * 1e9)
+	case profile.CPULimit > 0:
+		res.nanoCPUs = int64(profile.CPULimit * 1e9)
+	}
+	return res
+}
+
+// RunResult holds the captured result of a container execution. Stdout and
+// stderr are each cut off at the configured maximum output size.
+type RunResult struct {
+	Stdout       string
+	Stderr       string
+	ExitCode     int
+	Truncated    bool  // output was cut off
+	DroppedBytes int64 // output bytes beyond the limit, across stdout and stderr
+}
+
+// ExitError is returned when a container exits with a non-zero code
+type ExitError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container exited with code %d", e.ExitCode)
+}
+
+// TimeoutError is returned when an execution times out. It carries the
+// output the function produced before it was stopped.
+type TimeoutError struct {
+	Timeout   time.Duration
+	Stdout    string
+	Stderr    string
+	Truncated bool
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("container execution timed out after %s (partial output captured)", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return ErrRunTimeout
+}
+
+// BuildResult holds the outcome of a successful image build
+type BuildResult struct {
+	ImageID string
+	Log     string
+	// Shared is set when identical concurrent builds were merged into this
+	// one, so other callers may be about to use the image
+	Shared bool
+}
+
+// BuildError is returned when an image build fails. Log holds the build
+// output captured up to the failure.
+type BuildError struct {
+	Err error
+	Log string
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("docker build failed: %v", e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
 }
 
 // Manager DockerManager handles Docker operations
 type Manager struct {
-	config *config.DockerConfig
+	config   *config.DockerConfig
+	client   *client.Client
+	slots    chan struct{} // semaphore bounding concurrently running containers
+	warm     *warmPool     // nil when warm containers are disabled
+	running  *runningSet   // containers executing functions, for draining on shutdown
+	queue    *imageQueue   // per-image concurrency limit; nil when unlimited
+	pressure *pressureGate // holds back executions on a loaded host; nil when disabled
+	login    registryLogin
+	builds   singleflight.Group // merges concurrent builds of identical contexts
+}
+
+// NewDockerManager creates a new DockerManager with the given configuration.
+// The daemon endpoint is taken from the config, falling back to the standard
+// DOCKER_* environment variables.
+func NewDockerManager(config *config.DockerConfig) (*Manager, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if config.Host != "" {
+		opts = append(opts, client.WithHost(config.Host))
+	}
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %v", err)
+	}
+
+	limit := config.ContainerLimit
+	if limit < 1 {
+		limit = 1
+	}
+	dm := &Manager{
+		config:   config,
+		client:   cli,
+		slots:    make(chan struct{}, limit),
+		running:  newRunningSet(),
+		queue:    newImageQueue(config.MaxConcurrentPerImage, config.ImageQueueTimeout),
+		pressure: newPressureGate(config, procLoad{}),
+	}
+	dm.warm = newWarmPool(dm, config.WarmPoolSize, config.WarmPoolTTL)
+	return dm, nil
+}
+
+// Close removes any warm containers and releases the Docker client connection
+func (dm *Manager) Close() error {
+	dm.warm.close()
+	return dm.client.Close()
 }
 
-// NewDockerManager creates a new DockerManager with the given configuration
-func NewDockerManager(config *config.DockerConfig) *Manager {
-	return &Manager{
-		config: config,
+// Ping checks that the Docker daemon is reachable
+func (dm *Manager) Ping(ctx context.Context) error {
+	if _, err := dm.client.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping Docker daemon: %v", err)
 	}
+	return nil
+}
+
+// Verify checks that the Docker daemon is reachable, for use at startup. Its
+// error explains how to fix the most common causes.
+func (dm *Manager) Verify(ctx context.Context) error {
+	if _, err := dm.client.Ping(ctx); err != nil {
+		if client.IsErrConnectionFailed(err) || errors.Is(err, context.DeadlineExceeded) {
+			return dm.unavailable(err)
+		}
+		return fmt.Errorf("failed to ping Docker daemon at %s: %v", dm.client.DaemonHost(), err)
+	}
+	return nil
+}
+
+// unavailable wraps the error of a Docker API call that couldn't reach the
+// daemon with ErrDaemonUnavailable and what to do about it
+func (dm *Manager) unavailable(err error) error {
+	return fmt.Errorf("%w at %s (%v); make sure Docker is installed and running, and that DOCKER_HOST points at it",
+		ErrDaemonUnavailable, dm.client.DaemonHost(), err)
+}
+
+// InFlight returns the number of containers currently running
+func (dm *Manager) InFlight() int {
+	return len(dm.slots)
+}
+
+// acquireSlot reserves a container slot, either waiting for one to free up or
+// failing immediately depending on the configured limit policy
+func (dm *Manager) acquireSlot(ctx context.Context) error {
+	if dm.config.LimitPolicy == "reject" {
+		select {
+		case dm.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrContainerLimitReached
+		}
+	}
+
+	select {
+	case dm.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseSlot frees a slot reserved by acquireSlot
+func (dm *Manager) releaseSlot() {
+	<-dm.slots
 }
 
-// BuildDockerImage builds a Docker image using the specified template
-func (dm *Manager) BuildDockerImage(ctx context.Context, dir, language, handlerFile string) (string, error) {
-	requestID, _ := ctx.Value("requestID").(string)
+// BuildDockerImage builds a Docker image using the specified template. The
+// build arguments are available to the template and passed to the build, as
+// is runtimeVersion, which callers must have checked against the allowlist.
+// Functions in config.CustomLanguage are built from the Dockerfile in dir
+// instead, if custom Dockerfiles are enabled.
+// functionID, which may be empty, is recorded in the image's FunctionLabel.
+// Build failures are returned as *BuildError so the build log is not lost.
+// Concurrent builds of identical code and arguments run once, and every
+// caller gets the result.
+func (dm *Manager) BuildDockerImage(ctx context.Context, functionID, dir, language, handlerFile, runtimeVersion string, buildArgs map[string]string) (result *BuildResult, err error) {
+	requestID := requestctx.ID(ctx)
+
+	ctx, span := tracing.Start(ctx, "build", tracing.LanguageKey.String(language))
+	defer func() {
+		if result != nil {
+			span.SetAttributes(tracing.ImageIDKey.String(result.ImageID))
+		}
+		tracing.End(span, err)
+	}()
+
+	if language == config.CustomLanguage {
+		if !dm.config.CustomDockerfiles {
+			return nil, fmt.Errorf("custom Dockerfiles are not enabled")
+		}
+		// Build steps are the user's own, but containers still run with the
+		// configured limits and isolation
+		info, err := os.Lstat(filepath.Join(dir, "Dockerfile"))
+		if err != nil || !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("custom Dockerfile not found")
+		}
+		log.Warn().
+			Str("request_id", requestID).
+			Str("dir", dir).
+			Msg("Building from a custom Dockerfile; run-time security settings still apply")
+	} else if err := dm.writeDockerfile(ctx, dir, language, handlerFile, runtimeVersion, buildArgs); err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]*string, len(buildArgs)+1)
+	for name, value := range buildArgs {
+		args[name] = &value
+	}
+	// Set last so a manifest's build arguments can't pick a version outside
+	// the allowlist
+	if language != config.CustomLanguage {
+		args[RuntimeVersionArg] = &runtimeVersion
+	}
+
+	buildContext, digest, err := createBuildContext(ctx, dir, dm.config.DefaultIgnores)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("dir", dir).
+			Err(err).
+			Msg("Failed to create build context")
+		return nil, fmt.Errorf("failed to create build context: %v", err)
+	}
+
+	labels := imageLabels(functionID, language, runtimeVersion)
+
+	// The build isn't cancelled with the caller that started it, since
+	// callers of identical builds may be waiting on it; the build timeout
+	// bounds it instead. Labels aren't part of the key, so a shared build
+	// carries the labels of the caller that started it.
+	key := buildKey(digest, args)
+	builds := dm.builds.DoChan(key, func() (interface{}, error) {
+		return dm.build(context.WithoutCancel(ctx), buildContext, language, key, args, labels)
+	})
+
+	var outcome singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case outcome = <-builds:
+	}
+	if outcome.Err != nil {
+		return nil, outcome.Err
+	}
+
+	built := *outcome.Val.(*BuildResult)
+	built.Shared = outcome.Shared
+	if built.Shared {
+		log.Info().
+			Str("request_id", requestID).
+			Str("image_id", built.ImageID).
+			Msg("Shared the result of an identical concurrent build")
+	}
+	return &built, nil
+}
+
+// writeDockerfile renders the language's template into a Dockerfile in dir
+func (dm *Manager) writeDockerfile(ctx context.Context, dir, language, handlerFile, runtimeVersion string, buildArgs map[string]string) error {
+	requestID := requestctx.ID(ctx)
+
+	if !dm.config.Languages.Allows(language) {
+		return fmt.Errorf("language %s is not enabled", language)
+	}
 
 	// Load the Dockerfile template for the specified language
 	template, err := dm.LoadTemplate(ctx, language)
@@ -43,18 +407,23 @@ func (dm *Manager) BuildDockerImage(ctx context.Context, dir, language, handlerF
 			Str("language", language).
 			Err(err).
 			Msg("Failed to load template")
-		return "", fmt.Errorf("failed to load template: %v", err)
+		return fmt.Errorf("failed to load template: %v", err)
 	}
 
 	// Generate the Dockerfile content
-	var dockerfileContent string
-	switch language {
-	case "python":
-		dockerfileContent = fmt.Sprintf(template.Dockerfile, handlerFile)
-	case "golang":
-		dockerfileContent = template.Dockerfile
-	default:
-		return "", fmt.Errorf("unsupported language: %s", language)
+	dockerfileContent, err := template.Render(TemplateData{
+		Handler:        handlerFile,
+		Language:       language,
+		BuildArgs:      buildArgs,
+		RuntimeVersion: runtimeVersion,
+	})
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("language", language).
+			Err(err).
+			Msg("Failed to render template")
+		return err
 	}
 
 	// Write the Dockerfile to the directory
@@ -65,122 +434,739 @@ func (dm *Manager) BuildDockerImage(ctx context.Context, dir, language, handlerF
 			Str("path", dockerfilePath).
 			Err(err).
 			Msg("Failed to write Dockerfile")
-		return "", fmt.Errorf("failed to write Dockerfile: %v", err)
+		return fmt.Errorf("failed to write Dockerfile: %v", err)
+	}
+	return nil
+}
+
+// buildKey identifies a build by the digest of its context and its build
+// arguments, which together determine the image
+func buildKey(digest string, args map[string]*string) string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	hash.Write([]byte(digest))
+	for _, name := range names {
+		fmt.Fprintf(hash, "\x00%s=%s", name, *args[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// imageLabels returns the labels of an image built for functionID, which may
+// be empty, in language at runtimeVersion
+func imageLabels(functionID, language, runtimeVersion string) map[string]string {
+	labels := map[string]string{
+		ManagedLabel:  "true",
+		LanguageLabel: language,
+		CreatedLabel:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if runtimeVersion != "" && language != config.CustomLanguage {
+		labels[RuntimeVersionLabel] = runtimeVersion
 	}
+	if functionID != "" {
+		labels[FunctionLabel] = functionID
+	}
+	return labels
+}
+
+// build builds an image from a build context, retrying transient failures
+func (dm *Manager) build(ctx context.Context, buildContext []byte, language, key string, args map[string]*string, labels map[string]string) (*BuildResult, error) {
+	requestID := requestctx.ID(ctx)
 
-	// Build the Docker image with a unique tag
-	timestamp := time.Now().Unix()
-	imageTag := fmt.Sprintf("%s:%s-%d", dm.config.ImagePrefix, language, timestamp)
+	// The key keeps builds started in the same second from sharing a tag
+	imageTag := fmt.Sprintf("%s:%s-%d-%s", dm.config.ImagePrefix, language, time.Now().Unix(), key[:12])
 
 	log.Info().
 		Str("request_id", requestID).
 		Str("image_tag", imageTag).
-		Str("dir", dir).
 		Msg("Building Docker image")
 
-	// Set a timeout for the build command
+	// Set a timeout for the build
 	buildCtx, cancel := context.WithTimeout(ctx, dm.config.BuildTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(buildCtx, "docker", "build", "-t", imageTag, dir)
-	output, err := cmd.CombinedOutput()
+	// Base images may come from a private registry
+	if err := dm.EnsureRegistryAuth(buildCtx); err != nil {
+		return nil, err
+	}
+
+	// Retry transient failures, such as base image pull timeouts, within
+	// the build timeout
+	var imageID, buildLog string
+	var err error
+	backoff := dm.config.BuildBackoff
+	for attempt := 1; ; attempt++ {
+		imageID, buildLog, err = dm.buildImage(buildCtx, buildContext, imageTag, args, labels)
+		if err == nil {
+			break
+		}
+
+		var buildErr *BuildError
+		retry := attempt <= dm.config.BuildRetries && errors.As(err, &buildErr) &&
+			isTransientBuildError(buildErr) && buildCtx.Err() == nil
+		if !retry {
+			if attempt > 1 {
+				log.Error().
+					Str("request_id", requestID).
+					Str("image_tag", imageTag).
+					Int("attempt", attempt).
+					Err(err).
+					Msg("Docker build failed after retries")
+			}
+			return nil, err
+		}
+
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_tag", imageTag).
+			Int("attempt", attempt).
+			Dur("backoff", backoff).
+			Err(err).
+			Msg("Docker build failed transiently, retrying")
+
+		select {
+		case <-buildCtx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if imageID == "" {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_tag", imageTag).
+			Msg("Image ID missing from build response, using tag instead")
+		imageID = imageTag
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("image_id", imageID).
+		Str("image_tag", imageTag).
+		Msg("Docker image built successfully")
+
+	return &BuildResult{ImageID: imageID, Log: buildLog}, nil
+}
+
+// buildImage makes one attempt at building an image from a build context,
+// returning its ID, which may be empty, and its build log. Build failures are
+// returned as *BuildError.
+func (dm *Manager) buildImage(ctx context.Context, buildContext []byte, imageTag string, args map[string]*string, labels map[string]string) (string, string, error) {
+	requestID := requestctx.ID(ctx)
+
+	response, err := dm.client.ImageBuild(ctx, bytes.NewReader(buildContext), types.ImageBuildOptions{
+		Tags:        []string{imageTag},
+		Version:     dm.builderVersion(),
+		Dockerfile:  "Dockerfile",
+		BuildArgs:   args,
+		AuthConfigs: dm.buildAuthConfigs(),
+		Labels:      labels,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if client.IsErrConnectionFailed(err) {
+		return "", "", dm.unavailable(err)
+	}
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Str("image_tag", imageTag).
-			Str("output", string(output)).
 			Err(err).
 			Msg("Docker build failed")
-		return "", fmt.Errorf("docker build failed: %s", output)
+		return "", "", &BuildError{Err: err}
 	}
+	defer response.Body.Close()
 
-	// Extract the image ID from the build output
-	imageID, err := dm.ExtractImageID(string(output))
+	// Read the build output, collecting the log and the image ID
+	imageID, buildLog, err := readBuildResponse(response.Body)
+	buildLog = truncateLog(buildLog, dm.config.BuildLogLimit)
 	if err != nil {
-		log.Warn().
+		log.Error().
 			Str("request_id", requestID).
 			Str("image_tag", imageTag).
+			Str("output", buildLog).
 			Err(err).
-			Msg("Failed to extract image ID, using tag instead")
-		return imageTag, nil
+			Msg("Docker build failed")
+		return "", "", &BuildError{Err: err, Log: buildLog}
+	}
+
+	return imageID, buildLog, nil
+}
+
+// goBuildTarget returns the package to build for a Go handler, which is either
+// a main package path or a single .go file whose directory holds the package
+func goBuildTarget(handlerFile string) string {
+	target := handlerFile
+	if filepath.Ext(target) == ".go" {
+		target = filepath.Dir(target)
+	}
+	target = filepath.ToSlash(filepath.Clean(target))
+	if target == "." {
+		return "."
+	}
+	// Relative paths must start with ./ or go build treats them as import paths
+	return "./" + target
+}
+
+// readBuildResponse consumes a build response stream and returns the built
+// image ID (from the aux metadata) along with the build log. Classic builds
+// stream their log as text; BuildKit progress is rendered as plain progress
+// output. Both report the image ID the same way.
+func readBuildResponse(body io.Reader) (string, string, error) {
+	var imageID string
+	var buildLog strings.Builder
+	var progress buildkitProgress
+
+	decoder := json.NewDecoder(body)
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", buildLog.String(), fmt.Errorf("failed to read build output: %v", err)
+		}
+
+		if message.Error != nil {
+			return "", buildLog.String(), errors.New(message.Error.Message)
+		}
+		buildLog.WriteString(message.Stream)
+
+		if message.ID == buildkitTraceID && message.Aux != nil {
+			// Progress that can't be decoded only costs lines of the log
+			_ = progress.write(&buildLog, *message.Aux)
+			continue
+		}
+		if message.Aux != nil {
+			var result types.BuildResult
+			if err := json.Unmarshal(*message.Aux, &result); err == nil && result.ID != "" {
+				imageID = result.ID
+			}
+		}
+	}
+
+	return imageID, buildLog.String(), nil
+}
+
+// truncateLog keeps the last limit bytes of a build log, where errors show
+// up. A limit of zero or less disables truncation.
+func truncateLog(buildLog string, limit int) string {
+	if limit <= 0 || len(buildLog) <= limit {
+		return buildLog
+	}
+	return "...(truncated)\n" + buildLog[len(buildLog)-limit:]
+}
+
+// RunDockerContainer executes a function using a Docker container, reusing an
+// idle warm container when the warm pool is enabled. Stdout and stderr are
+// captured separately, up to the maximum output size each; a non-zero exit
+// returns the result along with an *ExitError.
+func (dm *Manager) RunDockerContainer(ctx context.Context, imageID string, input map[string]interface{}, opts RunOptions) (*RunResult, error) {
+	requestID := requestctx.ID(ctx)
+
+	stdout := &outputBuffer{limit: dm.config.MaxOutputSize}
+	stderr := &outputBuffer{limit: dm.config.MaxOutputSize}
+	exitCode, err := dm.execute(ctx, imageID, input, opts, stdout, stderr)
+	if errors.Is(err, ErrRunTimeout) {
+		// Return whatever the function wrote before it was stopped
+		result := &RunResult{
+			Stdout:       stdout.String(),
+			Stderr:       stderr.String(),
+			ExitCode:     exitCode,
+			DroppedBytes: stdout.dropped + stderr.dropped,
+		}
+		result.Truncated = result.DroppedBytes > 0
+		return result, &TimeoutError{
+			Timeout:   dm.runTimeout(opts),
+			Stdout:    result.Stdout,
+			Stderr:    result.Stderr,
+			Truncated: result.Truncated,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RunResult{
+		Stdout:       stdout.String(),
+		Stderr:       stderr.String(),
+		ExitCode:     exitCode,
+		DroppedBytes: stdout.dropped + stderr.dropped,
+	}
+	result.Truncated = result.DroppedBytes > 0
+	if result.Truncated {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_id", imageID).
+			Int64("dropped_bytes", result.DroppedBytes).
+			Int("limit", dm.config.MaxOutputSize).
+			Msg("Container output truncated")
+	}
+
+	if result.ExitCode != 0 {
+		log.Error().
+			Str("request_id", requestID).
+			Str("image_id", imageID).
+			Int("exit_code", result.ExitCode).
+			Str("stderr", result.Stderr).
+			Msg("Docker container exited with non-zero code")
+		return result, &ExitError{ExitCode: result.ExitCode, Stderr: result.Stderr}
 	}
 
 	log.Info().
 		Str("request_id", requestID).
 		Str("image_id", imageID).
-		Str("image_tag", imageTag).
-		Msg("Docker image built successfully")
+		Int("stdout_length", len(result.Stdout)).
+		Int("stderr_length", len(result.Stderr)).
+		Msg("Docker container executed successfully")
 
-	return imageID, nil
+	return result, nil
 }
 
-// RunDockerContainer executes a function using a Docker container
-func (dm *Manager) RunDockerContainer(ctx context.Context, imageID string, input map[string]string) (string, error) {
-	requestID, _ := ctx.Value("requestID").(string)
+// StreamDockerContainer executes a function like RunDockerContainer, but
+// copies its stdout and stderr to the given writers as they are produced. It
+// returns the exit code; a non-zero exit is not treated as an error.
+func (dm *Manager) StreamDockerContainer(ctx context.Context, imageID string, input map[string]interface{}, opts RunOptions, stdout, stderr io.Writer) (int, error) {
+	return dm.execute(ctx, imageID, input, opts, stdout, stderr)
+}
+
+// execute runs a function in a warm or fresh container, writing its output to
+// stdout and stderr, and returns its exit code. The execution is traced as a
+// run span.
+func (dm *Manager) execute(ctx context.Context, imageID string, input map[string]interface{}, opts RunOptions, stdout, stderr io.Writer) (int, error) {
+	ctx, span := tracing.Start(ctx, "run", tracing.ImageIDKey.String(imageID))
+	exitCode, err := dm.run(ctx, imageID, input, opts, stdout, stderr)
+	if err == nil {
+		span.SetAttributes(tracing.ExitCodeKey.Int(exitCode))
+	}
+	tracing.End(span, err)
+	return exitCode, err
+}
+
+// run implements execute
+func (dm *Manager) run(ctx context.Context, imageID string, input map[string]interface{}, opts RunOptions, stdout, stderr io.Writer) (int, error) {
+	requestID := requestctx.ID(ctx)
+	res := dm.resources(opts)
+	network := opts.NetworkMode
+	if network == "" {
+		network = dm.config.Network
+	}
 
 	log.Info().
 		Str("request_id", requestID).
 		Str("image_id", imageID).
-		Interface("input", input).
+		Str("input_mode", opts.InputMode).
+		Str("network_mode", network).
+		Int64("memory_limit", res.memory).
+		Int64("nano_cpus", res.nanoCPUs).
+		Strs("input_keys", inputKeys(input)). // values may hold secrets from the default input
 		Msg("Running Docker container")
 
-	// Set a timeout for the run command
-	runCtx, cancel := context.WithTimeout(ctx, dm.config.RunTimeout)
-	defer cancel()
+	// Reject bad input before waiting for a slot
+	env, stdin, err := dm.containerInput(ctx, input, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	// Wait for the image's turn before taking a global slot, so executions
+	// queued behind a busy image don't hold slots other images could use
+	releaseImage, err := dm.queue.acquire(ctx, imageID)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_id", imageID).
+			Err(err).
+			Msg("No slot available for image")
+		return 0, err
+	}
+	defer releaseImage()
 
-	// Prepare Docker run command
-	dockerArgs := []string{
-		"run",
-		"--rm",
-		"--network=bridge", // Enable networking
-		"--dns=8.8.8.8",    // Explicit DNS
-		"--cap-drop=ALL",
-		"--security-opt=no-new-privileges",
-		"--memory=128m",
-		"--cpus=0.5",
+	// Hold off while the host is overloaded, before taking a slot so waiting
+	// executions don't keep others from running once the pressure drops
+	if err := dm.pressure.wait(ctx); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_id", imageID).
+			Err(err).
+			Msg("Host is overloaded")
+		return 0, err
 	}
 
-	// Add environment variables for input if provided
-	if input != nil {
-		for key, value := range input {
-			// Sanitize and pass input as environment variables
-			sanitizedKey := sanitizeEnvVar(key)
-			dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", sanitizedKey, value))
+	// Wait for a free container slot
+	if err := dm.acquireSlot(ctx); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_id", imageID).
+			Int("in_flight", dm.InFlight()).
+			Err(err).
+			Msg("No container slot available")
+		return 0, err
+	}
+	defer dm.releaseSlot()
+
+	// Set a timeout for the run
+	timeout := dm.runTimeout(opts)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	hc := dm.hostConfig(res, network)
+	if opts.InputFile != "" {
+		inputMount, target, err := inputFileMount(opts.InputFile)
+		if err != nil {
+			return 0, err
 		}
+		hc.Mounts = append(hc.Mounts, inputMount)
+		env = append(env, models.InputFileEnv+"="+target)
 	}
 
-	// Add the image ID as the final argument
-	dockerArgs = append(dockerArgs, imageID)
+	var containerID string
+	var exitCode int
+	// Warm containers are only shared by executions with the same settings
+	user := dm.config.UserFor(opts.Language)
+	key := warmKey{imageID: imageID, resources: res, network: network, user: user}
+	var warm *warmContainer
+	if opts.InputFile == "" {
+		warm = dm.warm.get(key)
+	}
+	if warm != nil {
+		// Dispatch to an idle warm container, skipping container startup
+		containerID = warm.id
+		exitCode, err = dm.execWarm(runCtx, warm, env, stdin, stdout, stderr)
+		dm.warm.release(warm, err == nil)
+	} else {
+		containerID, exitCode, err = dm.runCold(ctx, runCtx, imageID, user, hc, env, stdin, stdout, stderr)
+	}
 
-	// Create the command
-	runCmd := exec.CommandContext(runCtx, "docker", dockerArgs...)
+	// Keep warm containers ready for the next execution of this image
+	dm.warm.fill(key)
 
-	output, err := runCmd.CombinedOutput()
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
+		if ctx.Err() == context.Canceled {
+			// The caller went away; the container has been killed and removed
+			log.Warn().
+				Str("request_id", requestID).
+				Str("image_id", imageID).
+				Str("container_id", containerID).
+				Msg("Docker container execution cancelled")
+			return 0, fmt.Errorf("container execution cancelled: %w", ctx.Err())
+		}
+		if runCtx.Err() == context.DeadlineExceeded {
 			log.Error().
 				Str("request_id", requestID).
 				Str("image_id", imageID).
+				Str("container_id", containerID).
+				Int("exit_code", exitCode).
 				Msg("Docker container execution timed out")
-			return "", fmt.Errorf("container execution timed out after %s", dm.config.RunTimeout)
+			return exitCode, fmt.Errorf("%w after %s", ErrRunTimeout, timeout)
 		}
 
 		log.Error().
 			Str("request_id", requestID).
 			Str("image_id", imageID).
-			Str("output", string(output)).
+			Str("container_id", containerID).
 			Err(err).
 			Msg("Docker container execution failed")
-		return "", fmt.Errorf("container execution failed: %s", output)
+		if errors.Is(err, ErrDaemonUnavailable) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("container execution failed: %v", err)
 	}
 
-	log.Info().
+	log.Debug().
 		Str("request_id", requestID).
 		Str("image_id", imageID).
-		Int("output_length", len(output)).
-		Msg("Docker container executed successfully")
+		Str("container_id", containerID).
+		Bool("warm", warm != nil).
+		Int("exit_code", exitCode).
+		Msg("Docker container finished")
+
+	return exitCode, nil
+}
+
+// runTimeout returns the run timeout for an execution with the given options
+func (dm *Manager) runTimeout(opts RunOptions) time.Duration {
+	if opts.Timeout > 0 && opts.Timeout < dm.config.RunTimeout {
+		return opts.Timeout
+	}
+	return dm.config.RunTimeout
+}
+
+// containerInput converts execution input into either environment variables
+// or a JSON stdin payload, depending on the input mode, and adds the
+// function's own environment variables. Input over the input limits fails
+// with *InputLimitError, and input that would set a blocked variable fails
+// with *EnvError or is dropped, as the input env policy says.
+func (dm *Manager) containerInput(ctx context.Context, input map[string]interface{}, opts RunOptions) ([]string, []byte, error) {
+	if err := dm.checkInputLimits(input); err != nil {
+		return nil, nil, err
+	}
+
+	// The function's environment is set in either mode
+	vars := make(map[string]string, len(opts.Env)+len(input))
+	for key, value := range opts.Env {
+		vars[key] = value
+	}
+
+	var stdin []byte
+	if opts.InputMode == models.InputModeStdin {
+		// Pipe the input to the container's stdin as a JSON object
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		payload, err := json.Marshal(input)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode input: %v", err)
+		}
+		stdin = payload
+	} else {
+		// Sanitize and pass input as environment variables, overriding the
+		// function's own
+		inputVars, blocked := dm.inputEnv(input, opts.AllowedEnv)
+		if len(blocked) > 0 {
+			if dm.config.InputEnvPolicy != "drop" {
+				return nil, nil, &EnvError{Names: blocked}
+			}
+			log.Warn().
+				Str("request_id", requestctx.ID(ctx)).
+				Strs("variables", blocked).
+				Msg("Dropped input that would set blocked environment variables")
+		}
+		for key, value := range inputVars {
+			vars[key] = value
+		}
+	}
+
+	var env []string
+	for key, value := range vars {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	return env, stdin, nil
+}
+
+// hostConfig returns the configured isolation settings for a function
+// container, with the given resource limits and network mode
+func (dm *Manager) hostConfig(res resources, network string) *container.HostConfig {
+	hc := &container.HostConfig{
+		NetworkMode: container.NetworkMode(network),
+		SecurityOpt: []string{"no-new-privileges"},
+		Resources: container.Resources{
+			Memory:   res.memory,
+			NanoCPUs: res.nanoCPUs,
+		},
+	}
+	// Docker rejects DNS servers for containers without networking
+	if network != models.NetworkModeNone {
+		hc.DNS = dm.config.DNS
+	}
+	if dm.config.DropAllCaps {
+		hc.CapDrop = []string{"ALL"}
+	}
+	if dm.config.ReadOnlyRootfs {
+		// Functions still get scratch space for temporary files
+		hc.ReadonlyRootfs = true
+		hc.Tmpfs = map[string]string{"/tmp": "rw,noexec,nosuid,size=64m"}
+	}
+	return hc
+}
+
+// inputFileMount returns a read-only bind mount of an input file into
+// models.InputFileDir, along with the file's path inside the container
+func inputFileMount(inputFile string) (mount.Mount, string, error) {
+	source, err := filepath.Abs(inputFile)
+	if err != nil {
+		return mount.Mount{}, "", fmt.Errorf("failed to resolve input file: %v", err)
+	}
+	target := path.Join(models.InputFileDir, filepath.Base(source))
+	return mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   source,
+		Target:   target,
+		ReadOnly: true,
+	}, target, nil
+}
+
+// runCold creates a fresh container for a single execution, running as user
+// unless it is empty, and removes it afterwards. ctx is used for cleanup so removal happens even after runCtx ends.
+// When runCtx times out the container is stopped gracefully, and its output
+// is read until it exits so nothing written during the grace period is lost.
+func (dm *Manager) runCold(ctx, runCtx context.Context, imageID, user string, hc *container.HostConfig, env []string, stdin []byte, stdout, stderr io.Writer) (string, int, error) {
+	containerConfig := &container.Config{
+		Image:        imageID,
+		User:         user,
+		Env:          env,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	if stdin != nil {
+		containerConfig.AttachStdin = true
+		containerConfig.OpenStdin = true
+		containerConfig.StdinOnce = true
+	}
+
+	created, err := dm.client.ContainerCreate(runCtx, containerConfig, hc, nil, nil, "")
+	if client.IsErrConnectionFailed(err) {
+		return "", 0, dm.unavailable(err)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create container: %v", err)
+	}
+	untrack, err := dm.running.add(created.ID, imageID)
+	if err != nil {
+		dm.removeContainer(ctx, created.ID)
+		return created.ID, 0, err
+	}
+	// Stop tracking only once the container is gone, so a drain waits for removal
+	defer untrack()
+	defer dm.removeContainer(ctx, created.ID)
+
+	stop := context.AfterFunc(runCtx, func() {
+		// A cancelled caller gets the container killed by removeContainer
+		if runCtx.Err() == context.DeadlineExceeded {
+			dm.stopContainer(ctx, created.ID)
+		}
+	})
+	defer stop()
+
+	// Keep reading past the run timeout while the container is being stopped
+	deadline, _ := runCtx.Deadline()
+	waitCtx, cancel := context.WithDeadline(ctx, deadline.Add(dm.config.StopGracePeriod+stopMargin))
+	defer cancel()
+
+	exitCode, err := dm.runContainer(waitCtx, created.ID, stdin, stdout, stderr)
+	if runCtx.Err() == context.DeadlineExceeded {
+		return created.ID, exitCode, runCtx.Err()
+	}
+	return created.ID, exitCode, err
+}
+
+// stopContainer sends a container SIGTERM and, if it hasn't exited after the
+// configured grace period, SIGKILL. It uses its own timeout so it works after
+// ctx ends.
+func (dm *Manager) stopContainer(ctx context.Context, containerID string) {
+	requestID := requestctx.ID(ctx)
+
+	grace := dm.config.StopGracePeriod
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), grace+stopMargin)
+	defer cancel()
+
+	log.Warn().
+		Str("request_id", requestID).
+		Str("container_id", containerID).
+		Dur("grace_period", grace).
+		Msg("Stopping timed out container")
+
+	// Docker takes the grace period in whole seconds
+	seconds := int((grace + time.Second - 1) / time.Second)
+	err := dm.client.ContainerStop(stopCtx, containerID, container.StopOptions{Timeout: &seconds})
+	if err != nil && !errdefs.IsNotFound(err) {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("container_id", containerID).
+			Err(err).
+			Msg("Failed to stop container")
+	}
+}
 
-	return string(output), nil
+// runContainer attaches to a created container, starts it, feeds it stdin if
+// provided and copies its output to stdout and stderr until it exits
+func (dm *Manager) runContainer(ctx context.Context, containerID string, stdin []byte, stdout, stderr io.Writer) (int, error) {
+	// Attach before starting so no output is missed
+	attached, err := dm.client.ContainerAttach(ctx, containerID, container.AttachOptions{
+		Stream: true,
+		Stdin:  stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to container: %v", err)
+	}
+	defer attached.Close()
+
+	// Register the wait before starting so a fast exit isn't missed
+	statusCh, errCh := dm.client.ContainerWait(ctx, containerID, container.WaitConditionNextExit)
+
+	if err := dm.client.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return 0, fmt.Errorf("failed to start container: %v", err)
+	}
+
+	if stdin != nil {
+		go func() {
+			attached.Conn.Write(stdin)
+			attached.CloseWrite()
+		}()
+	}
+
+	if err := copyOutput(ctx, attached, stdout, stderr); err != nil {
+		return 0, err
+	}
+
+	select {
+	case status := <-statusCh:
+		if status.Error != nil {
+			return 0, errors.New(status.Error.Message)
+		}
+		return int(status.StatusCode), nil
+	case err := <-errCh:
+		return 0, err
+	}
+}
+
+// copyOutput demultiplexes an attached stream into stdout and stderr until it
+// ends, giving up when ctx is done
+func copyOutput(ctx context.Context, attached types.HijackedResponse, stdout, stderr io.Writer) error {
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, attached.Reader)
+		copied <- err
+	}()
+
+	select {
+	case err := <-copied:
+		if err != nil {
+			return fmt.Errorf("failed to read container output: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		// Closing the connection unblocks the copy
+		attached.Close()
+		<-copied
+		return ctx.Err()
+	}
+}
+
+// removeContainer force-removes a container, killing it if still running. It
+// uses its own timeout so cleanup still happens after ctx is cancelled.
+func (dm *Manager) removeContainer(ctx context.Context, containerID string) {
+	requestID := requestctx.ID(ctx)
+
+	removeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	err := dm.client.ContainerRemove(removeCtx, containerID, container.RemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("container_id", containerID).
+			Err(err).
+			Msg("Failed to remove container")
+	}
+}
+
+// envValue converts an input value to its environment variable form. Strings
+// are passed as-is and everything else is JSON-encoded.
+func envValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
 }
 
 // sanitizeEnvVar ensures environment variable names are valid
@@ -211,20 +1197,14 @@ func sanitizeEnvVar(name string) string {
 	return strings.ToUpper(replacer.Replace(name))
 }
 
-// LoadTemplate loads a Dockerfile template for the specified language
+// LoadTemplate loads a Dockerfile template for the specified language from
+// the configured templates directory or, if it has none for the language,
+// from the defaults embedded in the binary
 func (dm *Manager) LoadTemplate(ctx context.Context, language string) (*Template, error) {
-	requestID, _ := ctx.Value("requestID").(string)
-
-	// Determine the path to the template file
-	templateFile := fmt.Sprintf("templates/%s.yaml", language)
-
-	log.Debug().
-		Str("request_id", requestID).
-		Str("template_file", templateFile).
-		Msg("Loading template file")
+	requestID := requestctx.ID(ctx)
 
 	// Read the template file
-	data, err := os.ReadFile(templateFile)
+	data, templateFile, err := dm.readTemplate(language)
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
@@ -246,48 +1226,173 @@ func (dm *Manager) LoadTemplate(ctx context.Context, language string) (*Template
 		return nil, fmt.Errorf("failed to unmarshal template: %v", err)
 	}
 
+	log.Debug().
+		Str("request_id", requestID).
+		Str("template_file", templateFile).
+		Msg("Loaded template file")
+
 	return &template, nil
 }
 
-// ExtractImageID extracts the Docker image ID from build output
-func (dm *Manager) ExtractImageID(output string) (string, error) {
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "writing image sha256:") {
-			parts := strings.Fields(line)
-			for _, part := range parts {
-				if strings.HasPrefix(part, "sha256:") {
-					return part, nil
-				}
-			}
+// LoadTemplates loads the template of every language that may be built, so a
+// missing or malformed template is found before any build needs it
+func (dm *Manager) LoadTemplates(ctx context.Context) error {
+	languages := dm.config.Languages
+	if len(languages) == 0 {
+		languages = config.SupportedLanguages
+	}
+	for _, language := range languages {
+		if _, err := dm.LoadTemplate(ctx, language); err != nil {
+			return fmt.Errorf("%s: %v", language, err)
 		}
 	}
-	return "", fmt.Errorf("image ID not found in build output")
+	return nil
 }
 
-// CleanupImages removes unused Docker images to free up space
-func (dm *Manager) CleanupImages(ctx context.Context) error {
-	requestID, _ := ctx.Value("requestID").(string)
+// readTemplate returns the contents of the language's template and where it
+// was read from
+func (dm *Manager) readTemplate(language string) ([]byte, string, error) {
+	name := language + ".yaml"
 
-	log.Info().
-		Str("request_id", requestID).
-		Msg("Cleaning up unused Docker images")
+	if dm.config.TemplatesDir != "" {
+		path := filepath.Join(dm.config.TemplatesDir, name)
+		data, err := os.ReadFile(path)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return data, path, err
+		}
+	}
+
+	data, err := templates.FS.ReadFile(name)
+	return data, "embedded:" + name, err
+}
 
-	cmd := exec.CommandContext(ctx, "docker", "image", "prune", "-f")
-	output, err := cmd.CombinedOutput()
+// RemoveImage removes a Docker image by ID or tag
+func (dm *Manager) RemoveImage(ctx context.Context, imageID string) error {
+	requestID := requestctx.ID(ctx)
+
+	// Warm containers would keep the image in use
+	dm.warm.evict(imageID)
+
+	_, err := dm.client.ImageRemove(ctx, imageID, image.RemoveOptions{PruneChildren: true})
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
-			Str("output", string(output)).
+			Str("image_id", imageID).
 			Err(err).
-			Msg("Failed to clean up Docker images")
-		return fmt.Errorf("failed to clean up Docker images: %s", output)
+			Msg("Failed to remove Docker image")
+		return fmt.Errorf("failed to remove Docker image: %v", err)
 	}
 
 	log.Info().
 		Str("request_id", requestID).
-		Str("output", string(output)).
-		Msg("Docker images cleaned up")
+		Str("image_id", imageID).
+		Msg("Docker image removed")
 
 	return nil
 }
+
+// ImageExists reports whether an image ID or tag is present in the Docker daemon
+func (dm *Manager) ImageExists(ctx context.Context, imageID string) (bool, error) {
+	if _, _, err := dm.client.ImageInspectWithRaw(ctx, imageID); err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to inspect image: %v", err)
+	}
+	return true, nil
+}
+
+// ListImages returns the images built by the platform, identified by the
+// configured image prefix
+func (dm *Manager) ListImages(ctx context.Context) ([]image.Summary, error) {
+	images, err := dm.client.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", dm.config.ImagePrefix)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	return images, nil
+}
+
+// CleanupImages removes images built by the platform that no function uses,
+// such as dangling images left by redeploys and images of failed updates.
+// Images in inUse, by ID or tag, images newer than imageCleanupGrace and
+// images that containers still use are kept.
+func (dm *Manager) CleanupImages(ctx context.Context, inUse map[string]bool) (models.ImageCleanupReport, error) {
+	requestID := requestctx.ID(ctx)
+	report := models.ImageCleanupReport{RemovedImages: []string{}}
+
+	log.Info().
+		Str("request_id", requestID).
+		Msg("Cleaning up unused Docker images")
+
+	// Labelled images include dangling ones; the prefix finds images built
+	// before builds were labelled
+	labelled, err := dm.client.ImageList(ctx, image.ListOptions{
+		Filters:    filters.NewArgs(filters.Arg("label", ManagedLabel)),
+		SharedSize: true,
+	})
+	if client.IsErrConnectionFailed(err) {
+		return report, dm.unavailable(err)
+	}
+	if err != nil {
+		return report, fmt.Errorf("failed to list images: %v", err)
+	}
+	prefixed, err := dm.ListImages(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-imageCleanupGrace).Unix()
+	seen := make(map[string]bool)
+	for _, summary := range append(labelled, prefixed...) {
+		if seen[summary.ID] {
+			continue
+		}
+		seen[summary.ID] = true
+
+		used := inUse[summary.ID]
+		for _, tag := range summary.RepoTags {
+			used = used || inUse[tag]
+		}
+		if used || summary.Created > cutoff {
+			continue
+		}
+
+		// Removing by ID fails rather than untagging when a container uses
+		// the image, so such images are skipped
+		dm.warm.evict(summary.ID)
+		if _, err := dm.client.ImageRemove(ctx, summary.ID, image.RemoveOptions{PruneChildren: true}); err != nil {
+			if errdefs.IsConflict(err) || errdefs.IsNotFound(err) {
+				log.Debug().
+					Str("request_id", requestID).
+					Str("image_id", summary.ID).
+					Err(err).
+					Msg("Skipped image during cleanup")
+				continue
+			}
+			log.Error().
+				Str("request_id", requestID).
+				Str("image_id", summary.ID).
+				Err(err).
+				Msg("Failed to clean up Docker image")
+			return report, fmt.Errorf("failed to remove image %s: %v", summary.ID, err)
+		}
+
+		report.RemovedImages = append(report.RemovedImages, summary.ID)
+		// Layers shared with other images stay on disk
+		reclaimed := summary.Size
+		if summary.SharedSize > 0 {
+			reclaimed -= summary.SharedSize
+		}
+		report.SpaceReclaimed += reclaimed
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("images_deleted", len(report.RemovedImages)).
+		Int64("space_reclaimed", report.SpaceReclaimed).
+		Msg("Docker images cleaned up")
+
+	return report, nil
+}
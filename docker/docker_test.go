@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+)
+
+// newTestManager creates a Manager with the default configuration, talking
+// to the fake daemon
+func newTestManager(t testing.TB, daemon *dockertest.Daemon, configure func(*config.DockerConfig)) *Manager {
+	t.Helper()
+
+	cfg := config.LoadConfig().Docker
+	cfg.Host = daemon.Host()
+	if configure != nil {
+		configure(&cfg)
+	}
+	dm, err := NewDockerManager(&cfg)
+	if err != nil {
+		t.Fatalf("NewDockerManager: %v", err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	return dm
+}
+
+func TestRunDockerContainerKillsContainerWhenCancelled(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	started := make(chan string, 1)
+	killed := make(chan string, 1)
+	daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		started <- p.ContainerID
+		<-ctx.Done()
+		killed <- p.ContainerID
+		return dockertest.Result{}
+	}
+	dm := newTestManager(t, daemon, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		_, err := dm.RunDockerContainer(ctx, "image", nil, RunOptions{})
+		result <- err
+	}()
+
+	var containerID string
+	select {
+	case containerID = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("container never started")
+	}
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RunDockerContainer error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunDockerContainer did not return after cancellation")
+	}
+
+	// The process must be killed rather than left to run to its timeout
+	select {
+	case id := <-killed:
+		if id != containerID {
+			t.Errorf("killed container %s, want %s", id, containerID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("container process was not terminated")
+	}
+
+	removed := daemon.Removed()
+	if len(removed) != 1 || removed[0] != containerID {
+		t.Errorf("removed containers = %v, want [%s]", removed, containerID)
+	}
+	if daemon.Running() != 0 {
+		t.Errorf("%d containers left behind", daemon.Running())
+	}
+}
+
+func TestRunDockerContainerRemovesContainerAfterRun(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		return dockertest.Result{Stdout: "hello\n", Stderr: "warning\n"}
+	}
+	dm := newTestManager(t, daemon, nil)
+
+	result, err := dm.RunDockerContainer(context.Background(), "image", nil, RunOptions{})
+	if err != nil {
+		t.Fatalf("RunDockerContainer: %v", err)
+	}
+	if result.Stdout != "hello\n" || result.Stderr != "warning\n" {
+		t.Errorf("output = %q, %q; want %q, %q", result.Stdout, result.Stderr, "hello\n", "warning\n")
+	}
+	if daemon.Running() != 0 {
+		t.Errorf("%d containers left behind", daemon.Running())
+	}
+}
+
+func TestRunDockerContainerLogsInputKeysOnly(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	dm := newTestManager(t, dockertest.NewDaemon(t), nil)
+	input := map[string]interface{}{"name": "Alice", "api_key": "s3cret-default"}
+	for _, mode := range []string{"env", "stdin"} {
+		if _, err := dm.RunDockerContainer(context.Background(), "image", input, RunOptions{InputMode: mode}); err != nil {
+			t.Fatalf("RunDockerContainer(%s): %v", mode, err)
+		}
+	}
+
+	if strings.Contains(logs.String(), "s3cret-default") || strings.Contains(logs.String(), "Alice") {
+		t.Errorf("input values logged:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), `"input_keys":["api_key","name"]`) {
+		t.Errorf("input keys not logged:\n%s", logs.String())
+	}
+}
+
+func TestVerify(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	dm := newTestManager(t, daemon, nil)
+	if err := dm.Verify(context.Background()); err != nil {
+		t.Fatalf("Verify with a running daemon: %v", err)
+	}
+
+	for name, host := range map[string]string{
+		"stopped daemon": "",
+		"missing socket": "unix://" + filepath.Join(t.TempDir(), "docker.sock"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			stopped := dockertest.NewDaemon(t)
+			dm := newTestManager(t, stopped, func(cfg *config.DockerConfig) {
+				if host != "" {
+					cfg.Host = host
+				}
+			})
+			stopped.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := dm.Verify(ctx)
+			if !errors.Is(err, ErrDaemonUnavailable) {
+				t.Fatalf("Verify = %v, want ErrDaemonUnavailable", err)
+			}
+			if !strings.Contains(err.Error(), "DOCKER_HOST") {
+				t.Errorf("error %q doesn't say how to fix it", err)
+			}
+		})
+	}
+}
+
+func TestRunDockerContainerWithoutDaemon(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	dm := newTestManager(t, daemon, nil)
+	daemon.Close()
+
+	if _, err := dm.RunDockerContainer(context.Background(), "image", nil, RunOptions{}); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Errorf("RunDockerContainer = %v, want ErrDaemonUnavailable", err)
+	}
+}
+
+func TestBuildDockerImageMergesConcurrentIdenticalBuilds(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	daemon.OnBuild = func(b dockertest.Build) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+	dm := newTestManager(t, daemon, nil)
+	runtimeVersion, err := dm.config.RuntimeVersions.Resolve("python", "")
+	if err != nil {
+		t.Fatalf("failed to resolve the python version: %v", err)
+	}
+
+	// Each build has its own copy of the code, as separate submissions do
+	build := func(code string) (*BuildResult, error) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "main.py"), []byte(code), 0644); err != nil {
+			return nil, err
+		}
+		return dm.BuildDockerImage(context.Background(), "", dir, "python", "main.py", runtimeVersion, nil)
+	}
+
+	const builds = 8
+	results := make(chan *BuildResult, builds)
+	errs := make(chan error, builds)
+	for i := 0; i < builds; i++ {
+		go func() {
+			result, err := build("print('hello')\n")
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- result
+		}()
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no build started")
+	}
+	// Give the other callers time to join the build in progress
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+
+	var imageID string
+	for i := 0; i < builds; i++ {
+		select {
+		case result := <-results:
+			if imageID == "" {
+				imageID = result.ImageID
+			}
+			if result.ImageID != imageID {
+				t.Errorf("image %s, want %s", result.ImageID, imageID)
+			}
+			if !result.Shared {
+				t.Error("merged build not marked as shared")
+			}
+		case err := <-errs:
+			t.Fatalf("BuildDockerImage: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("build never finished")
+		}
+	}
+	if n := len(daemon.Builds()); n != 1 {
+		t.Fatalf("daemon got %d builds, want 1", n)
+	}
+
+	// Different code is built separately
+	result, err := build("print('goodbye')\n")
+	if err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+	if result.Shared || result.ImageID == imageID {
+		t.Errorf("different code got image %s (shared %t)", result.ImageID, result.Shared)
+	}
+	if n := len(daemon.Builds()); n != 2 {
+		t.Errorf("daemon got %d builds, want 2", n)
+	}
+}
@@ -0,0 +1,537 @@
+// Package dockertest provides a fake Docker daemon for testing code that
+// drives the Docker Engine API, without needing Docker installed
+package dockertest
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// APIVersion is the Engine API version the daemon reports
+const APIVersion = "1.47"
+
+// versionPrefix matches the API version at the start of a request path
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// Result is the outcome of running a container or exec
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Process is a container or exec being run by the daemon
+type Process struct {
+	ContainerID string
+	Image       string
+	Entrypoint  []string // set when the container overrides the image's
+	Env         []string
+	Stdin       []byte // read to EOF before Run is called, when stdin is attached
+	Exec        bool   // run with docker exec in a running container
+}
+
+// Build is an image build received by the daemon
+type Build struct {
+	Tag        string
+	Dockerfile string
+	Files      map[string]string // build context, by path
+}
+
+// Daemon is a fake Docker daemon serving the parts of the Engine API the
+// platform uses. Containers run by calling Run, which is stopped through its
+// context when the container is stopped or removed. It is safe for
+// concurrent use.
+type Daemon struct {
+	server *httptest.Server
+
+	// Run runs a container or exec; by default it succeeds with no output.
+	// It must return once ctx is done.
+	Run func(ctx context.Context, p Process) Result
+	// OnBuild is called for each build before it answers, so tests can hold
+	// a build back or fail it by returning an error
+	OnBuild func(b Build) error
+
+	mutex      sync.Mutex
+	builds     []Build
+	containers map[string]*fakeContainer
+	execs      map[string]*fakeExec
+	removed    []string // container IDs, in order of removal
+	images     map[string]bool
+	removedImg []string
+	nextID     int
+}
+
+// fakeContainer is a created container
+type fakeContainer struct {
+	id      string
+	config  container.Config
+	started chan struct{}
+	stdin   chan []byte // stdin read from the attach stream
+	ctx     context.Context
+	stop    context.CancelFunc // stops Run
+	exited  chan struct{}
+	once    sync.Once
+	result  Result
+	code    int
+}
+
+// fakeExec is a created exec
+type fakeExec struct {
+	id        string
+	container string
+	config    container.ExecOptions
+	code      int
+}
+
+// NewDaemon starts a fake daemon that is shut down when the test ends
+func NewDaemon(t testing.TB) *Daemon {
+	d := &Daemon{
+		containers: make(map[string]*fakeContainer),
+		execs:      make(map[string]*fakeExec),
+		images:     make(map[string]bool),
+	}
+	d.server = httptest.NewServer(http.HandlerFunc(d.serve))
+	t.Cleanup(d.Close)
+	return d
+}
+
+// Host returns the daemon's address, for DOCKER_HOST or DockerConfig.Host
+func (d *Daemon) Host() string {
+	return "tcp://" + d.server.Listener.Addr().String()
+}
+
+// Close stops every container and shuts the daemon down
+func (d *Daemon) Close() {
+	d.mutex.Lock()
+	for _, c := range d.containers {
+		c.stop()
+	}
+	d.mutex.Unlock()
+	d.server.CloseClientConnections()
+	d.server.Close()
+}
+
+// Builds returns the builds the daemon has received
+func (d *Daemon) Builds() []Build {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]Build(nil), d.builds...)
+}
+
+// Removed returns the IDs of the containers removed so far
+func (d *Daemon) Removed() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.removed...)
+}
+
+// RemovedImages returns the IDs of the images removed so far
+func (d *Daemon) RemovedImages() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.removedImg...)
+}
+
+// Running returns the number of containers that have not been removed
+func (d *Daemon) Running() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.containers)
+}
+
+// AddImage makes an image known to the daemon, as if it had been built
+func (d *Daemon) AddImage(imageID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.images[imageID] = true
+}
+
+// serve routes a request to its endpoint
+func (d *Daemon) serve(w http.ResponseWriter, r *http.Request) {
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "/_ping":
+		w.Header().Set("Api-Version", APIVersion)
+		w.Header().Set("Ostype", "linux")
+		w.Write([]byte("OK"))
+	case r.Method == http.MethodPost && path == "/build":
+		d.build(w, r)
+	case r.Method == http.MethodPost && path == "/containers/create":
+		d.createContainer(w, r)
+	case len(parts) == 2 && parts[0] == "containers" && r.Method == http.MethodDelete:
+		d.removeContainer(w, parts[1])
+	case len(parts) == 3 && parts[0] == "containers" && r.Method == http.MethodPost:
+		d.containerAction(w, r, parts[1], parts[2])
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "start":
+		d.startExec(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "json":
+		d.inspectExec(w, parts[1])
+	case path == "/images/json":
+		writeJSON(w, http.StatusOK, []struct{}{})
+	case len(parts) >= 2 && parts[0] == "images" && r.Method == http.MethodDelete:
+		d.removeImage(w, strings.Join(parts[1:], "/"))
+	case len(parts) >= 3 && parts[0] == "images" && parts[len(parts)-1] == "json":
+		d.inspectImage(w, strings.Join(parts[1:len(parts)-1], "/"))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("fake daemon does not serve %s %s", r.Method, path))
+	}
+}
+
+// build reads a build context and answers with a classic build's stream
+func (d *Daemon) build(w http.ResponseWriter, r *http.Request) {
+	b := Build{Tag: r.URL.Query().Get("t"), Files: make(map[string]string)}
+	archive := tar.NewReader(r.Body)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		data, _ := io.ReadAll(archive)
+		b.Files[header.Name] = string(data)
+	}
+	b.Dockerfile = b.Files["Dockerfile"]
+
+	d.mutex.Lock()
+	d.builds = append(d.builds, b)
+	d.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.Encode(map[string]string{"stream": "Step 1/1 : FROM scratch\n"})
+	if d.OnBuild != nil {
+		if err := d.OnBuild(b); err != nil {
+			encoder.Encode(map[string]interface{}{"errorDetail": map[string]string{"message": err.Error()}, "error": err.Error()})
+			return
+		}
+	}
+
+	// Identical contexts build identical images, as with a real daemon's cache
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, b.Files[name])
+	}
+	imageID := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	d.AddImage(imageID)
+	encoder.Encode(map[string]interface{}{"aux": map[string]string{"ID": imageID}})
+	encoder.Encode(map[string]string{"stream": "Successfully built " + imageID[7:19] + "\n"})
+}
+
+// createContainer creates a container that runs once it is started
+func (d *Daemon) createContainer(w http.ResponseWriter, r *http.Request) {
+	var config container.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	d.mutex.Lock()
+	d.nextID++
+	ctx, stop := context.WithCancel(context.Background())
+	c := &fakeContainer{
+		id:      fmt.Sprintf("container%04d", d.nextID),
+		config:  config,
+		started: make(chan struct{}),
+		stdin:   make(chan []byte, 1),
+		ctx:     ctx,
+		stop:    stop,
+		exited:  make(chan struct{}),
+	}
+	d.containers[c.id] = c
+	d.mutex.Unlock()
+
+	writeJSON(w, http.StatusCreated, container.CreateResponse{ID: c.id})
+}
+
+// containerAction serves the endpoints of a single container
+func (d *Daemon) containerAction(w http.ResponseWriter, r *http.Request, id, action string) {
+	d.mutex.Lock()
+	c, ok := d.containers[id]
+	d.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+
+	switch action {
+	case "attach":
+		d.attach(w, r, c)
+	case "start":
+		select {
+		case <-c.started:
+		default:
+			close(c.started)
+			go d.runContainer(c)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "wait":
+		// Headers go out straight away, as the client waits for them before
+		// starting the container
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-c.exited:
+			json.NewEncoder(w).Encode(container.WaitResponse{StatusCode: int64(c.code)})
+		case <-r.Context().Done():
+		}
+	case "stop", "kill":
+		c.stop()
+		w.WriteHeader(http.StatusNoContent)
+	case "exec":
+		var config container.ExecOptions
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		d.mutex.Lock()
+		d.nextID++
+		exec := &fakeExec{id: fmt.Sprintf("exec%04d", d.nextID), container: id, config: config}
+		d.execs[exec.id] = exec
+		d.mutex.Unlock()
+		writeJSON(w, http.StatusCreated, map[string]string{"Id": exec.id})
+	default:
+		writeError(w, http.StatusNotFound, "fake daemon does not serve container "+action)
+	}
+}
+
+// runContainer runs a started container through Run and marks it exited
+func (d *Daemon) runContainer(c *fakeContainer) {
+	var stdin []byte
+	if c.config.OpenStdin {
+		select {
+		case stdin = <-c.stdin:
+		case <-c.ctx.Done():
+		}
+	}
+	result := d.run(c.ctx, Process{
+		ContainerID: c.id,
+		Image:       c.config.Image,
+		Entrypoint:  c.config.Entrypoint,
+		Env:         c.config.Env,
+		Stdin:       stdin,
+	})
+	if c.ctx.Err() != nil && result.ExitCode == 0 {
+		result.ExitCode = 137
+	}
+	c.exit(result)
+}
+
+// exit records the container's result and marks it exited, once
+func (c *fakeContainer) exit(result Result) {
+	c.once.Do(func() {
+		c.result = result
+		c.code = result.ExitCode
+		close(c.exited)
+	})
+}
+
+// run calls Run, or succeeds with no output if it is unset
+func (d *Daemon) run(ctx context.Context, p Process) Result {
+	if d.Run == nil {
+		return Result{}
+	}
+	return d.Run(ctx, p)
+}
+
+// attach hijacks the connection and streams the container's output once it
+// exits, reading its stdin if it was created with stdin open
+func (d *Daemon) attach(w http.ResponseWriter, r *http.Request, c *fakeContainer) {
+	conn, buffered, err := hijack(w)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if c.config.OpenStdin {
+		go func() {
+			data, _ := io.ReadAll(buffered)
+			c.stdin <- data
+		}()
+	}
+
+	select {
+	case <-c.exited:
+		writeFrames(conn, c.result)
+	case <-c.ctx.Done():
+		// Killed before it exited; the stream just ends
+		<-c.exited
+	}
+}
+
+// removeContainer force-removes a container, stopping it first
+func (d *Daemon) removeContainer(w http.ResponseWriter, id string) {
+	d.mutex.Lock()
+	c, ok := d.containers[id]
+	if ok {
+		delete(d.containers, id)
+		d.removed = append(d.removed, id)
+	}
+	d.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	c.stop()
+	select {
+	case <-c.started:
+	default:
+		// Never started, so nothing else will mark it exited
+		c.exit(Result{ExitCode: 137})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startExec hijacks the connection and runs the exec through Run
+func (d *Daemon) startExec(w http.ResponseWriter, r *http.Request, id string) {
+	d.mutex.Lock()
+	exec, ok := d.execs[id]
+	var c *fakeContainer
+	if ok {
+		c = d.containers[exec.container]
+	}
+	d.mutex.Unlock()
+	if !ok || c == nil {
+		writeError(w, http.StatusNotFound, "No such exec instance: "+id)
+		return
+	}
+
+	conn, buffered, err := hijack(w)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var stdin []byte
+	if exec.config.AttachStdin {
+		stdin, _ = io.ReadAll(buffered)
+	}
+	result := d.run(c.ctx, Process{
+		ContainerID: c.id,
+		Image:       c.config.Image,
+		Env:         exec.config.Env,
+		Stdin:       stdin,
+		Exec:        true,
+	})
+	d.mutex.Lock()
+	exec.code = result.ExitCode
+	d.mutex.Unlock()
+	writeFrames(conn, result)
+}
+
+// inspectExec reports an exec's exit code
+func (d *Daemon) inspectExec(w http.ResponseWriter, id string) {
+	d.mutex.Lock()
+	exec, ok := d.execs[id]
+	var code int
+	if ok {
+		code = exec.code
+	}
+	d.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such exec instance: "+id)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ID": id, "ExitCode": code})
+}
+
+// inspectImage reports an image known to the daemon
+func (d *Daemon) inspectImage(w http.ResponseWriter, imageID string) {
+	d.mutex.Lock()
+	ok := d.images[imageID]
+	d.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such image: "+imageID)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"Id":     imageID,
+		"Config": map[string]interface{}{"Cmd": []string{"/app/wrapper.sh"}},
+	})
+}
+
+// removeImage removes an image known to the daemon
+func (d *Daemon) removeImage(w http.ResponseWriter, imageID string) {
+	d.mutex.Lock()
+	ok := d.images[imageID]
+	if ok {
+		delete(d.images, imageID)
+		d.removedImg = append(d.removedImg, imageID)
+	}
+	d.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such image: "+imageID)
+		return
+	}
+	writeJSON(w, http.StatusOK, []map[string]string{{"Deleted": imageID}})
+}
+
+// hijack takes over the connection of an attach or exec start request, as
+// the daemon does, returning it along with anything the client has sent
+// after the request
+func hijack(w http.ResponseWriter) (net.Conn, io.Reader, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\n"+
+		"Content-Type: application/vnd.docker.multiplexed-stream\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: tcp\r\n\r\n")
+	return conn, bufio.NewReader(io.MultiReader(rw.Reader, conn)), nil
+}
+
+// writeFrames writes output in the multiplexed stream format
+func writeFrames(w io.Writer, result Result) {
+	for stream, data := range []string{1: result.Stdout, 2: result.Stderr} {
+		if data == "" {
+			continue
+		}
+		header := make([]byte, 8)
+		header[0] = byte(stream)
+		binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+		w.Write(header)
+		w.Write([]byte(data))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
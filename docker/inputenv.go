@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"youtube_serverless/models"
+)
+
+// EnvError is returned when execution input would set environment variables
+// that are blocked, or that the function doesn't allow
+type EnvError struct {
+	Names []string
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("input may not set the environment variables %s", strings.Join(e.Names, ", "))
+}
+
+// InputLimitError is returned when execution input exceeds the configured
+// limits on keys, lengths or total size
+type InputLimitError struct {
+	Violations []string
+}
+
+func (e *InputLimitError) Error() string {
+	return fmt.Sprintf("input exceeds limits: %s", strings.Join(e.Violations, "; "))
+}
+
+// CheckInputEnv returns *InputLimitError if input exceeds the input limits,
+// in either input mode, and *EnvError if input delivered as environment
+// variables would set variables that are blocked or that opts doesn't allow
+// and the input env policy rejects them rather than dropping them
+func (dm *Manager) CheckInputEnv(input map[string]interface{}, opts RunOptions) error {
+	if err := dm.checkInputLimits(input); err != nil {
+		return err
+	}
+	if opts.InputMode == models.InputModeStdin || dm.config.InputEnvPolicy == "drop" {
+		return nil
+	}
+	if _, blocked := dm.inputEnv(input, opts.AllowedEnv); len(blocked) > 0 {
+		return &EnvError{Names: blocked}
+	}
+	return nil
+}
+
+// maxInputViolations caps the violations an InputLimitError lists, so input
+// with thousands of oversized values doesn't get an error as large
+const maxInputViolations = 10
+
+// checkInputLimits returns *InputLimitError if input, as the environment
+// variables it becomes, has too many keys, a key or value that is too long,
+// or too many bytes in total. Lengths are measured after sanitizing keys and
+// encoding values, since that is what reaches the container. Input piped on
+// stdin is measured the same way, so the limits don't depend on the mode.
+func (dm *Manager) checkInputLimits(input map[string]interface{}) error {
+	cfg := dm.config
+	var violations []string
+	if cfg.MaxInputKeys > 0 && len(input) > cfg.MaxInputKeys {
+		violations = append(violations, fmt.Sprintf("%d keys exceed the limit of %d", len(input), cfg.MaxInputKeys))
+	}
+
+	total := 0
+	for _, key := range inputKeys(input) {
+		name := sanitizeEnvVar(key)
+		value := envValue(input[key])
+		// Each variable is passed as NAME=value
+		total += len(name) + 1 + len(value)
+
+		if len(violations) >= maxInputViolations {
+			continue
+		}
+		if cfg.MaxInputKeyLength > 0 && len(name) > cfg.MaxInputKeyLength {
+			violations = append(violations, fmt.Sprintf("key starting %q is %d bytes, over the limit of %d", name[:min(len(name), 32)], len(name), cfg.MaxInputKeyLength))
+		}
+		if cfg.MaxInputValueLength > 0 && len(value) > cfg.MaxInputValueLength {
+			violations = append(violations, fmt.Sprintf("value of %s is %d bytes, over the limit of %d", name, len(value), cfg.MaxInputValueLength))
+		}
+	}
+	if cfg.MaxInputBytes > 0 && total > cfg.MaxInputBytes {
+		violations = append(violations, fmt.Sprintf("input is %d bytes, over the limit of %d", total, cfg.MaxInputBytes))
+	}
+
+	if len(violations) > 0 {
+		return &InputLimitError{Violations: violations}
+	}
+	return nil
+}
+
+// inputEnv converts execution input to environment variables. Variables the
+// configured blocklist matches, or that allowed doesn't list when it isn't
+// empty, are left out and returned, sorted.
+func (dm *Manager) inputEnv(input map[string]interface{}, allowed []string) (map[string]string, []string) {
+	vars := make(map[string]string, len(input))
+	var blocked []string
+	for key, value := range input {
+		name := sanitizeEnvVar(key)
+		if matchEnv(name, dm.config.BlockedInputEnv) || (len(allowed) > 0 && !matchEnv(name, allowed)) {
+			blocked = append(blocked, name)
+			continue
+		}
+		vars[name] = envValue(value)
+	}
+	sort.Strings(blocked)
+	return vars, blocked
+}
+
+// matchEnv reports whether name matches one of the patterns, where a pattern
+// ending in * matches any name it is a prefix of. Patterns are sanitized like
+// input keys, so they may be written as the keys are.
+func matchEnv(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = sanitizeEnvVar(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// inputKeys returns the keys of the input, sorted
+func inputKeys(input map[string]interface{}) []string {
+	keys := make([]string, 0, len(input))
+	for key := range input {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
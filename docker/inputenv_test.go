@@ -0,0 +1,212 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+	"youtube_serverless/models"
+)
+
+func TestContainerInputMergesFunctionEnv(t *testing.T) {
+	dm := newTestManager(t, dockertest.NewDaemon(t), nil)
+	functionEnv := map[string]string{"API_KEY": "stored", "REGION": "eu"}
+
+	tests := []struct {
+		name      string
+		inputMode string
+		input     map[string]interface{}
+		env       []string
+		stdin     string
+	}{
+		{
+			name:  "call input overrides the function's env",
+			input: map[string]interface{}{"api_key": "call", "name": "Alice"},
+			env:   []string{"API_KEY=call", "NAME=Alice", "REGION=eu"},
+		},
+		{
+			name: "function env without input",
+			env:  []string{"API_KEY=stored", "REGION=eu"},
+		},
+		{
+			name:      "stdin input leaves the function env alone",
+			inputMode: models.InputModeStdin,
+			input:     map[string]interface{}{"api_key": "call"},
+			env:       []string{"API_KEY=stored", "REGION=eu"},
+			stdin:     `{"api_key":"call"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, stdin, err := dm.containerInput(context.Background(), tt.input, RunOptions{InputMode: tt.inputMode, Env: functionEnv})
+			if err != nil {
+				t.Fatalf("containerInput: %v", err)
+			}
+			sort.Strings(env)
+			if !reflect.DeepEqual(env, tt.env) {
+				t.Errorf("env = %q, want %q", env, tt.env)
+			}
+			if string(stdin) != tt.stdin {
+				t.Errorf("stdin = %q, want %q", stdin, tt.stdin)
+			}
+		})
+	}
+
+	if functionEnv["API_KEY"] != "stored" {
+		t.Error("merging modified the function's env")
+	}
+}
+
+func TestRunDockerContainerSetsFunctionEnv(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	envs := make(chan []string, 1)
+	daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		envs <- p.Env
+		return dockertest.Result{}
+	}
+	dm := newTestManager(t, daemon, nil)
+
+	input := map[string]interface{}{"API_KEY": "call"}
+	if _, err := dm.RunDockerContainer(context.Background(), "image", input, RunOptions{Env: map[string]string{"API_KEY": "stored", "TOKEN": "secret"}}); err != nil {
+		t.Fatalf("RunDockerContainer: %v", err)
+	}
+
+	env := <-envs
+	sort.Strings(env)
+	if want := []string{"API_KEY=call", "TOKEN=secret"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("container env = %q, want %q", env, want)
+	}
+}
+
+func TestContainerInputBlocksLDPreloadInjection(t *testing.T) {
+	input := map[string]interface{}{"ld-preload": "/tmp/evil.so", "name": "Alice"}
+
+	tests := []struct {
+		name       string
+		policy     string
+		allowedEnv []string
+		env        []string // nil when the input is rejected
+	}{
+		{name: "strict mode rejects it", policy: "reject"},
+		{name: "lenient mode drops it", policy: "drop", env: []string{"NAME=Alice"}},
+		{name: "an allowlist doesn't unblock it", policy: "reject", allowedEnv: []string{"NAME", "LD_PRELOAD"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+				cfg.InputEnvPolicy = tt.policy
+			})
+			opts := RunOptions{AllowedEnv: tt.allowedEnv}
+
+			checkErr := dm.CheckInputEnv(input, opts)
+			env, _, err := dm.containerInput(context.Background(), input, opts)
+			if tt.env == nil {
+				for _, err := range []error{checkErr, err} {
+					var envErr *EnvError
+					if !errors.As(err, &envErr) || !reflect.DeepEqual(envErr.Names, []string{"LD_PRELOAD"}) {
+						t.Errorf("error = %v, want an EnvError naming LD_PRELOAD", err)
+					}
+				}
+				return
+			}
+			if checkErr != nil || err != nil {
+				t.Fatalf("CheckInputEnv = %v, containerInput = %v; want no errors", checkErr, err)
+			}
+			sort.Strings(env)
+			if !reflect.DeepEqual(env, tt.env) {
+				t.Errorf("env = %q, want %q", env, tt.env)
+			}
+		})
+	}
+}
+
+func TestInputLimits(t *testing.T) {
+	value := func(n int) string { return strings.Repeat("x", n) }
+
+	tests := []struct {
+		name      string
+		input     map[string]interface{}
+		violation string // empty when the input is within the limits
+	}{
+		{name: "keys at the limit", input: map[string]interface{}{"a": "1", "b": "2", "c": "3"}},
+		{name: "keys over the limit", input: map[string]interface{}{"a": "1", "b": "2", "c": "3", "d": "4"}, violation: "4 keys exceed the limit of 3"},
+		{name: "key at the limit", input: map[string]interface{}{"abcdefgh": "1"}},
+		{name: "key one byte over the limit", input: map[string]interface{}{"abcdefghi": "1"}, violation: `key starting "ABCDEFGHI" is 9 bytes, over the limit of 8`},
+		{name: "value at the limit", input: map[string]interface{}{"doc": value(16)}},
+		{name: "value one byte over the limit", input: map[string]interface{}{"doc": value(17)}, violation: "value of DOC is 17 bytes, over the limit of 16"},
+		{name: "value measured encoded", input: map[string]interface{}{"list": []interface{}{"aaaaaa", "bbbbbb"}}, violation: "value of LIST is 19 bytes, over the limit of 16"},
+		// Each variable counts as NAME=value
+		{name: "total at the limit", input: map[string]interface{}{"aaaaaaaa": value(16), "bbbbbbbb": value(16), "c": value(12)}},
+		{name: "total one byte over the limit", input: map[string]interface{}{"aaaaaaaa": value(16), "bbbbbbbb": value(16), "c": value(13)}, violation: "input is 65 bytes, over the limit of 64"},
+	}
+
+	dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+		cfg.MaxInputKeys = 3
+		cfg.MaxInputKeyLength = 8
+		cfg.MaxInputValueLength = 16
+		cfg.MaxInputBytes = 64
+	})
+
+	for _, tt := range tests {
+		for mode, inputMode := range map[string]string{"env": "", "stdin": models.InputModeStdin} {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				opts := RunOptions{InputMode: inputMode}
+				checkErr := dm.CheckInputEnv(tt.input, opts)
+				_, _, err := dm.containerInput(context.Background(), tt.input, opts)
+
+				for _, err := range []error{checkErr, err} {
+					if tt.violation == "" {
+						if err != nil {
+							t.Errorf("error = %v, want none", err)
+						}
+						continue
+					}
+					var limitErr *InputLimitError
+					if !errors.As(err, &limitErr) || !reflect.DeepEqual(limitErr.Violations, []string{tt.violation}) {
+						t.Errorf("error = %v, want an InputLimitError with %q", err, tt.violation)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestInputLimitsDisabled(t *testing.T) {
+	dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+		cfg.MaxInputKeys = 0
+		cfg.MaxInputKeyLength = 0
+		cfg.MaxInputValueLength = 0
+		cfg.MaxInputBytes = 0
+	})
+
+	input := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		input[fmt.Sprintf("key_%d_%s", i, strings.Repeat("k", 200))] = strings.Repeat("v", 1<<10)
+	}
+	if err := dm.CheckInputEnv(input, RunOptions{}); err != nil {
+		t.Errorf("CheckInputEnv with no limits = %v", err)
+	}
+}
+
+func TestInputLimitErrorListsAtMostTenViolations(t *testing.T) {
+	dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+		cfg.MaxInputValueLength = 1
+	})
+
+	input := make(map[string]interface{})
+	for i := 0; i < 50; i++ {
+		input[fmt.Sprintf("key%d", i)] = "too long"
+	}
+	var limitErr *InputLimitError
+	if err := dm.CheckInputEnv(input, RunOptions{}); !errors.As(err, &limitErr) || len(limitErr.Violations) != maxInputViolations {
+		t.Errorf("error = %v, want an InputLimitError with %d violations", err, maxInputViolations)
+	}
+}
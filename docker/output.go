@@ -0,0 +1,26 @@
+package docker
+
+// outputBuffer keeps the first limit bytes written to it and counts the rest,
+// so a function that floods its output can't exhaust memory
+type outputBuffer struct {
+	data    []byte
+	limit   int
+	dropped int64
+}
+
+// Write keeps what fits and always reports success, so the container's output
+// keeps being drained rather than blocking it
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	keep := len(p)
+	if room := b.limit - len(b.data); keep > room {
+		keep = max(room, 0)
+	}
+	b.data = append(b.data, p[:keep]...)
+	b.dropped += int64(len(p) - keep)
+	return len(p), nil
+}
+
+// String returns the kept output
+func (b *outputBuffer) String() string {
+	return string(b.data)
+}
@@ -0,0 +1,199 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/config"
+)
+
+// ErrHostOverloaded is returned when an execution is shed because the host's
+// load or memory pressure is over the configured thresholds
+var ErrHostOverloaded = errors.New("host is overloaded")
+
+// pressureSampleInterval is how long a load reading is reused. The kernel
+// only updates the load average every few seconds, so reading it for every
+// execution would gain nothing.
+const pressureSampleInterval = time.Second
+
+// maxPressureBackoff caps the delay between rechecks of a loaded host
+const maxPressureBackoff = 2 * time.Second
+
+// hostLoad is a reading of the host's resource pressure
+type hostLoad struct {
+	loadPerCPU      float64 // one-minute load average divided by the CPU count
+	availableMemory int64   // bytes
+}
+
+// loadProvider reads the host's current load
+type loadProvider interface {
+	load() (hostLoad, error)
+}
+
+// procLoad reads the host's load from /proc, so it only works on Linux
+type procLoad struct{}
+
+// load implements loadProvider
+func (procLoad) load() (hostLoad, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return hostLoad{}, fmt.Errorf("failed to read load average: %v", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return hostLoad{}, fmt.Errorf("malformed /proc/loadavg: %q", data)
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return hostLoad{}, fmt.Errorf("malformed /proc/loadavg: %v", err)
+	}
+
+	data, err = os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return hostLoad{}, fmt.Errorf("failed to read memory info: %v", err)
+	}
+	available, err := parseMemAvailable(data)
+	if err != nil {
+		return hostLoad{}, err
+	}
+
+	return hostLoad{
+		loadPerCPU:      load1 / float64(runtime.NumCPU()),
+		availableMemory: available,
+	}, nil
+}
+
+// parseMemAvailable returns the MemAvailable entry of /proc/meminfo in bytes
+func parseMemAvailable(meminfo []byte) (int64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(meminfo))
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "MemAvailable:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB")), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed MemAvailable in /proc/meminfo: %v", err)
+		}
+		return kb << 10, nil
+	}
+	return 0, errors.New("no MemAvailable in /proc/meminfo")
+}
+
+// pressureGate holds back executions while the host is loaded beyond the
+// configured thresholds. Depending on the policy, it either rejects them
+// with ErrHostOverloaded or rechecks with exponential backoff until the
+// pressure drops or the timeout passes. A nil *pressureGate is valid and
+// never waits.
+type pressureGate struct {
+	provider  loadProvider
+	maxLoad   float64 // per CPU; 0 disables the check
+	minMemory int64   // bytes; 0 disables the check
+	reject    bool
+	backoff   time.Duration
+	timeout   time.Duration
+
+	mutex   sync.Mutex
+	reading hostLoad
+	readAt  time.Time
+	err     error
+}
+
+// newPressureGate creates a pressureGate reading the host's load from
+// provider, or returns nil when no threshold is set
+func newPressureGate(cfg *config.DockerConfig, provider loadProvider) *pressureGate {
+	if cfg.MaxLoadPerCPU <= 0 && cfg.MinAvailableMemory <= 0 {
+		return nil
+	}
+	return &pressureGate{
+		provider:  provider,
+		maxLoad:   cfg.MaxLoadPerCPU,
+		minMemory: cfg.MinAvailableMemory,
+		reject:    cfg.PressurePolicy == "reject",
+		backoff:   cfg.PressureBackoff,
+		timeout:   cfg.PressureTimeout,
+	}
+}
+
+// wait returns once the host is below the thresholds. It fails with
+// ErrHostOverloaded if the policy is to reject or the pressure outlasts the
+// timeout, and with ctx's error if ctx ends first. A host whose load can't
+// be read is treated as not loaded, so a broken reading never stops
+// executions.
+func (g *pressureGate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	reason := g.overloaded()
+	if reason == "" {
+		return nil
+	}
+	if g.reject {
+		return fmt.Errorf("%w: %s", ErrHostOverloaded, reason)
+	}
+
+	deadline := time.NewTimer(g.timeout)
+	defer deadline.Stop()
+	delay := g.backoff
+	for {
+		retry := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return ctx.Err()
+		case <-deadline.C:
+			retry.Stop()
+			return fmt.Errorf("%w after waiting %s: %s", ErrHostOverloaded, g.timeout, reason)
+		case <-retry.C:
+		}
+
+		if reason = g.overloaded(); reason == "" {
+			return nil
+		}
+		delay = min(delay*2, maxPressureBackoff)
+	}
+}
+
+// overloaded describes which threshold the host is over, or returns an empty
+// string when it is under all of them
+func (g *pressureGate) overloaded() string {
+	reading, err := g.read()
+	if err != nil {
+		return ""
+	}
+	if g.maxLoad > 0 && reading.loadPerCPU > g.maxLoad {
+		return fmt.Sprintf("load average of %.2f per CPU exceeds %.2f", reading.loadPerCPU, g.maxLoad)
+	}
+	if g.minMemory > 0 && reading.availableMemory < g.minMemory {
+		return fmt.Sprintf("%d bytes of memory available, below %d", reading.availableMemory, g.minMemory)
+	}
+	return ""
+}
+
+// read returns the host's load, reading it again once the last reading is
+// older than pressureSampleInterval
+func (g *pressureGate) read() (hostLoad, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if time.Since(g.readAt) < pressureSampleInterval {
+		return g.reading, g.err
+	}
+	g.reading, g.err = g.provider.load()
+	g.readAt = time.Now()
+	if g.err != nil {
+		log.Warn().Err(g.err).Msg("Failed to read host load; executions are not held back")
+	}
+	return g.reading, g.err
+}
@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+)
+
+// stubLoad is a loadProvider returning a reading the test sets
+type stubLoad struct {
+	mutex   sync.Mutex
+	reading hostLoad
+	err     error
+	reads   int
+}
+
+// load implements loadProvider
+func (s *stubLoad) load() (hostLoad, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reads++
+	return s.reading, s.err
+}
+
+// set changes the reading
+func (s *stubLoad) set(reading hostLoad) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reading = reading
+}
+
+// readCount returns how often the load was read
+func (s *stubLoad) readCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.reads
+}
+
+// expire makes the gate read the load again on its next check
+func (g *pressureGate) expire() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.readAt = time.Time{}
+}
+
+// newTestPressureGate creates a gate over the stub with thresholds of 2 load
+// per CPU and 1 GiB of memory, after configure has changed them
+func newTestPressureGate(t *testing.T, provider loadProvider, configure func(*config.DockerConfig)) *pressureGate {
+	t.Helper()
+
+	cfg := config.LoadConfig().Docker
+	cfg.MaxLoadPerCPU = 2
+	cfg.MinAvailableMemory = 1 << 30
+	cfg.PressureBackoff = 5 * time.Millisecond
+	cfg.PressureTimeout = 5 * time.Second
+	if configure != nil {
+		configure(&cfg)
+	}
+	return newPressureGate(&cfg, provider)
+}
+
+var (
+	idleHost       = hostLoad{loadPerCPU: 0.5, availableMemory: 8 << 30}
+	busyHost       = hostLoad{loadPerCPU: 4, availableMemory: 8 << 30}
+	lowMemoryHost  = hostLoad{loadPerCPU: 0.5, availableMemory: 512 << 20}
+	overloadedHost = hostLoad{loadPerCPU: 4, availableMemory: 512 << 20}
+)
+
+func TestPressureGateReject(t *testing.T) {
+	tests := []struct {
+		name    string
+		reading hostLoad
+		reason  string // empty when the execution may run
+	}{
+		{name: "idle host", reading: idleHost},
+		{name: "load over the threshold", reading: busyHost, reason: "load average"},
+		{name: "load at the threshold", reading: hostLoad{loadPerCPU: 2, availableMemory: 8 << 30}},
+		{name: "memory under the threshold", reading: lowMemoryHost, reason: "memory available"},
+		{name: "memory at the threshold", reading: hostLoad{loadPerCPU: 0.5, availableMemory: 1 << 30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestPressureGate(t, &stubLoad{reading: tt.reading}, func(cfg *config.DockerConfig) {
+				cfg.PressurePolicy = "reject"
+			})
+
+			start := time.Now()
+			err := g.wait(context.Background())
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("wait = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrHostOverloaded) || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("wait = %v, want ErrHostOverloaded naming the %s", err, tt.reason)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("reject took %s, want no delay", elapsed)
+			}
+		})
+	}
+}
+
+func TestPressureGateDelaysUntilTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	g := newTestPressureGate(t, &stubLoad{reading: busyHost}, func(cfg *config.DockerConfig) {
+		cfg.PressurePolicy = "delay"
+		cfg.PressureTimeout = timeout
+	})
+
+	start := time.Now()
+	err := g.wait(context.Background())
+	elapsed := time.Since(start)
+	if !errors.Is(err, ErrHostOverloaded) || !strings.Contains(err.Error(), "after waiting") {
+		t.Errorf("wait = %v, want ErrHostOverloaded after waiting", err)
+	}
+	if elapsed < timeout || elapsed > 5*timeout {
+		t.Errorf("wait gave up after %s, want about %s", elapsed, timeout)
+	}
+}
+
+func TestPressureGateDelaysUntilPressureDrops(t *testing.T) {
+	provider := &stubLoad{reading: overloadedHost}
+	g := newTestPressureGate(t, provider, func(cfg *config.DockerConfig) {
+		cfg.PressurePolicy = "delay"
+	})
+
+	result := make(chan error, 1)
+	go func() {
+		result <- g.wait(context.Background())
+	}()
+
+	select {
+	case err := <-result:
+		t.Fatalf("wait returned %v while the host was loaded", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	provider.set(idleHost)
+	g.expire()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("wait = %v once the pressure dropped, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait didn't return once the pressure dropped")
+	}
+}
+
+func TestPressureGateStopsWaitingWhenCancelled(t *testing.T) {
+	g := newTestPressureGate(t, &stubLoad{reading: busyHost}, func(cfg *config.DockerConfig) {
+		cfg.PressurePolicy = "delay"
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := g.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestPressureGateDisabled(t *testing.T) {
+	// No thresholds means no gate, and a nil gate never waits
+	g := newTestPressureGate(t, &stubLoad{reading: overloadedHost}, func(cfg *config.DockerConfig) {
+		cfg.MaxLoadPerCPU = 0
+		cfg.MinAvailableMemory = 0
+	})
+	if g != nil {
+		t.Fatal("gate created without thresholds")
+	}
+	if err := g.wait(context.Background()); err != nil {
+		t.Errorf("nil gate wait = %v", err)
+	}
+
+	// A threshold of 0 disables that check alone
+	for name, configure := range map[string]func(*config.DockerConfig){
+		"load":   func(cfg *config.DockerConfig) { cfg.MaxLoadPerCPU = 0 },
+		"memory": func(cfg *config.DockerConfig) { cfg.MinAvailableMemory = 0 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			reading := busyHost
+			if name == "memory" {
+				reading = lowMemoryHost
+			}
+			g := newTestPressureGate(t, &stubLoad{reading: reading}, func(cfg *config.DockerConfig) {
+				configure(cfg)
+				cfg.PressurePolicy = "reject"
+			})
+			if err := g.wait(context.Background()); err != nil {
+				t.Errorf("wait = %v with the %s check disabled", err, name)
+			}
+		})
+	}
+}
+
+func TestPressureGateReadingFailureDoesNotHoldBack(t *testing.T) {
+	g := newTestPressureGate(t, &stubLoad{err: errors.New("no /proc")}, func(cfg *config.DockerConfig) {
+		cfg.PressurePolicy = "reject"
+	})
+	if err := g.wait(context.Background()); err != nil {
+		t.Errorf("wait = %v when the load can't be read, want nil", err)
+	}
+}
+
+func TestPressureGateReusesReadings(t *testing.T) {
+	provider := &stubLoad{reading: idleHost}
+	g := newTestPressureGate(t, provider, nil)
+
+	for i := 0; i < 10; i++ {
+		if err := g.wait(context.Background()); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if n := provider.readCount(); n != 1 {
+		t.Errorf("load read %d times within the sample interval, want 1", n)
+	}
+
+	g.expire()
+	if err := g.wait(context.Background()); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if n := provider.readCount(); n != 2 {
+		t.Errorf("load read %d times after the reading expired, want 2", n)
+	}
+}
+
+func TestRunDockerContainerRejectsOnLoadedHost(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	dm := newTestManager(t, daemon, nil)
+	dm.pressure = newTestPressureGate(t, &stubLoad{reading: busyHost}, func(cfg *config.DockerConfig) {
+		cfg.PressurePolicy = "reject"
+	})
+
+	if _, err := dm.RunDockerContainer(context.Background(), "image", nil, RunOptions{}); !errors.Is(err, ErrHostOverloaded) {
+		t.Errorf("RunDockerContainer = %v, want ErrHostOverloaded", err)
+	}
+	if n := len(daemon.Removed()); n != 0 {
+		t.Errorf("%d containers started on an overloaded host", n)
+	}
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := "MemTotal:       16303428 kB\nMemFree:         1017324 kB\nMemAvailable:    8151716 kB\n"
+	if got, err := parseMemAvailable([]byte(meminfo)); err != nil || got != 8151716<<10 {
+		t.Errorf("parseMemAvailable = %d, %v; want %d", got, err, int64(8151716)<<10)
+	}
+	if _, err := parseMemAvailable([]byte("MemTotal: 16303428 kB\n")); err == nil {
+		t.Error("meminfo without MemAvailable was accepted")
+	}
+	if _, err := parseMemAvailable([]byte("MemAvailable: lots kB\n")); err == nil {
+		t.Error("malformed MemAvailable was accepted")
+	}
+}
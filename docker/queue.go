@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrImageQueueTimeout is returned when an execution waits longer than the
+// configured timeout for its image's concurrency limit
+var ErrImageQueueTimeout = errors.New("timed out waiting for the image's concurrency limit")
+
+// imageQueue bounds how many executions of the same image run at once, so a
+// burst of one heavy function can't crowd out the others. Waiting executions
+// are admitted in FIFO order. A nil *imageQueue is valid and never waits.
+type imageQueue struct {
+	limit   int
+	timeout time.Duration
+
+	mutex  sync.Mutex
+	images map[string]*imageSlots
+}
+
+// imageSlots tracks the running and waiting executions of one image
+type imageSlots struct {
+	running int
+	waiting *list.List // of chan struct{}, closed when the waiter is admitted
+}
+
+// newImageQueue creates an imageQueue, or returns nil when limit is zero
+func newImageQueue(limit int, timeout time.Duration) *imageQueue {
+	if limit < 1 {
+		return nil
+	}
+	return &imageQueue{
+		limit:   limit,
+		timeout: timeout,
+		images:  make(map[string]*imageSlots),
+	}
+}
+
+// acquire waits for the image to have a free slot and returns a function that
+// releases it. It fails if ctx ends or the wait exceeds the queue timeout.
+func (q *imageQueue) acquire(ctx context.Context, imageID string) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mutex.Lock()
+	slots, ok := q.images[imageID]
+	if !ok {
+		slots = &imageSlots{waiting: list.New()}
+		q.images[imageID] = slots
+	}
+	if slots.running < q.limit && slots.waiting.Len() == 0 {
+		slots.running++
+		q.mutex.Unlock()
+		return q.releaser(imageID), nil
+	}
+	admitted := make(chan struct{})
+	element := slots.waiting.PushBack(admitted)
+	q.mutex.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-admitted:
+		return q.releaser(imageID), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrImageQueueTimeout
+	}
+
+	q.mutex.Lock()
+	select {
+	case <-admitted:
+		// A slot was handed over just as the wait ended; pass it on
+		q.mutex.Unlock()
+		q.release(imageID)
+	default:
+		slots.waiting.Remove(element)
+		q.forget(imageID, slots)
+		q.mutex.Unlock()
+	}
+	return nil, err
+}
+
+// releaser returns an idempotent function that releases one slot of the image
+func (q *imageQueue) releaser(imageID string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { q.release(imageID) })
+	}
+}
+
+// release frees a slot of the image, handing it straight to the longest
+// waiting execution if there is one
+func (q *imageQueue) release(imageID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	slots := q.images[imageID]
+	if front := slots.waiting.Front(); front != nil {
+		slots.waiting.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	slots.running--
+	q.forget(imageID, slots)
+}
+
+// forget drops the image's entry once it is idle. Callers must hold the mutex.
+func (q *imageQueue) forget(imageID string, slots *imageSlots) {
+	if slots.running == 0 && slots.waiting.Len() == 0 {
+		delete(q.images, imageID)
+	}
+}
@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImageQueueBoundsEachImageSeparately(t *testing.T) {
+	q := newImageQueue(2, time.Minute)
+	ctx := context.Background()
+
+	// Fill image A's two slots
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := q.acquire(ctx, "a")
+		if err != nil {
+			t.Fatalf("acquire a #%d: %v", i+1, err)
+		}
+		releases = append(releases, release)
+	}
+
+	// A third execution of A waits, while B still runs straight away
+	waiting := make(chan func(), 1)
+	go func() {
+		release, err := q.acquire(ctx, "a")
+		if err != nil {
+			t.Errorf("waiting acquire of a: %v", err)
+		}
+		waiting <- release
+	}()
+
+	for i := 0; i < 2; i++ {
+		shortCtx, cancel := context.WithTimeout(ctx, time.Second)
+		release, err := q.acquire(shortCtx, "b")
+		cancel()
+		if err != nil {
+			t.Fatalf("acquire b #%d while a is full: %v", i+1, err)
+		}
+		defer release()
+	}
+
+	select {
+	case <-waiting:
+		t.Fatal("third execution of a ran past the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releases[0]()
+	select {
+	case release := <-waiting:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting execution of a was not admitted after a release")
+	}
+	releases[1]()
+}
+
+func TestImageQueueAdmitsInFIFOOrder(t *testing.T) {
+	q := newImageQueue(1, time.Minute)
+	ctx := context.Background()
+
+	release, err := q.acquire(ctx, "image")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	const waiters = 5
+	admitted := make(chan int, waiters)
+	for i := 0; i < waiters; i++ {
+		go func(i int) {
+			release, err := q.acquire(ctx, "image")
+			if err != nil {
+				t.Errorf("acquire #%d: %v", i, err)
+				return
+			}
+			admitted <- i
+			release()
+		}(i)
+		// Let each waiter queue before the next
+		waitForWaiters(t, q, "image", i+1)
+	}
+
+	release()
+	for want := 0; want < waiters; want++ {
+		select {
+		case got := <-admitted:
+			if got != want {
+				t.Fatalf("admitted waiter %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("waiter %d was never admitted", want)
+		}
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.images) != 0 {
+		t.Errorf("idle images still tracked: %v", q.images)
+	}
+}
+
+func TestImageQueueTimesOut(t *testing.T) {
+	q := newImageQueue(1, 20*time.Millisecond)
+	release, err := q.acquire(context.Background(), "image")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	if _, err := q.acquire(context.Background(), "image"); !errors.Is(err, ErrImageQueueTimeout) {
+		t.Errorf("acquire past the queue timeout: %v, want ErrImageQueueTimeout", err)
+	}
+	waitForWaiters(t, q, "image", 0)
+}
+
+func TestImageQueueRespectsCancellation(t *testing.T) {
+	q := newImageQueue(1, time.Minute)
+	release, err := q.acquire(context.Background(), "image")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := q.acquire(ctx, "image")
+		result <- err
+	}()
+	waitForWaiters(t, q, "image", 1)
+	cancel()
+
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled acquire: %v, want context.Canceled", err)
+	}
+	waitForWaiters(t, q, "image", 0)
+}
+
+func TestImageQueueUnlimited(t *testing.T) {
+	q := newImageQueue(0, time.Minute)
+	if q != nil {
+		t.Fatal("newImageQueue(0) is not nil")
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := q.acquire(context.Background(), "image"); err != nil {
+			t.Fatalf("acquire on a nil queue: %v", err)
+		}
+	}
+}
+
+// waitForWaiters waits until n executions of the image are queued
+func waitForWaiters(t *testing.T, q *imageQueue, imageID string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mutex.Lock()
+		waiting := 0
+		if slots, ok := q.images[imageID]; ok {
+			waiting = slots.waiting.Len()
+		}
+		q.mutex.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d executions waiting, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
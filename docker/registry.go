@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/requestctx"
+)
+
+// ErrRegistryAuth is returned when the configured registry rejects the
+// credentials, so callers can report it apart from an ordinary build failure
+var ErrRegistryAuth = errors.New("registry authentication failed")
+
+// registryLogin remembers whether the configured registry credentials have
+// been accepted, so the daemon is only asked to log in once
+type registryLogin struct {
+	mutex         sync.Mutex
+	authenticated bool
+}
+
+// registryAuth returns the credentials for the configured registry, and false
+// when none are configured
+func (dm *Manager) registryAuth() (registry.AuthConfig, bool) {
+	if dm.config.RegistryUser == "" {
+		return registry.AuthConfig{}, false
+	}
+	return registry.AuthConfig{
+		Username:      dm.config.RegistryUser,
+		Password:      dm.config.RegistryPass,
+		ServerAddress: dm.config.RegistryURL,
+	}, true
+}
+
+// buildAuthConfigs returns the credentials passed to image builds for pulling
+// base images, or nil when no registry is configured
+func (dm *Manager) buildAuthConfigs() map[string]registry.AuthConfig {
+	auth, ok := dm.registryAuth()
+	if !ok {
+		return nil
+	}
+	return map[string]registry.AuthConfig{auth.ServerAddress: auth}
+}
+
+// EnsureRegistryAuth checks the configured registry credentials with the
+// Docker daemon. It does nothing when no registry is configured or the
+// credentials were already accepted. The password never appears in logs or
+// in the returned error.
+func (dm *Manager) EnsureRegistryAuth(ctx context.Context) error {
+	auth, ok := dm.registryAuth()
+	if !ok {
+		return nil
+	}
+
+	dm.login.mutex.Lock()
+	defer dm.login.mutex.Unlock()
+
+	if dm.login.authenticated {
+		return nil
+	}
+
+	requestID := requestctx.ID(ctx)
+	_, err := dm.client.RegistryLogin(ctx, auth)
+	if client.IsErrConnectionFailed(err) {
+		return dm.unavailable(err)
+	}
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("registry", auth.ServerAddress).
+			Str("user", auth.Username).
+			Err(err).
+			Msg("Registry login failed")
+		return fmt.Errorf("%w for %s: %v", ErrRegistryAuth, auth.ServerAddress, err)
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("registry", auth.ServerAddress).
+		Str("user", auth.Username).
+		Msg("Logged in to registry")
+	dm.login.authenticated = true
+	return nil
+}
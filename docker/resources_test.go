@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+)
+
+func TestResourcesResolutionOrder(t *testing.T) {
+	dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+		cfg.ResourceProfiles = config.ResourceProfiles{
+			"java":   {MemoryLimit: 512 << 20, CPULimit: 2},
+			"python": {MemoryLimit: 256 << 20},
+		}
+	})
+
+	tests := []struct {
+		name string
+		opts RunOptions
+		want resources
+	}{
+		{
+			name: "function limits override the profile",
+			opts: RunOptions{Language: "java", MemoryLimit: 64 << 20, CPULimit: 0.25},
+			want: resources{memory: 64 << 20, nanoCPUs: 250_000_000},
+		},
+		{
+			name: "profile without function limits",
+			opts: RunOptions{Language: "java"},
+			want: resources{memory: 512 << 20, nanoCPUs: 2_000_000_000},
+		},
+		{
+			name: "each limit resolves separately",
+			opts: RunOptions{Language: "java", CPULimit: 1},
+			want: resources{memory: 512 << 20, nanoCPUs: 1_000_000_000},
+		},
+		{
+			name: "profile fields left unset use the default",
+			opts: RunOptions{Language: "python"},
+			want: resources{memory: 256 << 20, nanoCPUs: defaultNanoCPUs},
+		},
+		{
+			name: "language without a profile uses the defaults",
+			opts: RunOptions{Language: "go"},
+			want: resources{memory: defaultMemoryLimit, nanoCPUs: defaultNanoCPUs},
+		},
+		{
+			name: "function limits without a profile",
+			opts: RunOptions{Language: "go", MemoryLimit: 32 << 20},
+			want: resources{memory: 32 << 20, nanoCPUs: defaultNanoCPUs},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dm.resources(tt.opts); got != tt.want {
+				t.Errorf("resources = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
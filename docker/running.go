@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrShuttingDown is returned for executions that would start a container
+// after the manager has begun draining
+var ErrShuttingDown = errors.New("docker manager is shutting down")
+
+// runningContainer is a container currently executing a function
+type runningContainer struct {
+	imageID string
+	started time.Time
+}
+
+// runningSet tracks the containers executing functions so that shutdown can
+// wait for them to finish or remove them
+type runningSet struct {
+	mutex      sync.Mutex
+	containers map[string]runningContainer
+	draining   bool
+	drained    chan struct{} // closed once draining with no containers left
+}
+
+// newRunningSet creates an empty runningSet
+func newRunningSet() *runningSet {
+	return &runningSet{
+		containers: make(map[string]runningContainer),
+		drained:    make(chan struct{}),
+	}
+}
+
+// add records a container as running and returns a function that removes it
+// again. It fails with ErrShuttingDown once draining has begun.
+func (rs *runningSet) add(containerID, imageID string) (func(), error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if rs.draining {
+		return nil, ErrShuttingDown
+	}
+	rs.containers[containerID] = runningContainer{imageID: imageID, started: time.Now()}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { rs.remove(containerID) })
+	}, nil
+}
+
+// remove forgets a container, logging it if it finished during a drain
+func (rs *runningSet) remove(containerID string) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	running, ok := rs.containers[containerID]
+	if !ok {
+		return
+	}
+	delete(rs.containers, containerID)
+
+	if rs.draining {
+		log.Info().
+			Str("container_id", containerID).
+			Str("image_id", running.imageID).
+			Dur("duration", time.Since(running.started)).
+			Msg("Running container finished during shutdown")
+		if len(rs.containers) == 0 {
+			close(rs.drained)
+		}
+	}
+}
+
+// drain stops new containers from being added and returns the number still
+// running along with a channel closed once they have all finished
+func (rs *runningSet) drain() (int, <-chan struct{}) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if !rs.draining {
+		rs.draining = true
+		if len(rs.containers) == 0 {
+			close(rs.drained)
+		}
+	}
+	return len(rs.containers), rs.drained
+}
+
+// snapshot returns the containers still running, by container ID
+func (rs *runningSet) snapshot() map[string]runningContainer {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	containers := make(map[string]runningContainer, len(rs.containers))
+	for id, running := range rs.containers {
+		containers[id] = running
+	}
+	return containers
+}
+
+// Drain stops new executions from starting containers and waits for running
+// ones to finish. Containers still running when ctx ends are killed and
+// removed so they are not leaked when the process exits.
+func (dm *Manager) Drain(ctx context.Context) {
+	count, drained := dm.running.drain()
+	if count == 0 {
+		return
+	}
+
+	log.Info().
+		Int("count", count).
+		Msg("Waiting for running containers to finish")
+
+	select {
+	case <-drained:
+		log.Info().
+			Int("count", count).
+			Msg("All running containers finished")
+		return
+	case <-ctx.Done():
+	}
+
+	remaining := dm.running.snapshot()
+	var wg sync.WaitGroup
+	for containerID, running := range remaining {
+		wg.Add(1)
+		go func(containerID string, running runningContainer) {
+			defer wg.Done()
+			dm.removeContainer(context.Background(), containerID)
+			log.Warn().
+				Str("container_id", containerID).
+				Str("image_id", running.imageID).
+				Dur("duration", time.Since(running.started)).
+				Msg("Killed running container during shutdown")
+		}(containerID, running)
+	}
+	wg.Wait()
+
+	log.Warn().
+		Int("finished", count-len(remaining)).
+		Int("killed", len(remaining)).
+		Msg("Shutdown timeout reached with containers still running")
+}
@@ -0,0 +1,48 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Template represents a Docker template configuration. Dockerfile is a
+// text/template rendered with TemplateData.
+type Template struct {
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+// TemplateData holds the values available to a Dockerfile template
+type TemplateData struct {
+	Handler   string            // handler file or Go main package, relative to the build context
+	Language  string            // detected language, such as "python"
+	BuildArgs map[string]string // build arguments from the function's manifest
+
+	// RuntimeVersion is the validated base image version, also passed to the
+	// build as RuntimeVersionArg
+	RuntimeVersion string
+}
+
+// templateFuncs are the helper functions available to Dockerfile templates
+var templateFuncs = template.FuncMap{
+	"goPackage": goBuildTarget,
+}
+
+// Render executes the Dockerfile template with the given data. Fields that
+// don't exist in TemplateData are reported as errors rather than rendered
+// empty.
+func (t *Template) Render(data TemplateData) (string, error) {
+	tmpl, err := template.New("dockerfile").
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		Parse(t.Dockerfile)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %v", err)
+	}
+	return out.String(), nil
+}
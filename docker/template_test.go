@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+)
+
+func TestLoadTemplateFromEmbeddedDefaults(t *testing.T) {
+	for _, templatesDir := range []string{"", t.TempDir(), filepath.Join(t.TempDir(), "missing")} {
+		dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+			cfg.TemplatesDir = templatesDir
+		})
+
+		if err := dm.LoadTemplates(context.Background()); err != nil {
+			t.Fatalf("LoadTemplates with TEMPLATES_DIR %q: %v", templatesDir, err)
+		}
+		template, err := dm.LoadTemplate(context.Background(), "python")
+		if err != nil {
+			t.Fatalf("LoadTemplate with TEMPLATES_DIR %q: %v", templatesDir, err)
+		}
+		if !strings.Contains(template.Dockerfile, "FROM python:") {
+			t.Errorf("embedded python template with TEMPLATES_DIR %q:\n%s", templatesDir, template.Dockerfile)
+		}
+	}
+}
+
+func TestLoadTemplatePrefersTemplatesDir(t *testing.T) {
+	templatesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(templatesDir, "python.yaml"), []byte("dockerfile: FROM custom-python\n"), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+		cfg.TemplatesDir = templatesDir
+	})
+
+	template, err := dm.LoadTemplate(context.Background(), "python")
+	if err != nil {
+		t.Fatalf("LoadTemplate: %v", err)
+	}
+	if template.Dockerfile != "FROM custom-python" {
+		t.Errorf("python template = %q, want the one in TEMPLATES_DIR", template.Dockerfile)
+	}
+
+	// Languages the directory has no template for fall back to the defaults
+	template, err = dm.LoadTemplate(context.Background(), "ruby")
+	if err != nil {
+		t.Fatalf("LoadTemplate: %v", err)
+	}
+	if !strings.Contains(template.Dockerfile, "FROM ruby:") {
+		t.Errorf("ruby template didn't fall back to the embedded one:\n%s", template.Dockerfile)
+	}
+}
+
+func TestLoadTemplatesRejectsMalformedTemplate(t *testing.T) {
+	templatesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(templatesDir, "golang.yaml"), []byte("dockerfile: [unclosed\n"), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	dm := newTestManager(t, dockertest.NewDaemon(t), func(cfg *config.DockerConfig) {
+		cfg.TemplatesDir = templatesDir
+	})
+
+	if err := dm.LoadTemplates(context.Background()); err == nil || !strings.HasPrefix(err.Error(), "golang:") {
+		t.Errorf("LoadTemplates = %v, want an error for the golang template", err)
+	}
+}
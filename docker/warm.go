@@ -0,0 +1,352 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+)
+
+// warmStartTimeout bounds how long starting a warm container may take
+const warmStartTimeout = 30 * time.Second
+
+// warmKey identifies a set of interchangeable warm containers: those running
+// the same image with the same resource limits, network mode and user
+type warmKey struct {
+	imageID   string
+	resources resources
+	network   string
+	user      string
+}
+
+// warmContainer is a long-lived container kept idle so executions can be
+// dispatched to it with docker exec instead of starting a new container
+type warmContainer struct {
+	id       string
+	key      warmKey
+	cmd      []string // the image's entrypoint and command, run on each exec
+	lastUsed time.Time
+}
+
+// warmPool keeps up to size warm containers per key and reaps containers
+// that stay idle longer than ttl. A nil *warmPool is valid and disables
+// warm starts.
+type warmPool struct {
+	dm   *Manager
+	size int
+	ttl  time.Duration
+
+	mutex    sync.Mutex
+	idle     map[warmKey][]*warmContainer
+	busy     map[warmKey]int // executing containers
+	starting map[warmKey]int // containers being started
+	closed   bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newWarmPool creates a warm pool and starts its reaper, or returns nil when
+// size is zero
+func newWarmPool(dm *Manager, size int, ttl time.Duration) *warmPool {
+	if size < 1 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	p := &warmPool{
+		dm:       dm,
+		size:     size,
+		ttl:      ttl,
+		idle:     make(map[warmKey][]*warmContainer),
+		busy:     make(map[warmKey]int),
+		starting: make(map[warmKey]int),
+		stop:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.reap()
+	return p
+}
+
+// get takes an idle warm container for the key, or returns nil if none is
+// available and the execution should fall back to a cold start
+func (p *warmPool) get(key warmKey) *warmContainer {
+	if p == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	idle := p.idle[key]
+	if len(idle) == 0 {
+		return nil
+	}
+	wc := idle[len(idle)-1]
+	p.idle[key] = idle[:len(idle)-1]
+	p.busy[key]++
+	return wc
+}
+
+// release returns a container to the idle list after an execution. Containers
+// whose execution failed, timed out or was cancelled may still be running the
+// function, so they are removed instead.
+func (p *warmPool) release(wc *warmContainer, reusable bool) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	p.busy[wc.key]--
+	if reusable && !p.closed {
+		wc.lastUsed = time.Now()
+		p.idle[wc.key] = append(p.idle[wc.key], wc)
+		p.mutex.Unlock()
+		return
+	}
+	p.mutex.Unlock()
+
+	p.dm.removeContainer(context.Background(), wc.id)
+}
+
+// fill starts warm containers in the background until the key has size
+// containers idle, busy or starting
+func (p *warmPool) fill(key warmKey) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return
+	}
+	missing := p.size - len(p.idle[key]) - p.busy[key] - p.starting[key]
+	for i := 0; i < missing; i++ {
+		p.starting[key]++
+		p.wg.Add(1)
+		go p.start(key)
+	}
+}
+
+// start creates and starts one warm container for the key
+func (p *warmPool) start(key warmKey) {
+	defer p.wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), warmStartTimeout)
+	defer cancel()
+
+	wc, err := p.dm.startWarmContainer(ctx, key)
+
+	p.mutex.Lock()
+	p.starting[key]--
+	if err == nil && !p.closed {
+		p.idle[key] = append(p.idle[key], wc)
+		p.mutex.Unlock()
+
+		log.Debug().
+			Str("image_id", key.imageID).
+			Str("container_id", wc.id).
+			Msg("Warm container started")
+		return
+	}
+	p.mutex.Unlock()
+
+	if err != nil {
+		log.Warn().
+			Str("image_id", key.imageID).
+			Err(err).
+			Msg("Failed to start warm container")
+		return
+	}
+	p.dm.removeContainer(context.Background(), wc.id)
+}
+
+// reap periodically removes containers that have been idle longer than the TTL
+func (p *warmPool) reap() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*warmContainer
+		cutoff := time.Now().Add(-p.ttl)
+
+		p.mutex.Lock()
+		for key, idle := range p.idle {
+			kept := idle[:0]
+			for _, wc := range idle {
+				if wc.lastUsed.Before(cutoff) {
+					expired = append(expired, wc)
+				} else {
+					kept = append(kept, wc)
+				}
+			}
+			if len(kept) == 0 {
+				delete(p.idle, key)
+			} else {
+				p.idle[key] = kept
+			}
+		}
+		p.mutex.Unlock()
+
+		for _, wc := range expired {
+			p.dm.removeContainer(context.Background(), wc.id)
+		}
+		if len(expired) > 0 {
+			log.Info().
+				Int("count", len(expired)).
+				Msg("Reaped idle warm containers")
+		}
+	}
+}
+
+// evict removes every idle warm container for the image, such as before the
+// image itself is removed
+func (p *warmPool) evict(imageID string) {
+	if p == nil {
+		return
+	}
+
+	var idle []*warmContainer
+	p.mutex.Lock()
+	for key, containers := range p.idle {
+		if key.imageID == imageID {
+			idle = append(idle, containers...)
+			delete(p.idle, key)
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, wc := range idle {
+		p.dm.removeContainer(context.Background(), wc.id)
+	}
+}
+
+// close stops the reaper and removes every warm container. Containers still
+// executing are removed when they are released.
+func (p *warmPool) close() {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = make(map[warmKey][]*warmContainer)
+	p.mutex.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+
+	for _, containers := range idle {
+		for _, wc := range containers {
+			p.dm.removeContainer(context.Background(), wc.id)
+		}
+	}
+}
+
+// startWarmContainer starts a container from the key's image, with its
+// resource limits and network mode, that idles until an execution is dispatched to it
+func (dm *Manager) startWarmContainer(ctx context.Context, key warmKey) (*warmContainer, error) {
+	imageID := key.imageID
+	image, _, err := dm.client.ImageInspectWithRaw(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %v", err)
+	}
+	if image.Config == nil {
+		return nil, fmt.Errorf("image %s has no config", imageID)
+	}
+	cmd := append(append([]string{}, image.Config.Entrypoint...), image.Config.Cmd...)
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("image %s has no command", imageID)
+	}
+
+	// Replace the function's command with one that idles forever
+	created, err := dm.client.ContainerCreate(ctx, &container.Config{
+		Image:      imageID,
+		Entrypoint: []string{"sleep", "infinity"},
+		Cmd:        []string{},
+		User:       key.user,
+	}, dm.hostConfig(key.resources, key.network), nil, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %v", err)
+	}
+
+	if err := dm.client.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		dm.removeContainer(ctx, created.ID)
+		return nil, fmt.Errorf("failed to start container: %v", err)
+	}
+
+	return &warmContainer{
+		id:       created.ID,
+		key:      key,
+		cmd:      cmd,
+		lastUsed: time.Now(),
+	}, nil
+}
+
+// execWarm runs the function's command inside a warm container, copying its
+// output to stdout and stderr, and returns its exit code
+func (dm *Manager) execWarm(ctx context.Context, wc *warmContainer, env []string, stdin []byte, stdout, stderr io.Writer) (int, error) {
+	untrack, err := dm.running.add(wc.id, wc.key.imageID)
+	if err != nil {
+		return 0, err
+	}
+	defer untrack()
+
+	exec, err := dm.client.ContainerExecCreate(ctx, wc.id, container.ExecOptions{
+		Cmd:          wc.cmd,
+		Env:          env,
+		User:         wc.key.user,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if client.IsErrConnectionFailed(err) {
+		return 0, dm.unavailable(err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %v", err)
+	}
+
+	attached, err := dm.client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to exec: %v", err)
+	}
+	defer attached.Close()
+
+	if stdin != nil {
+		go func() {
+			attached.Conn.Write(stdin)
+			attached.CloseWrite()
+		}()
+	}
+
+	// Read output until the process exits, or give up when ctx ends
+	if err := copyOutput(ctx, attached, stdout, stderr); err != nil {
+		return 0, err
+	}
+
+	inspect, err := dm.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %v", err)
+	}
+
+	return inspect.ExitCode, nil
+}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+)
+
+// benchImage is a small image whose command exits straight away, so the
+// benchmarks measure container startup rather than the function
+const benchImage = "busybox:latest"
+
+// newBenchManager creates a Manager for the local Docker daemon with the
+// default configuration, after configure has changed it, skipping the
+// benchmark when there is no daemon
+func newBenchManager(b *testing.B, configure func(*config.DockerConfig)) *Manager {
+	b.Helper()
+
+	cfg := config.LoadConfig().Docker
+	if configure != nil {
+		configure(&cfg)
+	}
+	dm, err := NewDockerManager(&cfg)
+	if err != nil {
+		b.Fatalf("NewDockerManager: %v", err)
+	}
+	b.Cleanup(func() { dm.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := dm.Ping(ctx); err != nil {
+		b.Skipf("no Docker daemon: %v", err)
+	}
+	return dm
+}
+
+// newRunBenchManager creates a Manager for the local Docker daemon with the
+// given warm pool size, skipping the benchmark when there is no daemon or it
+// lacks benchImage
+func newRunBenchManager(b *testing.B, warmPoolSize int) *Manager {
+	b.Helper()
+
+	dm := newBenchManager(b, func(cfg *config.DockerConfig) {
+		cfg.WarmPoolSize = warmPoolSize
+	})
+	if exists, err := dm.ImageExists(context.Background(), benchImage); err != nil || !exists {
+		b.Skipf("%s is not available; run docker pull %s", benchImage, benchImage)
+	}
+	return dm
+}
+
+// hasIdle reports whether the pool has an idle container for the image
+func (p *warmPool) hasIdle(imageID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, idle := range p.idle {
+		if key.imageID == imageID && len(idle) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunDockerContainerDispatchesToWarmContainer(t *testing.T) {
+	daemon := dockertest.NewDaemon(t)
+	daemon.AddImage("image")
+	execs := make(chan bool, 2)
+	daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		if len(p.Entrypoint) > 0 && p.Entrypoint[0] == "sleep" {
+			// A warm container idles until it is removed
+			<-ctx.Done()
+			return dockertest.Result{}
+		}
+		execs <- p.Exec
+		return dockertest.Result{Stdout: "ok"}
+	}
+	dm := newTestManager(t, daemon, func(cfg *config.DockerConfig) {
+		cfg.WarmPoolSize = 1
+	})
+	input := map[string]interface{}{"name": "Alice"}
+
+	// The first execution starts cold and fills the pool
+	if _, err := dm.RunDockerContainer(context.Background(), "image", input, RunOptions{}); err != nil {
+		t.Fatalf("RunDockerContainer: %v", err)
+	}
+	if exec := <-execs; exec {
+		t.Error("first execution used a warm container")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !dm.warm.hasIdle("image") {
+		if time.Now().After(deadline) {
+			t.Fatal("warm container never became idle")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := dm.RunDockerContainer(context.Background(), "image", input, RunOptions{})
+	if err != nil {
+		t.Fatalf("RunDockerContainer: %v", err)
+	}
+	if exec := <-execs; !exec {
+		t.Error("second execution started a fresh container")
+	}
+	if result.Stdout != "ok" {
+		t.Errorf("stdout = %q, want %q", result.Stdout, "ok")
+	}
+}
+
+// BenchmarkRunCold measures executions that each start a fresh container
+func BenchmarkRunCold(b *testing.B) {
+	dm := newRunBenchManager(b, 0)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dm.RunDockerContainer(ctx, benchImage, nil, RunOptions{}); err != nil {
+			b.Fatalf("RunDockerContainer: %v", err)
+		}
+	}
+}
+
+// BenchmarkRunWarm measures executions dispatched to an idle warm container
+func BenchmarkRunWarm(b *testing.B) {
+	dm := newRunBenchManager(b, 1)
+	ctx := context.Background()
+
+	// The first execution starts cold and fills the pool in the background
+	if _, err := dm.RunDockerContainer(ctx, benchImage, nil, RunOptions{}); err != nil {
+		b.Fatalf("RunDockerContainer: %v", err)
+	}
+	deadline := time.Now().Add(warmStartTimeout)
+	for !dm.warm.hasIdle(benchImage) {
+		if time.Now().After(deadline) {
+			b.Fatal("warm container never became idle")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dm.RunDockerContainer(ctx, benchImage, nil, RunOptions{}); err != nil {
+			b.Fatalf("RunDockerContainer: %v", err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package events notifies external systems of platform events, such as
+// functions being deployed, executed or deleted
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"youtube_serverless/config"
+)
+
+// Event types
+const (
+	FunctionDeployed = "function.deployed" // a function was submitted or redeployed
+	FunctionExecuted = "function.executed" // an execution finished, successfully or not
+	FunctionDeleted  = "function.deleted"  // a function was deleted or evicted
+	BuildFailed      = "build.failed"      // building a function's image failed
+)
+
+// Event describes something that happened on the platform. Fields that
+// don't apply to an event's type are left empty.
+type Event struct {
+	ID         string `json:"id"` // unique per event, so receivers can drop redelivered ones
+	Type       string `json:"type"`
+	FunctionID string `json:"functionId,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+	RequestID  string `json:"requestId,omitempty"`
+
+	// Deployments and failed builds
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	ImageID  string `json:"imageId,omitempty"`
+
+	// Executions
+	ExecutionID string `json:"executionId,omitempty"`
+	Success     *bool  `json:"success,omitempty"`
+	ExitCode    *int   `json:"exitCode,omitempty"`
+	DurationMs  int64  `json:"durationMs,omitempty"`
+
+	Error string `json:"error,omitempty"` // of failed builds and executions
+}
+
+// Sink receives platform events. Emit must not block on delivery, since it
+// is called while requests are being served.
+type Sink interface {
+	Emit(ctx context.Context, event Event)
+	// Close delivers the events already emitted until ctx ends
+	Close(ctx context.Context) error
+}
+
+// New creates the Sink selected by the events configuration. The webhook
+// sink only connects to addresses outbound requests may reach: public
+// addresses and the allowed networks.
+func New(cfg *config.EventsConfig, allowed []string) (Sink, error) {
+	switch cfg.Sink {
+	case "none":
+		return Nop{}, nil
+	case "webhook":
+		return NewWebhookSink(cfg, allowed)
+	default:
+		return nil, fmt.Errorf("unsupported events sink: %s", cfg.Sink)
+	}
+}
+
+// Nop is a Sink that discards every event
+type Nop struct{}
+
+// Emit implements Sink
+func (Nop) Emit(context.Context, Event) {}
+
+// Close implements Sink
+func (Nop) Close(context.Context) error { return nil }
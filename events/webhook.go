@@ -0,0 +1,204 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/config"
+	"youtube_serverless/netsafe"
+	"youtube_serverless/webhook"
+)
+
+// TypeHeader carries the type of the event in the body
+const TypeHeader = "X-Serverless-Event"
+
+// WebhookSink POSTs each event as JSON to the configured URL. Events are
+// queued and delivered in order by a single worker, retrying failed
+// deliveries with exponential backoff, so emitting never waits on the
+// receiver. Events emitted while the queue is full are dropped.
+type WebhookSink struct {
+	config *config.EventsConfig
+	client *http.Client
+	queue  chan Event
+
+	mutex  sync.RWMutex
+	closed bool
+
+	// ctx ends deliveries still in flight when Close gives up on them
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWebhookSink creates a WebhookSink whose client only connects to public
+// addresses and the allowed networks, or any address when private addresses
+// are allowed, and starts its delivery worker
+func NewWebhookSink(cfg *config.EventsConfig, allowed []string) (*WebhookSink, error) {
+	guard, err := netsafe.NewGuard(allowed, cfg.AllowPrivate)
+	if err != nil {
+		return nil, err
+	}
+
+	client := guard.Client(cfg.Timeout)
+	// A redirect could point anywhere, so don't follow them
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &WebhookSink{
+		config: cfg,
+		client: client,
+		queue:  make(chan Event, cfg.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Emit queues the event for delivery, or drops it if the queue is full or
+// the sink is closed
+func (s *WebhookSink) Emit(ctx context.Context, event Event) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+		log.Warn().
+			Str("request_id", event.RequestID).
+			Str("event_type", event.Type).
+			Str("function_id", event.FunctionID).
+			Int("queue_size", s.config.QueueSize).
+			Msg("Event queue is full, dropping event")
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be
+// delivered. When ctx ends first, the delivery in flight is abandoned and
+// the events still queued are dropped.
+func (s *WebhookSink) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// run delivers queued events until the queue is closed and drained
+func (s *WebhookSink) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		if s.ctx.Err() != nil {
+			continue
+		}
+		s.send(event)
+	}
+}
+
+// send POSTs the event, retrying with exponential backoff after network
+// errors, 429 and 5xx responses. Other responses end delivery.
+func (s *WebhookSink) send(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error().
+			Str("event_type", event.Type).
+			Err(err).
+			Msg("Failed to encode event")
+		return
+	}
+
+	backoff := s.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.deliver(event.Type, body)
+		if err == nil {
+			log.Debug().
+				Str("request_id", event.RequestID).
+				Str("event_type", event.Type).
+				Str("event_id", event.ID).
+				Int("attempt", attempt+1).
+				Msg("Event delivered")
+			return
+		}
+		if !retry || attempt >= s.config.MaxRetries {
+			log.Error().
+				Str("request_id", event.RequestID).
+				Str("event_type", event.Type).
+				Str("event_id", event.ID).
+				Int("attempts", attempt+1).
+				Err(err).
+				Msg("Event delivery failed")
+			return
+		}
+
+		log.Warn().
+			Str("request_id", event.RequestID).
+			Str("event_type", event.Type).
+			Str("event_id", event.ID).
+			Int("attempt", attempt+1).
+			Dur("backoff", backoff).
+			Err(err).
+			Msg("Event delivery failed, retrying")
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// deliver makes one delivery attempt and reports whether a failure is worth
+// retrying
+func (s *WebhookSink) deliver(eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create event request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TypeHeader, eventType)
+	if s.config.Secret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(s.config.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Blocked addresses won't become allowed on a retry, and a cancelled
+		// delivery was given up on
+		retry := !errors.Is(err, netsafe.ErrBlocked) && s.ctx.Err() == nil
+		return retry, fmt.Errorf("failed to send event: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("event receiver returned status %d", resp.StatusCode)
+}
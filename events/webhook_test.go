@@ -0,0 +1,313 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+	"youtube_serverless/webhook"
+)
+
+// delivery is a request the receiver got
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is an event receiver answering each delivery with the next
+// status it is given, then with 200 OK
+type receiver struct {
+	*httptest.Server
+
+	mutex      sync.Mutex
+	statuses   []int
+	deliveries []delivery
+}
+
+// newReceiver starts a receiver, closed when the test ends
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("failed to read event: %v", err)
+		}
+
+		r.mutex.Lock()
+		r.deliveries = append(r.deliveries, delivery{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mutex.Unlock()
+
+		if status == http.StatusFound {
+			http.Redirect(w, req, "/elsewhere", status)
+		} else {
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// got returns the deliveries received so far
+func (r *receiver) got() []delivery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+// newTestSink creates a WebhookSink delivering to url, after configure has
+// changed its configuration, and closes it when the test ends
+func newTestSink(t *testing.T, url string, configure func(*config.EventsConfig)) *WebhookSink {
+	t.Helper()
+
+	cfg := config.LoadConfig().Events
+	cfg.Sink = "webhook"
+	cfg.URL = url
+	cfg.MaxRetries = 2
+	cfg.RetryBackoff = time.Millisecond
+	cfg.Timeout = 5 * time.Second
+	cfg.AllowPrivate = true // the receiver listens on loopback
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	s, err := NewWebhookSink(&cfg, nil)
+	if err != nil {
+		t.Fatalf("NewWebhookSink: %v", err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+// closeSink closes the sink, waiting for the queued events to be delivered
+func closeSink(t *testing.T, s *WebhookSink) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestWebhookSinkSignsEvents(t *testing.T) {
+	for _, secret := range []string{"", "s3cret"} {
+		t.Run("secret="+secret, func(t *testing.T) {
+			r := newReceiver(t)
+			s := newTestSink(t, r.URL, func(cfg *config.EventsConfig) {
+				cfg.Secret = secret
+			})
+
+			s.Emit(context.Background(), Event{ID: "event-1", Type: FunctionDeployed, FunctionID: "function", Name: "greeter"})
+			closeSink(t, s)
+
+			deliveries := r.got()
+			if len(deliveries) != 1 {
+				t.Fatalf("%d deliveries, want 1", len(deliveries))
+			}
+			d := deliveries[0]
+			if got := d.header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := d.header.Get(TypeHeader); got != FunctionDeployed {
+				t.Errorf("%s = %q, want %s", TypeHeader, got, FunctionDeployed)
+			}
+
+			signature := d.header.Get(webhook.SignatureHeader)
+			if secret == "" {
+				if signature != "" {
+					t.Errorf("unsigned sink sent %s %q", webhook.SignatureHeader, signature)
+				}
+			} else if want := webhook.Sign(secret, d.body); signature != want {
+				t.Errorf("%s = %q, want %q", webhook.SignatureHeader, signature, want)
+			}
+			if signature != "" && signature == webhook.Sign("other", d.body) {
+				t.Error("signature doesn't depend on the secret")
+			}
+
+			var event Event
+			if err := json.Unmarshal(d.body, &event); err != nil {
+				t.Fatalf("event is not JSON: %v: %s", err, d.body)
+			}
+			if event.ID != "event-1" || event.FunctionID != "function" || event.Name != "greeter" {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{name: "delivered first time", attempts: 1},
+		{name: "server errors are retried", statuses: []int{500, 503}, attempts: 3},
+		{name: "rate limiting is retried", statuses: []int{429}, attempts: 2},
+		{name: "retries stop at the maximum", statuses: []int{500, 500, 500, 500}, attempts: 3},
+		{name: "client errors aren't retried", statuses: []int{400}, attempts: 1},
+		{name: "redirects aren't followed", statuses: []int{302}, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			s := newTestSink(t, r.URL, nil)
+
+			s.Emit(context.Background(), Event{ID: "event-1", Type: FunctionExecuted})
+			closeSink(t, s)
+
+			deliveries := r.got()
+			if len(deliveries) != tt.attempts {
+				t.Fatalf("%d delivery attempts, want %d", len(deliveries), tt.attempts)
+			}
+			// Every attempt sends the same event, so receivers can drop repeats
+			for _, d := range deliveries[1:] {
+				if string(d.body) != string(deliveries[0].body) {
+					t.Errorf("retry sent %s, first attempt %s", d.body, deliveries[0].body)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookSinkDeliversInOrder(t *testing.T) {
+	r := newReceiver(t, 500)
+	s := newTestSink(t, r.URL, nil)
+
+	for _, id := range []string{"1", "2", "3"} {
+		s.Emit(context.Background(), Event{ID: id, Type: FunctionExecuted})
+	}
+	closeSink(t, s)
+
+	var ids []string
+	for _, d := range r.got() {
+		var event Event
+		if err := json.Unmarshal(d.body, &event); err != nil {
+			t.Fatalf("event is not JSON: %v", err)
+		}
+		ids = append(ids, event.ID)
+	}
+	// The first event is retried before the next is sent
+	if want := []string{"1", "1", "2", "3"}; !slices.Equal(ids, want) {
+		t.Errorf("delivered %q, want %q", ids, want)
+	}
+}
+
+func TestWebhookSinkDropsWhenQueueIsFull(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	var delivered []string
+	received := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event Event
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Errorf("event is not JSON: %v", err)
+		}
+		mutex.Lock()
+		delivered = append(delivered, event.ID)
+		mutex.Unlock()
+		received <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+
+	s := newTestSink(t, server.URL, func(cfg *config.EventsConfig) {
+		cfg.QueueSize = 1
+	})
+
+	// The first event is in flight, held up by the receiver
+	s.Emit(context.Background(), Event{ID: "in-flight", Type: FunctionExecuted})
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first event wasn't delivered")
+	}
+
+	// The next fills the queue, and the rest are dropped without waiting
+	start := time.Now()
+	s.Emit(context.Background(), Event{ID: "queued", Type: FunctionExecuted})
+	for i := 0; i < 100; i++ {
+		s.Emit(context.Background(), Event{ID: "dropped", Type: FunctionExecuted})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("emitting to a full queue took %s", elapsed)
+	}
+
+	close(release)
+	closeSink(t, s)
+	if want := []string{"in-flight", "queued"}; !slices.Equal(delivered, want) {
+		t.Errorf("delivered %q, want %q", delivered, want)
+	}
+}
+
+func TestWebhookSinkDoesNotRetryBlockedAddress(t *testing.T) {
+	r := newReceiver(t)
+	s := newTestSink(t, r.URL, func(cfg *config.EventsConfig) {
+		cfg.AllowPrivate = false
+		cfg.RetryBackoff = time.Hour
+	})
+
+	s.Emit(context.Background(), Event{ID: "event-1", Type: FunctionExecuted})
+	// With an hour between retries, Close would time out if it retried
+	closeSink(t, s)
+	if n := len(r.got()); n != 0 {
+		t.Errorf("%d events delivered to a loopback receiver, want 0", n)
+	}
+}
+
+func TestWebhookSinkDropsEventsAfterClose(t *testing.T) {
+	r := newReceiver(t)
+	s := newTestSink(t, r.URL, nil)
+	closeSink(t, s)
+
+	s.Emit(context.Background(), Event{ID: "late", Type: FunctionExecuted})
+	if err := s.Close(context.Background()); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if n := len(r.got()); n != 0 {
+		t.Errorf("%d events delivered after Close, want 0", n)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cfg := config.LoadConfig().Events
+
+	cfg.Sink = "none"
+	sink, err := New(&cfg, nil)
+	if err != nil {
+		t.Fatalf("New(none): %v", err)
+	}
+	if _, ok := sink.(Nop); !ok {
+		t.Errorf("New(none) = %T, want Nop", sink)
+	}
+
+	cfg.Sink = "webhook"
+	cfg.URL = "https://example.com/events"
+	sink, err = New(&cfg, nil)
+	if err != nil {
+		t.Fatalf("New(webhook): %v", err)
+	}
+	defer sink.Close(context.Background())
+	if _, ok := sink.(*WebhookSink); !ok {
+		t.Errorf("New(webhook) = %T, want *WebhookSink", sink)
+	}
+
+	cfg.Sink = "kafka"
+	if _, err := New(&cfg, nil); err == nil {
+		t.Error("unsupported sink was accepted")
+	}
+}
@@ -0,0 +1,350 @@
+// Package gitsource fetches function code from Git repositories so it can be
+// deployed like an uploaded archive
+package gitsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/config"
+	"youtube_serverless/netsafe"
+	"youtube_serverless/requestctx"
+)
+
+var (
+	// ErrDisabled is returned when deploying from Git is turned off
+	ErrDisabled = errors.New("deploying from git is disabled")
+	// ErrInvalidSource is returned for unusable repository URLs, refs and
+	// subdirectories, and for repositories containing symlinks
+	ErrInvalidSource = errors.New("invalid git source")
+	// ErrNotFound is returned when the repository or ref doesn't exist or
+	// can't be read with the given credentials
+	ErrNotFound = errors.New("repository or ref not found")
+	// ErrTooLarge is returned when a repository exceeds the download or
+	// checkout limits
+	ErrTooLarge = errors.New("repository too large")
+	// ErrTimeout is returned when fetching a repository takes longer than the
+	// clone timeout
+	ErrTimeout = errors.New("git clone timed out")
+)
+
+// Source identifies code in a Git repository
+type Source struct {
+	URL    string // http or https URL of the repository
+	Ref    string // branch or tag; the repository's default branch when empty
+	Subdir string // directory of the function within the repository
+	Token  string // access token for private repositories
+}
+
+// Fetcher clones repositories over HTTP through an SSRF-safe client and
+// checks out their code
+type Fetcher struct {
+	config   *config.GitConfig
+	maxSize  int64 // bytes of files a checkout may write
+	maxFiles int   // files and directories a checkout may write
+	dirMode  os.FileMode
+	fileMode os.FileMode
+	guard    *netsafe.Guard
+}
+
+// NewFetcher creates a Fetcher whose checkouts are limited, and given
+// permissions, like extracted archives. It replaces the http and https transports of the Git client for
+// the whole process, so every clone connects only to addresses guard allows.
+func NewFetcher(cfg *config.GitConfig, fileOps *config.FileOpsConfig, guard *netsafe.Guard) *Fetcher {
+	httpClient := guard.Client(cfg.CloneTimeout)
+	httpClient.Transport = &limitedTransport{base: httpClient.Transport}
+	transport := githttp.NewClient(httpClient)
+	client.InstallProtocol("http", transport)
+	client.InstallProtocol("https", transport)
+
+	return &Fetcher{
+		config:   cfg,
+		maxSize:  fileOps.MaxExtractedSize,
+		maxFiles: fileOps.MaxArchiveEntries,
+		dirMode:  fileOps.ExtractDirMode,
+		fileMode: fileOps.ExtractFileMode,
+		guard:    guard,
+	}
+}
+
+// Fetch shallow-clones source into memory and writes the files of its
+// subdirectory to dest, returning the commit it checked out
+func (f *Fetcher) Fetch(ctx context.Context, source Source, dest string) (string, error) {
+	requestID := requestctx.ID(ctx)
+	if !f.config.Enabled {
+		return "", ErrDisabled
+	}
+
+	subdir, err := cleanSubdir(source.Subdir)
+	if err != nil {
+		return "", err
+	}
+	if err := f.guard.CheckURL(ctx, source.URL); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+	auth, err := f.auth(source)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.config.CloneTimeout)
+	defer cancel()
+
+	repo, err := f.clone(ctx, source, auth)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to read checked out ref: %v", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s: %v", head.Hash(), err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("failed to read tree of commit %s: %v", head.Hash(), err)
+	}
+	if subdir != "" {
+		tree, err = tree.Tree(subdir)
+		if err != nil {
+			return "", fmt.Errorf("%w: directory %q not found in the repository", ErrInvalidSource, subdir)
+		}
+	}
+
+	if err := os.MkdirAll(dest, f.dirMode); err != nil {
+		return "", fmt.Errorf("failed to create checkout directory: %v", err)
+	}
+	if err := f.checkout(tree, dest); err != nil {
+		return "", err
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("url", source.URL).
+		Str("ref", head.Name().Short()).
+		Str("commit", head.Hash().String()).
+		Str("subdir", subdir).
+		Msg("Fetched code from git repository")
+	return head.Hash().String(), nil
+}
+
+// clone shallow-clones the ref of source into memory. A ref is tried as a
+// branch and then as a tag, unless it is a full reference name.
+func (f *Fetcher) clone(ctx context.Context, source Source, auth transport.AuthMethod) (*git.Repository, error) {
+	var candidates []plumbing.ReferenceName
+	switch {
+	case source.Ref == "":
+		candidates = []plumbing.ReferenceName{plumbing.HEAD}
+	case strings.HasPrefix(source.Ref, "refs/"):
+		candidates = []plumbing.ReferenceName{plumbing.ReferenceName(source.Ref)}
+	default:
+		candidates = []plumbing.ReferenceName{
+			plumbing.NewBranchReferenceName(source.Ref),
+			plumbing.NewTagReferenceName(source.Ref),
+		}
+	}
+
+	var err error
+	for _, ref := range candidates {
+		var repo *git.Repository
+		downloaded := &budget{}
+		downloaded.remaining.Store(f.config.MaxSize)
+
+		repo, err = git.CloneContext(context.WithValue(ctx, budgetKey{}, downloaded), memory.NewStorage(), nil, &git.CloneOptions{
+			URL:           source.URL,
+			Auth:          auth,
+			ReferenceName: ref,
+			SingleBranch:  true,
+			Depth:         1,
+			Tags:          git.NoTags,
+		})
+		switch {
+		case err == nil:
+			return repo, nil
+		case downloaded.remaining.Load() < 0:
+			return nil, fmt.Errorf("%w: more than %d bytes to download", ErrTooLarge, f.config.MaxSize)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return nil, fmt.Errorf("%w after %s", ErrTimeout, f.config.CloneTimeout)
+		case errors.Is(err, netsafe.ErrBlocked):
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
+		case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, transport.ErrAuthenticationRequired),
+			errors.Is(err, transport.ErrAuthorizationFailed), errors.Is(err, transport.ErrEmptyRemoteRepository):
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		case errors.Is(err, git.NoMatchingRefSpecError{}), errors.Is(err, plumbing.ErrReferenceNotFound):
+			// Try the next candidate
+		default:
+			return nil, fmt.Errorf("failed to clone repository: %v", err)
+		}
+	}
+	return nil, fmt.Errorf("%w: no branch or tag named %q", ErrNotFound, source.Ref)
+}
+
+// auth returns the credentials for source: its own token, or the configured
+// one when the repository is on one of the token hosts. Tokens are only
+// sent over https.
+func (f *Fetcher) auth(source Source) (transport.AuthMethod, error) {
+	target, err := url.Parse(source.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSource, err)
+	}
+
+	token := source.Token
+	if token == "" && f.config.Token != "" && slices.Contains(f.config.TokenHosts, strings.ToLower(target.Hostname())) {
+		token = f.config.Token
+	}
+	if token == "" {
+		return nil, nil
+	}
+	if target.Scheme != "https" {
+		return nil, fmt.Errorf("%w: tokens are only sent over https", ErrInvalidSource)
+	}
+	// Hosts ignore the user name of token authentication, but it can't be empty
+	return &githttp.BasicAuth{Username: "git", Password: token}, nil
+}
+
+// checkout writes the regular files of tree to dest, enforcing the size and
+// file count limits. Symlinks are rejected as they are in archives, and
+// submodules are skipped.
+func (f *Fetcher) checkout(tree *object.Tree, dest string) error {
+	var totalSize int64
+	var entries int
+
+	return tree.Files().ForEach(func(file *object.File) error {
+		if file.Mode == filemode.Symlink {
+			return fmt.Errorf("%w: symlinks are not supported: %s", ErrInvalidSource, file.Name)
+		}
+
+		entries++
+		totalSize += file.Size
+		if entries > f.maxFiles {
+			return fmt.Errorf("%w: more than %d files", ErrTooLarge, f.maxFiles)
+		}
+		if totalSize > f.maxSize {
+			return fmt.Errorf("%w: more than %d bytes of files", ErrTooLarge, f.maxSize)
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(file.Name))
+		if rel, err := filepath.Rel(dest, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%w: invalid file path %q", ErrInvalidSource, file.Name)
+		}
+
+		perm := f.fileMode
+		if file.Mode == filemode.Executable {
+			perm = f.dirMode
+		}
+		return writeFile(file, target, perm, f.dirMode)
+	})
+}
+
+// writeFile writes the contents of a blob to target, creating its directory
+// with dirPerm
+func writeFile(file *object.File, target string, perm, dirPerm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), dirPerm); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", file.Name, err)
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", file.Name, err)
+	}
+	defer reader.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", file.Name, err)
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %v", file.Name, err)
+	}
+	return out.Close()
+}
+
+// cleanSubdir normalizes a subdirectory given in a request, rejecting ones
+// that would leave the repository
+func cleanSubdir(subdir string) (string, error) {
+	if subdir == "" {
+		return "", nil
+	}
+	cleaned := path.Clean(strings.ReplaceAll(subdir, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: subdir %q must be a path inside the repository", ErrInvalidSource, subdir)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// budgetKey is the context key of the download budget of a clone
+type budgetKey struct{}
+
+// budget counts down the bytes a clone may still download
+type budget struct {
+	remaining atomic.Int64
+}
+
+// limitedTransport stops reading responses once the clone they belong to
+// has downloaded more than its budget
+type limitedTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := req.Context().Value(budgetKey{}).(*budget); ok {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, budget: b}
+	}
+	return resp, nil
+}
+
+// limitedBody is a response body that fails once its budget is spent
+type limitedBody struct {
+	io.ReadCloser
+	budget *budget
+}
+
+// Read implements io.Reader. Bytes past the budget are withheld, so the
+// clone fails even if the error is ignored.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	remaining := b.budget.remaining.Load()
+	if remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if left := b.budget.remaining.Add(-int64(n)); left < 0 {
+		return n + int(left), ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/docker"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// startupReconcileTimeout bounds the reconciliation run when the server starts
+const startupReconcileTimeout = time.Minute
+
+// ReconcileHandler handles POST requests that reconcile stored functions with
+// the images present in Docker, removing functions whose image is gone
+func (h *ServerHandler) ReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST requests are accepted")
+		return
+	}
+
+	report, err := h.functionStore.Reconcile(ctx, h.dockerManager)
+	// Functions removed before a failure are gone either way
+	for _, functionID := range report.RemovedFunctions {
+		h.scheduler.Remove(functionID)
+		h.removeSource(functionID)
+		h.executionLogs.remove(functionID)
+	}
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to reconcile functions")
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to reconcile functions", err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
+
+// reconcileOnStartup drops functions whose image was removed while the server
+// was down. Failures are logged rather than preventing startup.
+func (h *ServerHandler) reconcileOnStartup() {
+	ctx, cancel := context.WithTimeout(context.Background(), startupReconcileTimeout)
+	defer cancel()
+
+	report, err := h.functionStore.Reconcile(ctx, h.dockerManager)
+	for _, functionID := range report.RemovedFunctions {
+		h.removeSource(functionID)
+		h.executionLogs.remove(functionID)
+	}
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Failed to reconcile functions on startup")
+	}
+}
+
+// CleanupHandler handles POST requests that remove platform images no
+// function uses
+func (h *ServerHandler) CleanupHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST requests are accepted")
+		return
+	}
+
+	report, err := h.CleanupImages(ctx)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to clean up images")
+		if errors.Is(err, docker.ErrDaemonUnavailable) {
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Docker is unavailable", err.Error())
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to clean up images", err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
+
+// CleanupImages removes platform images that no stored function uses
+func (h *ServerHandler) CleanupImages(ctx context.Context) (models.ImageCleanupReport, error) {
+	inUse := make(map[string]bool)
+	for _, metadata := range h.functionStore.ListFunctions(ctx) {
+		inUse[metadata.ImageID] = true
+	}
+	return h.dockerManager.CleanupImages(ctx, inUse)
+}
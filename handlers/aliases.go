@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// aliasPattern matches valid alias names
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// AliasesHandler lists every alias, including those whose function has been
+// deleted
+func (h *ServerHandler) AliasesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, models.AliasListResponse{
+		Aliases: h.functionStore.ListAliases(ctx),
+	})
+}
+
+// AliasHandler handles GET, PUT and DELETE requests for a single alias. PUT
+// creates the alias or repoints it at another function, optionally sending a
+// share of executions to a canary, so traffic can move between versions of a
+// function without changing its callers.
+func (h *ServerHandler) AliasHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/aliases/")
+	if !aliasPattern.MatchString(name) {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("alias", name).
+			Msg("Invalid alias name")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid alias name",
+			"An alias must be 1-63 letters, digits, '.', '_' or '-', starting with a letter or digit")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		alias, err := h.functionStore.GetAlias(ctx, name)
+		if err != nil {
+			h.respondWithAliasError(w, requestID, name, err)
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, alias)
+
+	case http.MethodPut:
+		var request models.AliasRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Err(err).
+				Msg("Failed to parse request body")
+			utils.RespondWithBodyError(w, "Invalid request body", err)
+			return
+		}
+		if request.FunctionID == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Missing function ID", "The 'functionId' field is required")
+			return
+		}
+		if request.CanaryWeight < 0 || request.CanaryWeight > 100 {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid canary weight",
+				fmt.Sprintf("The 'canaryWeight' field must be between 0 and 100, got %d", request.CanaryWeight))
+			return
+		}
+		if request.CanaryWeight > 0 && request.CanaryFunctionID == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "Missing canary function ID", "A 'canaryWeight' requires the 'canaryFunctionId' field")
+			return
+		}
+
+		alias, err := h.functionStore.SetAlias(ctx, name, request)
+		if err != nil {
+			h.respondWithAliasError(w, requestID, name, err)
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, alias)
+
+	case http.MethodDelete:
+		if err := h.functionStore.DeleteAlias(ctx, name); err != nil {
+			h.respondWithAliasError(w, requestID, name, err)
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, map[string]string{
+			"message": fmt.Sprintf("Alias %s deleted successfully", name),
+		})
+
+	default:
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET, PUT and DELETE requests are accepted")
+	}
+}
+
+// respondWithAliasError maps an alias store error to an HTTP error response
+func (h *ServerHandler) respondWithAliasError(w http.ResponseWriter, requestID, name string, err error) {
+	log.Warn().
+		Str("request_id", requestID).
+		Str("alias", name).
+		Err(err).
+		Msg("Alias request failed")
+
+	switch {
+	case errors.Is(err, store.ErrAliasNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Alias not found", err.Error())
+	case errors.Is(err, store.ErrDanglingAlias):
+		utils.RespondWithError(w, http.StatusNotFound, "Alias points to a deleted function", err.Error())
+	case errors.Is(err, store.ErrFunctionNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+	case errors.Is(err, store.ErrStoreUnavailable):
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Function store is unavailable", err.Error())
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to access alias", err.Error())
+	}
+}
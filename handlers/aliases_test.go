@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"youtube_serverless/docker/dockertest"
+	"youtube_serverless/models"
+)
+
+// deployVersions deploys two versions of a function, blue and green, that
+// print their own image, and returns their submissions
+func (s *testServer) deployVersions(t *testing.T) (blue, green models.SubmissionResponse) {
+	t.Helper()
+
+	s.daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		return dockertest.Result{Stdout: p.Image}
+	}
+	blue = s.deploy(t, map[string]string{"main.py": "print('blue')\n"}, nil)
+	green = s.deploy(t, map[string]string{"main.py": "print('green')\n"}, nil)
+	return blue, green
+}
+
+// executeAlias executes through the alias and returns the response
+func (s *testServer) executeAlias(t *testing.T, alias string) (int, string) {
+	t.Helper()
+
+	w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{Alias: alias})
+	if w.Code != http.StatusOK {
+		return w.Code, w.Body.String()
+	}
+	var response models.ExecutionResponse
+	decode(t, w, &response)
+	return w.Code, strings.TrimSpace(response.Output)
+}
+
+func TestAliasResolvesAndRepoints(t *testing.T) {
+	s := newTestServer(t, nil)
+	blue, green := s.deployVersions(t)
+
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{FunctionID: blue.FunctionID}); w.Code != http.StatusOK {
+		t.Fatalf("set alias: status %d: %s", w.Code, w.Body)
+	}
+	if code, output := s.executeAlias(t, "live"); code != http.StatusOK || output != blue.ImageID {
+		t.Errorf("execute through the alias ran %q (status %d), want blue %q", output, code, blue.ImageID)
+	}
+
+	// Repointing sends the next call to the new version
+	w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{FunctionID: green.FunctionID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("repoint alias: status %d: %s", w.Code, w.Body)
+	}
+	var alias models.Alias
+	decode(t, w, &alias)
+	if alias.FunctionID != green.FunctionID || alias.PreviousFunctionID != blue.FunctionID {
+		t.Errorf("repointed alias = %+v, want green after blue", alias)
+	}
+	if code, output := s.executeAlias(t, "live"); code != http.StatusOK || output != green.ImageID {
+		t.Errorf("execute after repointing ran %q (status %d), want green %q", output, code, green.ImageID)
+	}
+
+	// An unknown alias is not found
+	if code, body := s.executeAlias(t, "missing"); code != http.StatusNotFound || !strings.Contains(body, "Alias not found") {
+		t.Errorf("unknown alias: status %d: %s", code, body)
+	}
+}
+
+func TestAliasToDeletedFunctionIsRejected(t *testing.T) {
+	s := newTestServer(t, nil)
+	blue, green := s.deployVersions(t)
+
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{FunctionID: green.FunctionID}); w.Code != http.StatusOK {
+		t.Fatalf("set alias: status %d: %s", w.Code, w.Body)
+	}
+	if w := s.doJSON(t, http.MethodDelete, "/api/functions/"+green.FunctionID, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete function: status %d: %s", w.Code, w.Body)
+	}
+
+	if code, body := s.executeAlias(t, "live"); code != http.StatusNotFound || !strings.Contains(body, "Alias points to a deleted function") {
+		t.Errorf("execute through a dangling alias: status %d: %s", code, body)
+	}
+	w := s.doJSON(t, http.MethodGet, "/api/aliases/live", nil)
+	var alias models.Alias
+	decode(t, w, &alias)
+	if !alias.Dangling {
+		t.Errorf("alias = %+v, want it dangling", alias)
+	}
+
+	// Pointing an alias at a deleted function is refused
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/next", models.AliasRequest{FunctionID: green.FunctionID}); w.Code != http.StatusNotFound {
+		t.Errorf("alias to a deleted function: status %d, want 404", w.Code)
+	}
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/next", models.AliasRequest{FunctionID: blue.FunctionID, CanaryFunctionID: green.FunctionID, CanaryWeight: 10}); w.Code != http.StatusNotFound {
+		t.Errorf("alias with a deleted canary: status %d, want 404", w.Code)
+	}
+
+	// Repointing heals the alias
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{FunctionID: blue.FunctionID}); w.Code != http.StatusOK {
+		t.Fatalf("repoint alias: status %d: %s", w.Code, w.Body)
+	}
+	if code, output := s.executeAlias(t, "live"); code != http.StatusOK || output != blue.ImageID {
+		t.Errorf("execute after repointing ran %q (status %d), want blue", output, code)
+	}
+}
+
+func TestAliasCanarySplit(t *testing.T) {
+	s := newTestServer(t, nil)
+	blue, green := s.deployVersions(t)
+
+	// The extremes of the split are exact whatever the roll
+	for _, tt := range []struct {
+		weight int
+		want   string
+	}{
+		{weight: 0, want: blue.ImageID},
+		{weight: 100, want: green.ImageID},
+	} {
+		w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{
+			FunctionID:       blue.FunctionID,
+			CanaryFunctionID: green.FunctionID,
+			CanaryWeight:     tt.weight,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("set alias: status %d: %s", w.Code, w.Body)
+		}
+		for i := 0; i < 20; i++ {
+			if code, output := s.executeAlias(t, "live"); code != http.StatusOK || output != tt.want {
+				t.Fatalf("weight %d: execute ran %q (status %d), want %q", tt.weight, output, code, tt.want)
+			}
+		}
+	}
+
+	// A split in between sends calls to both
+	w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{
+		FunctionID:       blue.FunctionID,
+		CanaryFunctionID: green.FunctionID,
+		CanaryWeight:     50,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("set alias: status %d: %s", w.Code, w.Body)
+	}
+	ran := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		code, output := s.executeAlias(t, "live")
+		if code != http.StatusOK {
+			t.Fatalf("execute: status %d: %s", code, output)
+		}
+		ran[output]++
+	}
+	if ran[blue.ImageID] == 0 || ran[green.ImageID] == 0 {
+		t.Errorf("an even split ran blue %d and green %d times", ran[blue.ImageID], ran[green.ImageID])
+	}
+}
+
+func TestAliasRequestValidation(t *testing.T) {
+	s := newTestServer(t, nil)
+	blue, green := s.deployVersions(t)
+
+	tests := []struct {
+		name    string
+		request models.AliasRequest
+		status  int
+	}{
+		{name: "no function", request: models.AliasRequest{}, status: http.StatusBadRequest},
+		{name: "missing function", request: models.AliasRequest{FunctionID: "missing"}, status: http.StatusNotFound},
+		{name: "negative weight", request: models.AliasRequest{FunctionID: blue.FunctionID, CanaryFunctionID: green.FunctionID, CanaryWeight: -1}, status: http.StatusBadRequest},
+		{name: "weight over 100", request: models.AliasRequest{FunctionID: blue.FunctionID, CanaryFunctionID: green.FunctionID, CanaryWeight: 101}, status: http.StatusBadRequest},
+		{name: "weight without a canary", request: models.AliasRequest{FunctionID: blue.FunctionID, CanaryWeight: 10}, status: http.StatusBadRequest},
+		{name: "canary without weight", request: models.AliasRequest{FunctionID: blue.FunctionID, CanaryFunctionID: green.FunctionID}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.doJSON(t, http.MethodPut, "/api/aliases/live", tt.request); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/-live", models.AliasRequest{FunctionID: blue.FunctionID}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid alias name: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/docker"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/schema"
+	"youtube_serverless/utils"
+)
+
+// BatchExecuteHandler runs a function once per input, concurrently up to the
+// configured fan-out, and responds with every result in input order. Failed
+// executions are reported per item rather than failing the batch.
+func (h *ServerHandler) BatchExecuteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST requests are accepted")
+		return
+	}
+
+	var request models.BatchExecutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if request.FunctionID == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing function ID", "The 'functionId' field is required")
+		return
+	}
+	if len(request.Inputs) == 0 {
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing inputs", "The 'inputs' field must list at least one input")
+		return
+	}
+	if limit := h.config.Batch.MaxSize; len(request.Inputs) > limit {
+		utils.RespondWithError(w, http.StatusBadRequest, "Batch too large",
+			fmt.Sprintf("A batch may have at most %d inputs, got %d", limit, len(request.Inputs)))
+		return
+	}
+
+	// An unknown function fails the whole batch rather than every item
+	if _, err := h.functionStore.GetFunction(ctx, request.FunctionID); err != nil {
+		h.respondWithExecutionError(w, requestID, request.FunctionID, err)
+		return
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("function_id", request.FunctionID).
+		Int("inputs", len(request.Inputs)).
+		Msg("Executing batch")
+
+	results := h.executeBatch(ctx, request.FunctionID, request.Inputs)
+	if ctx.Err() != nil {
+		// The client disconnected or the request timed out
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", request.FunctionID).
+			Err(ctx.Err()).
+			Msg("Batch execution cancelled")
+		return
+	}
+
+	response := models.BatchExecutionResponse{
+		FunctionID: request.FunctionID,
+		Results:    results,
+	}
+	for _, result := range results {
+		if result.Error == "" {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("function_id", request.FunctionID).
+		Int("succeeded", response.Succeeded).
+		Int("failed", response.Failed).
+		Msg("Batch executed")
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// executeBatch runs the function once per input and returns the results in
+// input order. At most the batch concurrency, and never more than the
+// container limit, run at once.
+func (h *ServerHandler) executeBatch(ctx context.Context, functionID string, inputs []map[string]interface{}) []models.BatchResult {
+	concurrency := h.config.Batch.Concurrency
+	if limit := h.config.Docker.ContainerLimit; limit < concurrency {
+		concurrency = limit
+	}
+
+	results := make([]models.BatchResult, len(inputs))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, input := range inputs {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return results
+		}
+
+		wg.Add(1)
+		go func(i int, input map[string]interface{}) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			response, err := h.executeFunction(ctx, functionID, input, "", nil, 0)
+			results[i] = batchResult(response, err)
+		}(i, input)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// batchResult converts the outcome of one execution in a batch to its result,
+// with the status code the equivalent single execution would have failed with.
+// Non-zero exits keep the function's output alongside the error.
+func batchResult(response *models.ExecutionResponse, err error) models.BatchResult {
+	var result models.BatchResult
+	if response != nil {
+		result.ExecutionResponse = *response
+	}
+	if err == nil {
+		return result
+	}
+
+	result.Error = err.Error()
+	var schemaErr *schema.ValidationError
+	var envErr *docker.EnvError
+	var limitErr *docker.InputLimitError
+	switch {
+	case errors.As(err, &schemaErr):
+		result.StatusCode = http.StatusBadRequest
+		result.Error = strings.Join(schemaErr.Violations, "; ")
+	case errors.As(err, &envErr), errors.As(err, &limitErr):
+		result.StatusCode = http.StatusBadRequest
+	case errors.Is(err, docker.ErrContainerLimitReached), errors.Is(err, docker.ErrImageQueueTimeout):
+		result.StatusCode = http.StatusTooManyRequests
+	case errors.Is(err, docker.ErrDaemonUnavailable), errors.Is(err, docker.ErrHostOverloaded):
+		result.StatusCode = http.StatusServiceUnavailable
+	case errors.Is(err, docker.ErrRunTimeout):
+		result.StatusCode = http.StatusGatewayTimeout
+	case errors.Is(err, errInvalidOutput):
+		result.StatusCode = http.StatusBadGateway
+	default:
+		result.StatusCode = http.StatusInternalServerError
+	}
+	return result
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/events"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// bulkDeleteConcurrency bounds how many functions a bulk delete removes at once
+const bulkDeleteConcurrency = 4
+
+// DeleteFunctionsHandler handles DELETE /api/functions?all=true, deleting
+// every function matching the optional language and olderThan filters along
+// with its image. Failures are reported per function.
+func (h *ServerHandler) DeleteFunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if !h.config.Server.AllowBulkDelete {
+		log.Warn().
+			Str("request_id", requestID).
+			Msg("Bulk delete attempted while disabled")
+		utils.RespondWithError(w, http.StatusForbidden, "Bulk delete is disabled", "Set ALLOW_BULK_DELETE=true to enable it")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("all") != "true" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing confirmation", "Set all=true to delete every function matching the filters")
+		return
+	}
+
+	var cutoff int64
+	if value := query.Get("olderThan"); value != "" {
+		age, err := parseAge(value)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+		cutoff = time.Now().Add(-age).Unix()
+	}
+
+	functions, _ := h.functionStore.ListFunctionsFiltered(ctx, store.ListOptions{
+		Language: query.Get("language"),
+	})
+	var functionIDs []string
+	for _, metadata := range functions {
+		if cutoff == 0 || metadata.CreatedAt < cutoff {
+			functionIDs = append(functionIDs, metadata.FunctionID)
+		}
+	}
+
+	response := models.BulkDeleteResponse{
+		Deleted: []string{},
+		Errors:  map[string]string{},
+	}
+	var mutex sync.Mutex
+	slots := make(chan struct{}, bulkDeleteConcurrency)
+	var wg sync.WaitGroup
+	for _, functionID := range functionIDs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(functionID string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := h.deleteFunction(ctx, functionID)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				response.Errors[functionID] = err.Error()
+				return
+			}
+			response.Deleted = append(response.Deleted, functionID)
+		}(functionID)
+	}
+	wg.Wait()
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("deleted", len(response.Deleted)).
+		Int("failed", len(response.Errors)).
+		Msg("Bulk deleted functions")
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// deleteFunction removes a function, its schedule, retained source and logs,
+// and its image unless another function still uses it. Failing to remove the
+// image is logged rather than returned, since the function is gone by then.
+func (h *ServerHandler) deleteFunction(ctx context.Context, functionID string) error {
+	metadata, err := h.functionStore.GetFunction(ctx, functionID)
+	if err != nil {
+		return err
+	}
+	if err := h.functionStore.DeleteFunction(ctx, functionID); err != nil {
+		return err
+	}
+	h.forgetFunction(ctx, metadata)
+	h.removeUnusedImage(ctx, metadata)
+	return nil
+}
+
+// parseAge parses an age such as "7d", "12h" or "90m". Days are accepted in
+// addition to the units of time.ParseDuration.
+func parseAge(value string) (time.Duration, error) {
+	var age time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		age, err = time.ParseDuration(value)
+	}
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("olderThan must be a positive age such as 7d or 12h, got %q", value)
+	}
+	return age, nil
+}
+
+// forgetFunction cleans up after a function deleted from the store: its
+// schedule, source, execution logs and subscribers. Its image is left to
+// removeUnusedImage.
+func (h *ServerHandler) forgetFunction(ctx context.Context, metadata models.FunctionMetadata) {
+	h.scheduler.Remove(metadata.FunctionID)
+	h.removeSource(metadata.FunctionID)
+	h.executionLogs.remove(metadata.FunctionID)
+	h.emit(ctx, events.Event{
+		Type:       events.FunctionDeleted,
+		FunctionID: metadata.FunctionID,
+		Name:       metadata.Name,
+	})
+}
+
+// removeUnusedImage removes the image of a deleted function, unless another
+// function still uses it. It does so under the build lock for the function's
+// code, so a concurrent submission of that code can't reuse the image as it
+// goes. Callers must not hold a build lock, since keyedMutex isn't reentrant
+// and two callers holding each other's locks could deadlock.
+func (h *ServerHandler) removeUnusedImage(ctx context.Context, metadata models.FunctionMetadata) {
+	release := h.buildLocks.lock(metadata.ContentHash)
+	defer release()
+
+	if h.imageInUse(ctx, metadata.ImageID) {
+		return
+	}
+	if err := h.dockerManager.RemoveImage(ctx, metadata.ImageID); err != nil {
+		log.Warn().
+			Str("request_id", requestctx.ID(ctx)).
+			Str("function_id", metadata.FunctionID).
+			Str("image_id", metadata.ImageID).
+			Err(err).
+			Msg("Failed to remove image of deleted function")
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+)
+
+func TestDeleteWaitsForSubmissionReusingImage(t *testing.T) {
+	s := newTestServer(t, nil)
+	deployed := s.deploy(t, pythonFunction, nil)
+	original := s.getFunction(t, deployed.FunctionID)
+
+	// Hold the build lock as a resubmission of the same code would between
+	// reusing the image and storing its function
+	release := s.buildLocks.lock(original.ContentHash)
+	defer release()
+
+	done := make(chan int)
+	go func() {
+		done <- s.doJSON(t, http.MethodDelete, "/api/functions/"+deployed.FunctionID, nil).Code
+	}()
+
+	// The function goes, but its image stays while the lock is held
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := s.functionStore.GetFunction(context.Background(), deployed.FunctionID); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("function wasn't deleted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if removed := s.daemon.RemovedImages(); len(removed) != 0 {
+		t.Fatalf("image removed under a submission's build lock: %v", removed)
+	}
+
+	// The resubmission stores its function on the image, and the delete
+	// then leaves it
+	resubmitted := original
+	resubmitted.FunctionID = "resubmitted"
+	if err := s.functionStore.StoreFunction(context.Background(), resubmitted); err != nil {
+		t.Fatalf("StoreFunction: %v", err)
+	}
+	release()
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("delete: status %d", code)
+	}
+	if removed := s.daemon.RemovedImages(); len(removed) != 0 {
+		t.Errorf("image of the resubmitted function removed: %v", removed)
+	}
+}
+
+func TestConcurrentDeleteAndResubmitKeepImages(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Server.AllowBulkDelete = true
+	})
+
+	for i := 0; i < 20; i++ {
+		s.deploy(t, pythonFunction, nil)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if w := s.doJSON(t, http.MethodDelete, "/api/functions?all=true", nil); w.Code != http.StatusOK {
+				t.Errorf("bulk delete: status %d: %s", w.Code, w.Body)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if w := s.do(submitRequest(t, pythonFunction, nil)); w.Code != http.StatusOK {
+				t.Errorf("resubmit: status %d: %s", w.Code, w.Body)
+			}
+		}()
+		wg.Wait()
+
+		// Whichever won, every function left runs an image that still exists
+		for _, metadata := range s.functionStore.ListFunctions(context.Background()) {
+			exists, err := s.dockerManager.ImageExists(context.Background(), metadata.ImageID)
+			if err != nil {
+				t.Fatalf("ImageExists: %v", err)
+			}
+			if !exists {
+				t.Fatalf("round %d: function %s runs the removed image %s", i, metadata.FunctionID, metadata.ImageID)
+			}
+		}
+	}
+}
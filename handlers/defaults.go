@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// functionDefaultInput reads a function's default input from the manifest
+// and the "defaultInput" form field, a JSON object whose entries override the
+// manifest's. It returns nil when neither sets any.
+func functionDefaultInput(r *http.Request, manifest *models.Manifest) (map[string]string, error) {
+	var defaults map[string]string
+	for key, value := range manifest.DefaultInput {
+		if defaults == nil {
+			defaults = make(map[string]string)
+		}
+		defaults[key] = value
+	}
+
+	if value := r.FormValue("defaultInput"); value != "" {
+		var override map[string]string
+		if err := json.Unmarshal([]byte(value), &override); err != nil {
+			return nil, fmt.Errorf("defaultInput must be a JSON object of strings: %v", err)
+		}
+		for key, value := range override {
+			if defaults == nil {
+				defaults = make(map[string]string)
+			}
+			defaults[key] = value
+		}
+	}
+	return defaults, nil
+}
+
+// withDefaults returns input merged over the function's default input, so a
+// key given in the call always wins over its default. input is not modified.
+func withDefaults(metadata models.FunctionMetadata, input map[string]interface{}) map[string]interface{} {
+	if len(metadata.DefaultInput) == 0 {
+		return input
+	}
+	merged := make(map[string]interface{}, len(metadata.DefaultInput)+len(input))
+	for key, value := range metadata.DefaultInput {
+		merged[key] = value
+	}
+	return mergeInput(merged, input)
+}
+
+// DefaultInputHandler handles PUT requests replacing a function's default
+// input without redeploying it
+func (h *ServerHandler) DefaultInputHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPut {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only PUT requests are accepted")
+		return
+	}
+
+	var request models.DefaultInputUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	metadata, err := h.functionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+		metadata.DefaultInput = request.DefaultInput
+		return nil
+	})
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to update default input")
+		if errors.Is(err, store.ErrFunctionNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update default input", err.Error())
+		return
+	}
+
+	// Only the keys are logged; the values may be secrets
+	keys := make([]string, 0, len(request.DefaultInput))
+	for key := range request.DefaultInput {
+		keys = append(keys, key)
+	}
+	log.Info().
+		Str("request_id", requestID).
+		Str("function_id", functionID).
+		Strs("keys", keys).
+		Msg("Updated default input")
+
+	utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
+}
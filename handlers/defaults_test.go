@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"youtube_serverless/docker/dockertest"
+	"youtube_serverless/models"
+)
+
+func TestWithDefaults(t *testing.T) {
+	metadata := models.FunctionMetadata{DefaultInput: map[string]string{"format": "png", "quality": "85"}}
+
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name: "defaults alone",
+			want: map[string]interface{}{"format": "png", "quality": "85"},
+		},
+		{
+			name:  "call input wins",
+			input: map[string]interface{}{"quality": float64(50), "width": "100"},
+			want:  map[string]interface{}{"format": "png", "quality": float64(50), "width": "100"},
+		},
+		{
+			name:  "call input wins even when empty",
+			input: map[string]interface{}{"format": ""},
+			want:  map[string]interface{}{"format": "", "quality": "85"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original map[string]interface{}
+			if tt.input != nil {
+				original = make(map[string]interface{}, len(tt.input))
+				for key, value := range tt.input {
+					original[key] = value
+				}
+			}
+
+			if got := withDefaults(metadata, tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withDefaults = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.input, original) {
+				t.Errorf("call input changed to %v", tt.input)
+			}
+		})
+	}
+
+	// Without defaults the call input is used as it is
+	input := map[string]interface{}{"name": "Alice"}
+	if got := withDefaults(models.FunctionMetadata{}, input); !reflect.DeepEqual(got, input) {
+		t.Errorf("withDefaults without defaults = %v, want %v", got, input)
+	}
+}
+
+func TestExecuteMergesCallInputOverDefaults(t *testing.T) {
+	s := newTestServer(t, nil)
+	functionID, inputs := s.deployStdinFunction(t, map[string]string{"defaultInput": `{"format": "png", "quality": "85"}`})
+
+	execute := func(input map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{FunctionID: functionID, Input: input})
+		if w.Code != http.StatusOK {
+			t.Fatalf("execute: status %d: %s", w.Code, w.Body)
+		}
+		return <-inputs
+	}
+
+	want := map[string]interface{}{"format": "png", "quality": float64(50)}
+	if got := execute(map[string]interface{}{"quality": 50}); !reflect.DeepEqual(got, want) {
+		t.Errorf("function input = %v, want %v", got, want)
+	}
+
+	// Stdin input isn't set in the environment, so its defaults are shown
+	if got := s.getFunction(t, functionID).DefaultInput; !reflect.DeepEqual(got, map[string]string{"format": "png", "quality": "85"}) {
+		t.Errorf("defaults in details = %v", got)
+	}
+
+	// Replacing the defaults applies to the next call without a redeploy
+	w := s.doJSON(t, http.MethodPut, "/api/functions/"+functionID+"/defaults", models.DefaultInputUpdateRequest{
+		DefaultInput: map[string]string{"format": "jpeg"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("set defaults: status %d: %s", w.Code, w.Body)
+	}
+	want = map[string]interface{}{"format": "jpeg", "quality": float64(50)}
+	if got := execute(map[string]interface{}{"quality": 50}); !reflect.DeepEqual(got, want) {
+		t.Errorf("function input after update = %v, want %v", got, want)
+	}
+}
+
+func TestDefaultInputIsMaskedInEnvMode(t *testing.T) {
+	s := newTestServer(t, nil)
+	envs := make(chan []string, 1)
+	s.daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		envs <- p.Env
+		return dockertest.Result{}
+	}
+	deployed := s.deploy(t, pythonFunction, map[string]string{"defaultInput": `{"token": "secret", "region": "eu"}`})
+
+	w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{
+		FunctionID: deployed.FunctionID,
+		Input:      map[string]interface{}{"region": "us"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("execute: status %d: %s", w.Code, w.Body)
+	}
+	env := <-envs
+	for _, v := range []string{"TOKEN=secret", "REGION=us"} {
+		if !slices.Contains(env, v) {
+			t.Errorf("container env %q lacks %s", env, v)
+		}
+	}
+
+	// The defaults are set in the environment, so their values are masked
+	want := map[string]string{"token": models.RedactedValue, "region": models.RedactedValue}
+	if got := s.getFunction(t, deployed.FunctionID).DefaultInput; !reflect.DeepEqual(got, want) {
+		t.Errorf("defaults in details = %v, want %v", got, want)
+	}
+	w = s.doJSON(t, http.MethodPut, "/api/functions/"+deployed.FunctionID+"/defaults", models.DefaultInputUpdateRequest{
+		DefaultInput: map[string]string{"token": "rotated"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("set defaults: status %d: %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "rotated") {
+		t.Errorf("update response exposes a default: %s", w.Body)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// envNamePattern matches names that are valid environment variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// functionEnv reads a function's environment variables from the manifest and
+// the "env" form field, a JSON object whose entries override the manifest's.
+// It returns nil when neither sets any.
+func functionEnv(r *http.Request, manifest *models.Manifest) (map[string]string, error) {
+	var env map[string]string
+	for key, value := range manifest.Env {
+		if env == nil {
+			env = make(map[string]string)
+		}
+		env[key] = value
+	}
+
+	if value := r.FormValue("env"); value != "" {
+		var override map[string]string
+		if err := json.Unmarshal([]byte(value), &override); err != nil {
+			return nil, fmt.Errorf("env must be a JSON object of strings: %v", err)
+		}
+		for key, value := range override {
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env[key] = value
+		}
+	}
+
+	if err := checkEnv(env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// checkEnv returns an error if any name is not a valid environment variable
+func checkEnv(env map[string]string) error {
+	for key := range env {
+		if !envNamePattern.MatchString(key) {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	return nil
+}
+
+// EnvHandler handles PUT requests replacing a function's environment
+// variables without redeploying it
+func (h *ServerHandler) EnvHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPut {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only PUT requests are accepted")
+		return
+	}
+
+	var request models.EnvUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if err := checkEnv(request.Env); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Invalid environment variables")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid environment variables", err.Error())
+		return
+	}
+
+	metadata, err := h.functionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+		metadata.Env = request.Env
+		return nil
+	})
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to update environment variables")
+		if errors.Is(err, store.ErrFunctionNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update environment variables", err.Error())
+		return
+	}
+
+	// Only the names are logged; the values are secrets
+	names := make([]string, 0, len(request.Env))
+	for key := range request.Env {
+		names = append(names, key)
+	}
+	log.Info().
+		Str("request_id", requestID).
+		Str("function_id", functionID).
+		Strs("env", names).
+		Msg("Updated environment variables")
+
+	utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
+}
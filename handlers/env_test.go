@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+	"youtube_serverless/models"
+)
+
+func TestFunctionEnvIsMergedAndRedacted(t *testing.T) {
+	s := newTestServer(t, nil)
+	envs := make(chan []string, 1)
+	s.daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		envs <- p.Env
+		return dockertest.Result{}
+	}
+
+	// The form field overrides the manifest's value
+	files := map[string]string{
+		"main.py":         "print('hello')\n",
+		"serverless.json": `{"env": {"API_KEY": "manifest", "REGION": "eu"}}`,
+	}
+	deployed := s.deploy(t, files, map[string]string{"env": `{"API_KEY": "secret"}`})
+
+	w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{
+		FunctionID: deployed.FunctionID,
+		Input:      map[string]interface{}{"region": "us"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("execute: status %d: %s", w.Code, w.Body)
+	}
+	var containerEnv []string
+	for _, v := range <-envs {
+		if strings.HasPrefix(v, "API_KEY=") || strings.HasPrefix(v, "REGION=") {
+			containerEnv = append(containerEnv, v)
+		}
+	}
+	sort.Strings(containerEnv)
+	if want := "API_KEY=secret REGION=us"; strings.Join(containerEnv, " ") != want {
+		t.Errorf("container env = %q, want %q", containerEnv, want)
+	}
+
+	// Responses show the names but not the values
+	w = s.doJSON(t, http.MethodGet, "/api/functions", nil)
+	var list models.FunctionListResponse
+	decode(t, w, &list)
+	if len(list.Functions) != 1 {
+		t.Fatalf("listed %d functions, want 1", len(list.Functions))
+	}
+	for _, metadata := range []models.FunctionMetadata{list.Functions[0], s.getFunction(t, deployed.FunctionID)} {
+		if len(metadata.Env) != 2 || metadata.Env["API_KEY"] != models.RedactedValue || metadata.Env["REGION"] != models.RedactedValue {
+			t.Errorf("env in response = %v, want masked values", metadata.Env)
+		}
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("list response exposes a secret: %s", w.Body)
+	}
+}
+
+// getFunction returns the function's details as the API responds with them
+func (s *testServer) getFunction(t *testing.T, functionID string) models.FunctionMetadata {
+	t.Helper()
+
+	w := s.doJSON(t, http.MethodGet, "/api/functions/"+functionID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get function: status %d: %s", w.Code, w.Body)
+	}
+	var metadata models.FunctionMetadata
+	decode(t, w, &metadata)
+	return metadata
+}
+
+func TestExecuteLDPreloadInjection(t *testing.T) {
+	for _, policy := range []string{"reject", "drop"} {
+		t.Run(policy, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *config.Config) {
+				cfg.Docker.InputEnvPolicy = policy
+			})
+			envs := make(chan []string, 1)
+			s.daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+				envs <- p.Env
+				return dockertest.Result{}
+			}
+			deployed := s.deploy(t, pythonFunction, nil)
+
+			w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{
+				FunctionID: deployed.FunctionID,
+				Input:      map[string]interface{}{"LD_PRELOAD": "/tmp/evil.so", "name": "Alice"},
+			})
+
+			if policy == "reject" {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status %d, want 400: %s", w.Code, w.Body)
+				}
+				var response models.ErrorResponse
+				decode(t, w, &response)
+				if !strings.Contains(response.Details, "LD_PRELOAD") {
+					t.Errorf("details %q don't name LD_PRELOAD", response.Details)
+				}
+				select {
+				case <-envs:
+					t.Error("rejected input ran the function")
+				default:
+				}
+				return
+			}
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+			}
+			env := <-envs
+			for _, v := range env {
+				if strings.HasPrefix(v, "LD_PRELOAD=") {
+					t.Errorf("container env has %s", v)
+				}
+			}
+			if !slices.Contains(env, "NAME=Alice") {
+				t.Errorf("container env %q lost the allowed input", env)
+			}
+		})
+	}
+}
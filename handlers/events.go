@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"youtube_serverless/events"
+	"youtube_serverless/requestctx"
+)
+
+// emit sends an event to the configured sink, stamped with a new ID, the
+// current time and the ID of the request that caused it
+func (h *ServerHandler) emit(ctx context.Context, event events.Event) {
+	event.ID = uuid.New().String()
+	event.Timestamp = time.Now().Unix()
+	event.RequestID = requestctx.ID(ctx)
+	h.eventSink.Emit(ctx, event)
+}
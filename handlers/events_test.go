@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+	"youtube_serverless/events"
+	"youtube_serverless/models"
+)
+
+// eventReceiver starts a server receiving events, closed when the test ends,
+// and returns its URL and a channel of the events it receives
+func eventReceiver(t *testing.T) (string, <-chan events.Event) {
+	t.Helper()
+
+	received := make(chan events.Event, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event events.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("event is not JSON: %v", err)
+		}
+		received <- event
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, received
+}
+
+// nextEvent waits for the next event the receiver gets
+func nextEvent(t *testing.T, received <-chan events.Event) events.Event {
+	t.Helper()
+
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return events.Event{}
+	}
+}
+
+func TestDeployAndExecuteEmitEvents(t *testing.T) {
+	url, received := eventReceiver(t)
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Events.Sink = "webhook"
+		cfg.Events.URL = url
+		cfg.Events.AllowPrivate = true
+	})
+
+	deployed := s.deploy(t, pythonFunction, map[string]string{"name": "greeter"})
+	event := nextEvent(t, received)
+	if event.Type != events.FunctionDeployed || event.FunctionID != deployed.FunctionID || event.Name != "greeter" || event.ImageID != deployed.ImageID {
+		t.Errorf("deploy event = %+v, want function.deployed for %s", event, deployed.FunctionID)
+	}
+	if event.ID == "" || event.Timestamp == 0 || event.RequestID == "" {
+		t.Errorf("deploy event = %+v, want it stamped with an ID, time and request ID", event)
+	}
+
+	w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{FunctionID: deployed.FunctionID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("execute: status %d: %s", w.Code, w.Body)
+	}
+	executed := nextEvent(t, received)
+	if executed.Type != events.FunctionExecuted || executed.FunctionID != deployed.FunctionID || executed.ExecutionID == "" {
+		t.Errorf("execute event = %+v, want function.executed for %s", executed, deployed.FunctionID)
+	}
+	if executed.Success == nil || !*executed.Success || executed.ExitCode == nil || *executed.ExitCode != 0 {
+		t.Errorf("execute event = %+v, want a success with exit code 0", executed)
+	}
+	if executed.ID == event.ID {
+		t.Errorf("events share the ID %s", event.ID)
+	}
+
+	select {
+	case extra := <-received:
+		t.Errorf("unexpected event %+v", extra)
+	default:
+	}
+}
+
+func TestNoEventsWithoutSink(t *testing.T) {
+	url, received := eventReceiver(t)
+	t.Setenv("EVENTS_SINK", "none")
+	t.Setenv("EVENTS_WEBHOOK_URL", url)
+	t.Setenv("EVENTS_ALLOW_PRIVATE", "true")
+	s := newTestServer(t, nil)
+
+	deployed := s.deploy(t, pythonFunction, nil)
+	if w := s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{FunctionID: deployed.FunctionID}); w.Code != http.StatusOK {
+		t.Fatalf("execute: status %d: %s", w.Code, w.Body)
+	}
+	if w := s.doJSON(t, http.MethodDelete, "/api/functions/"+deployed.FunctionID, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+	}
+
+	if _, ok := s.eventSink.(events.Nop); !ok {
+		t.Errorf("event sink = %T with EVENTS_SINK=none, want events.Nop", s.eventSink)
+	}
+	select {
+	case event := <-received:
+		t.Errorf("event %+v emitted with EVENTS_SINK=none", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker/dockertest"
+	"youtube_serverless/models"
+)
+
+// deployStdinFunction deploys a function that takes its input on stdin, with
+// the form fields, and returns its ID along with a channel receiving the input
+// of each execution
+func (s *testServer) deployStdinFunction(t *testing.T, fields map[string]string) (string, <-chan map[string]interface{}) {
+	t.Helper()
+
+	inputs := make(chan map[string]interface{}, 1)
+	s.daemon.Run = func(ctx context.Context, p dockertest.Process) dockertest.Result {
+		var input map[string]interface{}
+		if err := json.Unmarshal(p.Stdin, &input); err != nil {
+			t.Errorf("stdin is not a JSON object: %v: %s", err, p.Stdin)
+		}
+		inputs <- input
+		return dockertest.Result{}
+	}
+	deployed := s.deploy(t, map[string]string{
+		"main.py":         "print('hello')\n",
+		"serverless.json": `{"input": "stdin"}`,
+	}, fields)
+	return deployed.FunctionID, inputs
+}
+
+func TestExecuteQueryInput(t *testing.T) {
+	s := newTestServer(t, nil)
+	functionID, inputs := s.deployStdinFunction(t, map[string]string{"name": "greeter"})
+	if w := s.doJSON(t, http.MethodPut, "/api/aliases/live", models.AliasRequest{FunctionID: functionID}); w.Code != http.StatusOK {
+		t.Fatalf("set alias: status %d: %s", w.Code, w.Body)
+	}
+
+	jsonRequest := func(target, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+	formRequest := func(target string, fields map[string]string) *http.Request {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			if err := form.WriteField(name, value); err != nil {
+				t.Fatalf("failed to write field: %v", err)
+			}
+		}
+		if err := form.Close(); err != nil {
+			t.Fatalf("failed to close form: %v", err)
+		}
+		r := httptest.NewRequest(http.MethodPost, target, &body)
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		input   map[string]interface{}
+	}{
+		{
+			name:    "GET with parameters",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?functionId="+functionID+"&greeting=Alice&count=3", nil),
+			input:   map[string]interface{}{"greeting": "Alice", "count": "3"},
+		},
+		{
+			name:    "GET by ID passes name",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?functionId="+functionID+"&name=Alice", nil),
+			input:   map[string]interface{}{"name": "Alice"},
+		},
+		{
+			name:    "GET by alias passes name",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?alias=live&name=Alice", nil),
+			input:   map[string]interface{}{"name": "Alice"},
+		},
+		{
+			name:    "GET by name leaves it out",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?name=greeter&greeting=Alice", nil),
+			input:   map[string]interface{}{"greeting": "Alice"},
+		},
+		{
+			name:    "GET with a repeated parameter",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?functionId="+functionID+"&tag=a&tag=b", nil),
+			input:   map[string]interface{}{"tag": []interface{}{"a", "b"}},
+		},
+		{
+			name:    "GET leaves out execution parameters",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?functionId="+functionID+"&timeoutSeconds=5&strict=false&callbackUrl=&greeting=Alice", nil),
+			input:   map[string]interface{}{"greeting": "Alice"},
+		},
+		{
+			name:    "GET without parameters",
+			request: httptest.NewRequest(http.MethodGet, "/api/execute?functionId="+functionID, nil),
+			input:   map[string]interface{}{},
+		},
+		{
+			name:    "JSON body input takes precedence over the query",
+			request: jsonRequest("/api/execute?greeting=Query&extra=1", `{"functionId": "`+functionID+`", "input": {"greeting": "Body", "count": 2}}`),
+			input:   map[string]interface{}{"greeting": "Body", "extra": "1", "count": float64(2)},
+		},
+		{
+			name:    "JSON body without input",
+			request: jsonRequest("/api/execute?greeting=Query", `{"functionId": "`+functionID+`"}`),
+			input:   map[string]interface{}{"greeting": "Query"},
+		},
+		{
+			name: "form input takes precedence over the query",
+			request: formRequest("/api/execute?greeting=Query&extra=1", map[string]string{
+				"functionId": functionID,
+				"input":      `{"greeting": "Form"}`,
+			}),
+			input: map[string]interface{}{"greeting": "Form", "extra": "1"},
+		},
+		{
+			name:    "form by ID passes name in the query",
+			request: formRequest("/api/execute?name=Alice", map[string]string{"functionId": functionID}),
+			input:   map[string]interface{}{"name": "Alice"},
+		},
+		{
+			name:    "form by name leaves it out",
+			request: formRequest("/api/execute?name=greeter", nil),
+			input:   map[string]interface{}{},
+		},
+		{
+			name:    "JSON body passes name in the query",
+			request: jsonRequest("/api/execute?name=Alice", `{"functionId": "`+functionID+`"}`),
+			input:   map[string]interface{}{"name": "Alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(tt.request)
+			if w.Code != http.StatusOK {
+				t.Fatalf("execute: status %d: %s", w.Code, w.Body)
+			}
+			if input := <-inputs; !reflect.DeepEqual(input, tt.input) {
+				t.Errorf("function input = %v, want %v", input, tt.input)
+			}
+		})
+	}
+}
+
+func TestExecuteInputValueLimit(t *testing.T) {
+	const limit = 1024
+	configure := func(cfg *config.Config) {
+		cfg.Docker.MaxInputValueLength = limit
+	}
+
+	for _, mode := range []string{"env", "stdin"} {
+		t.Run(mode, func(t *testing.T) {
+			s := newTestServer(t, configure)
+			var functionID string
+			var inputs <-chan map[string]interface{}
+			if mode == "stdin" {
+				functionID, inputs = s.deployStdinFunction(t, nil)
+			} else {
+				functionID = s.deploy(t, pythonFunction, nil).FunctionID
+			}
+
+			execute := func(n int) *httptest.ResponseRecorder {
+				return s.doJSON(t, http.MethodPost, "/api/execute", models.ExecutionRequest{
+					FunctionID: functionID,
+					Input:      map[string]interface{}{"document": strings.Repeat("x", n)},
+				})
+			}
+
+			if w := execute(limit); w.Code != http.StatusOK {
+				t.Fatalf("value at the limit: status %d: %s", w.Code, w.Body)
+			}
+			if inputs != nil {
+				<-inputs
+			}
+
+			w := execute(limit + 1)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("value one byte over the limit: status %d, want 400: %s", w.Code, w.Body)
+			}
+			var response models.ErrorResponse
+			decode(t, w, &response)
+			if response.Error != "Input too large" || response.Details != "value of DOCUMENT is 1025 bytes, over the limit of 1024" {
+				t.Errorf("response = %+v, want Input too large naming the value", response)
+			}
+			if n := len(s.daemon.Removed()); n != 1 {
+				t.Errorf("%d containers ran, want only the one within the limit", n)
+			}
+		})
+	}
+}
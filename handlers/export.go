@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// ExportHandler handles GET requests for a backup of every stored function
+func (h *ServerHandler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	export := models.FunctionExport{
+		Version:    models.ExportVersion,
+		ExportedAt: time.Now().Unix(),
+		Functions:  h.functionStore.ExportAll(ctx),
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="functions-%d.json"`, export.ExportedAt))
+	utils.RespondWithJSON(w, http.StatusOK, export)
+}
+
+// ImportHandler handles POST requests loading a backup made by ExportHandler.
+// Stored functions are skipped unless overwrite=true. Functions whose image
+// is missing are rebuilt from their retained source when rebuild=true, and
+// otherwise left out.
+func (h *ServerHandler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST requests are accepted")
+		return
+	}
+
+	query := r.URL.Query()
+	overwrite := query.Get("overwrite") == "true"
+	rebuild := query.Get("rebuild") == "true"
+
+	var export models.FunctionExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+	if export.Version != models.ExportVersion {
+		utils.RespondWithError(w, http.StatusBadRequest, "Unsupported export version",
+			fmt.Sprintf("Expected version %d, got %d", models.ExportVersion, export.Version))
+		return
+	}
+
+	errs := map[string]string{}
+	var rebuilt []string
+	ready := make([]models.FunctionMetadata, 0, len(export.Functions))
+	seen := make(map[string]bool, len(export.Functions))
+	for i, metadata := range export.Functions {
+		if metadata.FunctionID == "" || metadata.Language == "" {
+			errs[fmt.Sprintf("functions[%d]", i)] = "functionId and language are required"
+			continue
+		}
+		if seen[metadata.FunctionID] {
+			errs[metadata.FunctionID] = "function appears more than once in the export"
+			continue
+		}
+		seen[metadata.FunctionID] = true
+
+		// Only check images of functions that will be written
+		if !overwrite {
+			if _, err := h.functionStore.GetFunction(ctx, metadata.FunctionID); err == nil {
+				ready = append(ready, metadata)
+				continue
+			}
+		}
+
+		exists := false
+		if metadata.ImageID != "" {
+			var err error
+			exists, err = h.dockerManager.ImageExists(ctx, metadata.ImageID)
+			if err != nil {
+				errs[metadata.FunctionID] = fmt.Sprintf("failed to check image: %v", err)
+				continue
+			}
+		}
+		if !exists {
+			if !rebuild {
+				errs[metadata.FunctionID] = fmt.Sprintf("image %s not found", metadata.ImageID)
+				continue
+			}
+			imageID, err := h.rebuildFromSource(ctx, metadata)
+			if err != nil {
+				errs[metadata.FunctionID] = fmt.Sprintf("image %s not found and rebuilding failed: %v", metadata.ImageID, err)
+				continue
+			}
+			metadata.ImageID = imageID
+			metadata.BuiltAt = time.Now().Unix()
+			rebuilt = append(rebuilt, metadata.FunctionID)
+		}
+		ready = append(ready, metadata)
+	}
+
+	report, err := h.functionStore.ImportAll(ctx, ready, overwrite)
+	if report.Errors == nil {
+		report.Errors = map[string]string{}
+	}
+	for id, message := range errs {
+		report.Errors[id] = message
+	}
+	imported := make(map[string]bool, len(report.Imported))
+	for _, id := range report.Imported {
+		imported[id] = true
+	}
+	report.Rebuilt = []string{}
+	for _, id := range rebuilt {
+		if imported[id] {
+			report.Rebuilt = append(report.Rebuilt, id)
+		}
+	}
+
+	// Functions imported before a failure are stored either way
+	h.registerImported(ctx, ready, imported)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to import functions")
+		if errors.Is(err, store.ErrStoreUnavailable) {
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Function store is unavailable", err.Error())
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to import functions", err.Error())
+		return
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("imported", len(report.Imported)).
+		Int("rebuilt", len(report.Rebuilt)).
+		Int("skipped", len(report.Skipped)).
+		Int("failed", len(report.Errors)).
+		Msg("Imported function backup")
+
+	utils.RespondWithJSON(w, http.StatusOK, report)
+}
+
+// registerImported brings the schedules of imported functions in line with
+// their metadata
+func (h *ServerHandler) registerImported(ctx context.Context, functions []models.FunctionMetadata, imported map[string]bool) {
+	requestID := requestctx.ID(ctx)
+
+	for _, metadata := range functions {
+		if !imported[metadata.FunctionID] {
+			continue
+		}
+		if metadata.Schedule == nil {
+			h.scheduler.Remove(metadata.FunctionID)
+			continue
+		}
+		if err := h.scheduler.Set(metadata.FunctionID, *metadata.Schedule); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("function_id", metadata.FunctionID).
+				Err(err).
+				Msg("Failed to restore schedule of imported function")
+		}
+	}
+}
+
+// rebuildFromSource builds a new image for a function from its retained
+// source archive and returns the image's ID
+func (h *ServerHandler) rebuildFromSource(ctx context.Context, metadata models.FunctionMetadata) (string, error) {
+	if metadata.SourcePath == "" {
+		return "", errors.New("no source was retained")
+	}
+
+	tempDir, err := h.fileHandler.CreateTempDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer h.fileHandler.CleanupTempDir(ctx, tempDir)
+
+	dir, err := h.fileHandler.ExtractArchive(ctx, metadata.SourcePath, tempDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract retained source: %v", err)
+	}
+	manifest, err := h.fileHandler.LoadManifest(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	handlerFile, language, err := h.fileHandler.DetectHandlerFile(ctx, dir, manifest, "")
+	if err != nil {
+		return "", err
+	}
+	if language != metadata.Language {
+		return "", fmt.Errorf("retained source is %s, but the function is %s", language, metadata.Language)
+	}
+
+	image, err := h.dockerManager.BuildDockerImage(ctx, metadata.FunctionID, dir, language, handlerFile, metadata.RuntimeVersion, manifest.BuildArgs)
+	h.metrics.RecordBuild(language, err != nil)
+	if err != nil {
+		return "", err
+	}
+	return image.ImageID, nil
+}
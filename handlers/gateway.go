@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// GatewayHandler invokes a function as a plain HTTP endpoint at /fn/{id} or
+// /fn/{name}. A JSON object body is the function's input, the query string
+// and selected headers are passed as environment variables. The function's
+// stdout is the response body, verbatim with the content type its manifest
+// declares, or wrapped in the JSON ExecutionResponse when it declares none.
+func (h *ServerHandler) GatewayHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET and POST requests are accepted")
+		return
+	}
+
+	ref := strings.TrimPrefix(r.URL.Path, "/fn/")
+	if ref == "" || strings.Contains(ref, "/") {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid function path", "Expected /fn/{functionId} or /fn/{name}")
+		return
+	}
+
+	metadata, ok := h.gatewayFunction(w, r, ref)
+	if !ok {
+		return
+	}
+
+	input, err := gatewayInput(r)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", metadata.FunctionID).
+			Err(err).
+			Msg("Invalid gateway request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	response, err := h.executeFunction(ctx, metadata.FunctionID, input, "", gatewayEnv(r, h.config.Server.GatewayHeaders), 0)
+	if err != nil {
+		h.respondWithExecutionError(w, requestID, metadata.FunctionID, err)
+		return
+	}
+
+	w.Header().Set("X-Function-ID", metadata.FunctionID)
+	w.Header().Set("X-Execution-Id", response.ExecutionID)
+	if metadata.ContentType == "" {
+		utils.RespondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// The output is written as is, so binary output such as images arrives intact
+	w.Header().Set("Content-Type", metadata.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if response.Truncated {
+		w.Header().Set("X-Output-Truncated", "true")
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, response.Output)
+}
+
+// gatewayFunction looks up the function a gateway path refers to, by ID and
+// then by name. On failure it writes the error response and returns false.
+func (h *ServerHandler) gatewayFunction(w http.ResponseWriter, r *http.Request, ref string) (models.FunctionMetadata, bool) {
+	ctx := r.Context()
+
+	metadata, err := h.functionStore.GetFunction(ctx, ref)
+	if err == nil {
+		return metadata, true
+	}
+	if !errors.Is(err, store.ErrFunctionNotFound) {
+		h.respondWithExecutionError(w, requestctx.ID(ctx), ref, err)
+		return models.FunctionMetadata{}, false
+	}
+
+	metadata, err = h.functionStore.GetFunctionByName(ctx, ref)
+	if err != nil {
+		h.respondWithNameError(w, requestctx.ID(ctx), ref, err)
+		return models.FunctionMetadata{}, false
+	}
+	return metadata, true
+}
+
+// gatewayInput decodes the request body, which must be empty or a JSON
+// object, as function input
+func gatewayInput(r *http.Request) (map[string]interface{}, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil, nil
+	}
+
+	var input map[string]interface{}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return nil, fmt.Errorf("the request body must be a JSON object: %v", err)
+	}
+	return input, nil
+}
+
+// gatewayEnv returns the environment variables describing a gateway request:
+// REQUEST_METHOD, QUERY_<NAME> for each query parameter and HTTP_<NAME> for
+// each forwarded header that is set. Repeated values are joined with commas.
+func gatewayEnv(r *http.Request, headers []string) map[string]string {
+	env := map[string]string{"REQUEST_METHOD": r.Method}
+	for name, values := range r.URL.Query() {
+		env["QUERY_"+gatewayEnvName(name)] = strings.Join(values, ",")
+	}
+	for _, header := range headers {
+		if values := r.Header.Values(header); len(values) > 0 {
+			env["HTTP_"+gatewayEnvName(header)] = strings.Join(values, ",")
+		}
+	}
+	return env
+}
+
+// gatewayEnvName converts a query parameter or header name to an environment
+// variable suffix: upper case, with anything but letters and digits replaced
+// by underscores
+func gatewayEnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
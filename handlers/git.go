@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/gitsource"
+	"youtube_serverless/models"
+	"youtube_serverless/netsafe"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// parseGitSubmission reads the JSON body of a deployment from a Git
+// repository and copies its deployment options into the request's form, so
+// they are read like the fields of an upload. It returns nil for requests
+// that upload an archive instead. On failure it writes the error response
+// and returns false.
+func (h *ServerHandler) parseGitSubmission(w http.ResponseWriter, r *http.Request) (*gitsource.Source, bool) {
+	requestID := requestctx.ID(r.Context())
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil, true
+	}
+
+	var submission models.GitSubmission
+	if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return nil, false
+	}
+	if submission.GitURL == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing gitUrl", "JSON submissions must give the URL of a Git repository in gitUrl")
+		return nil, false
+	}
+
+	// Only the query string is parsed from a JSON request
+	if err := r.ParseForm(); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid query string", err.Error())
+		return nil, false
+	}
+	fields := map[string]string{
+		"name":    submission.Name,
+		"handler": submission.Handler,
+		"memory":  submission.Memory,
+		"cpus":    submission.CPUs,
+		"network": submission.Network,
+	}
+	if submission.Env != nil {
+		env, err := json.Marshal(submission.Env)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid environment variables", err.Error())
+			return nil, false
+		}
+		fields["env"] = string(env)
+	}
+	if submission.Tags != nil {
+		tags, err := json.Marshal(submission.Tags)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid tags", err.Error())
+			return nil, false
+		}
+		fields["tags"] = string(tags)
+	}
+	if submission.DefaultInput != nil {
+		defaults, err := json.Marshal(submission.DefaultInput)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid default input", err.Error())
+			return nil, false
+		}
+		fields["defaultInput"] = string(defaults)
+	}
+	for key, value := range fields {
+		if value != "" {
+			r.Form.Set(key, value)
+		}
+	}
+
+	return &gitsource.Source{
+		URL:    submission.GitURL,
+		Ref:    submission.Ref,
+		Subdir: submission.Subdir,
+		Token:  submission.Token,
+	}, true
+}
+
+// fetchGitSource clones source into a directory under tempDir and returns
+// the directory and the commit checked out. On failure it writes the error
+// response and returns false.
+func (h *ServerHandler) fetchGitSource(w http.ResponseWriter, r *http.Request, source *gitsource.Source, tempDir string) (string, string, bool) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	dir := filepath.Join(tempDir, "extracted")
+	commit, err := h.gitFetcher.Fetch(ctx, *source, dir)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("url", source.URL).
+			Str("ref", source.Ref).
+			Err(err).
+			Msg("Failed to fetch git repository")
+		switch {
+		case errors.Is(err, gitsource.ErrDisabled):
+			utils.RespondWithError(w, http.StatusForbidden, "Deploying from Git is disabled", err.Error())
+		case errors.Is(err, gitsource.ErrInvalidSource), errors.Is(err, netsafe.ErrBlocked):
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid Git source", err.Error())
+		case errors.Is(err, gitsource.ErrNotFound):
+			utils.RespondWithError(w, http.StatusBadRequest, "Repository or ref not found", err.Error())
+		case errors.Is(err, gitsource.ErrTooLarge):
+			utils.RespondWithError(w, http.StatusRequestEntityTooLarge, "Repository too large", err.Error())
+		case errors.Is(err, gitsource.ErrTimeout):
+			utils.RespondWithError(w, http.StatusGatewayTimeout, "Git clone timed out", err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusBadGateway, "Failed to fetch Git repository", fmt.Sprintf("%s: %v", source.URL, err))
+		}
+		return "", "", false
+	}
+	return dir, commit, true
+}
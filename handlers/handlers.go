@@ -1,67 +1,236 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"youtube_serverless/config"
 	"youtube_serverless/docker"
+	"youtube_serverless/events"
+	"youtube_serverless/gitsource"
+	"youtube_serverless/jobs"
+	"youtube_serverless/metrics"
 	"youtube_serverless/middleware"
 	"youtube_serverless/models"
+	"youtube_serverless/netsafe"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/scheduler"
+	"youtube_serverless/schema"
 	"youtube_serverless/store"
+	"youtube_serverless/tracing"
 	"youtube_serverless/utils"
+	"youtube_serverless/webhook"
 )
 
+// Page size limits for function listings
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+// healthCheckTimeout bounds how long the health check waits for the Docker daemon
+const healthCheckTimeout = 2 * time.Second
+
 // ServerHandler handles HTTP requests for the serverless platform
 type ServerHandler struct {
 	fileHandler   *utils.FileHandler
 	dockerManager *docker.Manager
-	functionStore *store.FunctionStore
+	functionStore store.FunctionStore
+	jobStore      *jobs.Store
+	jobPool       *jobs.Pool
+	scheduler     *scheduler.Scheduler
+	buildLocks    *keyedMutex // serializes builds of identical code
+	running       *runningCounter
+	idempotency   *idempotencyStore
+	executionLogs *executionLogStore
+	metrics       *metrics.Metrics
+	notifier      *webhook.Notifier
+	eventSink     events.Sink
+	gitFetcher    *gitsource.Fetcher
+	ready         atomic.Bool // reported by /readyz; set once serving, cleared on shutdown
 	config        *config.Config
 }
 
 // NewServerHandler creates a new ServerHandler
-func NewServerHandler(config *config.Config) *ServerHandler {
-	return &ServerHandler{
+func NewServerHandler(config *config.Config) (*ServerHandler, error) {
+	functionStore, err := store.New(&config.Store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create function store: %v", err)
+	}
+
+	dockerManager, err := docker.NewDockerManager(&config.Docker)
+	if err != nil {
+		functionStore.Close()
+		return nil, fmt.Errorf("failed to create Docker manager: %v", err)
+	}
+
+	// Fail at startup with a clear message when Docker isn't there to use
+	verifyCtx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	if err := dockerManager.Verify(verifyCtx); err != nil {
+		dockerManager.Close()
+		functionStore.Close()
+		return nil, err
+	}
+
+	// Fail at startup rather than on the first build if a template is broken
+	if err := dockerManager.LoadTemplates(context.Background()); err != nil {
+		dockerManager.Close()
+		functionStore.Close()
+		return nil, fmt.Errorf("failed to load templates: %v", err)
+	}
+
+	notifier, err := webhook.NewNotifier(&config.Callback, config.Outbound.AllowedNetworks)
+	if err != nil {
+		dockerManager.Close()
+		functionStore.Close()
+		return nil, fmt.Errorf("failed to create callback notifier: %v", err)
+	}
+
+	outbound, err := netsafe.NewGuard(config.Outbound.AllowedNetworks, false)
+	if err != nil {
+		dockerManager.Close()
+		functionStore.Close()
+		return nil, fmt.Errorf("failed to create outbound guard: %v", err)
+	}
+
+	eventSink, err := events.New(&config.Events, config.Outbound.AllowedNetworks)
+	if err != nil {
+		dockerManager.Close()
+		functionStore.Close()
+		return nil, fmt.Errorf("failed to create events sink: %v", err)
+	}
+
+	jobStore := jobs.NewStore()
+
+	h := &ServerHandler{
 		fileHandler:   utils.NewFileHandler(&config.FileOps),
-		dockerManager: docker.NewDockerManager(&config.Docker),
-		functionStore: store.NewFunctionStore(),
+		dockerManager: dockerManager,
+		functionStore: functionStore,
+		jobStore:      jobStore,
+		jobPool:       jobs.NewPool(jobStore, config.Async.Workers, config.Async.QueueSize),
+		buildLocks:    newKeyedMutex(),
+		running:       newRunningCounter(),
+		idempotency:   newIdempotencyStore(config.Server.IdempotencyTTL),
+		executionLogs: newExecutionLogStore(config.Store.ExecutionLogs, config.Store.ExecutionLogSize),
+		metrics:       metrics.NewMetrics(),
+		notifier:      notifier,
+		eventSink:     eventSink,
+		gitFetcher:    gitsource.NewFetcher(&config.Git, &config.FileOps, outbound),
 		config:        config,
 	}
+	// Reconcile before restoring schedules so none are set for removed functions
+	h.reconcileOnStartup()
+	h.scheduler = scheduler.New(h.runScheduled, config.Scheduler.FailureHistory)
+	h.restoreSchedules(context.Background())
+
+	return h, nil
+}
+
+// Shutdown stops scheduled runs and drains asynchronous jobs, running
+// containers and undelivered events until ctx expires, killing any
+// containers left, then releases resources held by the handler, such as the
+// function store and Docker client
+func (h *ServerHandler) Shutdown(ctx context.Context) error {
+	h.scheduler.Stop(ctx)
+	h.jobPool.Shutdown(ctx)
+	h.dockerManager.Drain(ctx)
+	if err := h.eventSink.Close(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to deliver every event before shutdown")
+	}
+	if err := h.dockerManager.Close(); err != nil {
+		log.Warn().Err(err).Msg("Failed to close Docker client")
+	}
+	return h.functionStore.Close()
 }
 
 // RegisterRoutes registers all HTTP routes
 func (h *ServerHandler) RegisterRoutes(mux *http.ServeMux) {
 	// Apply middleware chain to all handlers
-	withMiddleware := func(handler http.HandlerFunc) http.Handler {
-		return middleware.RecoverMiddleware(
-			middleware.LoggingMiddleware(
-				middleware.TimeoutMiddleware(h.config.Server.WriteTimeout)(
-					http.HandlerFunc(handler),
-				),
-			),
-		)
+	cors := middleware.CORSMiddleware(h.config.CORS.AllowedOrigins)
+	maxBody := middleware.MaxBodyMiddleware(h.config.Server.MaxRequestBody, h.config.FileOps.MaxFileSize)
+	requireAuth := middleware.AuthMiddleware(h.config.Auth.APIKeys, "/health", "/healthz", "/readyz")
+
+	// Only trust API keys as client identities once they have been verified,
+	// otherwise rotating made-up keys would dodge the limit
+	rateLimitKey := middleware.ClientIP
+	if len(h.config.Auth.APIKeys) > 0 {
+		rateLimitKey = middleware.APIKeyOrClientIP
+	}
+	rateLimit := middleware.RateLimitMiddleware(h.config.RateLimit.RPS, h.config.RateLimit.Burst, rateLimitKey, "/health", "/healthz", "/readyz")
+
+	// Bodies are only logged when explicitly enabled while debugging
+	logging := middleware.LoggingMiddleware
+	if h.config.LogBodies && h.config.LogLevel == "debug" {
+		logging = middleware.BodyLoggingMiddleware(h.config.LogBodyLimit)
 	}
 
+	// Middleware in the order requests pass through it
+	chain := middleware.Chain{
+		middleware.ErrorFormatMiddleware,
+		middleware.RecoverMiddleware,
+		middleware.TracingMiddleware,
+		cors,
+		requireAuth,
+		rateLimit,
+		maxBody,
+		logging,
+	}
+	withTimeout := chain.Append(middleware.TimeoutMiddleware(h.config.Server.WriteTimeout, h.config.Server.MinRequestTimeout))
+	withMiddleware := withTimeout.ThenFunc
+	// HEAD is served like GET where GET only reads
+	readable := withTimeout.Append(middleware.HeadMiddleware).ThenFunc
+
 	// Register routes
 	mux.Handle("/api/submit", withMiddleware(h.SubmitHandler))
+	mux.Handle("/api/validate", withMiddleware(h.ValidateHandler))
 	mux.Handle("/api/execute", withMiddleware(h.ExecuteHandler))
-	mux.Handle("/api/functions", withMiddleware(h.ListFunctionsHandler))
-	mux.Handle("/api/functions/", withMiddleware(h.FunctionHandler)) // For GET, DELETE by ID
+	// Streams are bounded by the run timeout rather than the request timeout
+	mux.Handle("/api/execute/stream", chain.ThenFunc(h.StreamHandler))
+	mux.Handle("/api/execute/batch", withMiddleware(h.BatchExecuteHandler))
+	mux.Handle("/api/functions", readable(h.ListFunctionsHandler))
+	mux.Handle("/api/functions/", readable(h.FunctionHandler)) // For GET, PUT, DELETE by ID and schedules
+	mux.Handle("/api/jobs/", readable(h.JobHandler))
+	mux.Handle("/api/aliases", readable(h.AliasesHandler))
+	mux.Handle("/api/aliases/", readable(h.AliasHandler)) // For GET, PUT and DELETE by name
+	mux.Handle("/api/admin/reconcile", withMiddleware(h.ReconcileHandler))
+	mux.Handle("/api/admin/cleanup", withMiddleware(h.CleanupHandler))
+	mux.Handle("/api/admin/export", withMiddleware(h.ExportHandler))
+	mux.Handle("/api/admin/import", withMiddleware(h.ImportHandler))
+	mux.Handle("/api/version", readable(h.VersionHandler))
+	mux.Handle("/api/stats", readable(h.StatsHandler))
+
+	// Functions invoked as plain HTTP endpoints
+	mux.Handle("/fn/", withMiddleware(h.GatewayHandler))
 
 	// Health check endpoint
-	mux.Handle("/health", withMiddleware(h.HealthCheckHandler))
+	mux.Handle("/health", readable(h.HealthCheckHandler))
+
+	// Kubernetes liveness and readiness probes
+	mux.Handle("/healthz", readable(h.LivenessHandler))
+	mux.Handle("/readyz", readable(h.ReadinessHandler))
+
+	// Prometheus metrics endpoint
+	mux.Handle("/metrics", readable(h.metrics.Handler().ServeHTTP))
 }
 
-// SubmitHandler accepts a zip file containing user code and builds a Docker image
+// SubmitHandler accepts a zip or tar.gz archive containing user code, or a
+// JSON body naming a Git repository, and builds a Docker image
 func (h *ServerHandler) SubmitHandler(w http.ResponseWriter, r *http.Request) {
 	// Get request ID from context
 	ctx := r.Context()
-	requestID, _ := ctx.Value(middleware.RequestIDKey{}).(string)
+	requestID := requestctx.ID(ctx)
 
 	// Validate request method
 	if r.Method != http.MethodPost {
@@ -73,36 +242,351 @@ func (h *ServerHandler) SubmitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse the multipart form
-	err := r.ParseMultipartForm(h.config.FileOps.MaxFileSize)
+	// A JSON body deploys from a Git repository instead of an upload
+	source, ok := h.parseGitSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	// Replay the original response for a retried request. Keys are scoped to
+	// the caller's API key, and a retry waits while the first request is still
+	// in flight so that only one of them deploys.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		idempotencyKey = middleware.APIKeyFromRequest(r) + ":" + idempotencyKey
+		release := h.idempotency.lock(idempotencyKey)
+		defer release()
+
+		if response, ok := h.idempotency.get(idempotencyKey); ok {
+			log.Info().
+				Str("request_id", requestID).
+				Str("function_id", response.FunctionID).
+				Msg("Replaying idempotent submission")
+			w.Header().Set("Idempotent-Replayed", "true")
+			utils.RespondWithJSON(w, http.StatusOK, response)
+			return
+		}
+	}
+
+	// Parse an upload with its own limit before reading any field, which
+	// would otherwise parse it with the default limit of 32 MB
+	if source == nil && !h.parseUploadForm(w, r) {
+		return
+	}
+
+	// Fail before the build if the name is already taken. The store checks
+	// again when the function is stored, in case of a concurrent submission.
+	if name := r.FormValue("name"); h.config.Store.UniqueNames && name != "" && name != models.DefaultFunctionName {
+		if existing, err := h.functionStore.GetFunctionByName(ctx, name); err == nil || errors.Is(err, store.ErrAmbiguousName) {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("name", name).
+				Str("function_id", existing.FunctionID).
+				Msg("Function name already in use")
+			utils.RespondWithError(w, http.StatusConflict, "Function name already in use", fmt.Sprintf("A function named '%s' already exists", name))
+			return
+		}
+	}
+
+	// Likewise fail before the build if no more functions may be deployed
+	if h.functionLimitReached(ctx) {
+		log.Warn().
+			Str("request_id", requestID).
+			Int("max_functions", h.config.Store.MaxFunctions).
+			Msg("Function limit reached")
+		utils.RespondWithError(w, http.StatusConflict, "Function limit reached", fmt.Sprintf("At most %d functions may be deployed; delete one to make room", h.config.Store.MaxFunctions))
+		return
+	}
+
+	// Build the Docker image from the uploaded code
+	functionID := uuid.New().String()
+	build, ok := h.buildFromUpload(w, r, functionID, source)
+	if !ok {
+		return
+	}
+	defer build.release()
+
+	// Get optional function name
+	functionName := r.FormValue("name")
+	if functionName == "" {
+		functionName = models.DefaultFunctionName
+	}
+
+	// Store the metadata
+	metadata := models.FunctionMetadata{
+		FunctionID: functionID,
+		CreatedAt:  time.Now().Unix(),
+		Name:       functionName,
+	}
+	build.apply(&metadata)
+
+	evicted, err := h.storeNewFunction(ctx, metadata)
+	if len(evicted) > 0 {
+		// Removing the evicted functions' images takes their build locks, so
+		// release this one first
+		defer func() {
+			build.release()
+			h.removeEvictedImages(ctx, evicted)
+		}()
+	}
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Err(err).
-			Msg("Failed to parse multipart form")
-		utils.RespondWithError(w, http.StatusBadRequest, "Failed to parse form", err.Error())
+			Msg("Failed to store function metadata")
+		h.removeSource(functionID)
+		// Discard the new image, unless it is reused or shared and may
+		// belong to another function
+		if !build.Reused && !build.Shared && !h.imageInUse(ctx, build.ImageID) {
+			if rmErr := h.dockerManager.RemoveImage(ctx, build.ImageID); rmErr != nil {
+				log.Warn().
+					Str("request_id", requestID).
+					Str("image_id", build.ImageID).
+					Err(rmErr).
+					Msg("Failed to remove unused image")
+			}
+		}
+		if errors.Is(err, store.ErrNameTaken) {
+			utils.RespondWithError(w, http.StatusConflict, "Function name already in use", err.Error())
+			return
+		}
+		if errors.Is(err, store.ErrFunctionLimitReached) {
+			utils.RespondWithError(w, http.StatusConflict, "Function limit reached", err.Error())
+			return
+		}
+		if errors.Is(err, store.ErrStoreUnavailable) {
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Function store is unavailable", err.Error())
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to store function metadata", err.Error())
 		return
 	}
 
-	// Get the zip file from the request
-	file, header, err := r.FormFile("code")
+	h.emit(ctx, events.Event{
+		Type:       events.FunctionDeployed,
+		FunctionID: functionID,
+		Name:       functionName,
+		Language:   build.Language,
+		ImageID:    build.ImageID,
+	})
+
+	// Return success response
+	response := models.SubmissionResponse{
+		FunctionID: functionID,
+		ImageID:    build.ImageID,
+		Message:    fmt.Sprintf("Function '%s' deployed successfully", functionName),
+		Reused:     build.Reused,
+		Commit:     build.Commit,
+	}
+	if r.URL.Query().Get("verbose") == "true" {
+		response.BuildLog = build.BuildLog
+	}
+
+	// Only successful submissions are remembered, so a failed one can be retried
+	if idempotencyKey != "" {
+		h.idempotency.put(idempotencyKey, response)
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// UpdateFunctionHandler rebuilds an existing function from a new code upload,
+// replacing its image while keeping the same function ID
+func (h *ServerHandler) UpdateFunctionHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	// Make sure the function exists before doing any expensive work
+	if _, err := h.functionStore.GetFunction(ctx, functionID); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Function not found")
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+		return
+	}
+
+	source, ok := h.parseGitSubmission(w, r)
+	if !ok {
+		return
+	}
+
+	// Build the new image; the existing function is untouched if this fails
+	build, ok := h.buildFromUpload(w, r, functionID, source)
+	if !ok {
+		return
+	}
+	defer build.release()
+
+	var oldImageID, oldSourcePath string
+	updated, err := h.functionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+		oldImageID = metadata.ImageID
+		oldSourcePath = metadata.SourcePath
+		build.apply(metadata)
+		return nil
+	})
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
+			Str("function_id", functionID).
 			Err(err).
-			Msg("Failed to retrieve zip file")
-		utils.RespondWithError(w, http.StatusBadRequest, "Failed to retrieve zip file", err.Error())
+			Msg("Failed to update function metadata")
+		// Discard the new image so the old deployment stays the only one. A
+		// reused or shared image may belong to another function and is left
+		// alone.
+		if !build.Reused && !build.Shared {
+			if rmErr := h.dockerManager.RemoveImage(ctx, build.ImageID); rmErr != nil {
+				log.Warn().
+					Str("request_id", requestID).
+					Str("image_id", build.ImageID).
+					Err(rmErr).
+					Msg("Failed to remove unused image")
+			}
+		}
+		if build.SourcePath != oldSourcePath {
+			h.removeSourceFile(build.SourcePath)
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update function metadata", err.Error())
 		return
 	}
-	defer file.Close()
+	if oldSourcePath != build.SourcePath {
+		h.removeSourceFile(oldSourcePath)
+	}
+	h.emit(ctx, events.Event{
+		Type:       events.FunctionDeployed,
+		FunctionID: functionID,
+		Name:       updated.Name,
+		Language:   updated.Language,
+		ImageID:    updated.ImageID,
+	})
 
-	// Get optional function name
-	functionName := r.FormValue("name")
-	if functionName == "" {
-		functionName = "unnamed-function"
+	// Remove the old image now that the function points at the new one,
+	// unless another function with identical code still uses it
+	if oldImageID != build.ImageID && !h.imageInUse(ctx, oldImageID) {
+		if err := h.dockerManager.RemoveImage(ctx, oldImageID); err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("function_id", functionID).
+				Str("image_id", oldImageID).
+				Err(err).
+				Msg("Failed to remove old image")
+		}
+	}
+
+	response := models.UpdateResponse{
+		FunctionID: functionID,
+		OldImageID: oldImageID,
+		NewImageID: build.ImageID,
+		Message:    fmt.Sprintf("Function %s updated successfully", functionID),
+	}
+	if r.URL.Query().Get("verbose") == "true" {
+		response.BuildLog = build.BuildLog
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// buildResult holds the outcome of building a function image from an upload
+type buildResult struct {
+	ImageID        string
+	Language       string
+	HandlerFile    string
+	Manifest       *models.Manifest
+	BuildLog       string
+	ContentHash    string
+	MemoryLimit    int64
+	CPULimit       float64
+	Env            map[string]string
+	Tags           map[string]string
+	DefaultInput   map[string]string
+	NetworkMode    string
+	RuntimeVersion string
+	SourcePath     string // retained copy of the code; empty when not retained
+	Commit         string // commit the code was fetched from; empty for uploads
+	Reused         bool   // the image of an existing function with identical code was reused
+	Shared         bool   // the image came from an identical build running concurrently
+
+	// release unlocks builds of the same code; callers must call it once the
+	// function has been stored
+	release func()
+}
+
+// apply copies the build-derived fields onto function metadata. A redeploy
+// that sets no environment variables, tags or default input keeps the
+// function's existing ones.
+func (b *buildResult) apply(metadata *models.FunctionMetadata) {
+	metadata.ImageID = b.ImageID
+	metadata.BuiltAt = time.Now().Unix()
+	metadata.Language = b.Language
+	metadata.InputMode = b.Manifest.InputMode
+	metadata.ContentHash = b.ContentHash
+	metadata.MemoryLimit = b.MemoryLimit
+	metadata.CPULimit = b.CPULimit
+	metadata.InputSchema = b.Manifest.Schema
+	metadata.NetworkMode = b.NetworkMode
+	metadata.SourcePath = b.SourcePath
+	metadata.ContentType = b.Manifest.ContentType
+	metadata.OutputFormat = b.Manifest.OutputFormat
+	metadata.AllowedEnv = b.Manifest.AllowedEnv
+	metadata.RuntimeVersion = b.RuntimeVersion
+	if b.Env != nil {
+		metadata.Env = b.Env
+	}
+	if b.Tags != nil {
+		metadata.Tags = b.Tags
+	}
+	if b.DefaultInput != nil {
+		metadata.DefaultInput = b.DefaultInput
+	}
+}
+
+// present prepares function metadata for a response: environment values are
+// masked and the count of executions in flight is filled in
+func (h *ServerHandler) present(metadata models.FunctionMetadata) models.FunctionMetadata {
+	metadata = metadata.Redacted()
+	metadata.RunningCount = h.running.count(metadata.FunctionID)
+	return metadata
+}
+
+// imageInUse reports whether any stored function runs the given image
+func (h *ServerHandler) imageInUse(ctx context.Context, imageID string) bool {
+	for _, metadata := range h.functionStore.ListFunctions(ctx) {
+		if metadata.ImageID == imageID {
+			return true
+		}
 	}
+	return false
+}
+
+// upload is an extracted code upload with its detected handler, ready to build
+type upload struct {
+	Dir            string
+	Language       string
+	HandlerFile    string
+	Manifest       *models.Manifest
+	ContentHash    string
+	MemoryLimit    int64
+	CPULimit       float64
+	Env            map[string]string
+	Tags           map[string]string
+	DefaultInput   map[string]string
+	NetworkMode    string
+	RuntimeVersion string
+	Commit         string // commit the code was fetched from; empty for uploads
+
+	// cleanup removes the extracted files; callers must call it once done
+	cleanup func()
+}
+
+// prepareUpload extracts the uploaded code archive, or fetches the code from
+// source when it isn't nil, reads its manifest and detects its handler. On
+// failure it writes the error response and returns false.
+func (h *ServerHandler) prepareUpload(w http.ResponseWriter, r *http.Request, source *gitsource.Source) (*upload, bool) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
 
-	// Create a temporary directory for the zip file contents
+	// Create a temporary directory for the code
 	tempDir, err := h.fileHandler.CreateTempDir(ctx)
 	if err != nil {
 		log.Error().
@@ -110,88 +594,334 @@ func (h *ServerHandler) SubmitHandler(w http.ResponseWriter, r *http.Request) {
 			Err(err).
 			Msg("Failed to create temp directory")
 		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create temp directory", err.Error())
-		return
+		return nil, false
 	}
-	defer h.fileHandler.CleanupTempDir(ctx, tempDir)
 
-	// Save the zip file to the temp directory
-	zipPath, err := h.fileHandler.SaveZipFile(ctx, tempDir, header.Filename, file)
+	// The temp directory outlives this function only when the upload is returned
+	prepared := false
+	defer func() {
+		if !prepared {
+			h.fileHandler.CleanupTempDir(ctx, tempDir)
+		}
+	}()
+
+	var extractDir, commit string
+	var ok bool
+	if source != nil {
+		extractDir, commit, ok = h.fetchGitSource(w, r, source, tempDir)
+	} else {
+		extractDir, ok = h.extractUpload(w, r, tempDir)
+	}
+	if !ok {
+		return nil, false
+	}
+
+	// Read the optional manifest
+	manifest, err := h.fileHandler.LoadManifest(ctx, extractDir)
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Err(err).
-			Msg("Failed to save zip file")
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save zip file", err.Error())
-		return
+			Msg("Failed to load manifest")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid manifest", err.Error())
+		return nil, false
 	}
 
-	// Extract the zip file
-	extractDir, err := h.fileHandler.ExtractZip(ctx, zipPath, tempDir)
+	// Resolve the resource limits before doing any expensive work
+	memoryLimit, cpuLimit, err := h.resourceLimits(r, manifest)
 	if err != nil {
-		log.Error().
+		log.Warn().
 			Str("request_id", requestID).
 			Err(err).
-			Msg("Failed to extract zip file")
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to extract zip file", err.Error())
-		return
+			Msg("Invalid resource limits")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid resource limits", err.Error())
+		return nil, false
+	}
+
+	network, err := networkMode(r, manifest)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Invalid network mode")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid network mode", err.Error())
+		return nil, false
+	}
+
+	env, err := functionEnv(r, manifest)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Invalid environment variables")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid environment variables", err.Error())
+		return nil, false
+	}
+
+	tags, err := functionTags(r, manifest)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Invalid tags")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid tags", err.Error())
+		return nil, false
+	}
+
+	defaultInput, err := functionDefaultInput(r, manifest)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Invalid default input")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid default input", err.Error())
+		return nil, false
 	}
 
 	// Detect the programming language and find the handler file
-	handlerFile, language, err := h.fileHandler.DetectHandlerFile(ctx, extractDir)
+	handlerFile, language, err := h.fileHandler.DetectHandlerFile(ctx, extractDir, manifest, r.FormValue("handler"))
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Err(err).
 			Msg("Failed to detect handler file")
-		utils.RespondWithError(w, http.StatusBadRequest, "Failed to detect handler file", err.Error())
-		return
+		message := "Failed to detect handler file"
+		switch {
+		case errors.Is(err, utils.ErrLanguageNotEnabled):
+			message = "Language not enabled"
+		case errors.Is(err, utils.ErrAmbiguousHandler):
+			message = "Ambiguous handler file"
+		}
+		utils.RespondWithError(w, http.StatusBadRequest, message, err.Error())
+		return nil, false
 	}
 
-	// Build the Docker image
-	imageID, err := h.dockerManager.BuildDockerImage(ctx, extractDir, language, handlerFile)
+	// A custom Dockerfile picks its own base image
+	var runtimeVersion string
+	if language != config.CustomLanguage {
+		runtimeVersion, err = h.config.Docker.RuntimeVersions.Resolve(language, manifest.RuntimeVersion)
+		if err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("language", language).
+				Err(err).
+				Msg("Unsupported runtime version")
+			utils.RespondWithError(w, http.StatusBadRequest, "Unsupported runtime version", err.Error())
+			return nil, false
+		}
+	}
+
+	// Hash the code so identical uploads can share one image
+	contentHash, err := h.fileHandler.HashDirectory(ctx, extractDir)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to hash code", err.Error())
+		return nil, false
+	}
+
+	prepared = true
+	return &upload{
+		Dir:            extractDir,
+		Language:       language,
+		HandlerFile:    handlerFile,
+		Manifest:       manifest,
+		ContentHash:    contentHash,
+		MemoryLimit:    memoryLimit,
+		CPULimit:       cpuLimit,
+		Env:            env,
+		Tags:           tags,
+		DefaultInput:   defaultInput,
+		NetworkMode:    network,
+		RuntimeVersion: runtimeVersion,
+		Commit:         commit,
+		cleanup:        func() { h.fileHandler.CleanupTempDir(ctx, tempDir) },
+	}, true
+}
+
+// parseUploadForm parses a multipart upload, keeping up to MAX_FILE_SIZE
+// bytes in memory. Parsing again once it succeeded does nothing. On failure
+// it writes the error response and returns false.
+func (h *ServerHandler) parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseMultipartForm(h.config.FileOps.MaxFileSize); err != nil {
+		log.Error().
+			Str("request_id", requestctx.ID(r.Context())).
+			Err(err).
+			Msg("Failed to parse multipart form")
+		utils.RespondWithUploadError(w, "Failed to parse form", err, h.config.FileOps.MaxFileSize)
+		return false
+	}
+	return true
+}
+
+// extractUpload saves the archive uploaded in the "code" field to tempDir
+// and extracts it, returning the directory of the extracted code. On failure
+// it writes the error response and returns false.
+func (h *ServerHandler) extractUpload(w http.ResponseWriter, r *http.Request, tempDir string) (string, bool) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if !h.parseUploadForm(w, r) {
+		return "", false
+	}
+
+	// Get the zip file from the request
+	file, header, err := r.FormFile("code")
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Err(err).
-			Msg("Failed to build Docker image")
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build Docker image", err.Error())
-		return
+			Msg("Failed to retrieve zip file")
+		utils.RespondWithError(w, http.StatusBadRequest, "Failed to retrieve zip file", err.Error())
+		return "", false
 	}
+	defer file.Close()
 
-	// Generate a function ID and store the metadata
-	functionID := uuid.New().String()
-	metadata := models.FunctionMetadata{
-		FunctionID: functionID,
-		ImageID:    imageID,
-		Language:   language,
-		CreatedAt:  time.Now().Unix(),
-		Name:       functionName,
+	// Reject anything declared as something other than an archive up front
+	if err := utils.CheckArchiveContentType(header.Header.Get("Content-Type")); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("content_type", header.Header.Get("Content-Type")).
+			Msg("Upload is not an archive")
+		utils.RespondWithError(w, http.StatusBadRequest, "Unsupported archive format", err.Error())
+		return "", false
 	}
 
-	err = h.functionStore.StoreFunction(ctx, metadata)
+	// Save the zip file to the temp directory
+	zipPath, err := h.fileHandler.SaveZipFile(ctx, tempDir, header.Filename, file)
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Err(err).
-			Msg("Failed to store function metadata")
-		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to store function metadata", err.Error())
-		return
+			Msg("Failed to save zip file")
+		if errors.Is(err, utils.ErrUnsupportedArchive) {
+			utils.RespondWithError(w, http.StatusBadRequest, "Unsupported archive format", err.Error())
+			return "", false
+		}
+		if errors.Is(err, utils.ErrFileTooLarge) {
+			utils.RespondWithError(w, http.StatusRequestEntityTooLarge, "Code archive too large", err.Error())
+			return "", false
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to save zip file", err.Error())
+		return "", false
 	}
 
-	// Return success response
-	response := models.SubmissionResponse{
-		FunctionID: functionID,
-		ImageID:    imageID,
-		Message:    fmt.Sprintf("Function '%s' deployed successfully", functionName),
+	// Extract the archive
+	extractDir, err := h.fileHandler.ExtractArchive(ctx, zipPath, tempDir)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to extract archive")
+		utils.RespondWithError(w, http.StatusBadRequest, "Failed to extract archive", err.Error())
+		return "", false
 	}
+	return extractDir, true
+}
 
-	utils.RespondWithJSON(w, http.StatusOK, response)
+// buildFromUpload extracts the uploaded code archive, or fetches the code
+// from source when it isn't nil, detects its handler and builds a Docker
+// image from it, retaining the code for the function. On failure it writes
+// the error response and returns false.
+func (h *ServerHandler) buildFromUpload(w http.ResponseWriter, r *http.Request, functionID string, source *gitsource.Source) (*buildResult, bool) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	upload, ok := h.prepareUpload(w, r, source)
+	if !ok {
+		return nil, false
+	}
+	defer upload.cleanup()
+
+	// Serialize builds of identical code so concurrent submissions build once
+	release := h.buildLocks.lock(upload.ContentHash)
+
+	// Reuse the image of an existing function with identical code, unless the
+	// default runtime version has changed since it was built
+	if existing, err := h.functionStore.GetByContentHash(ctx, upload.ContentHash, upload.Language); err == nil && existing.RuntimeVersion == upload.RuntimeVersion {
+		log.Info().
+			Str("request_id", requestID).
+			Str("function_id", existing.FunctionID).
+			Str("image_id", existing.ImageID).
+			Str("content_hash", upload.ContentHash).
+			Msg("Identical code already deployed, reusing image")
+		return &buildResult{
+			ImageID:        existing.ImageID,
+			Language:       upload.Language,
+			HandlerFile:    upload.HandlerFile,
+			Manifest:       upload.Manifest,
+			ContentHash:    upload.ContentHash,
+			MemoryLimit:    upload.MemoryLimit,
+			CPULimit:       upload.CPULimit,
+			Env:            upload.Env,
+			Tags:           upload.Tags,
+			DefaultInput:   upload.DefaultInput,
+			NetworkMode:    upload.NetworkMode,
+			RuntimeVersion: upload.RuntimeVersion,
+			SourcePath:     h.retainSource(ctx, functionID, upload.Dir, upload.ContentHash),
+			Commit:         upload.Commit,
+			Reused:         true,
+			release:        release,
+		}, true
+	}
+
+	// Build the Docker image
+	image, err := h.dockerManager.BuildDockerImage(ctx, functionID, upload.Dir, upload.Language, upload.HandlerFile, upload.RuntimeVersion, upload.Manifest.BuildArgs)
+	h.metrics.RecordBuild(upload.Language, err != nil)
+	if err != nil {
+		release()
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to build Docker image")
+		h.emit(ctx, events.Event{
+			Type:       events.BuildFailed,
+			FunctionID: functionID,
+			Language:   upload.Language,
+			Error:      err.Error(),
+		})
+
+		// Include the build output so users can debug their code
+		details := err.Error()
+		if errors.Is(err, docker.ErrDaemonUnavailable) {
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Docker is unavailable", details)
+			return nil, false
+		}
+		if errors.Is(err, docker.ErrRegistryAuth) {
+			utils.RespondWithError(w, http.StatusBadGateway, "Failed to authenticate with the image registry", details)
+			return nil, false
+		}
+		var buildErr *docker.BuildError
+		if errors.As(err, &buildErr) && buildErr.Log != "" {
+			details = buildErr.Log + "\n" + details
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to build Docker image", details)
+		return nil, false
+	}
+
+	return &buildResult{
+		ImageID:        image.ImageID,
+		Language:       upload.Language,
+		HandlerFile:    upload.HandlerFile,
+		Manifest:       upload.Manifest,
+		BuildLog:       image.Log,
+		ContentHash:    upload.ContentHash,
+		MemoryLimit:    upload.MemoryLimit,
+		CPULimit:       upload.CPULimit,
+		Env:            upload.Env,
+		Tags:           upload.Tags,
+		DefaultInput:   upload.DefaultInput,
+		NetworkMode:    upload.NetworkMode,
+		RuntimeVersion: upload.RuntimeVersion,
+		SourcePath:     h.retainSource(ctx, functionID, upload.Dir, upload.ContentHash),
+		Commit:         upload.Commit,
+		Shared:         image.Shared,
+		release:        release,
+	}, true
 }
 
 // ExecuteHandler executes a function using a Docker container
 func (h *ServerHandler) ExecuteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	requestID, _ := ctx.Value(middleware.RequestIDKey{}).(string)
+	requestID := requestctx.ID(ctx)
 
 	// Only allow GET and POST methods
 	if r.Method != http.MethodGet && r.Method != http.MethodPost {
@@ -203,116 +933,541 @@ func (h *ServerHandler) ExecuteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var functionID string
-	var input map[string]string
+	execRequest, ok := h.parseExecutionRequest(w, r)
+	if !ok {
+		return
+	}
+	defer execRequest.cleanup()
+	functionID := execRequest.FunctionID
 
-	// Handle different request methods
-	if r.Method == http.MethodGet {
-		// For GET requests, get function ID from query parameters
-		functionID = r.URL.Query().Get("functionId")
-		if functionID == "" {
-			log.Warn().
-				Str("request_id", requestID).
-				Msg("Missing function ID in query parameters")
-			utils.RespondWithError(w, http.StatusBadRequest, "Missing function ID", "The 'functionId' query parameter is required")
+	// Queue the execution and return immediately in async mode, which a
+	// callback URL implies
+	if r.URL.Query().Get("async") == "true" || execRequest.CallbackURL != "" {
+		if execRequest.InputFile != "" {
+			// Queued jobs can outlive the request, and with it the uploaded file
+			utils.RespondWithError(w, http.StatusBadRequest, "Input files are not supported for asynchronous executions", "Execute synchronously or pass the data in 'input'")
 			return
 		}
-	} else {
-		// For POST requests, parse JSON body
-		var execRequest models.ExecutionRequest
-		if err := json.NewDecoder(r.Body).Decode(&execRequest); err != nil {
-			log.Error().
-				Str("request_id", requestID).
-				Err(err).
-				Msg("Failed to parse request body")
-			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body", err.Error())
-			return
+		h.executeAsync(w, r, functionID, execRequest.Input, execRequest.CallbackURL, execRequest.Timeout)
+		return
+	}
+
+	// Execute the function with input parameters
+	response, err := h.executeFunction(ctx, functionID, execRequest.Input, execRequest.InputFile, nil, execRequest.Timeout)
+	if err != nil {
+		h.respondWithExecutionError(w, requestID, functionID, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// executionRequest is a parsed request to execute a function
+type executionRequest struct {
+	FunctionID  string
+	Input       map[string]interface{}
+	InputFile   string // host path of an uploaded input file, if any
+	CallbackURL string // receives the result of an asynchronous execution
+	Timeout     time.Duration
+
+	// cleanup removes the uploaded input file; callers must call it once the
+	// execution has finished
+	cleanup func()
+}
+
+// parseExecutionRequest reads the function, by ID or name, and input of an
+// execution request from the query string (GET), a multipart form with an
+// input file (POST) or the JSON body (POST). On failure it writes the error response and
+// returns false. Query parameters other than reservedExecuteParams are input
+// too, with POST input taking precedence over them, and so is name when it
+// isn't what refers to the function.
+func (h *ServerHandler) parseExecutionRequest(w http.ResponseWriter, r *http.Request) (*executionRequest, bool) {
+	requestID := requestctx.ID(r.Context())
+
+	if r.Method == http.MethodGet {
+		// For GET requests, get the function ID, alias or name from query parameters
+		query := r.URL.Query()
+		functionID, ok := h.resolveFunctionID(w, r, query.Get("functionId"), query.Get("alias"), query.Get("name"))
+		if !ok {
+			return nil, false
+		}
+		timeout, ok := h.parseTimeoutFields(w, r)
+		if !ok {
+			return nil, false
 		}
+		return &executionRequest{
+			FunctionID:  functionID,
+			Input:       queryInput(query, query.Get("functionId") == "" && query.Get("alias") == ""),
+			CallbackURL: query.Get("callbackUrl"),
+			Timeout:     timeout,
+			cleanup:     func() {},
+		}, true
+	}
 
-		functionID = execRequest.FunctionID
-		input = execRequest.Input
+	// Multipart POST requests may carry an input file
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return h.parseExecutionForm(w, r)
+	}
 
-		if functionID == "" {
-			log.Warn().
-				Str("request_id", requestID).
-				Msg("Missing function ID in request body")
-			utils.RespondWithError(w, http.StatusBadRequest, "Missing function ID", "The 'functionId' field is required")
-			return
+	// For other POST requests, parse JSON body
+	var execRequest models.ExecutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&execRequest); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return nil, false
+	}
+
+	functionID, ok := h.resolveFunctionID(w, r, execRequest.FunctionID, execRequest.Alias, execRequest.Name)
+	if !ok {
+		return nil, false
+	}
+	timeout, ok := h.resolveTimeout(w, r, execRequest.TimeoutSeconds, execRequest.Strict)
+	if !ok {
+		return nil, false
+	}
+
+	return &executionRequest{
+		FunctionID:  functionID,
+		Input:       mergeInput(queryInput(r.URL.Query(), false), execRequest.Input),
+		CallbackURL: execRequest.CallbackURL,
+		Timeout:     timeout,
+		cleanup:     func() {},
+	}, true
+}
+
+// reservedExecuteParams are the query parameters that configure an execution
+// rather than being passed to the function as input. name is only reserved
+// when it refers to the function, see queryInput.
+var reservedExecuteParams = map[string]bool{
+	"functionId":     true,
+	"alias":          true,
+	"callbackUrl":    true,
+	"async":          true,
+	"timeoutSeconds": true,
+	"strict":         true,
+}
+
+// queryInput returns the query parameters other than reservedExecuteParams as
+// function input, leaving out name too if byName is set because the function
+// was looked up by it. Values are strings, and a parameter given more than
+// once becomes a list of its values. It returns nil when there are none.
+func queryInput(query url.Values, byName bool) map[string]interface{} {
+	var input map[string]interface{}
+	for key, values := range query {
+		if reservedExecuteParams[key] || (key == "name" && byName) || len(values) == 0 {
+			continue
 		}
+		if input == nil {
+			input = make(map[string]interface{})
+		}
+		if len(values) == 1 {
+			input[key] = values[0]
+			continue
+		}
+		list := make([]interface{}, len(values))
+		for i, value := range values {
+			list[i] = value
+		}
+		input[key] = list
+	}
+	return input
+}
+
+// mergeInput returns base with the keys of override set over it. Either may
+// be nil.
+func mergeInput(base, override map[string]interface{}) map[string]interface{} {
+	if len(base) == 0 {
+		return override
 	}
+	for key, value := range override {
+		base[key] = value
+	}
+	return base
+}
 
+// executeFunction runs a stored function with the given input, the input
+// file if inputFile isn't empty and any request environment variables, and
+// records the execution. input is merged over the function's default input.
+// The function's own environment variables take precedence over requestEnv,
+// and a non-zero timeout shortens the run
+// timeout. For non-zero exits and timeouts the response is returned along
+// with the error.
+func (h *ServerHandler) executeFunction(ctx context.Context, functionID string, input map[string]interface{}, inputFile string, requestEnv map[string]string, timeout time.Duration) (*models.ExecutionResponse, error) {
 	// Get function metadata
 	metadata, err := h.functionStore.GetFunction(ctx, functionID)
 	if err != nil {
+		return nil, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		tracing.FunctionIDKey.String(functionID),
+		tracing.LanguageKey.String(metadata.Language),
+	)
+	input = withDefaults(metadata, input)
+
+	// Reject malformed input before starting a container
+	if err := schema.Validate(metadata.InputSchema, input); err != nil {
+		return nil, err
+	}
+	opts := runOptions(metadata)
+	if err := h.dockerManager.CheckInputEnv(input, opts); err != nil {
+		return nil, err
+	}
+
+	defer h.running.start(functionID)()
+
+	start := time.Now()
+	opts.InputFile = inputFile
+	opts.Timeout = timeout
+	if len(requestEnv) > 0 {
+		env := make(map[string]string, len(requestEnv)+len(opts.Env))
+		for key, value := range requestEnv {
+			env[key] = value
+		}
+		for key, value := range opts.Env {
+			env[key] = value
+		}
+		opts.Env = env
+	}
+	result, err := h.dockerManager.RunDockerContainer(ctx, metadata.ImageID, input, opts)
+	if errors.Is(err, docker.ErrContainerLimitReached) || errors.Is(err, docker.ErrImageQueueTimeout) ||
+		errors.Is(err, docker.ErrHostOverloaded) || errors.Is(err, docker.ErrDaemonUnavailable) || errors.Is(err, context.Canceled) {
+		// The function never ran to completion, so there is nothing to record
+		return nil, err
+	}
+	if err == nil && metadata.OutputFormat == models.OutputFormatJSON {
+		err = checkJSONOutput(result)
+	}
+	duration := time.Since(start)
+	h.metrics.RecordExecution(functionID, metadata.Language, duration, err != nil)
+
+	record := models.ExecutionRecord{
+		ExecutionID: uuid.New().String(),
+		FunctionID:  functionID,
+		ExecutedAt:  start.Unix(),
+		DurationMs:  duration.Milliseconds(),
+		Success:     err == nil,
+	}
+	if result != nil {
+		record.ExitCode = result.ExitCode
+		record.Output = result.Stdout
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	h.recordExecution(ctx, record)
+
+	logs := models.ExecutionLog{
+		ExecutionID: record.ExecutionID,
+		FunctionID:  functionID,
+		ExecutedAt:  record.ExecutedAt,
+		ExitCode:    record.ExitCode,
+		Success:     record.Success,
+		Error:       record.Error,
+	}
+	if result != nil {
+		logs.Stdout = result.Stdout
+		logs.Stderr = result.Stderr
+		logs.Truncated = result.Truncated
+	}
+	h.executionLogs.add(logs)
+
+	if result == nil {
+		return nil, err
+	}
+
+	response := &models.ExecutionResponse{
+		Output:         result.Stdout,
+		Stderr:         result.Stderr,
+		ExitCode:       result.ExitCode,
+		StatusCode:     http.StatusOK,
+		ExecutedAt:     time.Now().Unix(),
+		Truncated:      result.Truncated,
+		DroppedBytes:   result.DroppedBytes,
+		TimeoutSeconds: h.effectiveTimeout(timeout).Seconds(),
+		ExecutionID:    record.ExecutionID,
+		OutputFormat:   metadata.OutputFormat,
+	}
+	if errors.Is(err, docker.ErrRunTimeout) {
+		response.StatusCode = http.StatusGatewayTimeout
+		response.TimedOut = true
+	} else if errors.Is(err, errInvalidOutput) {
+		response.StatusCode = http.StatusBadGateway
+	} else if err != nil {
+		response.StatusCode = http.StatusInternalServerError
+	}
+
+	return response, err
+}
+
+// errInvalidOutput is returned when a function that declares JSON output
+// writes something else to stdout
+var errInvalidOutput = errors.New("function output is not valid JSON")
+
+// checkJSONOutput returns errInvalidOutput if the function's stdout isn't a
+// JSON document
+func checkJSONOutput(result *docker.RunResult) error {
+	var document json.RawMessage
+	err := json.Unmarshal([]byte(result.Stdout), &document)
+	if err == nil {
+		return nil
+	}
+	if result.Truncated {
+		return fmt.Errorf("%w: output was cut off at MAX_OUTPUT_SIZE", errInvalidOutput)
+	}
+	return fmt.Errorf("%w: %v", errInvalidOutput, err)
+}
+
+// respondWithExecutionError maps an executeFunction error to an HTTP error response
+func (h *ServerHandler) respondWithExecutionError(w http.ResponseWriter, requestID, functionID string, err error) {
+	var exitErr *docker.ExitError
+	var timeoutErr *docker.TimeoutError
+	var schemaErr *schema.ValidationError
+	var envErr *docker.EnvError
+	var limitErr *docker.InputLimitError
+	switch {
+	case errors.Is(err, store.ErrFunctionNotFound):
 		log.Error().
 			Str("request_id", requestID).
 			Str("function_id", functionID).
 			Err(err).
 			Msg("Function not found")
 		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
-		return
-	}
 
-	// Execute the function with input parameters
-	output, err := h.dockerManager.RunDockerContainer(ctx, metadata.ImageID, input)
-	if err != nil {
+	case errors.As(err, &schemaErr):
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Strs("violations", schemaErr.Violations).
+			Msg("Input does not match schema")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input", strings.Join(schemaErr.Violations, "; "))
+
+	case errors.As(err, &envErr):
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Strs("variables", envErr.Names).
+			Msg("Input sets blocked environment variables")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid input", err.Error())
+
+	case errors.As(err, &limitErr):
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Strs("violations", limitErr.Violations).
+			Msg("Input exceeds limits")
+		utils.RespondWithError(w, http.StatusBadRequest, "Input too large", strings.Join(limitErr.Violations, "; "))
+
+	case errors.Is(err, docker.ErrDaemonUnavailable):
 		log.Error().
 			Str("request_id", requestID).
 			Str("function_id", functionID).
-			Str("image_id", metadata.ImageID).
 			Err(err).
-			Msg("Failed to execute function")
-		utils.RespondWithError(w, http.StatusInternalServerError, "Function execution failed", err.Error())
-		return
-	}
+			Msg("Docker daemon is unavailable")
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Docker is unavailable", err.Error())
 
-	// Update last executed timestamp
-	if err := h.functionStore.UpdateLastExecuted(ctx, functionID); err != nil {
+	case errors.Is(err, store.ErrStoreUnavailable):
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Function store is unavailable")
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Function store is unavailable", err.Error())
+
+	case errors.Is(err, docker.ErrHostOverloaded):
 		log.Warn().
 			Str("request_id", requestID).
 			Str("function_id", functionID).
 			Err(err).
-			Msg("Failed to update execution timestamp")
-	}
+			Msg("Execution shed on an overloaded host")
+		// The load average moves over seconds, so an immediate retry would be shed too
+		w.Header().Set("Retry-After", "5")
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Host is overloaded", err.Error())
 
-	// Return success response
-	response := models.ExecutionResponse{
-		Output:     output,
-		StatusCode: http.StatusOK,
-		ExecutedAt: time.Now().Unix(),
-	}
+	case errors.Is(err, docker.ErrContainerLimitReached):
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Msg("Container limit reached")
+		utils.RespondWithError(w, http.StatusTooManyRequests, "Too many concurrent executions", err.Error())
 
-	utils.RespondWithJSON(w, http.StatusOK, response)
+	case errors.Is(err, docker.ErrImageQueueTimeout):
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Msg("Timed out waiting for the function's concurrency limit")
+		utils.RespondWithError(w, http.StatusTooManyRequests, "Too many concurrent executions of this function", err.Error())
+
+	case errors.Is(err, context.Canceled):
+		// The client disconnected, so there is nobody left to respond to
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Msg("Function execution cancelled by client")
+
+	case errors.As(err, &timeoutErr):
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Dur("timeout", timeoutErr.Timeout).
+			Msg("Function timed out")
+		utils.RespondWithJSON(w, http.StatusGatewayTimeout, models.ExecutionResponse{
+			Output:         timeoutErr.Stdout,
+			Stderr:         timeoutErr.Stderr,
+			StatusCode:     http.StatusGatewayTimeout,
+			ExecutedAt:     time.Now().Unix(),
+			Truncated:      timeoutErr.Truncated,
+			TimedOut:       true,
+			TimeoutSeconds: timeoutErr.Timeout.Seconds(),
+		})
+
+	case errors.As(err, &exitErr):
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Int("exit_code", exitErr.ExitCode).
+			Msg("Function exited with non-zero code")
+		utils.RespondWithError(w, http.StatusInternalServerError, exitErr.Error(), exitErr.Stderr)
+
+	case errors.Is(err, errInvalidOutput):
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Function output violates its declared format")
+		utils.RespondWithError(w, http.StatusBadGateway, "Invalid function output", err.Error())
+
+	default:
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to execute function")
+		utils.RespondWithError(w, http.StatusInternalServerError, "Function execution failed", err.Error())
+	}
 }
 
-// ListFunctionsHandler returns a list of all deployed functions
+// ListFunctionsHandler returns a list of all deployed functions, or deletes
+// them in bulk for DELETE requests
 func (h *ServerHandler) ListFunctionsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	requestID, _ := ctx.Value(middleware.RequestIDKey{}).(string)
+	requestID := requestctx.ID(ctx)
 
+	if r.Method == http.MethodDelete {
+		h.DeleteFunctionsHandler(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		log.Warn().
 			Str("request_id", requestID).
 			Str("method", r.Method).
 			Msg("Invalid request method")
-		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET and DELETE requests are accepted")
 		return
 	}
 
-	functions := h.functionStore.ListFunctions(ctx)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Invalid list parameters")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	switch r.URL.Query().Get("stream") {
+	case "":
+	case "ndjson":
+		h.streamFunctions(w, r, opts)
+		return
+	default:
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid query parameters", "stream must be ndjson")
+		return
+	}
+
+	functions, total := h.functionStore.ListFunctionsFiltered(ctx, opts)
+	for i := range functions {
+		functions[i] = h.present(functions[i])
+	}
 
 	log.Info().
 		Str("request_id", requestID).
 		Int("count", len(functions)).
-		Msg("Listed all functions")
+		Int("total", total).
+		Msg("Listed functions")
 
-	utils.RespondWithJSON(w, http.StatusOK, functions)
+	utils.RespondWithJSON(w, http.StatusOK, models.FunctionListResponse{
+		Functions: functions,
+		Total:     total,
+		Limit:     opts.Limit,
+		Offset:    opts.Offset,
+	})
 }
 
-// FunctionHandler handles GET and DELETE requests for a specific function
+// parseListOptions reads the filtering, sorting and pagination query
+// parameters of a function listing
+func parseListOptions(r *http.Request) (store.ListOptions, error) {
+	query := r.URL.Query()
+	opts := store.ListOptions{
+		Language: query.Get("language"),
+		Name:     query.Get("name"),
+		Tags:     query["tag"],
+		SortBy:   store.SortByCreatedAt,
+		Order:    store.OrderAsc,
+		Limit:    defaultListLimit,
+	}
+
+	for _, filter := range opts.Tags {
+		if err := checkTagFilter(filter); err != nil {
+			return opts, err
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = limit
+	}
+
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return opts, errors.New("offset must be a non-negative integer")
+		}
+		opts.Offset = offset
+	}
+
+	if value := query.Get("sort"); value != "" {
+		switch value {
+		case store.SortByCreatedAt, store.SortByLastExecuted, store.SortByName:
+			opts.SortBy = value
+		default:
+			return opts, errors.New("sort must be one of createdAt, lastExecuted or name")
+		}
+	}
+
+	if value := query.Get("order"); value != "" {
+		switch value {
+		case store.OrderAsc, store.OrderDesc:
+			opts.Order = value
+		default:
+			return opts, errors.New("order must be asc or desc")
+		}
+	}
+
+	return opts, nil
+}
+
+// FunctionHandler handles GET, PUT, PATCH and DELETE requests for a specific
+// function
 func (h *ServerHandler) FunctionHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	requestID, _ := ctx.Value(middleware.RequestIDKey{}).(string)
+	requestID := requestctx.ID(ctx)
 
 	// Extract function ID from URL path
 	path := r.URL.Path
@@ -327,6 +1482,46 @@ func (h *ServerHandler) FunctionHandler(w http.ResponseWriter, r *http.Request)
 
 	functionID := path[len("/api/functions/"):]
 
+	if name, ok := strings.CutPrefix(functionID, "by-name/"); ok {
+		h.FunctionByNameHandler(w, r, name)
+		return
+	}
+
+	// Route schedule, execution history, log, environment, tag, default
+	// input and source requests
+	if id, ok := strings.CutSuffix(functionID, "/schedule/failures"); ok {
+		h.ScheduleFailuresHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/schedule"); ok {
+		h.ScheduleHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/executions"); ok {
+		h.ExecutionsHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/logs"); ok {
+		h.LogsHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/env"); ok {
+		h.EnvHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/tags"); ok {
+		h.TagsHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/defaults"); ok {
+		h.DefaultInputHandler(w, r, id)
+		return
+	}
+	if id, ok := strings.CutSuffix(functionID, "/source"); ok {
+		h.SourceHandler(w, r, id)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		// Get function details
@@ -341,18 +1536,22 @@ func (h *ServerHandler) FunctionHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		utils.RespondWithJSON(w, http.StatusOK, metadata)
+		utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
 
 	case http.MethodDelete:
 		// Delete function
-		err := h.functionStore.DeleteFunction(ctx, functionID)
+		err := h.deleteFunction(ctx, functionID)
 		if err != nil {
 			log.Error().
 				Str("request_id", requestID).
 				Str("function_id", functionID).
 				Err(err).
 				Msg("Failed to delete function")
-			utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+			if errors.Is(err, store.ErrFunctionNotFound) {
+				utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+				return
+			}
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to delete function", err.Error())
 			return
 		}
 
@@ -360,19 +1559,47 @@ func (h *ServerHandler) FunctionHandler(w http.ResponseWriter, r *http.Request)
 			"message": fmt.Sprintf("Function %s deleted successfully", functionID),
 		})
 
+	case http.MethodPut:
+		// Redeploy function with new code
+		h.UpdateFunctionHandler(w, r, functionID)
+
+	case http.MethodPatch:
+		// Rename function
+		h.RenameHandler(w, r, functionID)
+
 	default:
 		log.Warn().
 			Str("request_id", requestID).
 			Str("method", r.Method).
 			Msg("Invalid request method")
-		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET and DELETE requests are accepted")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET, PUT, PATCH and DELETE requests are accepted")
 	}
 }
 
-// HealthCheckHandler provides a simple health check endpoint
+// HealthCheckHandler reports whether the server can run functions. It probes
+// the Docker daemon and responds 503 when it is unreachable, so load balancers
+// stop routing to the instance.
 func (h *ServerHandler) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	utils.RespondWithJSON(w, http.StatusOK, map[string]string{
-		"status": "ok",
-		"time":   time.Now().Format(time.RFC3339),
+	requestID := requestctx.ID(r.Context())
+
+	// Probe with a short timeout of its own so a hung daemon can't stall the check
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status, dockerStatus, code := "ok", "up", http.StatusOK
+	if err := h.dockerManager.Ping(ctx); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Docker daemon is unreachable")
+		status, dockerStatus, code = "degraded", "down", http.StatusServiceUnavailable
+	}
+
+	utils.RespondWithJSON(w, code, map[string]interface{}{
+		"status":     status,
+		"docker":     dockerStatus,
+		"functions":  len(h.functionStore.ListFunctions(r.Context())),
+		"time":       time.Now().Format(time.RFC3339),
+		"containers": h.dockerManager.InFlight(),
 	})
 }
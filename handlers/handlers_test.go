@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+	"youtube_serverless/docker"
+	"youtube_serverless/docker/dockertest"
+	"youtube_serverless/models"
+	"youtube_serverless/utils"
+)
+
+// testServer is a ServerHandler talking to a fake Docker daemon, with its
+// routes registered the way the server registers them
+type testServer struct {
+	*ServerHandler
+	daemon *dockertest.Daemon
+	mux    *http.ServeMux
+}
+
+// newTestServer creates a testServer with the default configuration, after
+// configure has changed it
+func newTestServer(t *testing.T, configure func(*config.Config)) *testServer {
+	t.Helper()
+
+	daemon := dockertest.NewDaemon(t)
+	cfg := config.LoadConfig()
+	cfg.Docker.Host = daemon.Host()
+	cfg.FileOps.TempDirBase = t.TempDir()
+	cfg.FileOps.SourceDir = t.TempDir()
+	if configure != nil {
+		configure(cfg)
+	}
+
+	h, err := NewServerHandler(cfg)
+	if err != nil {
+		t.Fatalf("NewServerHandler: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		h.Shutdown(ctx)
+	})
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return &testServer{ServerHandler: h, daemon: daemon, mux: mux}
+}
+
+// do sends a request through the server's routes and middleware
+func (s *testServer) do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+// doJSON sends body, encoded as JSON, with the given method and path
+func (s *testServer) doJSON(t *testing.T, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return s.do(r)
+}
+
+// deploy submits files as a zip archive along with the form fields, failing
+// the test unless the function is deployed
+func (s *testServer) deploy(t *testing.T, files map[string]string, fields map[string]string) models.SubmissionResponse {
+	t.Helper()
+
+	w := s.do(submitRequest(t, files, fields))
+	if w.Code != http.StatusOK {
+		t.Fatalf("deploy: status %d: %s", w.Code, w.Body)
+	}
+	var response models.SubmissionResponse
+	decode(t, w, &response)
+	return response
+}
+
+// submitRequest creates a submission of files as a zip archive along with
+// the form fields
+func submitRequest(t *testing.T, files map[string]string, fields map[string]string) *http.Request {
+	t.Helper()
+	return submitArchive(t, zipArchive(t, files), fields)
+}
+
+// submitArchive creates a submission of archive along with the form fields
+func submitArchive(t *testing.T, archive []byte, fields map[string]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			t.Fatalf("failed to write field: %v", err)
+		}
+	}
+	part, err := form.CreateFormFile("code", "function.zip")
+	if err != nil {
+		t.Fatalf("failed to create file part: %v", err)
+	}
+	if _, err := part.Write(archive); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatalf("failed to close form: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/submit", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+// zipArchive returns a zip archive of files
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// zipArchiveOfSize returns a zip archive of a Python function, padded with
+// an archive comment to exactly size bytes
+func zipArchiveOfSize(t *testing.T, size int) []byte {
+	t.Helper()
+
+	build := func(comment string) []byte {
+		var buf bytes.Buffer
+		archive := zip.NewWriter(&buf)
+		f, err := archive.Create("main.py")
+		if err != nil {
+			t.Fatalf("failed to add main.py: %v", err)
+		}
+		f.Write([]byte(pythonFunction["main.py"]))
+		if err := archive.SetComment(comment); err != nil {
+			t.Fatalf("failed to set the comment: %v", err)
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatalf("failed to close archive: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	padding := size - len(build(""))
+	if padding < 0 || padding > 0xffff {
+		t.Fatalf("can't pad an archive to %d bytes", size)
+	}
+	return build(strings.Repeat("x", padding))
+}
+
+// decode decodes the JSON response body into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, w.Body)
+	}
+}
+
+// pythonFunction is a minimal Python function
+var pythonFunction = map[string]string{"main.py": "print('hello')\n"}
+
+func TestSubmitHandlerFileOverride(t *testing.T) {
+	s := newTestServer(t, nil)
+	files := map[string]string{"a.py": "print('a')\n", "b.py": "print('b')\n"}
+
+	w := s.do(submitRequest(t, files, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("ambiguous upload: status %d, want 400: %s", w.Code, w.Body)
+	}
+	var response models.ErrorResponse
+	decode(t, w, &response)
+	if !strings.Contains(response.Details, "a.py, b.py") || !strings.Contains(response.Details, "handler") {
+		t.Errorf("details %q don't list the candidates and the handler field", response.Details)
+	}
+
+	deployed := s.deploy(t, files, map[string]string{"handler": "b.py"})
+	metadata := s.getFunction(t, deployed.FunctionID)
+	if metadata.Language != "python" {
+		t.Errorf("language = %q, want python", metadata.Language)
+	}
+	builds := s.daemon.Builds()
+	if dockerfile := builds[len(builds)-1].Dockerfile; !strings.Contains(dockerfile, "b.py") {
+		t.Errorf("Dockerfile doesn't run b.py:\n%s", dockerfile)
+	}
+}
+
+func TestExecuteRejectsOversizedBody(t *testing.T) {
+	const maxRequestBody = 1 << 10
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Server.MaxRequestBody = maxRequestBody
+	})
+	deployed := s.deploy(t, pythonFunction, nil)
+
+	for _, chunked := range []bool{false, true} {
+		w := s.do(executeRequest(t, models.ExecutionRequest{
+			FunctionID: deployed.FunctionID,
+			Input:      map[string]interface{}{"data": strings.Repeat("x", maxRequestBody)},
+		}, chunked))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked %t: status %d, want 413: %s", chunked, w.Code, w.Body)
+		}
+	}
+	if running := s.daemon.Running(); running != 0 || len(s.daemon.Removed()) != 0 {
+		t.Error("an oversized request ran the function")
+	}
+}
+
+// executeRequest creates an execution request, sent without a declared
+// length if chunked is set
+func executeRequest(t *testing.T, request models.ExecutionRequest, chunked bool) *http.Request {
+	t.Helper()
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	var body io.Reader = bytes.NewReader(data)
+	if chunked {
+		body = io.MultiReader(body)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/execute", body)
+	r.Header.Set("Content-Type", "application/json")
+	if chunked {
+		r.ContentLength = -1
+	}
+	return r
+}
+
+func TestDaemonUnavailableGets503(t *testing.T) {
+	s := newTestServer(t, nil)
+	deployed := s.deploy(t, pythonFunction, nil)
+	s.daemon.Close()
+
+	w := s.do(executeRequest(t, models.ExecutionRequest{FunctionID: deployed.FunctionID}, false))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("execute: status %d, want 503: %s", w.Code, w.Body)
+	}
+	var response models.ErrorResponse
+	decode(t, w, &response)
+	if response.Error != "Docker is unavailable" || !strings.Contains(response.Details, "make sure Docker is installed and running") {
+		t.Errorf("error = %+v", response)
+	}
+
+	w = s.do(submitRequest(t, map[string]string{"main.py": "print('changed')\n"}, nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("submit: status %d, want 503: %s", w.Code, w.Body)
+	}
+}
+
+func TestNewServerHandlerWithoutDaemon(t *testing.T) {
+	cfg := config.LoadConfig()
+	cfg.Docker.Host = "unix://" + filepath.Join(t.TempDir(), "docker.sock")
+
+	if _, err := NewServerHandler(cfg); !errors.Is(err, docker.ErrDaemonUnavailable) {
+		t.Errorf("NewServerHandler = %v, want ErrDaemonUnavailable", err)
+	}
+}
+
+func TestSubmitUploadSizeLimit(t *testing.T) {
+	const maxFileSize = 16 << 10
+	const maxRequestBody = 4 << 10
+
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool
+		status  int
+		error   string
+	}{
+		{name: "just under the limit", size: maxFileSize - 1, status: http.StatusOK},
+		{name: "at the limit", size: maxFileSize, status: http.StatusOK},
+		{name: "just over the limit", size: maxFileSize + 1, status: http.StatusRequestEntityTooLarge, error: "Code archive too large"},
+		{name: "body over the upload limit", size: maxFileSize + maxRequestBody + 1, status: http.StatusRequestEntityTooLarge, error: "Upload too large"},
+		{name: "chunked body over the upload limit", size: maxFileSize + maxRequestBody + 1, chunked: true, status: http.StatusRequestEntityTooLarge, error: "Upload too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *config.Config) {
+				cfg.FileOps.MaxFileSize = maxFileSize
+				cfg.Server.MaxRequestBody = maxRequestBody
+			})
+
+			r := submitArchive(t, zipArchiveOfSize(t, tt.size), nil)
+			if tt.chunked {
+				r.Body = io.NopCloser(io.MultiReader(r.Body))
+				r.ContentLength = -1
+			}
+			w := s.do(r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if tt.error != "" {
+				var response models.ErrorResponse
+				decode(t, w, &response)
+				if response.Error != tt.error {
+					t.Errorf("error = %q, want %q", response.Error, tt.error)
+				}
+				if !strings.Contains(response.Details, utils.FormatMB(maxFileSize)) {
+					t.Errorf("details %q don't give the limit of %s", response.Details, utils.FormatMB(maxFileSize))
+				}
+			}
+
+			// The upload's temp directory is removed either way
+			if entries, _ := os.ReadDir(s.config.FileOps.TempDirBase); len(entries) != 0 {
+				t.Errorf("left %d temp directories behind", len(entries))
+			}
+		})
+	}
+}
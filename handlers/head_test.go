@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestHeadServesGetHeadersWithoutBody(t *testing.T) {
+	s := newTestServer(t, nil)
+	deployed := s.deploy(t, pythonFunction, nil)
+
+	for _, path := range []string{"/health", "/api/functions/" + deployed.FunctionID, "/api/functions/unknown"} {
+		t.Run(path, func(t *testing.T) {
+			get := s.do(httptest.NewRequest(http.MethodGet, path, nil))
+			head := s.do(httptest.NewRequest(http.MethodHead, path, nil))
+
+			if head.Code != get.Code {
+				t.Errorf("HEAD status %d, want GET's %d", head.Code, get.Code)
+			}
+			if head.Body.Len() != 0 {
+				t.Errorf("HEAD sent a body: %s", head.Body)
+			}
+			if got, want := head.Header().Get("Content-Type"), get.Header().Get("Content-Type"); got != want || got == "" {
+				t.Errorf("HEAD Content-Type = %q, want GET's %q", got, want)
+			}
+			if got, want := head.Header().Get("Content-Length"), strconv.Itoa(get.Body.Len()); got != want {
+				t.Errorf("HEAD Content-Length = %q, want the GET body's %s", got, want)
+			}
+		})
+	}
+}
+
+func TestHeadHealthWithoutDaemon(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.daemon.Close()
+
+	w := s.do(httptest.NewRequest(http.MethodHead, "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD sent a body: %s", w.Body)
+	}
+}
+
+func TestHeadOnWriteEndpointIsNotAllowed(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	w := s.do(httptest.NewRequest(http.MethodHead, "/api/submit", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want 405", w.Code)
+	}
+}
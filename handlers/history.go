@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/events"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// defaultHistoryLimit is the number of executions returned when no limit is given
+const defaultHistoryLimit = 20
+
+// ExecutionsHandler handles GET requests for a function's recent executions
+func (h *ServerHandler) ExecutionsHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid query parameters", "limit must be a positive integer")
+			return
+		}
+		limit = parsed
+	}
+
+	executions, err := h.functionStore.ListExecutions(ctx, functionID, limit)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Function not found")
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, models.ExecutionHistoryResponse{
+		FunctionID: functionID,
+		Executions: executions,
+	})
+}
+
+// recordExecution adds an execution to the function's history, which also
+// updates its last executed timestamp when it succeeded, and emits it as an
+// event
+func (h *ServerHandler) recordExecution(ctx context.Context, record models.ExecutionRecord) {
+	requestID := requestctx.ID(ctx)
+
+	if err := h.functionStore.RecordExecution(ctx, record); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", record.FunctionID).
+			Err(err).
+			Msg("Failed to record execution")
+	}
+
+	h.emit(ctx, events.Event{
+		Type:        events.FunctionExecuted,
+		FunctionID:  record.FunctionID,
+		ExecutionID: record.ExecutionID,
+		Success:     &record.Success,
+		ExitCode:    &record.ExitCode,
+		DurationMs:  record.DurationMs,
+		Error:       record.Error,
+	})
+}
+
+// headBuffer keeps the first limit bytes written to it and discards the rest,
+// so streamed output can be recorded without buffering all of it
+type headBuffer struct {
+	data  []byte
+	limit int
+}
+
+// Write keeps what fits and always reports success
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.data); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.data = append(b.data, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output
+func (b *headBuffer) String() string {
+	return string(b.data)
+}
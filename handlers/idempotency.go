@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"youtube_serverless/models"
+)
+
+// idempotencyCleanupInterval bounds how often expired idempotency entries are swept
+const idempotencyCleanupInterval = time.Minute
+
+// idempotencyEntry is the cached response for one idempotency key
+type idempotencyEntry struct {
+	response models.SubmissionResponse
+	expires  time.Time
+}
+
+// idempotencyStore remembers submission responses by idempotency key for ttl
+// so that retried requests return the original result. Concurrent requests
+// with the same key are serialized by locks, so only the first one builds.
+type idempotencyStore struct {
+	ttl         time.Duration
+	locks       *keyedMutex
+	entries     map[string]idempotencyEntry
+	mutex       sync.Mutex
+	lastCleanup time.Time
+}
+
+// newIdempotencyStore creates an idempotency store, or returns nil when ttl is
+// zero or less. A nil *idempotencyStore is valid and caches nothing.
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	if ttl <= 0 {
+		return nil
+	}
+	return &idempotencyStore{
+		ttl:         ttl,
+		locks:       newKeyedMutex(),
+		entries:     make(map[string]idempotencyEntry),
+		lastCleanup: time.Now(),
+	}
+}
+
+// lock blocks until no other request holds key and returns a function that
+// releases it
+func (s *idempotencyStore) lock(key string) func() {
+	if s == nil {
+		return func() {}
+	}
+	return s.locks.lock(key)
+}
+
+// get returns the cached response for key, if it has not expired
+func (s *idempotencyStore) get(key string) (models.SubmissionResponse, bool) {
+	if s == nil {
+		return models.SubmissionResponse{}, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return models.SubmissionResponse{}, false
+	}
+	return entry.response, true
+}
+
+// put caches the response for key and sweeps expired entries
+func (s *idempotencyStore) put(key string, response models.SubmissionResponse) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastCleanup) > idempotencyCleanupInterval {
+		for k, entry := range s.entries {
+			if now.After(entry.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastCleanup = now
+	}
+
+	s.entries[key] = idempotencyEntry{
+		response: response,
+		expires:  now.Add(s.ttl),
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// parseExecutionForm reads a multipart execution request: the function ID in
+// the "functionId" field or its name in the "name" field, optional JSON input in the "input" field and an
+// optional input file in the "file" field, which is saved to a temp directory
+// for mounting into the container. On failure it writes the error response
+// and returns false.
+func (h *ServerHandler) parseExecutionForm(w http.ResponseWriter, r *http.Request) (*executionRequest, bool) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if err := r.ParseMultipartForm(h.config.FileOps.MaxFileSize); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse multipart form")
+		utils.RespondWithUploadError(w, "Failed to parse form", err, h.config.FileOps.MaxFileSize)
+		return nil, false
+	}
+
+	functionID, ok := h.resolveFunctionID(w, r, r.FormValue("functionId"), r.FormValue("alias"), r.FormValue("name"))
+	if !ok {
+		return nil, false
+	}
+	timeout, ok := h.parseTimeoutFields(w, r)
+	if !ok {
+		return nil, false
+	}
+	execRequest := &executionRequest{
+		FunctionID:  functionID,
+		CallbackURL: r.FormValue("callbackUrl"),
+		Timeout:     timeout,
+		cleanup:     func() {},
+	}
+
+	if value := r.FormValue("input"); value != "" {
+		if err := json.Unmarshal([]byte(value), &execRequest.Input); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Err(err).
+				Msg("Failed to parse input field")
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid input", "The 'input' field must be a JSON object: "+err.Error())
+			return nil, false
+		}
+	}
+	execRequest.Input = mergeInput(queryInput(r.URL.Query(), r.FormValue("functionId") == "" && r.FormValue("alias") == ""), execRequest.Input)
+
+	file, header, err := r.FormFile("file")
+	if err == http.ErrMissingFile {
+		return execRequest, true
+	}
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to retrieve input file")
+		utils.RespondWithError(w, http.StatusBadRequest, "Failed to retrieve input file", err.Error())
+		return nil, false
+	}
+	defer file.Close()
+
+	tempDir, err := h.fileHandler.CreateTempDir(ctx)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to create temp directory")
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to create temp directory", err.Error())
+		return nil, false
+	}
+
+	inputFile, err := h.fileHandler.SaveInputFile(ctx, tempDir, header.Filename, file)
+	if err != nil {
+		h.fileHandler.CleanupTempDir(ctx, tempDir)
+		if errors.Is(err, utils.ErrFileTooLarge) {
+			utils.RespondWithError(w, http.StatusRequestEntityTooLarge, "Input file too large", err.Error())
+			return nil, false
+		}
+		utils.RespondWithError(w, http.StatusBadRequest, "Failed to save input file", err.Error())
+		return nil, false
+	}
+
+	execRequest.InputFile = inputFile
+	execRequest.cleanup = func() { h.fileHandler.CleanupTempDir(ctx, tempDir) }
+	return execRequest, true
+}
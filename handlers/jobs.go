@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/jobs"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/schema"
+	"youtube_serverless/utils"
+	"youtube_serverless/webhook"
+)
+
+// executeAsync queues a function execution on the job pool and responds with
+// 202 and the job ID. When callbackURL is set, the finished job is POSTed to it.
+func (h *ServerHandler) executeAsync(w http.ResponseWriter, r *http.Request, functionID string, input map[string]interface{}, callbackURL string, timeout time.Duration) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	// Fail fast for unknown functions and invalid input rather than queueing a
+	// doomed job. Defaults are merged again when the job runs, so it gets any
+	// set in the meantime.
+	metadata, err := h.functionStore.GetFunction(ctx, functionID)
+	if err == nil {
+		err = schema.Validate(metadata.InputSchema, withDefaults(metadata, input))
+	}
+	if err != nil {
+		h.respondWithExecutionError(w, requestID, functionID, err)
+		return
+	}
+
+	var done jobs.DoneFunc
+	if callbackURL != "" {
+		if err := h.notifier.Validate(ctx, callbackURL); err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("function_id", functionID).
+				Err(err).
+				Msg("Rejected callback URL")
+			message := "Invalid callback URL"
+			if errors.Is(err, webhook.ErrDisabled) {
+				message = "Callbacks are disabled"
+			}
+			utils.RespondWithError(w, http.StatusBadRequest, message, err.Error())
+			return
+		}
+		done = func(ctx context.Context, job models.Job) {
+			h.notifier.Notify(ctx, callbackURL, job)
+		}
+	}
+
+	job := h.jobStore.CreateJob(ctx, functionID)
+	err = h.jobPool.Submit(ctx, job.JobID, func(jobCtx context.Context) (*models.ExecutionResponse, error) {
+		return h.executeFunction(jobCtx, functionID, input, "", nil, timeout)
+	}, done)
+	if err != nil {
+		h.jobStore.Complete(job.JobID, nil, err)
+		log.Warn().
+			Str("request_id", requestID).
+			Str("job_id", job.JobID).
+			Err(err).
+			Msg("Failed to queue job")
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, jobs.ErrQueueFull) {
+			status = http.StatusTooManyRequests
+		}
+		utils.RespondWithError(w, status, "Failed to queue execution", err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusAccepted, models.AsyncExecutionResponse{
+		JobID:  job.JobID,
+		Status: job.Status,
+	})
+}
+
+// JobHandler returns the status and, once finished, the result of an asynchronous execution
+func (h *ServerHandler) JobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	jobID := r.URL.Path[len("/api/jobs/"):]
+	if jobID == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid job path", "Job ID is required")
+		return
+	}
+
+	job, err := h.jobStore.GetJob(ctx, jobID)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("job_id", jobID).
+			Msg("Job not found")
+		utils.RespondWithError(w, http.StatusNotFound, "Job not found", err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, job)
+}
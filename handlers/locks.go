@@ -0,0 +1,50 @@
+package handlers
+
+import "sync"
+
+// keyedMutex serializes work per key while letting different keys proceed
+// concurrently
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the lock for one key, shared by everyone waiting on it
+type keyedLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// newKeyedMutex creates an empty keyedMutex
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// lock blocks until key is free and returns a function that releases it.
+// Calling the release function more than once is a no-op.
+func (km *keyedMutex) lock(key string) func() {
+	km.mutex.Lock()
+	l, ok := km.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		km.locks[key] = l
+	}
+	l.waiters++
+	km.mutex.Unlock()
+
+	l.Lock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Unlock()
+
+			km.mutex.Lock()
+			l.waiters--
+			if l.waiters == 0 {
+				delete(km.locks, key)
+			}
+			km.mutex.Unlock()
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// executionLogStore keeps the stdout and stderr of each function's most
+// recent executions in memory, so they can be fetched after the response
+// that carried them is gone
+type executionLogStore struct {
+	count   int                              // executions retained per function
+	maxSize int                              // bytes of stdout and of stderr retained per execution
+	logs    map[string][]models.ExecutionLog // function ID -> logs, oldest first
+	mutex   sync.Mutex
+}
+
+// newExecutionLogStore creates an execution log store, or returns nil when
+// count is zero or less. A nil *executionLogStore is valid and retains nothing.
+func newExecutionLogStore(count, maxSize int) *executionLogStore {
+	if count <= 0 {
+		return nil
+	}
+	return &executionLogStore{
+		count:   count,
+		maxSize: maxSize,
+		logs:    make(map[string][]models.ExecutionLog),
+	}
+}
+
+// add retains an execution's logs, cutting each stream to the size limit and
+// evicting the function's oldest logs once it has count of them
+func (s *executionLogStore) add(entry models.ExecutionLog) {
+	if s == nil {
+		return
+	}
+
+	if len(entry.Stdout) > s.maxSize {
+		entry.Stdout = entry.Stdout[:s.maxSize]
+		entry.Truncated = true
+	}
+	if len(entry.Stderr) > s.maxSize {
+		entry.Stderr = entry.Stderr[:s.maxSize]
+		entry.Truncated = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	logs := append(s.logs[entry.FunctionID], entry)
+	if len(logs) > s.count {
+		// Copy rather than reslice so evicted logs can be freed
+		logs = append([]models.ExecutionLog(nil), logs[len(logs)-s.count:]...)
+	}
+	s.logs[entry.FunctionID] = logs
+}
+
+// get returns a function's logs for executionID, or its most recent logs when
+// executionID is empty
+func (s *executionLogStore) get(functionID, executionID string) (models.ExecutionLog, bool) {
+	if s == nil {
+		return models.ExecutionLog{}, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	logs := s.logs[functionID]
+	for i := len(logs) - 1; i >= 0; i-- {
+		if executionID == "" || logs[i].ExecutionID == executionID {
+			return logs[i], true
+		}
+	}
+	return models.ExecutionLog{}, false
+}
+
+// remove forgets every retained log of a function
+func (s *executionLogStore) remove(functionID string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.logs, functionID)
+}
+
+// LogsHandler handles GET requests for the retained logs of one of a
+// function's executions, given by executionId, or of its most recent one
+func (h *ServerHandler) LogsHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	if _, err := h.functionStore.GetFunction(ctx, functionID); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Function not found")
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+		return
+	}
+
+	executionID := r.URL.Query().Get("executionId")
+	entry, ok := h.executionLogs.get(functionID, executionID)
+	if !ok {
+		details := "The function has no retained logs"
+		if executionID != "" {
+			details = "No logs are retained for execution " + executionID
+		}
+		utils.RespondWithError(w, http.StatusNotFound, "Logs not found", details)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, entry)
+}
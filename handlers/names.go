@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// FunctionByNameHandler returns the details of the function with the given name
+func (h *ServerHandler) FunctionByNameHandler(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	if name == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid function path", "Function name is required")
+		return
+	}
+
+	metadata, err := h.functionStore.GetFunctionByName(ctx, name)
+	if err != nil {
+		h.respondWithNameError(w, requestID, name, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
+}
+
+// resolveFunctionID returns functionID if set, otherwise the ID of the
+// function the alias points to if set, and otherwise the ID of the function
+// called name. On failure it writes the error response and returns false.
+func (h *ServerHandler) resolveFunctionID(w http.ResponseWriter, r *http.Request, functionID, alias, name string) (string, bool) {
+	if functionID != "" {
+		return functionID, true
+	}
+
+	requestID := requestctx.ID(r.Context())
+	if alias != "" {
+		metadata, err := h.functionStore.ResolveAlias(r.Context(), alias)
+		if err != nil {
+			h.respondWithAliasError(w, requestID, alias, err)
+			return "", false
+		}
+		return metadata.FunctionID, true
+	}
+
+	if name == "" {
+		log.Warn().
+			Str("request_id", requestID).
+			Msg("Missing function ID, alias and name")
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing function ID", "The 'functionId', 'alias' or 'name' field is required")
+		return "", false
+	}
+
+	metadata, err := h.functionStore.GetFunctionByName(r.Context(), name)
+	if err != nil {
+		h.respondWithNameError(w, requestID, name, err)
+		return "", false
+	}
+	return metadata.FunctionID, true
+}
+
+// respondWithNameError maps a GetFunctionByName error to an HTTP error response
+func (h *ServerHandler) respondWithNameError(w http.ResponseWriter, requestID, name string, err error) {
+	log.Warn().
+		Str("request_id", requestID).
+		Str("name", name).
+		Err(err).
+		Msg("Failed to find function by name")
+
+	switch {
+	case errors.Is(err, store.ErrFunctionNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+	case errors.Is(err, store.ErrAmbiguousName):
+		utils.RespondWithError(w, http.StatusConflict, "Function name is ambiguous", err.Error())
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to find function", err.Error())
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+func TestSubmitRejectsDuplicateName(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Store.UniqueNames = true
+	})
+
+	deployed := s.deploy(t, pythonFunction, map[string]string{"name": "greeter"})
+
+	w := s.do(submitRequest(t, pythonFunction, map[string]string{"name": "greeter"}))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("duplicate name: status %d, want 409: %s", w.Code, w.Body)
+	}
+	if builds := len(s.daemon.Builds()); builds != 1 {
+		t.Errorf("%d builds, want the duplicate rejected before building", builds)
+	}
+
+	w = s.doJSON(t, http.MethodGet, "/api/functions/by-name/greeter", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get by name: status %d: %s", w.Code, w.Body)
+	}
+	var metadata models.FunctionMetadata
+	decode(t, w, &metadata)
+	if metadata.FunctionID != deployed.FunctionID {
+		t.Errorf("by name = %s, want %s", metadata.FunctionID, deployed.FunctionID)
+	}
+}
+
+func TestSubmitParsesUploadWithFileSizeLimit(t *testing.T) {
+	const maxFileSize = 1 << 10
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Store.UniqueNames = true
+		cfg.FileOps.MaxFileSize = maxFileSize
+	})
+
+	// Random content doesn't compress, so the archive is over the limit
+	content := make([]byte, 4*maxFileSize)
+	rand.New(rand.NewSource(1)).Read(content)
+	r := submitRequest(t, map[string]string{"main.py": string(content)}, map[string]string{"name": "greeter"})
+
+	// Called directly, since middleware passes the handler a copy of r
+	w := httptest.NewRecorder()
+	s.SubmitHandler(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want 413: %s", w.Code, w.Body)
+	}
+
+	// Reading the name must not have parsed the form with the default
+	// limit, which would have kept the whole archive in memory
+	if r.MultipartForm == nil {
+		t.Fatal("form was not parsed")
+	}
+	t.Cleanup(func() { r.MultipartForm.RemoveAll() })
+	f, err := r.MultipartForm.File["code"][0].Open()
+	if err != nil {
+		t.Fatalf("failed to open upload: %v", err)
+	}
+	defer f.Close()
+	if _, onDisk := f.(*os.File); !onDisk {
+		t.Errorf("archive over MAX_FILE_SIZE was kept in memory")
+	}
+}
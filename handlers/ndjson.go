@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+)
+
+// ndjsonFlushInterval is how many functions a streamed listing writes between
+// flushes, so clients get them promptly without a flush per line
+const ndjsonFlushInterval = 100
+
+// streamFunctions writes the functions matching the filters of opts as
+// newline-delimited JSON, one function per line, oldest first. Functions are
+// read from the store one at a time rather than collected into a slice, so
+// the response starts straight away and its size doesn't bound memory.
+// Sorting and pagination don't apply.
+func (h *ServerHandler) streamFunctions(w http.ResponseWriter, r *http.Request, opts store.ListOptions) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	count := 0
+	err := h.functionStore.ForEachFunction(ctx, func(metadata models.FunctionMetadata) error {
+		if !opts.Matches(metadata) {
+			return nil
+		}
+		if err := encoder.Encode(h.present(metadata)); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%ndjsonFlushInterval == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	// The status has been sent, so a failure can only cut the stream short
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Int("count", count).
+			Err(err).
+			Msg("Function stream ended early")
+		return
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("count", count).
+		Msg("Streamed functions")
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// SetReady sets whether the readiness probe reports the server as ready. It
+// is set once the server is listening and cleared when shutdown begins, so
+// orchestrators stop routing traffic before connections are closed.
+func (h *ServerHandler) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// LivenessHandler reports that the process is up. It checks nothing else, so
+// a slow Docker daemon never gets the server restarted.
+func (h *ServerHandler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadinessHandler reports whether the server should receive traffic: it has
+// finished starting, isn't shutting down, can reach the Docker daemon and
+// the function store's circuit breaker, if it has one, isn't open
+func (h *ServerHandler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := requestctx.ID(r.Context())
+
+	if !h.ready.Load() {
+		utils.RespondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+
+	// Probe with a short timeout of its own so a hung daemon can't stall the check
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	if err := h.dockerManager.Ping(ctx); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Docker daemon is unreachable")
+		utils.RespondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "docker": "down"})
+		return
+	}
+
+	status := map[string]string{"status": "ready", "docker": "up"}
+	if state, ok := store.BreakerState(h.functionStore); ok {
+		status["store"] = state
+		if state == store.BreakerOpen {
+			log.Warn().
+				Str("request_id", requestID).
+				Msg("Function store circuit breaker is open")
+			status["status"] = "not ready"
+			utils.RespondWithJSON(w, http.StatusServiceUnavailable, status)
+			return
+		}
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, status)
+}
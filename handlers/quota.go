@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+
+	"youtube_serverless/models"
+)
+
+// functionLimitReached reports whether deploying another function would go
+// past MAX_FUNCTIONS and the quota policy rejects rather than evicts, so a
+// submission can fail before its build. The store checks again when the
+// function is stored.
+func (h *ServerHandler) functionLimitReached(ctx context.Context) bool {
+	limit := h.config.Store.MaxFunctions
+	if limit <= 0 || h.config.Store.QuotaPolicy != "reject" {
+		return false
+	}
+	return len(h.functionStore.ListFunctions(ctx)) >= limit
+}
+
+// storeNewFunction stores a newly deployed function. When the function limit
+// is reached and the quota policy is evict, the store deletes the least
+// recently executed functions until it fits. They are cleaned up here except
+// for their images, which the caller removes with removeEvictedImages once it
+// has released its build lock.
+func (h *ServerHandler) storeNewFunction(ctx context.Context, metadata models.FunctionMetadata) ([]models.FunctionMetadata, error) {
+	if h.config.Store.QuotaPolicy != "evict" {
+		return nil, h.functionStore.StoreFunction(ctx, metadata)
+	}
+
+	evicted, err := h.functionStore.StoreFunctionEvicting(ctx, metadata)
+	for _, victim := range evicted {
+		h.forgetFunction(ctx, victim)
+	}
+	return evicted, err
+}
+
+// removeEvictedImages removes the images of evicted functions that no function
+// uses any more, such as one the new function reuses. removeUnusedImage takes
+// the build lock for each, so callers must not hold one.
+func (h *ServerHandler) removeEvictedImages(ctx context.Context, evicted []models.FunctionMetadata) {
+	for _, victim := range evicted {
+		h.removeUnusedImage(ctx, victim)
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+// newQuotaTestServer creates a test server that allows limit functions,
+// handling a submission past it by the quota policy
+func newQuotaTestServer(t *testing.T, limit int, policy string) *testServer {
+	return newTestServer(t, func(cfg *config.Config) {
+		cfg.Store.MaxFunctions = limit
+		cfg.Store.QuotaPolicy = policy
+	})
+}
+
+// numberedFunction returns the code of a function that differs for each n, so
+// each gets its own image
+func numberedFunction(n int) map[string]string {
+	return map[string]string{"main.py": fmt.Sprintf("print(%d)\n", n)}
+}
+
+// setLastExecuted backdates when a function last executed
+func (s *testServer) setLastExecuted(t *testing.T, functionID string, at int64) {
+	t.Helper()
+	if _, err := s.functionStore.UpdateFunction(context.Background(), functionID, func(metadata *models.FunctionMetadata) error {
+		metadata.LastExecuted = at
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+}
+
+func TestSubmitRejectsPastFunctionLimit(t *testing.T) {
+	const limit = 3
+	s := newQuotaTestServer(t, limit, "reject")
+
+	// N-1 and N functions deploy
+	for i := 1; i <= limit; i++ {
+		s.deploy(t, numberedFunction(i), nil)
+	}
+
+	// N+1 is rejected before its build
+	builds := len(s.daemon.Builds())
+	w := s.do(submitRequest(t, numberedFunction(limit+1), nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("submission past the limit: status %d, want 409: %s", w.Code, w.Body)
+	}
+	var response models.ErrorResponse
+	decode(t, w, &response)
+	if response.Error != "Function limit reached" {
+		t.Errorf("error = %q, want %q", response.Error, "Function limit reached")
+	}
+	if len(s.daemon.Builds()) != builds {
+		t.Errorf("rejected submission was built")
+	}
+	if n := len(s.functionStore.ListFunctions(context.Background())); n != limit {
+		t.Errorf("%d functions deployed, want %d", n, limit)
+	}
+	if removed := s.daemon.RemovedImages(); len(removed) != 0 {
+		t.Errorf("images removed: %v", removed)
+	}
+}
+
+func TestSubmitEvictsLeastRecentlyExecutedFunction(t *testing.T) {
+	const limit = 3
+	s := newQuotaTestServer(t, limit, "evict")
+
+	// N-1 and N functions deploy without evicting anything
+	var deployed []models.SubmissionResponse
+	for i := 1; i <= limit; i++ {
+		deployed = append(deployed, s.deploy(t, numberedFunction(i), nil))
+	}
+	if removed := s.daemon.RemovedImages(); len(removed) != 0 {
+		t.Fatalf("images removed below the limit: %v", removed)
+	}
+
+	// The second function has gone longest without executing
+	s.setLastExecuted(t, deployed[0].FunctionID, 200)
+	s.setLastExecuted(t, deployed[1].FunctionID, 100)
+	s.setLastExecuted(t, deployed[2].FunctionID, 300)
+
+	// N+1 evicts it along with its image
+	added := s.deploy(t, numberedFunction(limit+1), nil)
+	victim := deployed[1]
+	if w := s.do(httptest.NewRequest(http.MethodGet, "/api/functions/"+victim.FunctionID, nil)); w.Code != http.StatusNotFound {
+		t.Errorf("evicted function: status %d, want 404", w.Code)
+	}
+	removed := s.daemon.RemovedImages()
+	if len(removed) != 1 || removed[0] != victim.ImageID {
+		t.Errorf("removed images %v, want [%s]", removed, victim.ImageID)
+	}
+	for _, kept := range []models.SubmissionResponse{deployed[0], deployed[2], added} {
+		s.getFunction(t, kept.FunctionID)
+	}
+	if n := len(s.functionStore.ListFunctions(context.Background())); n != limit {
+		t.Errorf("%d functions deployed, want %d", n, limit)
+	}
+}
+
+func TestSubmitEvictionKeepsReusedImage(t *testing.T) {
+	s := newQuotaTestServer(t, 2, "evict")
+
+	first := s.deploy(t, numberedFunction(1), nil)
+	second := s.deploy(t, numberedFunction(2), nil)
+	s.setLastExecuted(t, first.FunctionID, 100)
+	s.setLastExecuted(t, second.FunctionID, 200)
+
+	// The same code as the least recently executed function reuses its
+	// image, which must outlive the function's eviction
+	added := s.deploy(t, numberedFunction(1), nil)
+	if !added.Reused || added.ImageID != first.ImageID {
+		t.Fatalf("submission got image %s (reused %t), want %s reused", added.ImageID, added.Reused, first.ImageID)
+	}
+	if w := s.do(httptest.NewRequest(http.MethodGet, "/api/functions/"+first.FunctionID, nil)); w.Code != http.StatusNotFound {
+		t.Errorf("evicted function: status %d, want 404", w.Code)
+	}
+	if removed := s.daemon.RemovedImages(); len(removed) != 0 {
+		t.Errorf("removed images %v, want none", removed)
+	}
+	if got := s.getFunction(t, added.FunctionID); got.ImageID != first.ImageID {
+		t.Errorf("new function has image %s, want %s", got.ImageID, first.ImageID)
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// RenameHandler handles PATCH requests renaming a function. Only the name
+// changes; the function keeps its ID, image and everything else.
+func (h *ServerHandler) RenameHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	var request models.RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		utils.RespondWithError(w, http.StatusBadRequest, "Missing name", "The request body must give the new name in name")
+		return
+	}
+
+	metadata, err := h.functionStore.UpdateName(ctx, functionID, name)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Str("name", name).
+			Err(err).
+			Msg("Failed to rename function")
+		switch {
+		case errors.Is(err, store.ErrFunctionNotFound):
+			utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+		case errors.Is(err, store.ErrNameTaken):
+			utils.RespondWithError(w, http.StatusConflict, "Function name already in use", err.Error())
+		case errors.Is(err, store.ErrStoreUnavailable):
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Function store is unavailable", err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to rename function", err.Error())
+		}
+		return
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("function_id", functionID).
+		Str("name", name).
+		Msg("Renamed function")
+
+	utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+func TestRenameConcurrentlyToSameName(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Store.UniqueNames = true
+	})
+	first := s.deploy(t, numberedFunction(1), map[string]string{"name": "first"})
+	second := s.deploy(t, numberedFunction(2), map[string]string{"name": "second"})
+
+	deployed := []models.SubmissionResponse{first, second}
+	requests := make([]*http.Request, len(deployed))
+	for i, d := range deployed {
+		requests[i] = httptest.NewRequest(http.MethodPatch, "/api/functions/"+d.FunctionID, bytes.NewBufferString(`{"name": "target"}`))
+		requests[i].Header.Set("Content-Type", "application/json")
+	}
+
+	start := make(chan struct{})
+	responses := make([]*httptest.ResponseRecorder, len(requests))
+	var wg sync.WaitGroup
+	for i, r := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			responses[i] = s.do(r)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	winner := -1
+	for i, w := range responses {
+		switch w.Code {
+		case http.StatusOK:
+			if winner >= 0 {
+				t.Fatal("both renames succeeded")
+			}
+			winner = i
+		case http.StatusConflict:
+		default:
+			t.Fatalf("rename %d: status %d: %s", i, w.Code, w.Body)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("neither rename succeeded")
+	}
+
+	// The winner keeps its ID and image under the new name
+	var renamed models.FunctionMetadata
+	decode(t, responses[winner], &renamed)
+	if renamed.Name != "target" || renamed.FunctionID != deployed[winner].FunctionID || renamed.ImageID != deployed[winner].ImageID {
+		t.Errorf("renamed function = %s %q image %s; want %s %q image %s",
+			renamed.FunctionID, renamed.Name, renamed.ImageID, deployed[winner].FunctionID, "target", deployed[winner].ImageID)
+	}
+	loser := deployed[1-winner]
+	if got := s.getFunction(t, loser.FunctionID); got.Name != []string{"first", "second"}[1-winner] {
+		t.Errorf("losing function renamed to %q", got.Name)
+	}
+}
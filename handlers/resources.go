@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/docker"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// minMemoryLimit is the smallest memory limit Docker accepts for a container
+const minMemoryLimit = 6 * 1024 * 1024 // 6 MiB
+
+// resourceLimits reads a function's memory and CPU limits from the "memory"
+// and "cpus" form fields, falling back to the manifest, and checks them
+// against the configured maximums. Zero means the platform default.
+func (h *ServerHandler) resourceLimits(r *http.Request, manifest *models.Manifest) (int64, float64, error) {
+	memory := manifest.Memory
+	if value := r.FormValue("memory"); value != "" {
+		memory = value
+	}
+
+	var memoryLimit int64
+	if memory != "" {
+		bytes, err := units.RAMInBytes(memory)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid memory limit %q: %v", memory, err)
+		}
+		if bytes < minMemoryLimit {
+			return 0, 0, fmt.Errorf("memory limit %q is below the minimum of %s", memory, units.BytesSize(minMemoryLimit))
+		}
+		if bytes > h.config.Docker.MaxMemory {
+			return 0, 0, fmt.Errorf("memory limit %q exceeds the maximum of %s", memory, units.BytesSize(float64(h.config.Docker.MaxMemory)))
+		}
+		memoryLimit = bytes
+	}
+
+	cpuLimit := manifest.CPUs
+	if value := r.FormValue("cpus"); value != "" {
+		cpus, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid CPU limit %q: %v", value, err)
+		}
+		cpuLimit = cpus
+	}
+	if cpuLimit < 0 {
+		return 0, 0, fmt.Errorf("CPU limit must not be negative, got %g", cpuLimit)
+	}
+	if cpuLimit > h.config.Docker.MaxCPUs {
+		return 0, 0, fmt.Errorf("CPU limit %g exceeds the maximum of %g", cpuLimit, h.config.Docker.MaxCPUs)
+	}
+
+	return memoryLimit, cpuLimit, nil
+}
+
+// networkMode reads a function's network mode from the "network" form field,
+// falling back to the manifest. Empty means the platform default.
+func networkMode(r *http.Request, manifest *models.Manifest) (string, error) {
+	mode := manifest.Network
+	if value := r.FormValue("network"); value != "" {
+		mode = value
+	}
+	if mode != "" && !models.ValidNetworkMode(mode) {
+		return "", fmt.Errorf("network must be %s or %s, got %q", models.NetworkModeNone, models.NetworkModeBridge, mode)
+	}
+	return mode, nil
+}
+
+// executionTimeout returns the run timeout for an execution requesting
+// timeoutSeconds, where zero means DOCKER_RUN_TIMEOUT. Longer timeouts are
+// capped at DOCKER_RUN_TIMEOUT, or rejected if strict is set.
+func (h *ServerHandler) executionTimeout(timeoutSeconds int, strict bool) (time.Duration, error) {
+	if timeoutSeconds < 0 {
+		return 0, fmt.Errorf("timeoutSeconds must not be negative, got %d", timeoutSeconds)
+	}
+	if timeoutSeconds == 0 {
+		return h.config.Docker.RunTimeout, nil
+	}
+
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if timeout > h.config.Docker.RunTimeout {
+		if strict {
+			return 0, fmt.Errorf("timeoutSeconds %d exceeds the maximum of %s", timeoutSeconds, h.config.Docker.RunTimeout)
+		}
+		return h.config.Docker.RunTimeout, nil
+	}
+	return timeout, nil
+}
+
+// effectiveTimeout returns the run timeout an execution given timeout gets,
+// where zero means DOCKER_RUN_TIMEOUT
+func (h *ServerHandler) effectiveTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 && timeout < h.config.Docker.RunTimeout {
+		return timeout
+	}
+	return h.config.Docker.RunTimeout
+}
+
+// resolveTimeout returns the run timeout for an execution request. On
+// failure it writes the error response and returns false.
+func (h *ServerHandler) resolveTimeout(w http.ResponseWriter, r *http.Request, timeoutSeconds int, strict bool) (time.Duration, bool) {
+	timeout, err := h.executionTimeout(timeoutSeconds, strict)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestctx.ID(r.Context())).
+			Err(err).
+			Msg("Invalid execution timeout")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid timeout", err.Error())
+		return 0, false
+	}
+	return timeout, true
+}
+
+// parseTimeoutFields reads the "timeoutSeconds" and "strict" query or form
+// fields and returns the run timeout they request. On failure it writes the
+// error response and returns false.
+func (h *ServerHandler) parseTimeoutFields(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	var timeoutSeconds int
+	if value := r.FormValue("timeoutSeconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid timeout", fmt.Sprintf("timeoutSeconds must be a whole number of seconds, got %q", value))
+			return 0, false
+		}
+		timeoutSeconds = seconds
+	}
+	return h.resolveTimeout(w, r, timeoutSeconds, r.FormValue("strict") == "true")
+}
+
+// runOptions returns the container settings for executing a function
+func runOptions(metadata models.FunctionMetadata) docker.RunOptions {
+	return docker.RunOptions{
+		InputMode:   metadata.InputMode,
+		Language:    metadata.Language,
+		MemoryLimit: metadata.MemoryLimit,
+		CPULimit:    metadata.CPULimit,
+		Env:         metadata.Env,
+		NetworkMode: metadata.NetworkMode,
+		AllowedEnv:  metadata.AllowedEnv,
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import "sync"
+
+// runningCounter counts each function's executions in flight
+type runningCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// newRunningCounter creates an empty runningCounter
+func newRunningCounter() *runningCounter {
+	return &runningCounter{counts: make(map[string]int)}
+}
+
+// start counts an execution of the function as running and returns a function
+// that ends it, which callers should defer so panics are counted too. Calling
+// the returned function more than once is a no-op.
+func (rc *runningCounter) start(functionID string) func() {
+	rc.mutex.Lock()
+	rc.counts[functionID]++
+	rc.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rc.mutex.Lock()
+			defer rc.mutex.Unlock()
+
+			rc.counts[functionID]--
+			if rc.counts[functionID] <= 0 {
+				delete(rc.counts, functionID)
+			}
+		})
+	}
+}
+
+// count returns the number of the function's executions in flight
+func (rc *runningCounter) count(functionID string) int {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	return rc.counts[functionID]
+}
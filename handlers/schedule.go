@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/docker"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/scheduler"
+	"youtube_serverless/schema"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// errNoSchedule is returned when removing a schedule from a function that has none
+var errNoSchedule = errors.New("function has no schedule")
+
+// maxRetryBackoffSeconds bounds the retry backoff a schedule may set
+const maxRetryBackoffSeconds = 3600
+
+// ScheduleHandler handles POST and DELETE requests for a function's cron schedule
+func (h *ServerHandler) ScheduleHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	switch r.Method {
+	case http.MethodPost:
+		var schedule models.Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Err(err).
+				Msg("Failed to parse request body")
+			utils.RespondWithBodyError(w, "Invalid request body", err)
+			return
+		}
+
+		if err := scheduler.Validate(schedule.Cron); err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("function_id", functionID).
+				Str("cron", schedule.Cron).
+				Msg("Invalid cron expression")
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid cron expression", err.Error())
+			return
+		}
+
+		if err := h.checkScheduleRetries(schedule); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid schedule", err.Error())
+			return
+		}
+
+		metadata, err := h.functionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+			metadata.Schedule = &schedule
+			return nil
+		})
+		if err != nil {
+			h.respondWithScheduleError(w, requestID, functionID, err)
+			return
+		}
+
+		if err := h.scheduler.Set(functionID, schedule); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("function_id", functionID).
+				Err(err).
+				Msg("Failed to register schedule")
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to register schedule", err.Error())
+			return
+		}
+
+		utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
+
+	case http.MethodDelete:
+		_, err := h.functionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+			if metadata.Schedule == nil {
+				return errNoSchedule
+			}
+			metadata.Schedule = nil
+			return nil
+		})
+		if err != nil {
+			h.respondWithScheduleError(w, requestID, functionID, err)
+			return
+		}
+
+		h.scheduler.Remove(functionID)
+
+		utils.RespondWithJSON(w, http.StatusOK, map[string]string{
+			"message": fmt.Sprintf("Schedule for function %s removed successfully", functionID),
+		})
+
+	default:
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST and DELETE requests are accepted")
+	}
+}
+
+// checkScheduleRetries checks a schedule's retry settings against the
+// configured maximum
+func (h *ServerHandler) checkScheduleRetries(schedule models.Schedule) error {
+	maxRetries := h.config.Scheduler.MaxRetries
+	if schedule.Retries < 0 || schedule.Retries > maxRetries {
+		return fmt.Errorf("retries must be between 0 and %d, got %d", maxRetries, schedule.Retries)
+	}
+	if schedule.RetryBackoffSeconds < 0 || schedule.RetryBackoffSeconds > maxRetryBackoffSeconds {
+		return fmt.Errorf("retryBackoffSeconds must be between 0 and %d, got %d", maxRetryBackoffSeconds, schedule.RetryBackoffSeconds)
+	}
+	return nil
+}
+
+// ScheduleFailuresHandler handles GET requests for a function's recent
+// scheduled runs that failed every attempt or were skipped
+func (h *ServerHandler) ScheduleFailuresHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	if _, err := h.functionStore.GetFunction(ctx, functionID); err != nil {
+		h.respondWithScheduleError(w, requestID, functionID, err)
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, models.ScheduleFailuresResponse{
+		FunctionID: functionID,
+		Failures:   h.scheduler.Failures(functionID),
+	})
+}
+
+// respondWithScheduleError maps a schedule update error to an HTTP error response
+func (h *ServerHandler) respondWithScheduleError(w http.ResponseWriter, requestID, functionID string, err error) {
+	log.Error().
+		Str("request_id", requestID).
+		Str("function_id", functionID).
+		Err(err).
+		Msg("Failed to update schedule")
+
+	switch {
+	case errors.Is(err, store.ErrFunctionNotFound):
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+	case errors.Is(err, errNoSchedule):
+		utils.RespondWithError(w, http.StatusNotFound, "Schedule not found", err.Error())
+	default:
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update schedule", err.Error())
+	}
+}
+
+// runScheduled executes a function from a scheduler tick, using the same path
+// as ExecuteHandler
+func (h *ServerHandler) runScheduled(ctx context.Context, functionID string, input map[string]interface{}) error {
+	// Give each run its own request ID so its logs can be correlated
+	ctx = requestctx.WithID(ctx, uuid.New().String())
+
+	_, err := h.executeFunction(ctx, functionID, input, "", nil, 0)
+
+	// Retrying can't bring back a deleted function or fix invalid input
+	var schemaErr *schema.ValidationError
+	var envErr *docker.EnvError
+	var limitErr *docker.InputLimitError
+	if errors.Is(err, store.ErrFunctionNotFound) || errors.As(err, &schemaErr) || errors.As(err, &envErr) || errors.As(err, &limitErr) {
+		return scheduler.Permanent(err)
+	}
+	return err
+}
+
+// restoreSchedules registers the schedules of all stored functions
+func (h *ServerHandler) restoreSchedules(ctx context.Context) {
+	restored := 0
+	for _, metadata := range h.functionStore.ListFunctions(ctx) {
+		if metadata.Schedule == nil {
+			continue
+		}
+		if err := h.scheduler.Set(metadata.FunctionID, *metadata.Schedule); err != nil {
+			log.Error().
+				Str("function_id", metadata.FunctionID).
+				Err(err).
+				Msg("Failed to restore schedule")
+			continue
+		}
+		restored++
+	}
+
+	log.Info().
+		Int("count", restored).
+		Msg("Restored function schedules")
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+func TestScheduleRetriesAreCapped(t *testing.T) {
+	const maxRetries = 2
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Scheduler.MaxRetries = maxRetries
+	})
+	deployed := s.deploy(t, pythonFunction, nil)
+	path := "/api/functions/" + deployed.FunctionID + "/schedule"
+
+	tests := []struct {
+		name     string
+		schedule models.Schedule
+		status   int
+	}{
+		{name: "no retries", schedule: models.Schedule{Cron: "0 * * * *"}, status: http.StatusOK},
+		{name: "retries at the maximum", schedule: models.Schedule{Cron: "0 * * * *", Retries: maxRetries}, status: http.StatusOK},
+		{name: "retries over the maximum", schedule: models.Schedule{Cron: "0 * * * *", Retries: maxRetries + 1}, status: http.StatusBadRequest},
+		{name: "negative retries", schedule: models.Schedule{Cron: "0 * * * *", Retries: -1}, status: http.StatusBadRequest},
+		{name: "backoff at the maximum", schedule: models.Schedule{Cron: "0 * * * *", Retries: 1, RetryBackoffSeconds: maxRetryBackoffSeconds}, status: http.StatusOK},
+		{name: "backoff over the maximum", schedule: models.Schedule{Cron: "0 * * * *", Retries: 1, RetryBackoffSeconds: maxRetryBackoffSeconds + 1}, status: http.StatusBadRequest},
+		{name: "invalid cron expression", schedule: models.Schedule{Cron: "every hour"}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := s.getFunction(t, deployed.FunctionID).Schedule
+
+			w := s.doJSON(t, http.MethodPost, path, tt.schedule)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			schedule := s.getFunction(t, deployed.FunctionID).Schedule
+			if tt.status != http.StatusOK {
+				if !reflect.DeepEqual(schedule, before) {
+					t.Errorf("rejected schedule changed the stored one from %+v to %+v", before, schedule)
+				}
+				return
+			}
+			if schedule == nil || schedule.Retries != tt.schedule.Retries || schedule.RetryBackoffSeconds != tt.schedule.RetryBackoffSeconds {
+				t.Errorf("stored schedule = %+v, want %+v", schedule, tt.schedule)
+			}
+		})
+	}
+}
+
+func TestScheduleFailuresHandler(t *testing.T) {
+	s := newTestServer(t, nil)
+	deployed := s.deploy(t, pythonFunction, nil)
+
+	w := s.doJSON(t, http.MethodGet, "/api/functions/"+deployed.FunctionID+"/schedule/failures", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var response models.ScheduleFailuresResponse
+	decode(t, w, &response)
+	if response.FunctionID != deployed.FunctionID || len(response.Failures) != 0 {
+		t.Errorf("response = %+v, want no failures", response)
+	}
+
+	if w := s.doJSON(t, http.MethodGet, "/api/functions/missing/schedule/failures", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown function: status %d, want 404", w.Code)
+	}
+	if w := s.doJSON(t, http.MethodPost, "/api/functions/"+deployed.FunctionID+"/schedule/failures", nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// retainSource keeps a zip of a function's extracted code under the source
+// directory and returns its path. Retention is best effort: it returns an
+// empty path when disabled or on failure, without failing the deployment.
+func (h *ServerHandler) retainSource(ctx context.Context, functionID, dir, contentHash string) string {
+	requestID := requestctx.ID(ctx)
+	if !h.config.FileOps.RetainSource {
+		return ""
+	}
+
+	// Archives are named by content, so redeploying the same code reuses one
+	path := filepath.Join(h.config.FileOps.SourceDir, functionID, contentHash[:16]+".zip")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = h.fileHandler.ArchiveDirectory(ctx, dir, path)
+	}
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to retain function source")
+		return ""
+	}
+	return path
+}
+
+// removeSource deletes every retained source archive of a function
+func (h *ServerHandler) removeSource(functionID string) {
+	if err := os.RemoveAll(filepath.Join(h.config.FileOps.SourceDir, functionID)); err != nil {
+		log.Warn().
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to remove function source")
+	}
+}
+
+// removeSourceFile deletes one retained source archive, such as the previous
+// version's after a redeploy
+func (h *ServerHandler) removeSourceFile(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().
+			Str("path", path).
+			Err(err).
+			Msg("Failed to remove function source")
+	}
+}
+
+// SourceHandler handles GET requests downloading a function's deployed code
+// as a zip archive
+func (h *ServerHandler) SourceHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	metadata, err := h.functionStore.GetFunction(ctx, functionID)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Function not found")
+		utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+		return
+	}
+	if metadata.SourcePath == "" {
+		utils.RespondWithError(w, http.StatusNotFound, "Source not available", "The function's source was not retained")
+		return
+	}
+
+	file, err := os.Open(metadata.SourcePath)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Str("path", metadata.SourcePath).
+			Err(err).
+			Msg("Failed to open function source")
+		utils.RespondWithError(w, http.StatusNotFound, "Source not available", err.Error())
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to read function source", err.Error())
+		return
+	}
+
+	name := functionID + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
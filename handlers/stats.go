@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// StatsHandler returns a summary of the deployed functions: how many there
+// are in total and per language, how often they have run, and the most
+// recent execution and build
+func (h *ServerHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, h.functionStore.Stats(ctx))
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/docker"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/schema"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// StreamHandler executes a function and streams its stdout and stderr to the
+// client as Server-Sent Events while it runs, ending with an exit or error event
+func (h *ServerHandler) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET and POST requests are accepted")
+		return
+	}
+
+	execRequest, ok := h.parseExecutionRequest(w, r)
+	if !ok {
+		return
+	}
+	defer execRequest.cleanup()
+	functionID, input := execRequest.FunctionID, execRequest.Input
+
+	// Errors before the stream starts are reported as regular JSON responses
+	metadata, err := h.functionStore.GetFunction(ctx, functionID)
+	if err == nil {
+		input = withDefaults(metadata, input)
+		err = schema.Validate(metadata.InputSchema, input)
+	}
+	if err == nil {
+		err = h.dockerManager.CheckInputEnv(input, runOptions(metadata))
+	}
+	if err != nil {
+		h.respondWithExecutionError(w, requestID, functionID, err)
+		return
+	}
+
+	// The run timeout bounds the stream, not the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Debug().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to clear write deadline")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	executionID := uuid.New().String()
+	w.Header().Set("X-Execution-Id", executionID)
+	w.WriteHeader(http.StatusOK)
+
+	events := &eventWriter{w: w}
+	events.flush()
+
+	// Keep the start of stdout for the execution history, and up to one byte
+	// over the limit of each stream for the logs, so cutting them is noticed
+	output := headBuffer{limit: store.MaxRecordedOutput + 1}
+	stdoutLog := headBuffer{limit: h.config.Store.ExecutionLogSize + 1}
+	stderrLog := headBuffer{limit: h.config.Store.ExecutionLogSize + 1}
+	stdout := io.MultiWriter(events.stream(models.StreamEventStdout), &output, &stdoutLog)
+	stderr := io.MultiWriter(events.stream(models.StreamEventStderr), &stderrLog)
+
+	opts := runOptions(metadata)
+	opts.InputFile = execRequest.InputFile
+	opts.Timeout = execRequest.Timeout
+
+	defer h.running.start(functionID)()
+
+	start := time.Now()
+	exitCode, err := h.dockerManager.StreamDockerContainer(ctx, metadata.ImageID, input, opts, stdout, stderr)
+
+	if errors.Is(err, docker.ErrContainerLimitReached) || errors.Is(err, docker.ErrImageQueueTimeout) ||
+		errors.Is(err, docker.ErrHostOverloaded) || errors.Is(err, docker.ErrDaemonUnavailable) || errors.Is(err, context.Canceled) {
+		events.send(models.StreamEventError, models.StreamEvent{Error: err.Error()})
+		return
+	}
+	duration := time.Since(start)
+	h.metrics.RecordExecution(functionID, metadata.Language, duration, err != nil || exitCode != 0)
+
+	record := models.ExecutionRecord{
+		ExecutionID: executionID,
+		FunctionID:  functionID,
+		ExecutedAt:  start.Unix(),
+		DurationMs:  duration.Milliseconds(),
+		ExitCode:    exitCode,
+		Success:     err == nil && exitCode == 0,
+		Output:      output.String(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	h.recordExecution(ctx, record)
+	h.executionLogs.add(models.ExecutionLog{
+		ExecutionID: executionID,
+		FunctionID:  functionID,
+		ExecutedAt:  record.ExecutedAt,
+		ExitCode:    exitCode,
+		Success:     record.Success,
+		Stdout:      stdoutLog.String(),
+		Stderr:      stderrLog.String(),
+		Error:       record.Error,
+	})
+
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Streamed execution failed")
+		events.send(models.StreamEventError, models.StreamEvent{Error: err.Error()})
+		return
+	}
+
+	events.send(models.StreamEventExit, models.StreamEvent{ExitCode: &exitCode})
+}
+
+// eventWriter writes Server-Sent Events, flushing after each one
+type eventWriter struct {
+	w     http.ResponseWriter
+	mutex sync.Mutex
+}
+
+// send writes one event with a JSON-encoded payload
+func (ew *eventWriter) send(event string, payload models.StreamEvent) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ew.mutex.Lock()
+	defer ew.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(ew.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	ew.flush()
+	return nil
+}
+
+// flush pushes buffered events to the client
+func (ew *eventWriter) flush() {
+	if flusher, ok := ew.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// stream returns an io.Writer that sends everything written to it as events
+// of the given type
+func (ew *eventWriter) stream(event string) *eventStream {
+	return &eventStream{events: ew, event: event}
+}
+
+// eventStream adapts an eventWriter to io.Writer for one event type
+type eventStream struct {
+	events *eventWriter
+	event  string
+}
+
+// Write sends p as a single event
+func (es *eventStream) Write(p []byte) (int, error) {
+	if err := es.events.send(es.event, models.StreamEvent{Data: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+	"youtube_serverless/utils"
+)
+
+// maxTags is the number of tags a function may have
+const maxTags = 20
+
+var (
+	// tagKeyPattern matches valid tag keys
+	tagKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+	// tagValuePattern matches valid tag values, which may be empty
+	tagValuePattern = regexp.MustCompile(`^[A-Za-z0-9._/-]{0,63}$`)
+)
+
+// functionTags reads a function's tags from the manifest and the "tags" form
+// field, a JSON object whose entries override the manifest's. It returns nil
+// when neither sets any.
+func functionTags(r *http.Request, manifest *models.Manifest) (map[string]string, error) {
+	var tags map[string]string
+	for key, value := range manifest.Tags {
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = value
+	}
+
+	if value := r.FormValue("tags"); value != "" {
+		var override map[string]string
+		if err := json.Unmarshal([]byte(value), &override); err != nil {
+			return nil, fmt.Errorf("tags must be a JSON object of strings: %v", err)
+		}
+		for key, value := range override {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[key] = value
+		}
+	}
+
+	if err := checkTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// checkTags returns an error if there are too many tags or any key or value
+// is malformed
+func checkTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("a function may have at most %d tags, got %d", maxTags, len(tags))
+	}
+	for key, value := range tags {
+		if !tagKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid tag key %q: must be 1-63 letters, digits, '.', '_', '/' or '-', starting with a letter or digit", key)
+		}
+		if !tagValuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value %q for tag %s: must be at most 63 letters, digits, '.', '_', '/' or '-'", value, key)
+		}
+	}
+	return nil
+}
+
+// checkTagFilter returns an error if a list filter is not a valid tag key or
+// key:value pair
+func checkTagFilter(filter string) error {
+	key, value, _ := strings.Cut(filter, ":")
+	if !tagKeyPattern.MatchString(key) || !tagValuePattern.MatchString(value) {
+		return fmt.Errorf("invalid tag filter %q: must be a tag key or key:value", filter)
+	}
+	return nil
+}
+
+// TagsHandler handles PUT requests replacing a function's tags without
+// redeploying it
+func (h *ServerHandler) TagsHandler(w http.ResponseWriter, r *http.Request, functionID string) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPut {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only PUT requests are accepted")
+		return
+	}
+
+	var request models.TagsUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to parse request body")
+		utils.RespondWithBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if err := checkTags(request.Tags); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Invalid tags")
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid tags", err.Error())
+		return
+	}
+
+	metadata, err := h.functionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+		metadata.Tags = request.Tags
+		return nil
+	})
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to update tags")
+		if errors.Is(err, store.ErrFunctionNotFound) {
+			utils.RespondWithError(w, http.StatusNotFound, "Function not found", err.Error())
+			return
+		}
+		utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update tags", err.Error())
+		return
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("function_id", functionID).
+		Interface("tags", request.Tags).
+		Msg("Updated tags")
+
+	utils.RespondWithJSON(w, http.StatusOK, h.present(metadata))
+}
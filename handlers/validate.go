@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/docker"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
+
+// ValidateHandler performs a dry run of a submission: it extracts the uploaded
+// code or fetches it from a Git repository, detects its handler and builds
+// the image, then discards the image without registering a function
+func (h *ServerHandler) ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	requestID := requestctx.ID(ctx)
+
+	if r.Method != http.MethodPost {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only POST requests are accepted")
+		return
+	}
+
+	source, ok := h.parseGitSubmission(w, r)
+	if !ok {
+		return
+	}
+	upload, ok := h.prepareUpload(w, r, source)
+	if !ok {
+		return
+	}
+	defer upload.cleanup()
+
+	response := models.ValidationResponse{
+		Language:    upload.Language,
+		HandlerFile: upload.HandlerFile,
+	}
+
+	// Hold the build lock so a concurrent submission of the same code can't
+	// end up sharing the image this validation removes
+	release := h.buildLocks.lock(upload.ContentHash)
+	defer release()
+
+	// Code that is already deployed is known to build
+	if existing, err := h.functionStore.GetByContentHash(ctx, upload.ContentHash, upload.Language); err == nil && existing.RuntimeVersion == upload.RuntimeVersion {
+		response.Valid = true
+		response.Message = fmt.Sprintf("Identical code is already deployed as function %s", existing.FunctionID)
+		utils.RespondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	image, err := h.dockerManager.BuildDockerImage(ctx, "", upload.Dir, upload.Language, upload.HandlerFile, upload.RuntimeVersion, upload.Manifest.BuildArgs)
+	h.metrics.RecordBuild(upload.Language, err != nil)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Validation build failed")
+
+		// An unreachable daemon says nothing about the code
+		if errors.Is(err, docker.ErrDaemonUnavailable) {
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Docker is unavailable", err.Error())
+			return
+		}
+
+		response.Message = "Build failed"
+		if errors.Is(err, docker.ErrRegistryAuth) {
+			response.Message = "Failed to authenticate with the image registry"
+		}
+		response.Error = err.Error()
+		var buildErr *docker.BuildError
+		if errors.As(err, &buildErr) {
+			response.BuildLog = buildErr.Log
+		}
+		utils.RespondWithJSON(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+
+	// Discard the image, since nothing will run it. A shared image is left
+	// to the other build it came from.
+	if !image.Shared && !h.imageInUse(ctx, image.ImageID) {
+		if err := h.dockerManager.RemoveImage(ctx, image.ImageID); err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("image_id", image.ImageID).
+				Err(err).
+				Msg("Failed to remove validation image")
+		}
+	}
+
+	response.Valid = true
+	response.Message = "Build succeeded"
+	if r.URL.Query().Get("verbose") == "true" {
+		response.BuildLog = image.Log
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
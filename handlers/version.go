@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+	"youtube_serverless/version"
+)
+
+// VersionHandler reports the build running on this instance and how long it
+// has been up
+func (h *ServerHandler) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := requestctx.ID(r.Context())
+
+	if r.Method != http.MethodGet {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Msg("Invalid request method")
+		utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", "Only GET requests are accepted")
+		return
+	}
+
+	uptime := version.Uptime()
+	utils.RespondWithJSON(w, http.StatusOK, models.VersionResponse{
+		Version:       version.Version,
+		Commit:        version.Commit,
+		BuildDate:     version.Date,
+		GoVersion:     runtime.Version(),
+		Uptime:        uptime.Truncate(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+	})
+}
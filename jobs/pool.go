@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+)
+
+// ErrQueueFull is returned when the job queue has no room for another job
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrPoolClosed is returned when submitting to a pool that is shutting down
+var ErrPoolClosed = errors.New("job pool is shutting down")
+
+// RunFunc executes a job and returns its result
+type RunFunc func(ctx context.Context) (*models.ExecutionResponse, error)
+
+// DoneFunc is called with a job's final state once it has completed
+type DoneFunc func(ctx context.Context, job models.Job)
+
+// task is a queued job waiting for a worker
+type task struct {
+	jobID string
+	ctx   context.Context
+	run   RunFunc
+	done  DoneFunc
+}
+
+// Pool runs queued jobs on a fixed number of worker goroutines
+type Pool struct {
+	store  *Store
+	tasks  chan task
+	wg     sync.WaitGroup
+	mutex  sync.RWMutex
+	closed bool
+
+	// ctx is the parent of every job context; cancelling it aborts running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewPool creates a Pool and starts its workers
+func NewPool(store *Store, workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		store:  store,
+		tasks:  make(chan task, queueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+
+	return p
+}
+
+// Submit queues a job for execution. Values from ctx (such as the request ID)
+// are carried over, but its cancellation is not, so jobs outlive the request.
+// If done is not nil it is called in the background once the job has
+// completed; Shutdown waits for it as for running jobs.
+func (p *Pool) Submit(ctx context.Context, jobID string, run RunFunc, done DoneFunc) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.tasks <- task{jobID: jobID, ctx: ctx, run: run, done: done}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting jobs and waits for queued and running jobs to
+// finish. If ctx expires first, running jobs are cancelled and any jobs still
+// queued are marked as failed.
+func (p *Pool) Shutdown(ctx context.Context) {
+	p.mutex.Lock()
+	p.closed = true
+	close(p.tasks)
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Msg("All jobs drained")
+	case <-ctx.Done():
+		log.Warn().Msg("Job drain timed out, cancelling remaining jobs")
+		p.cancel()
+		<-done
+	}
+	p.cancel()
+}
+
+// worker executes queued jobs until the queue is closed
+func (p *Pool) worker() {
+	defer p.wg.Done()
+
+	for t := range p.tasks {
+		if err := p.ctx.Err(); err != nil {
+			p.store.Complete(t.jobID, nil, ErrPoolClosed)
+			continue
+		}
+
+		p.store.MarkRunning(t.jobID)
+
+		// Keep the request's values but tie cancellation to the pool
+		jobCtx, cancel := context.WithCancel(context.WithoutCancel(t.ctx))
+		stop := context.AfterFunc(p.ctx, cancel)
+		result, err := t.run(jobCtx)
+		stop()
+		cancel()
+		p.store.Complete(t.jobID, result, err)
+		p.notify(t)
+
+		if err != nil {
+			log.Warn().
+				Str("job_id", t.jobID).
+				Err(err).
+				Msg("Job failed")
+		} else {
+			log.Info().
+				Str("job_id", t.jobID).
+				Msg("Job succeeded")
+		}
+	}
+}
+
+// notify calls the task's done function, if any, with the job's final state
+// without holding up the worker
+func (p *Pool) notify(t task) {
+	if t.done == nil {
+		return
+	}
+	job, err := p.store.GetJob(t.ctx, t.jobID)
+	if err != nil {
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ctx, cancel := context.WithCancel(context.WithoutCancel(t.ctx))
+		defer cancel()
+		stop := context.AfterFunc(p.ctx, cancel)
+		defer stop()
+		t.done(ctx, job)
+	}()
+}
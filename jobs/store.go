@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// ErrJobNotFound is returned when a job ID doesn't exist in the store
+var ErrJobNotFound = errors.New("job not found")
+
+// Store keeps asynchronous execution jobs in memory
+type Store struct {
+	jobs  map[string]models.Job
+	mutex sync.RWMutex
+}
+
+// NewStore creates a new job Store
+func NewStore() *Store {
+	return &Store{
+		jobs: make(map[string]models.Job),
+	}
+}
+
+// CreateJob registers a new pending job for a function
+func (s *Store) CreateJob(ctx context.Context, functionID string) models.Job {
+	requestID := requestctx.ID(ctx)
+
+	job := models.Job{
+		JobID:      uuid.New().String(),
+		FunctionID: functionID,
+		Status:     models.JobPending,
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs[job.JobID] = job
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("job_id", job.JobID).
+		Str("function_id", functionID).
+		Msg("Job created")
+
+	return job
+}
+
+// GetJob retrieves a job by ID
+func (s *Store) GetJob(ctx context.Context, jobID string) (models.Job, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return models.Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	return job, nil
+}
+
+// MarkRunning records that a job has started executing
+func (s *Store) MarkRunning(jobID string) {
+	s.update(jobID, func(job *models.Job) {
+		job.Status = models.JobRunning
+		job.StartedAt = time.Now().Unix()
+	})
+}
+
+// Complete records the outcome of a job. A non-nil err marks the job as failed;
+// result may still be set for failed executions that produced output.
+func (s *Store) Complete(jobID string, result *models.ExecutionResponse, err error) {
+	s.update(jobID, func(job *models.Job) {
+		job.Status = models.JobSucceeded
+		job.Result = result
+		job.CompletedAt = time.Now().Unix()
+		if err != nil {
+			job.Status = models.JobFailed
+			job.Error = err.Error()
+		}
+	})
+}
+
+// update applies a change to a job under the write lock
+func (s *Store) update(jobID string, apply func(*models.Job)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok {
+		log.Warn().
+			Str("job_id", jobID).
+			Msg("Job not found for update")
+		return
+	}
+	apply(&job)
+	s.jobs[jobID] = job
+
+	log.Debug().
+		Str("job_id", jobID).
+		Str("status", job.Status).
+		Msg("Job updated")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,19 +13,51 @@ import (
 	
 	"youtube_serverless/config"
 	"youtube_serverless/handlers"
+	"youtube_serverless/tracing"
+	"youtube_serverless/utils"
+	"youtube_serverless/version"
 )
 
 func main() {
 	// Initialize configuration
-	cfg := config.LoadConfig()
+	cfg := config.LoadConfigStrict()
 	
-	// Configure logging
-	configureLogging(cfg.LogLevel)
+	// Configure logging before anything is logged, so every line uses the
+	// configured format
+	configureLogging(cfg.LogLevel, cfg.LogFormat, cfg.LogOutput)
 	
-	log.Info().Msg("Starting YouTube Serverless Platform")
+	// Fail fast on misconfiguration, reporting every problem at once
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+	
+	log.Info().
+		Str("version", version.Version).
+		Str("commit", version.Commit).
+		Str("build_date", version.Date).
+		Msg("Starting YouTube Serverless Platform")
+	
+	// Export traces when an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
 	
 	// Create server handler
-	serverHandler := handlers.NewServerHandler(cfg)
+	serverHandler, err := handlers.NewServerHandler(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize server")
+	}
+	
+	// Remove temp directories leaked by earlier crashes, now and periodically
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go sweepTempDirs(sweepCtx, utils.NewFileHandler(&cfg.FileOps), cfg.FileOps.TempSweepInterval, cfg.FileOps.TempMaxAge)
+	
+	// Remove images no function uses, if enabled
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	go cleanupImages(cleanupCtx, serverHandler, cfg.Docker.ImageCleanupInterval)
 	
 	// Create server mux
 	mux := http.NewServeMux()
@@ -32,21 +65,23 @@ func main() {
 	// Register routes
 	serverHandler.RegisterRoutes(mux)
 	
-	// Create server with timeouts
-	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      mux,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
+	// Create server with timeouts, limits and HTTP/2 settings
+	server, err := newServer(&cfg.Server, mux)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create server")
 	}
 	
 	// Start server in a goroutine
 	go func() {
-		log.Info().Msgf("Server listening on port %s", cfg.Server.Port)
+		log.Info().
+			Bool("tls", cfg.Server.TLSCertFile != "").
+			Bool("h2c", cfg.Server.H2C).
+			Msgf("Server listening on port %s", cfg.Server.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("Server failed to start")
 		}
 	}()
+	serverHandler.SetReady(true)
 	
 	// Wait for interrupt signal to gracefully shut down the server
 	quit := make(chan os.Signal, 1)
@@ -55,6 +90,14 @@ func main() {
 	
 	log.Info().Msg("Shutting down server...")
 	
+	// Fail readiness probes first, and keep serving while orchestrators notice
+	// and stop sending traffic
+	serverHandler.SetReady(false)
+	if cfg.Server.ShutdownDelay > 0 {
+		log.Info().Dur("delay", cfg.Server.ShutdownDelay).Msg("Waiting for traffic to drain")
+		time.Sleep(cfg.Server.ShutdownDelay)
+	}
+	
 	// Create a deadline for server shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
@@ -64,14 +107,80 @@ func main() {
 		log.Error().Err(err).Msg("Server forced to shutdown")
 	}
 	
+	// Drain async jobs and running containers, then release handler resources
+	// such as the function store
+	if err := serverHandler.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to shut down server handler")
+	}
+	
+	// Flush spans still buffered for export
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to shut down tracing")
+	}
+	
 	log.Info().Msg("Server exited properly")
 }
 
-// configureLogging sets up the logger based on the provided log level
-func configureLogging(level string) {
-	// Set up pretty console logging
-	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
-	log.Logger = log.Output(output)
+// sweepTempDirs removes orphaned temp directories every interval until ctx is
+// cancelled. It does nothing when interval is zero.
+func sweepTempDirs(ctx context.Context, fileHandler *utils.FileHandler, interval, maxAge time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		if _, err := fileHandler.SweepOrphans(ctx, maxAge); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Failed to sweep temp directories")
+		}
+		
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanupImages removes images that no function uses every interval until
+// ctx is cancelled. It does nothing when interval is zero.
+func cleanupImages(ctx context.Context, serverHandler *handlers.ServerHandler, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		
+		if _, err := serverHandler.CleanupImages(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Failed to clean up images")
+		}
+	}
+}
+
+// configureLogging sets up the logger with the provided level, format and output
+func configureLogging(level, format, output string) {
+	var out io.Writer = os.Stdout
+	if output == "stderr" {
+		out = os.Stderr
+	}
+	
+	// JSON lines for log aggregation, otherwise pretty console logging.
+	// Unknown formats fall back to console until Validate rejects them.
+	if format == "json" {
+		log.Logger = log.Output(out)
+	} else {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339})
+	}
 	
 	// Set log level
 	switch level {
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics records function execution and build metrics in a dedicated Prometheus registry
+type Metrics struct {
+	registry          *prometheus.Registry
+	executions        *prometheus.CounterVec
+	executionFailures *prometheus.CounterVec
+	executionDuration *prometheus.HistogramVec
+	builds            *prometheus.CounterVec
+}
+
+// NewMetrics creates a new Metrics with all collectors registered
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "serverless_executions_total",
+			Help: "Total number of function executions.",
+		}, []string{"function_id", "language"}),
+		executionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "serverless_execution_failures_total",
+			Help: "Total number of failed function executions.",
+		}, []string{"function_id", "language"}),
+		executionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "serverless_execution_duration_seconds",
+			Help:    "Duration of function executions in seconds.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"function_id", "language"}),
+		builds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "serverless_builds_total",
+			Help: "Total number of function image builds.",
+		}, []string{"language", "result"}),
+	}
+
+	m.registry.MustRegister(
+		m.executions,
+		m.executionFailures,
+		m.executionDuration,
+		m.builds,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// RecordExecution records a function execution and whether it failed
+func (m *Metrics) RecordExecution(functionID, language string, duration time.Duration, failed bool) {
+	m.executions.WithLabelValues(functionID, language).Inc()
+	m.executionDuration.WithLabelValues(functionID, language).Observe(duration.Seconds())
+	if failed {
+		m.executionFailures.WithLabelValues(functionID, language).Inc()
+	}
+}
+
+// RecordBuild records an image build and whether it failed
+func (m *Metrics) RecordBuild(language string, failed bool) {
+	result := "success"
+	if failed {
+		result = "failure"
+	}
+	m.builds.WithLabelValues(language, result).Inc()
+}
+
+// Handler returns an HTTP handler serving the metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+
+	"youtube_serverless/utils"
+)
+
+// MaxBodyMiddleware caps request bodies at limit bytes, or fileLimit bytes
+// more for multipart forms, which carry code archives and input files of up
+// to fileLimit bytes. Requests that declare a longer body are rejected with
+// 413 straight away; bodies that turn out longer fail when read, which
+// handlers also report as 413.
+func MaxBodyMiddleware(limit, fileLimit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maxBytes := limit
+			multipart := false
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+				maxBytes, multipart = limit+fileLimit, true
+			}
+
+			if r.ContentLength > maxBytes {
+				if multipart {
+					utils.RespondWithError(w, http.StatusRequestEntityTooLarge, "Upload too large", utils.UploadLimitDetails(maxBytes, fileLimit))
+					return
+				}
+				utils.RespondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large", fmt.Sprintf("The request body must not exceed %d bytes", maxBytes))
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
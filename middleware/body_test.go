@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"youtube_serverless/models"
+	"youtube_serverless/utils"
+)
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	const limit = 100
+	const fileLimit = 1000
+
+	// Decodes the body like the JSON endpoints do
+	handler := MaxBodyMiddleware(limit, fileLimit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			utils.RespondWithBodyError(w, "Invalid request body", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		contentType string
+		size        int
+		chunked     bool
+		status      int
+		error       string
+	}{
+		{name: "JSON at the limit", contentType: "application/json", size: limit, status: http.StatusOK},
+		{name: "JSON over the limit", contentType: "application/json", size: limit + 1, status: http.StatusRequestEntityTooLarge, error: "Request body too large"},
+		{name: "chunked JSON over the limit", contentType: "application/json", size: limit + 1, chunked: true, status: http.StatusRequestEntityTooLarge, error: "Request body too large"},
+		{name: "multipart within its own limit", contentType: "multipart/form-data; boundary=x", size: limit + fileLimit, status: http.StatusOK},
+		{name: "multipart over its own limit", contentType: "multipart/form-data; boundary=x", size: limit + fileLimit + 1, status: http.StatusRequestEntityTooLarge, error: "Upload too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(strings.Repeat("x", tt.size))
+			if tt.chunked {
+				// Hide the length, so the body is only found too large when read
+				body = io.MultiReader(body)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/api/execute", body)
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.error == "" {
+				return
+			}
+			var response models.ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("error body is not JSON: %v", err)
+			}
+			if response.Error != tt.error {
+				t.Errorf("error = %q, want %q", response.Error, tt.error)
+			}
+		})
+	}
+}
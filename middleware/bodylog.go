@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// redactedHeaders are replaced in logged headers because they carry credentials
+var redactedHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+// BodyLoggingMiddleware works like LoggingMiddleware, and also logs request
+// and response headers and up to limit bytes of each body at debug level.
+// Credential headers are redacted. It is meant for debugging client
+// integrations, since bodies may contain sensitive data.
+func BodyLoggingMiddleware(limit int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return logRequests(next, limit)
+	}
+}
+
+// logRequestBody logs the start of the request body, then restores the body
+// so handlers still read all of it. Only the logged bytes are buffered, so
+// large uploads are not held in memory. Multipart bodies are not logged,
+// since they are mostly binary archives.
+func logRequestBody(requestID string, r *http.Request, limit int) {
+	event := log.Debug().
+		Str("request_id", requestID).
+		Interface("headers", redactHeaders(r.Header))
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" || r.Body == nil || r.Body == http.NoBody {
+		event.Msg("Request body")
+		return
+	}
+
+	head := make([]byte, limit+1)
+	n, err := io.ReadFull(r.Body, head)
+	head = head[:n]
+	r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(head), r.Body), Closer: r.Body}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		event.Err(err).Msg("Request body")
+		return
+	}
+
+	truncated := n > limit
+	if truncated {
+		head = head[:limit]
+	}
+	event.
+		Str("body", string(head)).
+		Bool("truncated", truncated).
+		Msg("Request body")
+}
+
+// replayBody serves the already-read start of a request body followed by the
+// rest, closing the original body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// redactHeaders returns a copy of the headers with credentials masked
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "[REDACTED]")
+		}
+	}
+	return redacted
+}
+
+// headBuffer keeps the first limit bytes written to it
+type headBuffer struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// Write keeps as much of p as fits within the limit and never fails
+func (b *headBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if remaining := b.limit - b.buf.Len(); n > remaining {
+		p = p[:remaining]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// String returns the captured bytes
+func (b *headBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import "net/http"
+
+// Middleware wraps a handler with behaviour that runs around it
+type Middleware func(http.Handler) http.Handler
+
+// Chain is an ordered list of middleware. Requests pass through it first to
+// last, so the first middleware is the outermost: it sees each request first
+// and its response last.
+type Chain []Middleware
+
+// Append returns a chain with more middleware after the chain's own. The
+// chain itself is left unchanged.
+func (c Chain) Append(more ...Middleware) Chain {
+	chain := make(Chain, 0, len(c)+len(more))
+	chain = append(chain, c...)
+	return append(chain, more...)
+}
+
+// Then wraps handler in the chain's middleware
+func (c Chain) Then(handler http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		handler = c[i](handler)
+	}
+	return handler
+}
+
+// ThenFunc wraps a handler function in the chain's middleware
+func (c Chain) ThenFunc(handler http.HandlerFunc) http.Handler {
+	return c.Then(handler)
+}
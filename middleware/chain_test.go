@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// recorder returns middleware that notes when requests enter and leave it
+func recorder(name string, calls *[]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name+" in")
+			next.ServeHTTP(w, r)
+			*calls = append(*calls, name+" out")
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	chain := Chain{recorder("first", &calls), recorder("second", &calls), recorder("third", &calls)}
+	handler := chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	want := []string{"first in", "second in", "third in", "handler", "third out", "second out", "first out"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestChainAppend(t *testing.T) {
+	var calls []string
+	base := make(Chain, 0, 4)
+	base = append(base, recorder("base", &calls))
+
+	// Appending to the same chain twice must not let one extension
+	// overwrite the other, even when the chain has spare capacity
+	withA := base.Append(recorder("a", &calls))
+	withB := base.Append(recorder("b", &calls))
+
+	serve := func(c Chain) []string {
+		calls = nil
+		c.ThenFunc(func(w http.ResponseWriter, r *http.Request) {}).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		return calls
+	}
+	if got, want := serve(withA), []string{"base in", "a in", "a out", "base out"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first extension calls = %q, want %q", got, want)
+	}
+	if got, want := serve(withB), []string{"base in", "b in", "b out", "base out"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second extension calls = %q, want %q", got, want)
+	}
+	if got, want := serve(base), []string{"base in", "base out"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("base chain calls = %q, want %q", got, want)
+	}
+}
+
+func TestEmptyChain(t *testing.T) {
+	called := false
+	Chain{}.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !called {
+		t.Error("empty chain didn't call the handler")
+	}
+}
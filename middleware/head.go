@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// HeadMiddleware serves HEAD requests with the handler's GET logic, sending
+// the headers of the GET response, with its Content-Length, and no body. It
+// must only wrap handlers whose GET requests have no side effects.
+func HeadMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		get := r.Clone(r.Context())
+		get.Method = http.MethodGet
+		hw := &headWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(hw, get)
+
+		if hw.Header().Get("Content-Length") == "" {
+			hw.Header().Set("Content-Length", strconv.FormatInt(hw.size, 10))
+		}
+		w.WriteHeader(hw.status)
+	})
+}
+
+// headWriter discards the body of a response to a HEAD request, counting its
+// size, and holds back the status so the Content-Length can still be set
+type headWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+// Write counts the body without sending it
+func (hw *headWriter) Write(b []byte) (int, error) {
+	hw.wroteHeader = true
+	hw.size += int64(len(b))
+	return len(b), nil
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (hw *headWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// WriteHeader records the first status code written
+func (hw *headWriter) WriteHeader(code int) {
+	if !hw.wroteHeader {
+		hw.status = code
+		hw.wroteHeader = true
+	}
+}
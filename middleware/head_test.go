@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeadMiddleware(t *testing.T) {
+	var methods []string
+	handler := HeadMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/", nil))
+	if w.Code != http.StatusCreated {
+		t.Errorf("status %d, want 201", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body %q, want none", w.Body)
+	}
+	if got := w.Header().Get("Content-Length"); got != "15" {
+		t.Errorf("Content-Length = %q, want 15", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	// Other methods pass through unchanged
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", w.Code)
+	}
+	if want := []string{http.MethodGet, http.MethodPost}; len(methods) != 2 || methods[0] != want[0] || methods[1] != want[1] {
+		t.Errorf("handler saw %q, want %q", methods, want)
+	}
+}
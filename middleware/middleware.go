@@ -2,30 +2,45 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
-)
 
-// RequestIDKey is the context key for the request ID
-type RequestIDKey struct{}
+	"youtube_serverless/requestctx"
+	"youtube_serverless/utils"
+)
 
 // LoggingMiddleware logs request information and adds a request ID to the context
 func LoggingMiddleware(next http.Handler) http.Handler {
+	return logRequests(next, 0)
+}
+
+// logRequests implements LoggingMiddleware, additionally logging up to
+// bodyLimit bytes of each request and response body when bodyLimit is positive
+func logRequests(next http.Handler, bodyLimit int) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		requestID := uuid.New().String()
 
 		// Add request ID to context
-		ctx := context.WithValue(r.Context(), RequestIDKey{}, requestID)
+		ctx := requestctx.WithID(r.Context(), requestID)
 
 		// Add request ID to response headers
-		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set(requestctx.Header, requestID)
+		spanRequestID(r, requestID)
 
 		// Create a response wrapper to capture the status code
-		rw := &responseWriter{w, http.StatusOK}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		if bodyLimit > 0 {
+			rw.body = &headBuffer{limit: bodyLimit}
+		}
 
 		// Log the incoming request
 		log.Info().
@@ -35,6 +50,9 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			Str("remote_addr", r.RemoteAddr).
 			Str("user_agent", r.UserAgent()).
 			Msg("Request received")
+		if bodyLimit > 0 {
+			logRequestBody(requestID, r, bodyLimit)
+		}
 
 		// Call the next handler with the updated context
 		next.ServeHTTP(rw, r.WithContext(ctx))
@@ -45,39 +63,302 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			Int("status", rw.status).
 			Dur("duration", time.Since(start)).
 			Msg("Request completed")
+
+		if rw.body != nil {
+			log.Debug().
+				Str("request_id", requestID).
+				Interface("headers", redactHeaders(rw.Header())).
+				Str("body", rw.body.String()).
+				Bool("truncated", rw.body.truncated).
+				Msg("Response body")
+		}
 	})
 }
 
-// TimeoutMiddleware adds a timeout to the request context
-func TimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+// TimeoutHeader lets a client ask for a request timeout, in whole seconds
+const TimeoutHeader = "X-Timeout-Seconds"
+
+// TimeoutMiddleware adds a timeout to the request context. The handler always
+// runs to completion before the middleware returns, so work tied to the request
+// context (such as a running container) is cleaned up when the deadline passes
+// or the client disconnects. Clients may ask for a different timeout with the
+// TimeoutHeader, which is clamped between minTimeout and timeout.
+func TimeoutMiddleware(timeout, minTimeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			requested, err := requestTimeout(r, timeout, minTimeout)
+			if err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, "Invalid "+TimeoutHeader+" header", err.Error())
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), requested)
 			defer cancel()
 
+			tw := &timeoutWriter{w: w, header: make(http.Header)}
 			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
 			go func() {
-				next.ServeHTTP(w, r.WithContext(ctx))
-				close(done)
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
 			}()
 
 			select {
 			case <-done:
-				return
 			case <-ctx.Done():
-				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-					w.WriteHeader(http.StatusGatewayTimeout)
-					w.Write([]byte("Request timeout"))
+				requestID := requestctx.ID(r.Context())
+				log.Warn().
+					Str("request_id", requestID).
+					Err(ctx.Err()).
+					Msg("Request cancelled, waiting for handler to stop")
+
+				tw.cancel(ctx.Err(), requested)
+				<-done
+			}
+
+			// Re-raise handler panics so RecoverMiddleware can handle them
+			select {
+			case p := <-panicked:
+				panic(p)
+			default:
+			}
+		})
+	}
+}
+
+// requestTimeout returns the timeout a request asks for with the
+// TimeoutHeader, clamped between minTimeout and maxTimeout, or maxTimeout when
+// it doesn't ask. The header must be a positive whole number of seconds.
+func requestTimeout(r *http.Request, maxTimeout, minTimeout time.Duration) (time.Duration, error) {
+	value := r.Header.Get(TimeoutHeader)
+	if value == "" {
+		return maxTimeout, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("%s must be a positive whole number of seconds, got %q", TimeoutHeader, value)
+	}
+
+	// Compare in seconds so huge values can't overflow a Duration
+	switch {
+	case seconds > int(maxTimeout/time.Second):
+		return maxTimeout, nil
+	case time.Duration(seconds)*time.Second < minTimeout:
+		return minTimeout, nil
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// timeoutWriter guards a ResponseWriter shared between a handler and
+// TimeoutMiddleware. Once the request is cancelled, handler writes are dropped.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	mu          sync.Mutex
+	cancelled   bool
+	wroteHeader bool
+}
+
+// Header returns the handler's header map, copied to the response on WriteHeader
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write writes the response body unless the request has been cancelled
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.cancelled {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// WriteHeader writes the status code unless the request has been cancelled
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.cancelled || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// Flush sends buffered data to the client unless the request has been cancelled
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.cancelled {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	if flusher, ok := tw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	for key, values := range tw.header {
+		tw.w.Header()[key] = values
+	}
+	tw.wroteHeader = true
+	tw.w.WriteHeader(code)
+}
+
+// cancel stops further handler writes and, if the deadline passed before the
+// handler responded, replies with 504 Gateway Timeout
+func (tw *timeoutWriter) cancel(err error, timeout time.Duration) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if errors.Is(err, context.DeadlineExceeded) && !tw.wroteHeader {
+		utils.RespondWithError(tw.w, http.StatusGatewayTimeout, "Request timeout", fmt.Sprintf("The request did not complete within %s", timeout))
+	}
+	tw.cancelled = true
+}
+
+// AuthMiddleware requires a valid API key in the Authorization (Bearer) or
+// X-API-Key header. It is a no-op when no keys are configured, and requests to
+// any of the public paths are always allowed through.
+func AuthMiddleware(apiKeys []string, publicPaths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(apiKeys) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range publicPaths {
+				if r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
 				}
 			}
+
+			key := APIKeyFromRequest(r)
+			if key == "" {
+				log.Warn().
+					Str("path", r.URL.Path).
+					Str("remote_addr", r.RemoteAddr).
+					Msg("Missing API key")
+				utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized", "An API key is required")
+				return
+			}
+
+			if !validAPIKey(key, apiKeys) {
+				log.Warn().
+					Str("path", r.URL.Path).
+					Str("remote_addr", r.RemoteAddr).
+					Msg("Invalid API key")
+				utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized", "Invalid API key")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORSMiddleware adds CORS headers for requests from allowed origins and
+// answers preflight requests with 204. The requesting origin is echoed back
+// so credentialed requests work. It is a no-op when no origins are configured;
+// an origin of "*" allows any origin.
+func CORSMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(allowedOrigins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			if origin == "" || !allowedOrigin(origin, allowedOrigins) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Expose-Headers", requestctx.Header)
+
+			// Short-circuit preflight requests before authentication
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key, "+TimeoutHeader)
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// responseWriter is a wrapper for http.ResponseWriter that captures the status code
+// allowedOrigin reports whether origin is in the allowlist
+func allowedOrigin(origin string, allowedOrigins []string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyFromRequest returns the API key from the Authorization bearer token or
+// the X-API-Key header, or an empty string if neither is set
+func APIKeyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// validAPIKey compares the key against every configured key in constant time
+func validAPIKey(key string, apiKeys []string) bool {
+	valid := false
+	for _, apiKey := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// responseWriter is a wrapper for http.ResponseWriter that captures the status
+// code and, when body logging is enabled, the start of the body
 type responseWriter struct {
 	http.ResponseWriter
 	status int
+	body   *headBuffer // nil unless body logging is enabled
+}
+
+// Write captures the start of the body before writing it
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.body != nil {
+		rw.body.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
 }
 
 // WriteHeader captures the status code before writing it
@@ -86,19 +367,41 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush sends buffered data to the client, for streaming responses
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// ErrorFormatMiddleware lets RespondWithError answer in the format the
+// client's Accept header prefers, JSON or plain text, with the request ID.
+// It must come first, so errors from the rest of the chain are covered too.
+func ErrorFormatMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(utils.NewErrorWriter(w, r), r)
+	})
+}
+
 // RecoverMiddleware recovers from panics and logs the error
 func RecoverMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				requestID, _ := r.Context().Value(RequestIDKey{}).(string)
+				// The request ID is assigned further down the chain, so the
+				// request's context doesn't carry it here
+				requestID := w.Header().Get(requestctx.Header)
 				log.Error().
 					Str("request_id", requestID).
 					Interface("error", err).
 					Msg("Panic recovered")
 				
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("Internal server error"))
+				utils.RespondWithError(w, http.StatusInternalServerError, "Internal server error", "The request failed unexpectedly")
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/store"
+)
+
+// captureLogs sends log lines to the returned buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = logger })
+	return &buf
+}
+
+func TestRequestIDReachesStore(t *testing.T) {
+	logs := captureLogs(t)
+	functionStore := store.NewFunctionStore(0, false, 0)
+
+	var handlerID string
+	chain := Chain{RecoverMiddleware, LoggingMiddleware}
+	handler := chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = requestctx.ID(r.Context())
+		metadata := models.FunctionMetadata{FunctionID: "function", Name: "greeter"}
+		if err := functionStore.StoreFunction(r.Context(), metadata); err != nil {
+			t.Errorf("StoreFunction: %v", err)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/submit", nil))
+
+	headerID := w.Header().Get(requestctx.Header)
+	if headerID == "" {
+		t.Fatalf("no %s header", requestctx.Header)
+	}
+	if handlerID != headerID {
+		t.Errorf("handler saw request ID %q, want %q", handlerID, headerID)
+	}
+
+	// The store tags its log line with the same ID
+	var storeID *string
+	scanner := bufio.NewScanner(logs)
+	for scanner.Scan() {
+		var line struct {
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line is not JSON: %v: %s", err, scanner.Bytes())
+		}
+		if line.Message == "Function stored" {
+			storeID = &line.RequestID
+		}
+	}
+	if storeID == nil {
+		t.Fatal("store logged nothing")
+	}
+	if *storeID != headerID {
+		t.Errorf("store logged request ID %q, want %q", *storeID, headerID)
+	}
+}
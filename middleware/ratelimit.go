@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	"youtube_serverless/utils"
+)
+
+// Stale rate limiter entries are swept at most once per interval
+const (
+	rateLimitCleanupInterval = time.Minute
+	rateLimitIdleTimeout     = 3 * time.Minute
+)
+
+// visitor tracks the token bucket of one client
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds a token bucket per client key
+type rateLimiter struct {
+	rps         rate.Limit
+	burst       int
+	visitors    map[string]*visitor
+	mutex       sync.Mutex
+	lastCleanup time.Time
+}
+
+// RateLimitMiddleware limits each client to rps requests per second with the
+// given burst, replying 429 with a Retry-After header when exceeded. Clients
+// are identified by keyFunc. It is a no-op when rps is zero or less, and
+// requests to any of the exempt paths are always allowed through.
+func RateLimitMiddleware(rps float64, burst int, keyFunc func(*http.Request) string, exemptPaths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if rps <= 0 {
+			return next
+		}
+		if burst < 1 {
+			burst = 1
+		}
+
+		rl := &rateLimiter{
+			rps:         rate.Limit(rps),
+			burst:       burst,
+			visitors:    make(map[string]*visitor),
+			lastCleanup: time.Now(),
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range exemptPaths {
+				if r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			reservation := rl.limiter(keyFunc(r)).Reserve()
+			if delay := reservation.Delay(); delay > 0 {
+				// Give the token back, since the request is rejected rather than delayed
+				reservation.Cancel()
+
+				retryAfter := int(math.Ceil(delay.Seconds()))
+				log.Warn().
+					Str("path", r.URL.Path).
+					Str("remote_addr", r.RemoteAddr).
+					Int("retry_after", retryAfter).
+					Msg("Rate limit exceeded")
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				utils.RespondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded", "Too many requests, retry after "+strconv.Itoa(retryAfter)+"s")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limiter returns the token bucket for key, creating it if needed, and sweeps
+// buckets of clients that have gone quiet
+func (rl *rateLimiter) limiter(key string) *rate.Limiter {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastCleanup) > rateLimitCleanupInterval {
+		for k, v := range rl.visitors {
+			if now.Sub(v.lastSeen) > rateLimitIdleTimeout {
+				delete(rl.visitors, k)
+			}
+		}
+		rl.lastCleanup = now
+	}
+
+	v, ok := rl.visitors[key]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.visitors[key] = v
+	}
+	v.lastSeen = now
+	return v.limiter
+}
+
+// ClientIP returns the IP address of the client that sent the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// APIKeyOrClientIP identifies a client by its API key when one is sent, and
+// by its IP address otherwise
+func APIKeyOrClientIP(r *http.Request) string {
+	if key := APIKeyFromRequest(r); key != "" {
+		return "key:" + key
+	}
+	return "ip:" + ClientIP(r)
+}
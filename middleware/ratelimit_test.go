@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"youtube_serverless/models"
+)
+
+func TestRateLimitMiddlewareRejectsPastBurst(t *testing.T) {
+	const burst = 3
+	handler := RateLimitMiddleware(0.001, burst, ClientIP, "/health")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 1; i <= burst; i++ {
+		if w := send("/api/functions", "10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst: status %d, want 200", i, w.Code)
+		}
+	}
+
+	w := send("/api/functions", "10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst: status %d, want 429", w.Code)
+	}
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", w.Header().Get("Retry-After"))
+	}
+	var response models.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("error body is not JSON: %v", err)
+	}
+	if response.Code != http.StatusTooManyRequests || response.Error != "Rate limit exceeded" {
+		t.Errorf("error body = %+v", response)
+	}
+
+	// Rejected requests don't use up tokens, other clients have their own
+	// bucket and exempt paths are never limited
+	if w := send("/api/functions", "10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client: status %d, want 200", w.Code)
+	}
+	if w := send("/health", "10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("exempt path: status %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitMiddlewareDisabled(t *testing.T) {
+	handler := RateLimitMiddleware(0, 1, ClientIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/functions", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, w.Code)
+		}
+	}
+}
+
+func TestAPIKeyOrClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if key := APIKeyOrClientIP(r); key != "ip:10.0.0.1" {
+		t.Errorf("without a key: %q, want %q", key, "ip:10.0.0.1")
+	}
+
+	r.Header.Set("X-API-Key", "secret")
+	if key := APIKeyOrClientIP(r); key != "key:secret" {
+		t.Errorf("with a key: %q, want %q", key, "key:secret")
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	const (
+		minTimeout = 5 * time.Second
+		maxTimeout = 60 * time.Second
+	)
+
+	tests := []struct {
+		name    string
+		header  string
+		want    time.Duration
+		invalid bool
+	}{
+		{name: "no header", want: maxTimeout},
+		{name: "within bounds", header: "30", want: 30 * time.Second},
+		{name: "at the lower bound", header: "5", want: minTimeout},
+		{name: "below the lower bound", header: "4", want: minTimeout},
+		{name: "far below the lower bound", header: "1", want: minTimeout},
+		{name: "at the upper bound", header: "60", want: maxTimeout},
+		{name: "above the upper bound", header: "61", want: maxTimeout},
+		{name: "too large for a duration", header: "9999999999999", want: maxTimeout},
+		{name: "zero", header: "0", invalid: true},
+		{name: "negative", header: "-5", invalid: true},
+		{name: "fractional", header: "1.5", invalid: true},
+		{name: "not a number", header: "soon", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(TimeoutHeader, tt.header)
+			}
+
+			got, err := requestTimeout(r, maxTimeout, minTimeout)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("requestTimeout = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("requestTimeout: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("requestTimeout = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddlewareAppliesRequestedTimeout(t *testing.T) {
+	const (
+		minTimeout = 5 * time.Second
+		maxTimeout = time.Minute
+	)
+	var remaining time.Duration
+	called := false
+	handler := TimeoutMiddleware(maxTimeout, minTimeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		deadline, ok := r.Context().Deadline()
+		if !ok {
+			t.Error("request context has no deadline")
+		}
+		remaining = time.Until(deadline)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "", want: maxTimeout},
+		{header: "10", want: 10 * time.Second},
+		{header: "1", want: minTimeout},
+		{header: "3600", want: maxTimeout},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set(TimeoutHeader, tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %q: status %d", TimeoutHeader, tt.header, w.Code)
+		}
+		if remaining > tt.want || remaining < tt.want-time.Second {
+			t.Errorf("%s %q: %s left of the deadline, want about %s", TimeoutHeader, tt.header, remaining, tt.want)
+		}
+	}
+
+	// An invalid header fails without running the handler
+	called = false
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(TimeoutHeader, "-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid header: status %d, want 400", w.Code)
+	}
+	if called {
+		t.Error("invalid header ran the handler")
+	}
+}
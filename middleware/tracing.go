@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"youtube_serverless/tracing"
+)
+
+// TracingMiddleware starts a root span for each request, continuing the
+// caller's trace when the request carries W3C trace context headers
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rw.status))
+		if rw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.status))
+		}
+	})
+}
+
+// spanRequestID records the request ID on the request's span so traces can
+// be matched with log lines
+func spanRequestID(r *http.Request, requestID string) {
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.RequestIDKey.String(requestID))
+}
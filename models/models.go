@@ -1,38 +1,509 @@
 package models
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// Input delivery modes for function execution
+const (
+	InputModeEnv   = "env"   // input keys are passed as environment variables
+	InputModeStdin = "stdin" // input is piped to the container's stdin as a JSON object
+)
+
+// Network modes a function container may run with
+const (
+	NetworkModeNone   = "none"   // no network access
+	NetworkModeBridge = "bridge" // outbound access through Docker's default bridge
+)
+
+// ValidNetworkMode reports whether mode is a supported network mode
+func ValidNetworkMode(mode string) bool {
+	return mode == NetworkModeNone || mode == NetworkModeBridge
+}
+
+// Files uploaded with an execution are mounted read-only into the container
+// under InputFileDir, with their path in the InputFileEnv environment variable
+const (
+	InputFileDir = "/input"
+	InputFileEnv = "INPUT_FILE"
+)
+
+// OutputFormatJSON is the output format of functions whose stdout must be a
+// JSON document, which executions return parsed rather than as a string
+const OutputFormatJSON = "json"
+
+// DefaultFunctionName is the name of functions deployed without one. Any
+// number of functions may have it, even when names must be unique.
+const DefaultFunctionName = "unnamed-function"
+
 // FunctionMetadata represents metadata about a deployed function
 type FunctionMetadata struct {
-	FunctionID   string `json:"functionId"`
-	ImageID      string `json:"imageId"`
-	Language     string `json:"language"`
-	CreatedAt    int64  `json:"createdAt"`
-	LastExecuted int64  `json:"lastExecuted,omitempty"`
-	Name         string `json:"name"`
+	FunctionID     string            `json:"functionId"`
+	ImageID        string            `json:"imageId"`
+	Language       string            `json:"language"`
+	CreatedAt      int64             `json:"createdAt"`
+	LastExecuted   int64             `json:"lastExecuted,omitempty"`
+	BuiltAt        int64             `json:"builtAt,omitempty"`        // when the current image was built or reused
+	RuntimeVersion string            `json:"runtimeVersion,omitempty"` // base image version the function was built with
+	Name           string            `json:"name"`
+	InputMode      string            `json:"inputMode,omitempty"`
+	Schedule       *Schedule         `json:"schedule,omitempty"`
+	ContentHash    string            `json:"contentHash,omitempty"`
+	MemoryLimit    int64             `json:"memoryLimit,omitempty"` // bytes; the platform default when zero
+	CPULimit       float64           `json:"cpuLimit,omitempty"`    // CPUs; the platform default when zero
+	InputSchema    *InputSchema      `json:"inputSchema,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`         // secrets set in the container environment
+	NetworkMode    string            `json:"networkMode,omitempty"` // the platform default when empty
+	SourcePath     string            `json:"sourcePath,omitempty"`  // retained code archive; empty when not retained
+	ContentType    string            `json:"contentType,omitempty"` // of the output when invoked through /fn/
+
+	OutputFormat string `json:"outputFormat,omitempty"` // OutputFormatJSON when stdout must be JSON
+
+	AllowedEnv []string `json:"allowedEnv,omitempty"` // environment variables input may set; any when empty
+
+	Tags map[string]string `json:"tags,omitempty"` // labels functions can be filtered by
+
+	DefaultInput map[string]string `json:"defaultInput,omitempty"` // input keys used when a call doesn't set them
+
+	InvocationCount int64 `json:"invocationCount"` // executions recorded since the function was created
+	RunningCount    int   `json:"runningCount"`    // executions in flight; filled in for responses, never stored
+}
+
+// RedactedValue replaces function environment values in API responses
+const RedactedValue = "********"
+
+// Redacted returns a copy of the metadata with its environment values masked,
+// for responses that must not expose secrets. Default input is masked too
+// unless the function takes its input on stdin, since it is otherwise set in
+// the container environment like a secret.
+func (m FunctionMetadata) Redacted() FunctionMetadata {
+	m.Env = redact(m.Env)
+	if m.InputMode != InputModeStdin {
+		m.DefaultInput = redact(m.DefaultInput)
+	}
+	return m
+}
+
+// redact returns a copy of values with every value replaced by RedactedValue
+func redact(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return values
+	}
+	redacted := make(map[string]string, len(values))
+	for key := range values {
+		redacted[key] = RedactedValue
+	}
+	return redacted
+}
+
+// Schedule represents a cron trigger for a function
+type Schedule struct {
+	Cron  string                 `json:"cron"`
+	Input map[string]interface{} `json:"input,omitempty"`
+
+	// Retries is how many times a failed run is retried before it is
+	// recorded as a ScheduleFailure
+	Retries int `json:"retries,omitempty"`
+	// RetryBackoffSeconds is the delay before the first retry, doubled for
+	// each one after; zero uses the scheduler's default
+	RetryBackoffSeconds int `json:"retryBackoffSeconds,omitempty"`
+	// AllowOverlap lets a tick start a run while the previous one, retries
+	// included, is still going. By default the tick is skipped.
+	AllowOverlap bool `json:"allowOverlap,omitempty"`
+}
+
+// ScheduleFailure records a scheduled run that failed every attempt, or a
+// tick that was skipped because the previous run was still going
+type ScheduleFailure struct {
+	ScheduledAt int64  `json:"scheduledAt"` // when the tick fired
+	FailedAt    int64  `json:"failedAt"`
+	Attempts    int    `json:"attempts"` // zero for skipped ticks
+	Error       string `json:"error"`    // of the last attempt
+}
+
+// ScheduleFailuresResponse represents a function's recent schedule failures,
+// newest first
+type ScheduleFailuresResponse struct {
+	FunctionID string            `json:"functionId"`
+	Failures   []ScheduleFailure `json:"failures"`
+}
+
+// Manifest represents the optional serverless.json file shipped with a function
+type Manifest struct {
+	Handler   string            `json:"handler"`
+	Language  string            `json:"language"`
+	InputMode string            `json:"input,omitempty"`
+	Memory    string            `json:"memory,omitempty"` // memory limit such as "256m"
+	CPUs      float64           `json:"cpus,omitempty"`
+	Schema    *InputSchema      `json:"schema,omitempty"` // expected execution input
+	Env       map[string]string `json:"env,omitempty"`
+	Network   string            `json:"network,omitempty"`   // "none" or "bridge"
+	BuildArgs map[string]string `json:"buildArgs,omitempty"` // Docker build arguments, also available to templates
+	// RuntimeVersion selects the base image version, such as "3.12" for
+	// Python; the language's default when empty
+	RuntimeVersion string `json:"runtimeVersion,omitempty"`
+
+	ContentType string `json:"contentType,omitempty"` // of the output when invoked through /fn/
+
+	OutputFormat string `json:"outputFormat,omitempty"` // OutputFormatJSON to require and return JSON output
+
+	// AllowedEnv lists the environment variables execution input may set;
+	// any not blocked by the platform when empty
+	AllowedEnv []string `json:"allowedEnv,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	DefaultInput map[string]string `json:"defaultInput,omitempty"` // input keys used when a call doesn't set them
+}
+
+// EnvUpdateRequest replaces a function's environment variables
+type EnvUpdateRequest struct {
+	Env map[string]string `json:"env"`
+}
+
+// TagsUpdateRequest replaces a function's tags
+type TagsUpdateRequest struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// RenameRequest changes a function's name
+type RenameRequest struct {
+	Name string `json:"name"`
+}
+
+// DefaultInputUpdateRequest replaces a function's default input
+type DefaultInputUpdateRequest struct {
+	DefaultInput map[string]string `json:"defaultInput"`
+}
+
+// GitSubmission is the JSON body of a submission that deploys code from a
+// Git repository instead of an uploaded archive. The deployment options are
+// the same as the multipart form fields of an upload.
+type GitSubmission struct {
+	GitURL  string            `json:"gitUrl"`
+	Ref     string            `json:"ref,omitempty"`    // branch or tag; the default branch when empty
+	Subdir  string            `json:"subdir,omitempty"` // directory of the function within the repository
+	Token   string            `json:"token,omitempty"`  // access token for a private repository
+	Name    string            `json:"name,omitempty"`
+	Handler string            `json:"handler,omitempty"`
+	Memory  string            `json:"memory,omitempty"`
+	CPUs    string            `json:"cpus,omitempty"`
+	Network string            `json:"network,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+
+	DefaultInput map[string]string `json:"defaultInput,omitempty"`
+}
+
+// InputSchema describes the execution input a function expects, using a
+// subset of JSON Schema
+type InputSchema struct {
+	Type       string                  `json:"type,omitempty"`
+	Required   []string                `json:"required,omitempty"`
+	Properties map[string]*InputSchema `json:"properties,omitempty"`
+	Items      *InputSchema            `json:"items,omitempty"`
 }
 
 // ExecutionRequest represents a request to execute a function
 type ExecutionRequest struct {
-	FunctionID string            `json:"functionId"`
-	Input      map[string]string `json:"input,omitempty"`
+	FunctionID string                 `json:"functionId"`
+	Name       string                 `json:"name,omitempty"`  // alternative to FunctionID
+	Alias      string                 `json:"alias,omitempty"` // alternative to FunctionID, resolved before Name
+	Input      map[string]interface{} `json:"input,omitempty"`
+	// CallbackURL receives the finished job as a signed POST; setting it
+	// makes the execution asynchronous
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// TimeoutSeconds shortens the run timeout for this execution; longer
+	// values are capped at DOCKER_RUN_TIMEOUT, or rejected if Strict is set
+	TimeoutSeconds int  `json:"timeoutSeconds,omitempty"`
+	Strict         bool `json:"strict,omitempty"`
 }
 
 // ExecutionResponse represents the response from executing a function
 type ExecutionResponse struct {
-	Output     string `json:"output"`
-	StatusCode int    `json:"statusCode"`
+	Output         string  `json:"output"`
+	Stderr         string  `json:"stderr,omitempty"`
+	ExitCode       int     `json:"exitCode"`
+	StatusCode     int     `json:"statusCode"`
+	ExecutedAt     int64   `json:"executedAt"`
+	Truncated      bool    `json:"truncated,omitempty"`      // output exceeded MAX_OUTPUT_SIZE and was cut off
+	DroppedBytes   int64   `json:"droppedBytes,omitempty"`   // output bytes cut off, across stdout and stderr
+	TimedOut       bool    `json:"timedOut,omitempty"`       // the function was stopped at its timeout; the output is partial
+	TimeoutSeconds float64 `json:"timeoutSeconds"`           // the run timeout the execution had
+	OutputEncoding string  `json:"outputEncoding,omitempty"` // "base64" when Output is binary
+	ExecutionID    string  `json:"executionId,omitempty"`    // identifies the execution's logs and history record
+
+	OutputFormat string `json:"outputFormat,omitempty"` // OutputFormatJSON when Output is a JSON document
+}
+
+// MarshalJSON encodes Output as base64, setting OutputEncoding, when it
+// isn't valid UTF-8, since a JSON string would replace the invalid bytes.
+// JSON output is embedded as is rather than as a string.
+func (r ExecutionResponse) MarshalJSON() ([]byte, error) {
+	type plain ExecutionResponse
+	encoded := plain(r)
+	if encoded.OutputFormat == OutputFormatJSON && json.Valid([]byte(encoded.Output)) {
+		return json.Marshal(struct {
+			plain
+			Output json.RawMessage `json:"output"`
+		}{encoded, json.RawMessage(encoded.Output)})
+	}
+	encoded.OutputFormat = ""
+	if !utf8.ValidString(encoded.Output) {
+		encoded.Output = base64.StdEncoding.EncodeToString([]byte(encoded.Output))
+		encoded.OutputEncoding = "base64"
+	}
+	return json.Marshal(encoded)
+}
+
+// BatchExecutionRequest represents a request to run a function once per input
+type BatchExecutionRequest struct {
+	FunctionID string                   `json:"functionId"`
+	Inputs     []map[string]interface{} `json:"inputs"`
+}
+
+// BatchResult is the outcome of one execution in a batch. Error is set when
+// the execution failed, with StatusCode giving the reason as for a single
+// execution.
+type BatchResult struct {
+	ExecutionResponse
+	Error string `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the result as its ExecutionResponse with Error added,
+// which the promoted ExecutionResponse.MarshalJSON would otherwise leave out
+func (r BatchResult) MarshalJSON() ([]byte, error) {
+	response, err := json.Marshal(r.ExecutionResponse)
+	if err != nil || r.Error == "" {
+		return response, err
+	}
+	errorValue, err := json.Marshal(r.Error)
+	if err != nil {
+		return nil, err
+	}
+	encoded := append(response[:len(response)-1], `,"error":`...)
+	encoded = append(encoded, errorValue...)
+	return append(encoded, '}'), nil
+}
+
+// BatchExecutionResponse represents the results of a batch execution, in the
+// order of the request's inputs
+type BatchExecutionResponse struct {
+	FunctionID string        `json:"functionId"`
+	Results    []BatchResult `json:"results"`
+	Succeeded  int           `json:"succeeded"`
+	Failed     int           `json:"failed"`
+}
+
+// ExecutionRecord is an entry in a function's execution history
+type ExecutionRecord struct {
+	ExecutionID string `json:"executionId,omitempty"`
+	FunctionID  string `json:"functionId"`
+	ExecutedAt  int64  `json:"executedAt"`
+	DurationMs  int64  `json:"durationMs"`
+	ExitCode    int    `json:"exitCode"`
+	Success     bool   `json:"success"`
+	Output      string `json:"output,omitempty"`    // the start of the function's stdout
+	Truncated   bool   `json:"truncated,omitempty"` // Output was cut short
+	Error       string `json:"error,omitempty"`
+}
+
+// ExecutionHistoryResponse represents a function's recent executions
+type ExecutionHistoryResponse struct {
+	FunctionID string            `json:"functionId"`
+	Executions []ExecutionRecord `json:"executions"`
+}
+
+// ExecutionLog is the retained stdout and stderr of one execution
+type ExecutionLog struct {
+	ExecutionID string `json:"executionId"`
+	FunctionID  string `json:"functionId"`
+	ExecutedAt  int64  `json:"executedAt"`
+	ExitCode    int    `json:"exitCode"`
+	Success     bool   `json:"success"`
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	Truncated   bool   `json:"truncated,omitempty"` // Stdout or Stderr was cut short
+	Error       string `json:"error,omitempty"`
+}
+
+// Server-Sent Event types sent by GET /api/execute/stream. Every event's data
+// is a JSON-encoded StreamEvent.
+const (
+	StreamEventStdout = "stdout" // a chunk of the function's stdout, in Data
+	StreamEventStderr = "stderr" // a chunk of the function's stderr, in Data
+	StreamEventExit   = "exit"   // the function finished, with its ExitCode; always last on success
+	StreamEventError  = "error"  // the execution failed, with the reason in Error; always last on failure
+)
+
+// StreamEvent is the payload of a streamed execution event
+type StreamEvent struct {
+	Data     string `json:"data,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Job statuses for asynchronous executions
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job represents an asynchronous function execution
+type Job struct {
+	JobID       string             `json:"jobId"`
+	FunctionID  string             `json:"functionId"`
+	Status      string             `json:"status"`
+	CreatedAt   int64              `json:"createdAt"`
+	StartedAt   int64              `json:"startedAt,omitempty"`
+	CompletedAt int64              `json:"completedAt,omitempty"`
+	Result      *ExecutionResponse `json:"result,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// FunctionStats summarizes the deployed functions
+type FunctionStats struct {
+	TotalFunctions   int               `json:"totalFunctions"`
+	ByLanguage       map[string]int    `json:"byLanguage"`
+	TotalInvocations int64             `json:"totalInvocations"`
+	LastExecuted     *FunctionActivity `json:"lastExecuted,omitempty"` // the most recently executed function
+	LastBuiltAt      int64             `json:"lastBuiltAt,omitempty"`  // the most recent build of any function
+}
+
+// FunctionActivity identifies a function and when it last ran
+type FunctionActivity struct {
+	FunctionID string `json:"functionId"`
+	Name       string `json:"name"`
 	ExecutedAt int64  `json:"executedAt"`
 }
 
+// VersionResponse describes the build an instance is running
+type VersionResponse struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	BuildDate     string `json:"buildDate"`
+	GoVersion     string `json:"goVersion"`
+	Uptime        string `json:"uptime"` // such as "26h3m12s"
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+}
+
+// AsyncExecutionResponse represents the response after queueing an asynchronous execution
+type AsyncExecutionResponse struct {
+	JobID  string `json:"jobId"`
+	Status string `json:"status"`
+}
+
 // SubmissionResponse represents the response after submitting a function
 type SubmissionResponse struct {
 	FunctionID string `json:"functionId"`
 	ImageID    string `json:"imageId"`
 	Message    string `json:"message"`
+	BuildLog   string `json:"buildLog,omitempty"`
+	Reused     bool   `json:"reused"`
+	Commit     string `json:"commit,omitempty"` // commit deployed from a Git repository
+}
+
+// ValidationResponse represents the result of a dry-run build of a function
+type ValidationResponse struct {
+	Valid       bool   `json:"valid"`
+	Language    string `json:"language"`
+	HandlerFile string `json:"handlerFile"`
+	Message     string `json:"message"`
+	BuildLog    string `json:"buildLog,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// UpdateResponse represents the response after redeploying a function
+type UpdateResponse struct {
+	FunctionID string `json:"functionId"`
+	OldImageID string `json:"oldImageId"`
+	NewImageID string `json:"newImageId"`
+	Message    string `json:"message"`
+	BuildLog   string `json:"buildLog,omitempty"`
+}
+
+// ReconcileReport describes the changes made by reconciling stored functions
+// with the images present in Docker
+type ReconcileReport struct {
+	RemovedFunctions []string `json:"removedFunctions"` // functions whose image was missing
+	OrphanedImages   []string `json:"orphanedImages"`   // platform images no function uses
+}
+
+// ExportVersion is the version of the FunctionExport format
+const ExportVersion = 1
+
+// FunctionExport is a backup of every stored function, for restoring on
+// another instance. Environment values are included unmasked.
+type FunctionExport struct {
+	Version    int                `json:"version"`
+	ExportedAt int64              `json:"exportedAt"`
+	Functions  []FunctionMetadata `json:"functions"`
+}
+
+// ImportReport describes the outcome of importing a FunctionExport, with the
+// error for each function that could not be imported
+type ImportReport struct {
+	Imported []string          `json:"imported"` // functions added, or overwritten when asked to
+	Skipped  []string          `json:"skipped"`  // functions already stored and left as they were
+	Rebuilt  []string          `json:"rebuilt"`  // imported functions whose image was rebuilt from retained source
+	Errors   map[string]string `json:"errors"`
+}
+
+// ImageCleanupReport describes the images removed by an image cleanup
+type ImageCleanupReport struct {
+	RemovedImages  []string `json:"removedImages"`
+	SpaceReclaimed int64    `json:"spaceReclaimed"` // bytes, not counting layers still shared with other images
+}
+
+// BulkDeleteResponse represents the outcome of deleting many functions at
+// once, with the error for each function that could not be deleted
+type BulkDeleteResponse struct {
+	Deleted []string          `json:"deleted"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// Alias is a stable name pointing at a function, which can be repointed to
+// another function, such as a new version, without changing its callers.
+// With a canary, a share of executions goes to the canary function instead.
+type Alias struct {
+	Name               string `json:"name"`
+	FunctionID         string `json:"functionId"`
+	CanaryFunctionID   string `json:"canaryFunctionId,omitempty"`
+	CanaryWeight       int    `json:"canaryWeight,omitempty"`       // percent of executions sent to the canary
+	PreviousFunctionID string `json:"previousFunctionId,omitempty"` // the function pointed to before the last change, to roll back to
+	UpdatedAt          int64  `json:"updatedAt"`
+	Dangling           bool   `json:"dangling,omitempty"` // the function or canary has been deleted; filled in for responses, never stored
+}
+
+// AliasRequest represents a request to point an alias at a function, and
+// optionally to send a share of its executions to a canary function
+type AliasRequest struct {
+	FunctionID       string `json:"functionId"`
+	CanaryFunctionID string `json:"canaryFunctionId,omitempty"`
+	CanaryWeight     int    `json:"canaryWeight,omitempty"` // 0-100
+}
+
+// AliasListResponse represents every alias, sorted by name
+type AliasListResponse struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+// FunctionListResponse represents one page of a function listing
+type FunctionListResponse struct {
+	Functions []FunctionMetadata `json:"functions"`
+	Total     int                `json:"total"`
+	Limit     int                `json:"limit"`
+	Offset    int                `json:"offset"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Details string `json:"details,omitempty"`
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
 }
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	tests := []struct {
+		name         string
+		metadata     FunctionMetadata
+		env          map[string]string
+		defaultInput map[string]string
+	}{
+		{
+			name: "env values are masked",
+			metadata: FunctionMetadata{
+				Env:          map[string]string{"API_KEY": "secret", "REGION": "eu"},
+				DefaultInput: map[string]string{"name": "Alice"},
+				InputMode:    InputModeStdin,
+			},
+			env:          map[string]string{"API_KEY": RedactedValue, "REGION": RedactedValue},
+			defaultInput: map[string]string{"name": "Alice"},
+		},
+		{
+			name: "default input is masked in env mode",
+			metadata: FunctionMetadata{
+				DefaultInput: map[string]string{"name": "Alice"},
+				InputMode:    InputModeEnv,
+			},
+			defaultInput: map[string]string{"name": RedactedValue},
+		},
+		{
+			name: "default input is masked when the input mode is unset",
+			metadata: FunctionMetadata{
+				DefaultInput: map[string]string{"name": "Alice"},
+			},
+			defaultInput: map[string]string{"name": RedactedValue},
+		},
+		{
+			name:     "nothing to mask",
+			metadata: FunctionMetadata{InputMode: InputModeEnv},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.metadata
+			originalEnv := copyMap(tt.metadata.Env)
+
+			redacted := tt.metadata.Redacted()
+			if !reflect.DeepEqual(redacted.Env, tt.env) {
+				t.Errorf("env = %v, want %v", redacted.Env, tt.env)
+			}
+			if !reflect.DeepEqual(redacted.DefaultInput, tt.defaultInput) {
+				t.Errorf("default input = %v, want %v", redacted.DefaultInput, tt.defaultInput)
+			}
+			if !reflect.DeepEqual(original.Env, originalEnv) {
+				t.Errorf("Redacted modified the original env: %v", original.Env)
+			}
+		})
+	}
+}
+
+func copyMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,173 @@
+// Package netsafe guards outbound requests against server-side request
+// forgery. Every feature that connects to an address supplied by a client
+// should check it with a Guard and connect through the Guard's client.
+package netsafe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned for URLs that are malformed or point at an address
+// outbound requests may not be sent to
+var ErrBlocked = errors.New("destination not allowed")
+
+// blockedNetworks are ranges that the net.IP predicates in blockedIP don't
+// cover but that reach internal services: "this network", carrier-grade NAT
+// (where some clouds serve instance metadata) and IPv4 translated into IPv6
+var blockedNetworks = mustParseNetworks("0.0.0.0/8", "100.64.0.0/10", "64:ff9b::/96", "64:ff9b:1::/48")
+
+// Guard decides which addresses outbound requests may connect to. Public
+// addresses are allowed; loopback, private, link-local (including the
+// 169.254.169.254 metadata service), multicast and unspecified addresses are
+// blocked unless they fall in one of the allowlisted networks.
+type Guard struct {
+	allowed      []*net.IPNet
+	allowPrivate bool
+	resolver     *net.Resolver
+}
+
+// NewGuard creates a Guard that also allows the given networks, each a CIDR
+// such as "10.1.0.0/16" or a single IP address. When allowPrivate is set
+// every address is allowed, such as for local development.
+func NewGuard(allowed []string, allowPrivate bool) (*Guard, error) {
+	networks, err := ParseNetworks(allowed)
+	if err != nil {
+		return nil, err
+	}
+	return &Guard{allowed: networks, allowPrivate: allowPrivate, resolver: net.DefaultResolver}, nil
+}
+
+// ParseNetworks parses CIDRs and single IP addresses into networks
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: expected a CIDR or an IP address", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Allowed reports whether outbound requests may connect to ip
+func (g *Guard) Allowed(ip net.IP) bool {
+	if g.allowPrivate {
+		return true
+	}
+	for _, network := range g.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !blockedIP(ip)
+}
+
+// CheckURL checks that rawURL is an http or https URL without credentials
+// whose host resolves only to allowed addresses. The host may resolve
+// differently by the time it is connected to, so clients must also connect
+// through DialContext.
+func (g *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return fmt.Errorf("%w: expected an http or https URL", ErrBlocked)
+	}
+	if target.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed in the URL", ErrBlocked)
+	}
+	return g.CheckHost(ctx, target.Hostname())
+}
+
+// CheckHost checks that host is an allowed IP address or a name that
+// resolves only to allowed addresses
+func (g *Guard) CheckHost(ctx context.Context, host string) error {
+	if g.allowPrivate {
+		return nil
+	}
+
+	addrs, err := g.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: failed to resolve %s: %v", ErrBlocked, host, err)
+	}
+	for _, addr := range addrs {
+		if !g.Allowed(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s, which is not an allowed address", ErrBlocked, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// Dialer returns a dialer that checks each address it connects to after
+// the host has been resolved, so a name can't pass CheckURL and then
+// resolve to a blocked address when connecting
+func (g *Guard) Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout:  timeout,
+		Resolver: g.resolver,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !g.Allowed(ip) {
+				return fmt.Errorf("%w: %s is not an allowed address", ErrBlocked, host)
+			}
+			return nil
+		},
+	}
+}
+
+// Client returns an HTTP client that connects only to allowed addresses.
+// Proxies are ignored since the proxy, not the client, would connect to the
+// destination; redirects are followed, each checked again when connecting.
+func (g *Guard) Client(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = g.Dialer(timeout).DialContext
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// blockedIP reports whether ip is loopback, private, link-local, multicast,
+// unspecified or in one of blockedNetworks
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseNetworks(values ...string) []*net.IPNet {
+	networks, err := ParseNetworks(values)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
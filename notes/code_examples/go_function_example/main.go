@@ -1,8 +1,16 @@
 package main
 
+// Example Go function for the YouTube Serverless Platform.
+//
+// Input contract: by default each input key is passed as an upper-cased
+// environment variable (e.g. {"name": "Alice"} becomes NAME=Alice). If
+// serverless.json sets "input": "stdin", the whole input is written to stdin
+// as a single JSON object instead, preserving types and nesting.
+
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"time"
@@ -15,9 +23,30 @@ type Response struct {
 	Environment map[string]string `json:"environment"`
 }
 
+// readInput reads the JSON input object from stdin. It returns an empty map
+// when the function runs in env mode and stdin is empty.
+func readInput() (map[string]interface{}, error) {
+	input := map[string]interface{}{}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil || len(data) == 0 {
+		return input, err
+	}
+	err = json.Unmarshal(data, &input)
+	return input, err
+}
+
 func main() {
-	// Get input from environment variables
-	name := os.Getenv("NAME")
+	input, err := readInput()
+	if err != nil {
+		fmt.Printf("Error reading input: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Prefer stdin input, falling back to environment variables
+	name, _ := input["name"].(string)
+	if name == "" {
+		name = os.Getenv("NAME")
+	}
 	if name == "" {
 		name = "World"
 	}
@@ -1,17 +1,34 @@
 """
 Example Python function for the YouTube Serverless Platform
 This function demonstrates a simple HTTP request handler
+
+Input contract:
+- By default each input key is passed as an upper-cased environment variable
+  (e.g. {"name": "Alice"} becomes NAME=Alice).
+- If serverless.json sets "input": "stdin", the whole input is written to
+  stdin as a single JSON object instead, preserving types and nesting.
 """
 import os
 import json
 import sys
 
+def read_input():
+    """
+    Read the JSON input object from stdin, returning an empty dict when
+    the function is invoked in env mode and stdin is empty
+    """
+    data = sys.stdin.read()
+    if not data.strip():
+        return {}
+    return json.loads(data)
+
 def main():
     """
     Main function that processes input and returns a response
     """
-    # Get input from environment variables
-    name = os.environ.get('NAME', 'World')
+    # Prefer stdin input, falling back to environment variables
+    payload = read_input()
+    name = payload.get('name') or os.environ.get('NAME', 'World')
     
     # Process the input
     message = f"Hello, {name}!"
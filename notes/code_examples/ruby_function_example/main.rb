@@ -0,0 +1,36 @@
+# Example Ruby function for the YouTube Serverless Platform
+#
+# Input contract:
+# - By default each input key is passed as an upper-cased environment variable
+#   (e.g. {"name": "Alice"} becomes NAME=Alice).
+# - If serverless.json sets "input": "stdin", the whole input is written to
+#   stdin as a single JSON object instead, preserving types and nesting.
+require 'json'
+
+# Read the JSON input object from stdin, returning an empty hash when the
+# function is invoked in env mode and stdin is empty
+def read_input
+  data = $stdin.tty? ? '' : $stdin.read
+  return {} if data.strip.empty?
+
+  JSON.parse(data)
+end
+
+def main
+  # Prefer stdin input, falling back to environment variables
+  payload = read_input
+  name = payload['name'] || ENV.fetch('NAME', 'World')
+
+  response = {
+    message: "Hello, #{name}!",
+    timestamp: Time.now.utc.iso8601,
+    environment: {
+      ruby_version: RUBY_VERSION,
+      platform: RUBY_PLATFORM
+    }
+  }
+
+  puts JSON.pretty_generate(response)
+end
+
+main if __FILE__ == $PROGRAM_NAME
@@ -0,0 +1,25 @@
+// Package requestctx carries the ID assigned to each API request through
+// contexts, so every layer can tag its log lines with the same ID
+package requestctx
+
+import "context"
+
+// Header is the response header the request ID is sent to clients in
+const Header = "X-Request-ID"
+
+// idKey is the context key for the request ID. It is an unexported struct
+// type, so it can't collide with keys set by other packages and the ID can
+// only be set and read through this package.
+type idKey struct{}
+
+// WithID returns a copy of ctx carrying the request ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ID returns the request ID carried by ctx, or an empty string if it has
+// none
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
@@ -0,0 +1,34 @@
+package requestctx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithID(t *testing.T) {
+	ctx := WithID(context.Background(), "request-1")
+	if id := ID(ctx); id != "request-1" {
+		t.Errorf("ID = %q, want %q", id, "request-1")
+	}
+
+	// A nested context replaces the ID without changing its parent's
+	nested := WithID(ctx, "request-2")
+	if id := ID(nested); id != "request-2" {
+		t.Errorf("nested ID = %q, want %q", id, "request-2")
+	}
+	if id := ID(ctx); id != "request-1" {
+		t.Errorf("parent ID = %q after nesting, want %q", id, "request-1")
+	}
+}
+
+func TestIDWithoutID(t *testing.T) {
+	if id := ID(context.Background()); id != "" {
+		t.Errorf("ID of an empty context = %q, want empty", id)
+	}
+
+	// A string key of the same name as the ID isn't mistaken for it
+	ctx := context.WithValue(context.Background(), "requestID", "request-1")
+	if id := ID(ctx); id != "" {
+		t.Errorf("ID of a context with a string key = %q, want empty", id)
+	}
+}
@@ -0,0 +1,309 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+)
+
+// DefaultRetryBackoff is the delay before the first retry of a failed run
+// when its schedule sets none
+const DefaultRetryBackoff = 10 * time.Second
+
+// maxRetryBackoff caps the delay between retries of a failed run
+const maxRetryBackoff = 10 * time.Minute
+
+// RunFunc executes a scheduled function with its stored input. Errors
+// wrapped with Permanent are not retried.
+type RunFunc func(ctx context.Context, functionID string, input map[string]interface{}) error
+
+// Scheduler triggers function executions on cron schedules. Failed runs are
+// retried as their schedule asks, and runs that fail every attempt are kept
+// in a bounded record per function.
+type Scheduler struct {
+	cron    *cron.Cron
+	run     RunFunc
+	entries map[string]cron.EntryID
+	mutex   sync.Mutex
+
+	running     map[string]int                      // runs in progress per function, retries included
+	failures    map[string][]models.ScheduleFailure // oldest first
+	failureSize int                                 // failures kept per function; 0 keeps none
+
+	// ctx is the parent of every scheduled run; cancelling it aborts them
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopping is closed by Stop so runs waiting to retry give up
+	stopping chan struct{}
+}
+
+// New creates a Scheduler that keeps the last failureSize failures of each
+// function, and starts its clock
+func New(run RunFunc, failureSize int) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	logger := cronLogger{}
+	s := &Scheduler{
+		cron: cron.New(
+			cron.WithLogger(logger),
+			cron.WithChain(cron.Recover(logger)),
+		),
+		run:         run,
+		entries:     make(map[string]cron.EntryID),
+		running:     make(map[string]int),
+		failures:    make(map[string][]models.ScheduleFailure),
+		failureSize: failureSize,
+		ctx:         ctx,
+		cancel:      cancel,
+		stopping:    make(chan struct{}),
+	}
+	s.cron.Start()
+	return s
+}
+
+// permanentError marks a run error that retrying can't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err so the scheduler doesn't retry the run that returned
+// it, such as when the function no longer exists or its input is invalid
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Validate checks that expr is a valid standard five-field cron expression
+func Validate(expr string) error {
+	if _, err := cron.ParseStandard(expr); err != nil {
+		return fmt.Errorf("invalid cron expression %q: %v", expr, err)
+	}
+	return nil
+}
+
+// Set registers the schedule for a function, replacing any existing one
+func (s *Scheduler) Set(functionID string, schedule models.Schedule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entryID, err := s.cron.AddFunc(schedule.Cron, func() {
+		s.trigger(functionID, schedule)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %v", schedule.Cron, err)
+	}
+
+	if existing, ok := s.entries[functionID]; ok {
+		s.cron.Remove(existing)
+	}
+	s.entries[functionID] = entryID
+
+	log.Info().
+		Str("function_id", functionID).
+		Str("cron", schedule.Cron).
+		Int("retries", schedule.Retries).
+		Bool("allow_overlap", schedule.AllowOverlap).
+		Time("next_run", s.cron.Entry(entryID).Next).
+		Msg("Function scheduled")
+
+	return nil
+}
+
+// Remove unregisters the schedule for a function, if any, and discards its
+// recorded failures
+func (s *Scheduler) Remove(functionID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.failures, functionID)
+	entryID, ok := s.entries[functionID]
+	if !ok {
+		return
+	}
+	s.cron.Remove(entryID)
+	delete(s.entries, functionID)
+
+	log.Info().
+		Str("function_id", functionID).
+		Msg("Function schedule removed")
+}
+
+// Stop stops triggering new runs and waits for running ones to finish. If
+// ctx expires first, the remaining runs are cancelled.
+func (s *Scheduler) Stop(ctx context.Context) {
+	close(s.stopping)
+	done := s.cron.Stop().Done()
+
+	select {
+	case <-done:
+		log.Info().Msg("Scheduler stopped")
+	case <-ctx.Done():
+		log.Warn().Msg("Scheduler stop timed out, cancelling running executions")
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+}
+
+// Failures returns the recorded failures of a function's scheduled runs,
+// newest first
+func (s *Scheduler) Failures(functionID string) []models.ScheduleFailure {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recorded := s.failures[functionID]
+	failures := make([]models.ScheduleFailure, len(recorded))
+	for i, failure := range recorded {
+		failures[len(recorded)-1-i] = failure
+	}
+	return failures
+}
+
+// trigger runs a scheduled execution, retrying it as the schedule asks, and
+// records it as a failure if every attempt fails. Unless the schedule allows
+// overlap, the tick is skipped, and recorded, while a previous run of the
+// function is still going.
+func (s *Scheduler) trigger(functionID string, schedule models.Schedule) {
+	scheduledAt := time.Now()
+	if !s.begin(functionID, schedule.AllowOverlap) {
+		log.Warn().
+			Str("function_id", functionID).
+			Msg("Skipping scheduled execution; the previous run is still going")
+		s.recordFailure(functionID, models.ScheduleFailure{
+			ScheduledAt: scheduledAt.Unix(),
+			FailedAt:    time.Now().Unix(),
+			Error:       "skipped: the previous run was still going",
+		})
+		return
+	}
+	defer s.end(functionID)
+
+	backoff := DefaultRetryBackoff
+	if schedule.RetryBackoffSeconds > 0 {
+		backoff = time.Duration(schedule.RetryBackoffSeconds) * time.Second
+	}
+
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		log.Info().
+			Str("function_id", functionID).
+			Int("attempt", attempts).
+			Msg("Running scheduled execution")
+
+		if err = s.run(s.ctx, functionID, schedule.Input); err == nil {
+			log.Info().
+				Str("function_id", functionID).
+				Int("attempt", attempts).
+				Msg("Scheduled execution succeeded")
+			return
+		}
+
+		var permanent permanentError
+		if attempts > schedule.Retries || errors.As(err, &permanent) || !s.wait(backoff) {
+			break
+		}
+		log.Warn().
+			Str("function_id", functionID).
+			Int("attempt", attempts).
+			Err(err).
+			Msg("Retrying failed scheduled execution")
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+
+	log.Error().
+		Str("function_id", functionID).
+		Int("attempts", attempts).
+		Err(err).
+		Msg("Scheduled execution failed")
+	s.recordFailure(functionID, models.ScheduleFailure{
+		ScheduledAt: scheduledAt.Unix(),
+		FailedAt:    time.Now().Unix(),
+		Attempts:    attempts,
+		Error:       err.Error(),
+	})
+}
+
+// begin counts a run of the function as started. Unless overlap is allowed,
+// it refuses while another run of the function is going.
+func (s *Scheduler) begin(functionID string, allowOverlap bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !allowOverlap && s.running[functionID] > 0 {
+		return false
+	}
+	s.running[functionID]++
+	return true
+}
+
+// end counts a run started by begin as finished
+func (s *Scheduler) end(functionID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running[functionID]--; s.running[functionID] <= 0 {
+		delete(s.running, functionID)
+	}
+}
+
+// wait sleeps for d before a retry. It returns false if the scheduler is
+// stopped first.
+func (s *Scheduler) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stopping:
+		return false
+	}
+}
+
+// recordFailure adds a failure to the function's record, dropping the
+// oldest once it holds failureSize. Runs that outlive their schedule aren't
+// recorded, so removing a schedule leaves nothing behind.
+func (s *Scheduler) recordFailure(functionID string, failure models.ScheduleFailure) {
+	if s.failureSize <= 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.entries[functionID]; !ok {
+		return
+	}
+	failures := append(s.failures[functionID], failure)
+	if len(failures) > s.failureSize {
+		failures = failures[len(failures)-s.failureSize:]
+	}
+	s.failures[functionID] = failures
+}
+
+// cronLogger adapts zerolog to the cron.Logger interface
+type cronLogger struct{}
+
+// Info logs routine cron messages at debug level
+func (cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	log.Debug().Fields(keysAndValues).Msg(msg)
+}
+
+// Error logs cron errors, such as recovered panics
+func (cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	log.Error().Err(err).Fields(keysAndValues).Msg(msg)
+}
@@ -0,0 +1,249 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"youtube_serverless/models"
+)
+
+// yearly is a schedule that doesn't fire during a test, so runs are
+// triggered by calling trigger
+const yearly = "0 0 1 1 *"
+
+// countingRun is a RunFunc failing with the errors it is given, in turn,
+// then succeeding
+type countingRun struct {
+	mutex sync.Mutex
+	errs  []error
+	calls int
+}
+
+// run implements RunFunc
+func (c *countingRun) run(ctx context.Context, functionID string, input map[string]interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return c.errs[c.calls-1]
+	}
+	return nil
+}
+
+// callCount returns how often the function was run
+func (c *countingRun) callCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls
+}
+
+// newTestScheduler creates a Scheduler with the schedule set for functionID,
+// stopped when the test ends
+func newTestScheduler(t *testing.T, run RunFunc, failureSize int, functionID string, schedule models.Schedule) *Scheduler {
+	t.Helper()
+
+	s := New(run, failureSize)
+	t.Cleanup(func() {
+		s.Stop(context.Background())
+	})
+	if err := s.Set(functionID, schedule); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	return s
+}
+
+func TestTriggerSkipsOverlappingRun(t *testing.T) {
+	for _, allowOverlap := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allowOverlap=%t", allowOverlap), func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			run := func(ctx context.Context, functionID string, input map[string]interface{}) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			}
+			schedule := models.Schedule{Cron: yearly, AllowOverlap: allowOverlap}
+			s := newTestScheduler(t, run, 10, "fn", schedule)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.trigger("fn", schedule)
+			}()
+			<-started
+
+			// The second tick fires while the first run is still going
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.trigger("fn", schedule)
+			}()
+			select {
+			case <-started:
+				if !allowOverlap {
+					t.Error("overlapping tick ran the function")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if allowOverlap {
+					t.Error("overlapping tick didn't run the function")
+				}
+			}
+			close(release)
+			wg.Wait()
+
+			failures := s.Failures("fn")
+			if allowOverlap {
+				if len(failures) != 0 {
+					t.Errorf("failures = %+v, want none", failures)
+				}
+				return
+			}
+			if len(failures) != 1 || failures[0].Attempts != 0 || !strings.Contains(failures[0].Error, "skipped") {
+				t.Errorf("failures = %+v, want one skipped tick", failures)
+			}
+
+			// Once the run has finished the next tick runs again
+			release = make(chan struct{})
+			close(release)
+			s.trigger("fn", schedule)
+			if len(started) != 1 {
+				t.Error("tick after the run finished didn't run the function")
+			}
+		})
+	}
+}
+
+func TestTriggerRetries(t *testing.T) {
+	failed := errors.New("exit code 1")
+
+	tests := []struct {
+		name     string
+		retries  int
+		errs     []error
+		attempts int
+		failed   bool
+	}{
+		{name: "no retries", retries: 0, errs: []error{failed}, attempts: 1, failed: true},
+		{name: "retries exhausted", retries: 1, errs: []error{failed, failed, failed}, attempts: 2, failed: true},
+		{name: "succeeds on retry", retries: 1, errs: []error{failed}, attempts: 2},
+		{name: "succeeds first time", retries: 1, attempts: 1},
+		{name: "permanent failure", retries: 1, errs: []error{Permanent(failed)}, attempts: 1, failed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := &countingRun{errs: tt.errs}
+			schedule := models.Schedule{Cron: yearly, Retries: tt.retries, RetryBackoffSeconds: 1}
+			s := newTestScheduler(t, run.run, 10, "fn", schedule)
+
+			s.trigger("fn", schedule)
+
+			if n := run.callCount(); n != tt.attempts {
+				t.Errorf("function ran %d times, want %d", n, tt.attempts)
+			}
+			failures := s.Failures("fn")
+			if !tt.failed {
+				if len(failures) != 0 {
+					t.Errorf("failures = %+v, want none", failures)
+				}
+				return
+			}
+			if len(failures) != 1 || failures[0].Attempts != tt.attempts || failures[0].Error != failed.Error() {
+				t.Errorf("failures = %+v, want one after %d attempts", failures, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestStopInterruptsRetryBackoff(t *testing.T) {
+	run := &countingRun{errs: []error{errors.New("exit code 1")}}
+	schedule := models.Schedule{Cron: yearly, Retries: 3, RetryBackoffSeconds: 3600}
+	s := New(run.run, 10)
+	if err := s.Set("fn", schedule); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.trigger("fn", schedule)
+	}()
+	for run.callCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop(context.Background())
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run kept waiting to retry after Stop")
+	}
+	if n := run.callCount(); n != 1 {
+		t.Errorf("function ran %d times, want 1", n)
+	}
+}
+
+func TestFailureHistoryIsCapped(t *testing.T) {
+	const size = 3
+	var mutex sync.Mutex
+	calls := 0
+	run := func(ctx context.Context, functionID string, input map[string]interface{}) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return fmt.Errorf("failure %d", calls)
+	}
+	schedule := models.Schedule{Cron: yearly}
+	s := newTestScheduler(t, run, size, "fn", schedule)
+
+	for i := 0; i < size+2; i++ {
+		s.trigger("fn", schedule)
+	}
+
+	// The newest failures are kept, newest first
+	failures := s.Failures("fn")
+	var got []string
+	for _, failure := range failures {
+		got = append(got, failure.Error)
+	}
+	if want := []string{"failure 5", "failure 4", "failure 3"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("failures = %q, want %q", got, want)
+	}
+
+	// Replacing the schedule keeps them, removing it discards them
+	if err := s.Set("fn", schedule); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if n := len(s.Failures("fn")); n != size {
+		t.Errorf("%d failures after replacing the schedule, want %d", n, size)
+	}
+	s.Remove("fn")
+	if n := len(s.Failures("fn")); n != 0 {
+		t.Errorf("%d failures after removing the schedule, want 0", n)
+	}
+
+	// A run outliving its schedule isn't recorded
+	s.trigger("fn", schedule)
+	if n := len(s.Failures("fn")); n != 0 {
+		t.Errorf("%d failures recorded for a removed schedule, want 0", n)
+	}
+}
+
+func TestFailureHistoryDisabled(t *testing.T) {
+	run := func(ctx context.Context, functionID string, input map[string]interface{}) error {
+		return errors.New("exit code 1")
+	}
+	schedule := models.Schedule{Cron: yearly}
+	s := newTestScheduler(t, run, 0, "fn", schedule)
+
+	s.trigger("fn", schedule)
+	if failures := s.Failures("fn"); len(failures) != 0 {
+		t.Errorf("failures = %+v with no history kept, want none", failures)
+	}
+}
@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"youtube_serverless/models"
+)
+
+// Supported schema types, following JSON Schema
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeNull    = "null"
+)
+
+// ValidationError lists every way an input fails to match its schema
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return "input does not match schema: " + strings.Join(e.Violations, "; ")
+}
+
+// Check reports whether a schema only uses the supported subset of JSON
+// Schema: type, required, properties and items. The top level must describe
+// an object, since execution input is always a JSON object.
+func Check(s *models.InputSchema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Type != "" && s.Type != TypeObject {
+		return fmt.Errorf("input schema must have type %q, got %q", TypeObject, s.Type)
+	}
+	return check(s, "input")
+}
+
+func check(s *models.InputSchema, path string) error {
+	switch s.Type {
+	case "", TypeObject, TypeArray, TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeNull:
+	default:
+		return fmt.Errorf("%s: unsupported type %q", path, s.Type)
+	}
+	if len(s.Properties) > 0 && s.Type != "" && s.Type != TypeObject {
+		return fmt.Errorf("%s: properties are only allowed on objects", path)
+	}
+	if len(s.Required) > 0 && s.Type != "" && s.Type != TypeObject {
+		return fmt.Errorf("%s: required is only allowed on objects", path)
+	}
+	if s.Items != nil && s.Type != "" && s.Type != TypeArray {
+		return fmt.Errorf("%s: items is only allowed on arrays", path)
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s.%s: schema must not be null", path, name)
+		}
+		if err := check(property, path+"."+name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return check(s.Items, path+"[]")
+	}
+	return nil
+}
+
+// Validate checks execution input against a schema and returns a
+// *ValidationError listing every violation, or nil if the input matches or
+// there is no schema
+func Validate(s *models.InputSchema, input map[string]interface{}) error {
+	if s == nil {
+		return nil
+	}
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+
+	var violations []string
+	validate(s, input, "input", &violations)
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func validate(s *models.InputSchema, value interface{}, path string, violations *[]string) {
+	if s.Type != "" && !hasType(value, s.Type) {
+		*violations = append(*violations, fmt.Sprintf("%s must be %s, got %s", path, s.Type, typeOf(value)))
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*violations = append(*violations, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+
+		// Walk properties in a stable order so violations are reported consistently
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := v[name]; ok {
+				validate(s.Properties[name], property, path+"."+name, violations)
+			}
+		}
+
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+	}
+}
+
+// hasType reports whether a decoded JSON value is of the schema type
+func hasType(value interface{}, schemaType string) bool {
+	if schemaType == TypeInteger {
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	}
+	return typeOf(value) == schemaType
+}
+
+// typeOf returns the schema type name of a decoded JSON value
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return TypeNull
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case string:
+		return TypeString
+	case float64:
+		return TypeNumber
+	case bool:
+		return TypeBoolean
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
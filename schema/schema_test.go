@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"youtube_serverless/models"
+)
+
+// testSchema is a schema as a function's serverless.json declares it
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "count"],
+	"properties": {
+		"name": {"type": "string"},
+		"count": {"type": "integer"},
+		"ratio": {"type": "number"},
+		"tags": {"type": "array", "items": {"type": "string"}},
+		"options": {
+			"type": "object",
+			"required": ["verbose"],
+			"properties": {"verbose": {"type": "boolean"}}
+		}
+	}
+}`
+
+func parseSchema(t *testing.T, data string) *models.InputSchema {
+	t.Helper()
+	var s models.InputSchema
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	return &s
+}
+
+func parseInput(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &input); err != nil {
+		t.Fatalf("failed to parse input: %v", err)
+	}
+	return input
+}
+
+func TestValidate(t *testing.T) {
+	s := parseSchema(t, testSchema)
+
+	tests := []struct {
+		name       string
+		input      string
+		violations []string
+	}{
+		{
+			name:  "matching input",
+			input: `{"name": "Alice", "count": 3, "ratio": 0.5, "tags": ["a"], "options": {"verbose": true}}`,
+		},
+		{
+			name:  "whole numbers are integers and numbers",
+			input: `{"name": "Alice", "count": 3.0, "ratio": 2}`,
+		},
+		{
+			name:       "missing required keys",
+			input:      `{}`,
+			violations: []string{"input.name is required", "input.count is required"},
+		},
+		{
+			name:       "wrong types",
+			input:      `{"name": 42, "count": "three", "ratio": true}`,
+			violations: []string{"input.count must be integer, got string", "input.name must be string, got number", "input.ratio must be number, got boolean"},
+		},
+		{
+			name:       "fractional integer",
+			input:      `{"name": "Alice", "count": 1.5}`,
+			violations: []string{"input.count must be integer, got number"},
+		},
+		{
+			name:       "null is its own type",
+			input:      `{"name": null, "count": 1}`,
+			violations: []string{"input.name must be string, got null"},
+		},
+		{
+			name:       "nested violations",
+			input:      `{"name": "Alice", "count": 1, "tags": ["a", 2], "options": {}}`,
+			violations: []string{"input.options.verbose is required", "input.tags[1] must be string, got number"},
+		},
+		{
+			name:  "unknown keys are allowed",
+			input: `{"name": "Alice", "count": 1, "extra": [1, 2]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(s, parseInput(t, tt.input))
+			if tt.violations == nil {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate error = %v, want *ValidationError", err)
+			}
+			if !reflect.DeepEqual(validationErr.Violations, tt.violations) {
+				t.Errorf("violations = %q, want %q", validationErr.Violations, tt.violations)
+			}
+		})
+	}
+}
+
+func TestValidateWithoutSchema(t *testing.T) {
+	if err := Validate(nil, map[string]interface{}{"anything": 1}); err != nil {
+		t.Errorf("Validate without a schema: %v", err)
+	}
+}
+
+func TestValidateNilInput(t *testing.T) {
+	err := Validate(parseSchema(t, `{"required": ["name"]}`), nil)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Violations) != 1 {
+		t.Errorf("Validate(nil input) = %v, want one missing key", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		valid  bool
+	}{
+		{name: "supported subset", schema: testSchema, valid: true},
+		{name: "untyped top level", schema: `{"required": ["name"]}`, valid: true},
+		{name: "top level must be an object", schema: `{"type": "array"}`},
+		{name: "unsupported type", schema: `{"properties": {"when": {"type": "date"}}}`},
+		{name: "properties on a string", schema: `{"properties": {"name": {"type": "string", "properties": {"a": {}}}}}`},
+		{name: "required on an array", schema: `{"properties": {"tags": {"type": "array", "required": ["a"]}}}`},
+		{name: "items on an object", schema: `{"properties": {"options": {"type": "object", "items": {}}}}`},
+		{name: "null property schema", schema: `{"properties": {"name": null}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(parseSchema(t, tt.schema))
+			if tt.valid && err != nil {
+				t.Errorf("Check: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Check accepted an invalid schema")
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"youtube_serverless/config"
+)
+
+// server is the HTTP server with the configured timeouts, limits and HTTP/2
+// settings. HTTP/2 is negotiated on TLS connections, and with cfg.H2C also
+// served in cleartext alongside HTTP/1.1.
+type server struct {
+	*http.Server
+	cfg *config.ServerConfig
+
+	// h2cConns tracks cleartext HTTP/2 connections, which the h2c handler
+	// takes over from http.Server, so Shutdown no longer waits for them
+	h2cConns sync.WaitGroup
+}
+
+// newServer creates a server for handler
+func newServer(cfg *config.ServerConfig, handler http.Handler) (*server, error) {
+	s := &server{
+		Server: &http.Server{
+			Addr:           ":" + cfg.Port,
+			ReadTimeout:    cfg.ReadTimeout,
+			WriteTimeout:   cfg.WriteTimeout,
+			IdleTimeout:    cfg.IdleTimeout,
+			MaxHeaderBytes: cfg.MaxHeaderBytes,
+		},
+		cfg: cfg,
+	}
+
+	// Configuring the HTTP/2 server on the HTTP/1 one also makes Shutdown
+	// send every HTTP/2 connection a GOAWAY, so clients stop opening streams
+	// while the ones in flight finish
+	h2s := &http2.Server{
+		MaxConcurrentStreams: uint32(cfg.MaxConcurrentStreams),
+		IdleTimeout:          cfg.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(s.Server, h2s); err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP/2: %v", err)
+	}
+
+	if cfg.H2C {
+		h2cHandler := h2c.NewHandler(handler, h2s)
+		// An h2c connection is served within the ServeHTTP call that took it
+		// over, so counting the calls counts the connections. Their streams
+		// go to handler directly and aren't counted again.
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.h2cConns.Add(1)
+			defer s.h2cConns.Done()
+			h2cHandler.ServeHTTP(w, r)
+		})
+	}
+	s.Handler = handler
+	return s, nil
+}
+
+// ListenAndServe serves over TLS when a certificate is configured and in
+// cleartext otherwise
+func (s *server) ListenAndServe() error {
+	if s.cfg.TLSCertFile != "" {
+		return s.Server.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	}
+	return s.Server.ListenAndServe()
+}
+
+// Shutdown stops accepting connections and waits for active ones to finish,
+// cleartext HTTP/2 connections included, or for ctx to end
+func (s *server) Shutdown(ctx context.Context) error {
+	if err := s.Server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.h2cConns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// Errors returned for aliases
+var (
+	// ErrAliasNotFound is returned when no alias has the given name
+	ErrAliasNotFound = errors.New("alias not found")
+
+	// ErrDanglingAlias is returned by ResolveAlias when the function the
+	// alias points to has been deleted
+	ErrDanglingAlias = errors.New("alias points to a function that no longer exists")
+)
+
+// rollPercent picks a number in [0, 100) at random
+func rollPercent() int {
+	return rand.Intn(100)
+}
+
+// SetAlias points the alias at the requested function and canary, creating
+// the alias if it doesn't exist yet. It returns ErrFunctionNotFound if either
+// function doesn't exist, so an alias never starts out dangling.
+func (fs *functionStore) SetAlias(ctx context.Context, name string, request models.AliasRequest) (models.Alias, error) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for _, functionID := range []string{request.FunctionID, request.CanaryFunctionID} {
+		if _, ok := fs.functions[functionID]; !ok && functionID != "" {
+			return models.Alias{}, fmt.Errorf("%w: %s", ErrFunctionNotFound, functionID)
+		}
+	}
+
+	alias := models.Alias{
+		Name:             name,
+		FunctionID:       request.FunctionID,
+		CanaryFunctionID: request.CanaryFunctionID,
+		CanaryWeight:     request.CanaryWeight,
+		UpdatedAt:        time.Now().Unix(),
+	}
+	if previous, ok := fs.aliases[name]; ok {
+		alias.PreviousFunctionID = previous.FunctionID
+	}
+
+	if fs.persister != nil {
+		if err := fs.persister.saveAlias(alias); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("alias", name).
+				Err(err).
+				Msg("Failed to persist alias")
+			return models.Alias{}, err
+		}
+	}
+	fs.aliases[name] = alias
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("alias", name).
+		Str("function_id", alias.FunctionID).
+		Str("canary_function_id", alias.CanaryFunctionID).
+		Int("canary_weight", alias.CanaryWeight).
+		Str("previous_function_id", alias.PreviousFunctionID).
+		Msg("Alias set")
+
+	return alias, nil
+}
+
+// GetAlias retrieves an alias, marked as dangling if its function has been
+// deleted. It returns ErrAliasNotFound if there is no such alias.
+func (fs *functionStore) GetAlias(ctx context.Context, name string) (models.Alias, error) {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	alias, ok := fs.aliases[name]
+	if !ok {
+		return models.Alias{}, fmt.Errorf("%w: %s", ErrAliasNotFound, name)
+	}
+	return fs.withDangling(alias), nil
+}
+
+// ResolveAlias retrieves the function an alias points to, or for its canary
+// weight's share of calls, its canary. It returns ErrAliasNotFound if there
+// is no such alias, and ErrDanglingAlias if its function or canary has been
+// deleted.
+func (fs *functionStore) ResolveAlias(ctx context.Context, name string) (models.FunctionMetadata, error) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	alias, ok := fs.aliases[name]
+	if !ok {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("alias", name).
+			Msg("Alias not found")
+		return models.FunctionMetadata{}, fmt.Errorf("%w: %s", ErrAliasNotFound, name)
+	}
+
+	// Fail whichever way the split goes, so a deleted canary is noticed
+	// however small its weight
+	for _, functionID := range []string{alias.FunctionID, alias.CanaryFunctionID} {
+		if _, ok := fs.functions[functionID]; !ok && functionID != "" {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("alias", name).
+				Str("function_id", functionID).
+				Msg("Alias is dangling")
+			return models.FunctionMetadata{}, fmt.Errorf("%w: alias %s, function %s", ErrDanglingAlias, name, functionID)
+		}
+	}
+
+	if alias.CanaryFunctionID != "" && fs.roll() < alias.CanaryWeight {
+		return fs.functions[alias.CanaryFunctionID], nil
+	}
+	return fs.functions[alias.FunctionID], nil
+}
+
+// ListAliases returns every alias sorted by name, dangling ones included
+func (fs *functionStore) ListAliases(ctx context.Context) []models.Alias {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	aliases := make([]models.Alias, 0, len(fs.aliases))
+	for _, alias := range fs.aliases {
+		aliases = append(aliases, fs.withDangling(alias))
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].Name < aliases[j].Name
+	})
+	return aliases
+}
+
+// DeleteAlias removes an alias, leaving its function in place. It returns
+// ErrAliasNotFound if there is no such alias.
+func (fs *functionStore) DeleteAlias(ctx context.Context, name string) error {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if _, ok := fs.aliases[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrAliasNotFound, name)
+	}
+
+	if fs.persister != nil {
+		if err := fs.persister.deleteAlias(name); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("alias", name).
+				Err(err).
+				Msg("Failed to delete persisted alias")
+			return err
+		}
+	}
+	delete(fs.aliases, name)
+
+	log.Info().
+		Str("request_id", requestID).
+		Str("alias", name).
+		Msg("Alias deleted")
+
+	return nil
+}
+
+// withDangling returns the alias marked as dangling if its function or
+// canary no longer exists. Callers must hold the lock.
+func (fs *functionStore) withDangling(alias models.Alias) models.Alias {
+	_, ok := fs.functions[alias.FunctionID]
+	if alias.CanaryFunctionID != "" {
+		_, canaryOK := fs.functions[alias.CanaryFunctionID]
+		ok = ok && canaryOK
+	}
+	alias.Dangling = !ok
+	return alias
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+// storeFunctions stores a function for each ID, failing the test on error
+func storeFunctions(t *testing.T, fs FunctionStore, functionIDs ...string) {
+	t.Helper()
+
+	for _, id := range functionIDs {
+		if err := fs.StoreFunction(context.Background(), models.FunctionMetadata{FunctionID: id, Name: id}); err != nil {
+			t.Fatalf("StoreFunction(%s): %v", id, err)
+		}
+	}
+}
+
+func TestAliasRepointAndDangling(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			fs := newTestStore(t, backend, nil)
+			ctx := context.Background()
+			storeFunctions(t, fs, "blue", "green")
+
+			// An alias never starts out dangling
+			if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "missing"}); !errors.Is(err, ErrFunctionNotFound) {
+				t.Errorf("SetAlias to a missing function = %v, want ErrFunctionNotFound", err)
+			}
+			if _, err := fs.ResolveAlias(ctx, "live"); !errors.Is(err, ErrAliasNotFound) {
+				t.Errorf("ResolveAlias before it is set = %v, want ErrAliasNotFound", err)
+			}
+
+			if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue"}); err != nil {
+				t.Fatalf("SetAlias: %v", err)
+			}
+			if got, err := fs.ResolveAlias(ctx, "live"); err != nil || got.FunctionID != "blue" {
+				t.Errorf("ResolveAlias = %q, %v; want blue", got.FunctionID, err)
+			}
+
+			// Repointing moves every call over and remembers where it came from
+			alias, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "green"})
+			if err != nil {
+				t.Fatalf("SetAlias: %v", err)
+			}
+			if alias.FunctionID != "green" || alias.PreviousFunctionID != "blue" {
+				t.Errorf("repointed alias = %+v, want green after blue", alias)
+			}
+			if got, err := fs.ResolveAlias(ctx, "live"); err != nil || got.FunctionID != "green" {
+				t.Errorf("ResolveAlias after repointing = %q, %v; want green", got.FunctionID, err)
+			}
+
+			// Deleting the function leaves the alias dangling
+			if err := fs.DeleteFunction(ctx, "green"); err != nil {
+				t.Fatalf("DeleteFunction: %v", err)
+			}
+			if _, err := fs.ResolveAlias(ctx, "live"); !errors.Is(err, ErrDanglingAlias) {
+				t.Errorf("ResolveAlias after deleting its function = %v, want ErrDanglingAlias", err)
+			}
+			if alias, err := fs.GetAlias(ctx, "live"); err != nil || !alias.Dangling {
+				t.Errorf("GetAlias = %+v, %v; want it dangling", alias, err)
+			}
+			if aliases := fs.ListAliases(ctx); len(aliases) != 1 || !aliases[0].Dangling {
+				t.Errorf("ListAliases = %+v, want one dangling alias", aliases)
+			}
+
+			// Repointing heals it, and deleting it leaves the function
+			if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue"}); err != nil {
+				t.Fatalf("SetAlias: %v", err)
+			}
+			if alias, err := fs.GetAlias(ctx, "live"); err != nil || alias.Dangling {
+				t.Errorf("GetAlias after repointing = %+v, %v; want it not dangling", alias, err)
+			}
+			if err := fs.DeleteAlias(ctx, "live"); err != nil {
+				t.Fatalf("DeleteAlias: %v", err)
+			}
+			if _, err := fs.ResolveAlias(ctx, "live"); !errors.Is(err, ErrAliasNotFound) {
+				t.Errorf("ResolveAlias after deleting it = %v, want ErrAliasNotFound", err)
+			}
+			if _, err := fs.GetFunction(ctx, "blue"); err != nil {
+				t.Errorf("deleting the alias deleted its function: %v", err)
+			}
+			if err := fs.DeleteAlias(ctx, "live"); !errors.Is(err, ErrAliasNotFound) {
+				t.Errorf("DeleteAlias twice = %v, want ErrAliasNotFound", err)
+			}
+		})
+	}
+}
+
+func TestResolveAliasCanarySplit(t *testing.T) {
+	tests := []struct {
+		weight int
+		canary int // calls out of 100 sent to the canary
+	}{
+		{weight: 0, canary: 0},
+		{weight: 1, canary: 1},
+		{weight: 25, canary: 25},
+		{weight: 99, canary: 99},
+		{weight: 100, canary: 100},
+	}
+
+	for _, tt := range tests {
+		fs := NewFunctionStore(0, false, 0)
+		ctx := context.Background()
+		storeFunctions(t, fs, "blue", "green")
+
+		// Roll every number in [0, 100) once
+		next := 0
+		fs.(*functionStore).roll = func() int {
+			n := next % 100
+			next++
+			return n
+		}
+		if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue", CanaryFunctionID: "green", CanaryWeight: tt.weight}); err != nil {
+			t.Fatalf("SetAlias: %v", err)
+		}
+
+		canary := 0
+		for i := 0; i < 100; i++ {
+			metadata, err := fs.ResolveAlias(ctx, "live")
+			if err != nil {
+				t.Fatalf("ResolveAlias: %v", err)
+			}
+			if metadata.FunctionID == "green" {
+				canary++
+			}
+		}
+		if canary != tt.canary {
+			t.Errorf("weight %d: %d of 100 calls went to the canary, want %d", tt.weight, canary, tt.canary)
+		}
+	}
+}
+
+func TestAliasCanaryMustExist(t *testing.T) {
+	fs := NewFunctionStore(0, false, 0)
+	ctx := context.Background()
+	storeFunctions(t, fs, "blue", "green")
+
+	if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue", CanaryFunctionID: "missing", CanaryWeight: 10}); !errors.Is(err, ErrFunctionNotFound) {
+		t.Errorf("SetAlias with a missing canary = %v, want ErrFunctionNotFound", err)
+	}
+
+	// A deleted canary fails every call, not only those sent to it
+	if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue", CanaryFunctionID: "green", CanaryWeight: 1}); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+	fs.(*functionStore).roll = func() int { return 99 }
+	if err := fs.DeleteFunction(ctx, "green"); err != nil {
+		t.Fatalf("DeleteFunction: %v", err)
+	}
+	if _, err := fs.ResolveAlias(ctx, "live"); !errors.Is(err, ErrDanglingAlias) {
+		t.Errorf("ResolveAlias with a deleted canary = %v, want ErrDanglingAlias", err)
+	}
+	if alias, err := fs.GetAlias(ctx, "live"); err != nil || !alias.Dangling {
+		t.Errorf("GetAlias = %+v, %v; want it dangling", alias, err)
+	}
+
+	// Leaving the canary out removes it
+	if _, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue"}); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+	if metadata, err := fs.ResolveAlias(ctx, "live"); err != nil || metadata.FunctionID != "blue" {
+		t.Errorf("ResolveAlias without a canary = %q, %v; want blue", metadata.FunctionID, err)
+	}
+}
+
+func TestPersistentStoresReloadAliases(t *testing.T) {
+	for _, backend := range []string{"sqlite", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := config.LoadConfig().Store
+			cfg.Backend = backend
+			cfg.Path = filepath.Join(t.TempDir(), "functions.db")
+			ctx := context.Background()
+
+			fs, err := New(&cfg)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			storeFunctions(t, fs, "blue", "green")
+			want, err := fs.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "blue", CanaryFunctionID: "green", CanaryWeight: 20})
+			if err != nil {
+				t.Fatalf("SetAlias: %v", err)
+			}
+			if _, err := fs.SetAlias(ctx, "old", models.AliasRequest{FunctionID: "blue"}); err != nil {
+				t.Fatalf("SetAlias: %v", err)
+			}
+			if err := fs.DeleteAlias(ctx, "old"); err != nil {
+				t.Fatalf("DeleteAlias: %v", err)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			reopened, err := New(&cfg)
+			if err != nil {
+				t.Fatalf("New after reopening: %v", err)
+			}
+			defer reopened.Close()
+			if got, err := reopened.GetAlias(ctx, "live"); err != nil || got != want {
+				t.Errorf("reloaded alias = %+v, %v; want %+v", got, err, want)
+			}
+			if _, err := reopened.GetAlias(ctx, "old"); !errors.Is(err, ErrAliasNotFound) {
+				t.Errorf("reloaded deleted alias = %v, want ErrAliasNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"youtube_serverless/models"
+)
+
+// functionsBucket holds each function as a JSON document keyed by ID
+var functionsBucket = []byte("functions")
+
+// aliasesBucket holds each alias as a JSON document keyed by name
+var aliasesBucket = []byte("aliases")
+
+// boltPersister persists function metadata to a bbolt database file
+type boltPersister struct {
+	db *bolt.DB
+}
+
+// NewBoltFunctionStore creates a FunctionStore backed by the bbolt database
+// at path, creating it if missing and loading existing functions. Unlike the
+// SQLite backend it needs no cgo. The last historySize executions of each
+// function are kept in memory, with uniqueNames no two functions may share a
+// name, and with a positive maxFunctions no more than that many functions may
+// be stored.
+func NewBoltFunctionStore(path string, historySize int, uniqueNames bool, maxFunctions int) (FunctionStore, error) {
+	// Another process holding the file lock would otherwise block forever
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(functionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(aliasesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %v", err)
+	}
+
+	fs, err := newPersistentFunctionStore(&boltPersister{db: db}, historySize, uniqueNames, maxFunctions)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (p *boltPersister) load() ([]models.FunctionMetadata, error) {
+	var functions []models.FunctionMetadata
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(functionsBucket).ForEach(func(_, data []byte) error {
+			var metadata models.FunctionMetadata
+			if err := json.Unmarshal(data, &metadata); err != nil {
+				return fmt.Errorf("failed to decode function metadata: %v", err)
+			}
+			functions = append(functions, metadata)
+			return nil
+		})
+	})
+	return functions, err
+}
+
+func (p *boltPersister) save(metadata models.FunctionMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(functionsBucket).Put([]byte(metadata.FunctionID), data)
+	})
+}
+
+func (p *boltPersister) delete(functionID string) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(functionsBucket).Delete([]byte(functionID))
+	})
+}
+
+func (p *boltPersister) loadAliases() ([]models.Alias, error) {
+	var aliases []models.Alias
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(aliasesBucket).ForEach(func(_, data []byte) error {
+			var alias models.Alias
+			if err := json.Unmarshal(data, &alias); err != nil {
+				return fmt.Errorf("failed to decode alias: %v", err)
+			}
+			aliases = append(aliases, alias)
+			return nil
+		})
+	})
+	return aliases, err
+}
+
+func (p *boltPersister) saveAlias(alias models.Alias) error {
+	data, err := json.Marshal(alias)
+	if err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(aliasesBucket).Put([]byte(alias.Name), data)
+	})
+}
+
+func (p *boltPersister) deleteAlias(name string) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(aliasesBucket).Delete([]byte(name))
+	})
+}
+
+func (p *boltPersister) close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"youtube_serverless/models"
+)
+
+// CachingFunctionStore wraps a FunctionStore with an LRU cache of function
+// lookups by ID and by name. Every write through it invalidates the entries
+// it could have changed, so a lookup never returns metadata older than the
+// last write, such as an image that has since been replaced.
+type CachingFunctionStore struct {
+	FunctionStore
+
+	mutex     sync.Mutex
+	functions *lruCache         // function ID -> metadata
+	names     map[string]string // name -> function ID, for names that resolved to one function
+	// generation changes on every invalidation, so a lookup that raced with a
+	// write doesn't cache what it read before the write
+	generation uint64
+}
+
+// NewCachingFunctionStore creates a CachingFunctionStore that caches up to
+// size functions looked up from store
+func NewCachingFunctionStore(store FunctionStore, size int) *CachingFunctionStore {
+	return &CachingFunctionStore{
+		FunctionStore: store,
+		functions:     newLRUCache(size),
+		names:         make(map[string]string),
+	}
+}
+
+// Unwrap returns the wrapped store
+func (c *CachingFunctionStore) Unwrap() FunctionStore {
+	return c.FunctionStore
+}
+
+// GetFunction returns a function's metadata from the cache, or from the
+// wrapped store on a miss
+func (c *CachingFunctionStore) GetFunction(ctx context.Context, functionID string) (models.FunctionMetadata, error) {
+	c.mutex.Lock()
+	if metadata, ok := c.functions.get(functionID); ok {
+		c.mutex.Unlock()
+		return metadata, nil
+	}
+	generation := c.generation
+	c.mutex.Unlock()
+
+	metadata, err := c.FunctionStore.GetFunction(ctx, functionID)
+	if err != nil {
+		return metadata, err
+	}
+
+	c.mutex.Lock()
+	if c.generation == generation {
+		c.functions.put(functionID, metadata)
+	}
+	c.mutex.Unlock()
+	return metadata, nil
+}
+
+// GetFunctionByName resolves a name to a function ID from the cache, or from
+// the wrapped store on a miss. Names that match no function or several are
+// not cached.
+func (c *CachingFunctionStore) GetFunctionByName(ctx context.Context, name string) (models.FunctionMetadata, error) {
+	c.mutex.Lock()
+	functionID, ok := c.names[name]
+	c.mutex.Unlock()
+	if ok {
+		return c.GetFunction(ctx, functionID)
+	}
+
+	c.mutex.Lock()
+	generation := c.generation
+	c.mutex.Unlock()
+
+	metadata, err := c.FunctionStore.GetFunctionByName(ctx, name)
+	if err != nil {
+		return metadata, err
+	}
+
+	c.mutex.Lock()
+	if c.generation == generation {
+		// Names are only evicted on writes, so bound them by the cache size
+		if len(c.names) >= c.functions.size {
+			clear(c.names)
+		}
+		c.names[name] = metadata.FunctionID
+		c.functions.put(metadata.FunctionID, metadata)
+	}
+	c.mutex.Unlock()
+	return metadata, nil
+}
+
+// StoreFunction stores a function and forgets cached names, since the new
+// function may share a name with a cached one
+func (c *CachingFunctionStore) StoreFunction(ctx context.Context, metadata models.FunctionMetadata) error {
+	defer c.invalidate(metadata.FunctionID, true)
+	return c.FunctionStore.StoreFunction(ctx, metadata)
+}
+
+// UpdateFunction updates a function and forgets its cached metadata and the
+// cached names, since the update may rename it
+func (c *CachingFunctionStore) UpdateFunction(ctx context.Context, functionID string, apply func(*models.FunctionMetadata) error) (models.FunctionMetadata, error) {
+	defer c.invalidate(functionID, true)
+	return c.FunctionStore.UpdateFunction(ctx, functionID, apply)
+}
+
+// UpdateName renames a function and forgets its cached metadata and the
+// cached names
+func (c *CachingFunctionStore) UpdateName(ctx context.Context, functionID, name string) (models.FunctionMetadata, error) {
+	defer c.invalidate(functionID, true)
+	return c.FunctionStore.UpdateName(ctx, functionID, name)
+}
+
+// StoreFunctionEvicting stores a function, evicting others to make room, and
+// forgets the cached names along with the cached metadata of every evicted
+// function
+func (c *CachingFunctionStore) StoreFunctionEvicting(ctx context.Context, metadata models.FunctionMetadata) ([]models.FunctionMetadata, error) {
+	evicted, err := c.FunctionStore.StoreFunctionEvicting(ctx, metadata)
+	c.invalidate(metadata.FunctionID, true)
+	for _, victim := range evicted {
+		c.invalidate(victim.FunctionID, false)
+	}
+	return evicted, err
+}
+
+// RecordExecution records an execution and forgets the function's cached
+// metadata, whose invocation count and last execution time it changes
+func (c *CachingFunctionStore) RecordExecution(ctx context.Context, record models.ExecutionRecord) error {
+	defer c.invalidate(record.FunctionID, false)
+	return c.FunctionStore.RecordExecution(ctx, record)
+}
+
+// DeleteFunction deletes a function and forgets its cached metadata and the
+// cached names
+func (c *CachingFunctionStore) DeleteFunction(ctx context.Context, functionID string) error {
+	defer c.invalidate(functionID, true)
+	return c.FunctionStore.DeleteFunction(ctx, functionID)
+}
+
+// Reconcile reconciles the wrapped store and empties the cache, since any
+// function may have been removed
+func (c *CachingFunctionStore) Reconcile(ctx context.Context, images ImageSource) (models.ReconcileReport, error) {
+	defer c.invalidateAll()
+	return c.FunctionStore.Reconcile(ctx, images)
+}
+
+// ImportAll imports functions into the wrapped store and empties the cache,
+// since any function may have been overwritten
+func (c *CachingFunctionStore) ImportAll(ctx context.Context, functions []models.FunctionMetadata, overwrite bool) (models.ImportReport, error) {
+	defer c.invalidateAll()
+	return c.FunctionStore.ImportAll(ctx, functions, overwrite)
+}
+
+// invalidate forgets the cached metadata of a function and, if names is set,
+// every cached name
+func (c *CachingFunctionStore) invalidate(functionID string, names bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.functions.remove(functionID)
+	if names {
+		clear(c.names)
+	}
+}
+
+// invalidateAll empties the cache
+func (c *CachingFunctionStore) invalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.functions = newLRUCache(c.functions.size)
+	clear(c.names)
+}
+
+// lruCache is a fixed-size cache of function metadata that evicts the least
+// recently used entry. It is not safe for concurrent use.
+type lruCache struct {
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+// lruEntry is the value of an element of lruCache.order
+type lruEntry struct {
+	key   string
+	value models.FunctionMetadata
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (l *lruCache) get(key string) (models.FunctionMetadata, bool) {
+	element, ok := l.entries[key]
+	if !ok {
+		return models.FunctionMetadata{}, false
+	}
+	l.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (l *lruCache) put(key string, value models.FunctionMetadata) {
+	if element, ok := l.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		l.order.MoveToFront(element)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (l *lruCache) remove(key string) {
+	if element, ok := l.entries[key]; ok {
+		l.order.Remove(element)
+		delete(l.entries, key)
+	}
+}
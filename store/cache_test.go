@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"youtube_serverless/models"
+)
+
+// countingStore counts the lookups that reach the store it wraps
+type countingStore struct {
+	FunctionStore
+	lookups int
+}
+
+func (s *countingStore) GetFunction(ctx context.Context, functionID string) (models.FunctionMetadata, error) {
+	s.lookups++
+	return s.FunctionStore.GetFunction(ctx, functionID)
+}
+
+func (s *countingStore) GetFunctionByName(ctx context.Context, name string) (models.FunctionMetadata, error) {
+	s.lookups++
+	return s.FunctionStore.GetFunctionByName(ctx, name)
+}
+
+// newCachingTestStore creates a CachingFunctionStore of the given size over
+// an in-memory store whose lookups are counted
+func newCachingTestStore(size int) (*CachingFunctionStore, *countingStore) {
+	inner := &countingStore{FunctionStore: NewFunctionStore(0, false, 0)}
+	return NewCachingFunctionStore(inner, size), inner
+}
+
+func TestCachingFunctionStoreServesRepeatedLookups(t *testing.T) {
+	c, inner := newCachingTestStore(10)
+	ctx := context.Background()
+	storeFunctions(t, c, "function")
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetFunction(ctx, "function"); err != nil {
+			t.Fatalf("GetFunction: %v", err)
+		}
+		if _, err := c.GetFunctionByName(ctx, "function"); err != nil {
+			t.Fatalf("GetFunctionByName: %v", err)
+		}
+	}
+	if inner.lookups != 2 {
+		t.Errorf("%d lookups reached the store, want 2", inner.lookups)
+	}
+
+	// Misses aren't cached, so a function stored later is found
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetFunction(ctx, "missing"); !errors.Is(err, ErrFunctionNotFound) {
+			t.Errorf("GetFunction(missing) = %v, want ErrFunctionNotFound", err)
+		}
+	}
+	if inner.lookups != 4 {
+		t.Errorf("%d lookups reached the store, want misses to reach it every time", inner.lookups)
+	}
+}
+
+func TestCachingFunctionStoreIsFreshAfterWrites(t *testing.T) {
+	ctx := context.Background()
+
+	// Each write changes or removes a function whose ID and name lookups
+	// are cached, and check says whether the lookups see the change
+	tests := []struct {
+		name  string
+		write func(t *testing.T, c *CachingFunctionStore)
+		check func(t *testing.T, c *CachingFunctionStore)
+	}{
+		{
+			name: "store",
+			write: func(t *testing.T, c *CachingFunctionStore) {
+				if err := c.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function", Name: "function", ImageID: "image-2"}); err != nil {
+					t.Fatalf("StoreFunction: %v", err)
+				}
+			},
+			check: wantImage("image-2"),
+		},
+		{
+			name: "store evicting",
+			write: func(t *testing.T, c *CachingFunctionStore) {
+				if _, err := c.StoreFunctionEvicting(ctx, models.FunctionMetadata{FunctionID: "function", Name: "function", ImageID: "image-2"}); err != nil {
+					t.Fatalf("StoreFunctionEvicting: %v", err)
+				}
+			},
+			check: wantImage("image-2"),
+		},
+		{
+			name: "update",
+			write: func(t *testing.T, c *CachingFunctionStore) {
+				if _, err := c.UpdateFunction(ctx, "function", func(metadata *models.FunctionMetadata) error {
+					metadata.ImageID = "image-2"
+					return nil
+				}); err != nil {
+					t.Fatalf("UpdateFunction: %v", err)
+				}
+			},
+			check: wantImage("image-2"),
+		},
+		{
+			name: "rename",
+			write: func(t *testing.T, c *CachingFunctionStore) {
+				if _, err := c.UpdateName(ctx, "function", "renamed"); err != nil {
+					t.Fatalf("UpdateName: %v", err)
+				}
+			},
+			check: func(t *testing.T, c *CachingFunctionStore) {
+				if got, err := c.GetFunction(ctx, "function"); err != nil || got.Name != "renamed" {
+					t.Errorf("GetFunction = %q, %v; want renamed", got.Name, err)
+				}
+				if _, err := c.GetFunctionByName(ctx, "function"); !errors.Is(err, ErrFunctionNotFound) {
+					t.Errorf("GetFunctionByName(old name) = %v, want ErrFunctionNotFound", err)
+				}
+			},
+		},
+		{
+			name: "record execution",
+			write: func(t *testing.T, c *CachingFunctionStore) {
+				if err := c.RecordExecution(ctx, models.ExecutionRecord{FunctionID: "function", ExecutionID: "execution", Success: true, ExecutedAt: time.Now().Unix()}); err != nil {
+					t.Fatalf("RecordExecution: %v", err)
+				}
+			},
+			check: func(t *testing.T, c *CachingFunctionStore) {
+				for _, lookup := range lookups(c) {
+					if got, err := lookup(ctx); err != nil || got.InvocationCount != 1 {
+						t.Errorf("lookup = %d invocations, %v; want 1", got.InvocationCount, err)
+					}
+				}
+			},
+		},
+		{
+			name: "delete",
+			write: func(t *testing.T, c *CachingFunctionStore) {
+				if err := c.DeleteFunction(ctx, "function"); err != nil {
+					t.Fatalf("DeleteFunction: %v", err)
+				}
+			},
+			check: func(t *testing.T, c *CachingFunctionStore) {
+				for _, lookup := range lookups(c) {
+					if _, err := lookup(ctx); !errors.Is(err, ErrFunctionNotFound) {
+						t.Errorf("lookup after delete = %v, want ErrFunctionNotFound", err)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newCachingTestStore(10)
+			if err := c.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function", Name: "function", ImageID: "image-1"}); err != nil {
+				t.Fatalf("StoreFunction: %v", err)
+			}
+			// Cache both lookups before the write
+			for _, lookup := range lookups(c) {
+				if _, err := lookup(ctx); err != nil {
+					t.Fatalf("lookup: %v", err)
+				}
+			}
+
+			tt.write(t, c)
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestCachingFunctionStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	c, inner := newCachingTestStore(2)
+	ctx := context.Background()
+	storeFunctions(t, c, "a", "b", "c")
+
+	for _, id := range []string{"a", "b", "a", "c"} {
+		if _, err := c.GetFunction(ctx, id); err != nil {
+			t.Fatalf("GetFunction(%s): %v", id, err)
+		}
+	}
+	if inner.lookups != 3 {
+		t.Fatalf("%d lookups reached the store, want 3", inner.lookups)
+	}
+
+	// c pushed out b, the least recently used, and kept a
+	if _, err := c.GetFunction(ctx, "a"); err != nil {
+		t.Fatalf("GetFunction(a): %v", err)
+	}
+	if inner.lookups != 3 {
+		t.Errorf("a was evicted, though used after b")
+	}
+	if _, err := c.GetFunction(ctx, "b"); err != nil {
+		t.Fatalf("GetFunction(b): %v", err)
+	}
+	if inner.lookups != 4 {
+		t.Errorf("b was still cached past the cache size")
+	}
+}
+
+func TestCachingFunctionStoreEmptiesOnImport(t *testing.T) {
+	c, _ := newCachingTestStore(10)
+	ctx := context.Background()
+	storeFunctions(t, c, "function")
+	if _, err := c.GetFunction(ctx, "function"); err != nil {
+		t.Fatalf("GetFunction: %v", err)
+	}
+
+	if _, err := c.ImportAll(ctx, []models.FunctionMetadata{{FunctionID: "function", Name: "function", ImageID: "imported"}}, true); err != nil {
+		t.Fatalf("ImportAll: %v", err)
+	}
+	if got, err := c.GetFunction(ctx, "function"); err != nil || got.ImageID != "imported" {
+		t.Errorf("GetFunction after import = %q, %v; want imported", got.ImageID, err)
+	}
+}
+
+// lookups returns the ID and name lookups of the function stored as
+// "function" under that name
+func lookups(c *CachingFunctionStore) []func(context.Context) (models.FunctionMetadata, error) {
+	return []func(context.Context) (models.FunctionMetadata, error){
+		func(ctx context.Context) (models.FunctionMetadata, error) { return c.GetFunction(ctx, "function") },
+		func(ctx context.Context) (models.FunctionMetadata, error) {
+			return c.GetFunctionByName(ctx, "function")
+		},
+	}
+}
+
+// wantImage checks that both lookups return the image
+func wantImage(imageID string) func(t *testing.T, c *CachingFunctionStore) {
+	return func(t *testing.T, c *CachingFunctionStore) {
+		for _, lookup := range lookups(c) {
+			if got, err := lookup(context.Background()); err != nil || got.ImageID != imageID {
+				t.Errorf("lookup = %q, %v; want %s", got.ImageID, err, imageID)
+			}
+		}
+	}
+}
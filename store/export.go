@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// ExportAll returns every stored function, oldest first, for a backup.
+// Execution history is not included.
+func (fs *functionStore) ExportAll(ctx context.Context) []models.FunctionMetadata {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	functions := make([]models.FunctionMetadata, 0, len(fs.functions))
+	for _, metadata := range fs.functions {
+		functions = append(functions, metadata)
+	}
+	fs.mutex.RUnlock()
+
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].CreatedAt != functions[j].CreatedAt {
+			return functions[i].CreatedAt < functions[j].CreatedAt
+		}
+		return functions[i].FunctionID < functions[j].FunctionID
+	})
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("count", len(functions)).
+		Msg("Exported functions")
+
+	return functions
+}
+
+// ImportAll stores functions from a backup under one lock. Functions whose ID
+// is already stored are skipped unless overwrite is set. Functions that break
+// the unique name rule or the function limit are left out, with their error
+// in the report. A persistence failure stops the import and is returned along
+// with the report of what was imported before it.
+func (fs *functionStore) ImportAll(ctx context.Context, functions []models.FunctionMetadata, overwrite bool) (models.ImportReport, error) {
+	requestID := requestctx.ID(ctx)
+	report := models.ImportReport{
+		Imported: []string{},
+		Skipped:  []string{},
+		Rebuilt:  []string{},
+		Errors:   map[string]string{},
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for _, metadata := range functions {
+		previous, exists := fs.functions[metadata.FunctionID]
+		if exists && !overwrite {
+			report.Skipped = append(report.Skipped, metadata.FunctionID)
+			continue
+		}
+
+		if err := fs.checkName(metadata.FunctionID, metadata.Name); err != nil {
+			report.Errors[metadata.FunctionID] = err.Error()
+			continue
+		}
+		if err := fs.checkLimit(metadata.FunctionID); err != nil {
+			report.Errors[metadata.FunctionID] = err.Error()
+			continue
+		}
+
+		metadata.RunningCount = 0
+		if err := fs.save(metadata); err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("function_id", metadata.FunctionID).
+				Err(err).
+				Msg("Failed to persist imported function")
+			return report, fmt.Errorf("failed to import function %s: %w", metadata.FunctionID, err)
+		}
+
+		if exists {
+			fs.names.remove(previous)
+			fs.tags.remove(previous)
+		}
+		fs.functions[metadata.FunctionID] = metadata
+		fs.names.add(metadata)
+		fs.tags.add(metadata)
+		report.Imported = append(report.Imported, metadata.FunctionID)
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("imported", len(report.Imported)).
+		Int("skipped", len(report.Skipped)).
+		Int("failed", len(report.Errors)).
+		Bool("overwrite", overwrite).
+		Msg("Imported functions")
+
+	return report, nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// MaxRecordedOutput is the most output kept per execution record, in bytes
+const MaxRecordedOutput = 4 << 10 // 4 KB
+
+// executionRing holds the most recent executions of one function, overwriting
+// the oldest record once full
+type executionRing struct {
+	records []models.ExecutionRecord
+	next    int // where the next record goes once the ring is full
+}
+
+// add appends a record, evicting the oldest if the ring holds size records
+func (r *executionRing) add(record models.ExecutionRecord, size int) {
+	if len(r.records) < size {
+		r.records = append(r.records, record)
+		return
+	}
+	r.records[r.next] = record
+	r.next = (r.next + 1) % size
+}
+
+// newest returns up to limit records, newest first
+func (r *executionRing) newest(limit int) []models.ExecutionRecord {
+	n := len(r.records)
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	records := make([]models.ExecutionRecord, 0, limit)
+	for i := 0; i < limit; i++ {
+		// The newest record sits just before next, wrapping around
+		records = append(records, r.records[(r.next-1-i+2*n)%n])
+	}
+	return records
+}
+
+// RecordExecution adds an execution to the function's history, counts it in
+// the function's invocation count and, if it succeeded, updates the
+// function's last executed timestamp
+func (fs *functionStore) RecordExecution(ctx context.Context, record models.ExecutionRecord) error {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	metadata, ok := fs.functions[record.FunctionID]
+	if !ok {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", record.FunctionID).
+			Msg("Function not found for execution record")
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, record.FunctionID)
+	}
+
+	if len(record.Output) > MaxRecordedOutput {
+		record.Output = record.Output[:MaxRecordedOutput]
+		record.Truncated = true
+	}
+	if fs.historySize > 0 {
+		ring, ok := fs.history[record.FunctionID]
+		if !ok {
+			ring = &executionRing{}
+			fs.history[record.FunctionID] = ring
+		}
+		ring.add(record, fs.historySize)
+	}
+
+	metadata.InvocationCount++
+	if record.Success {
+		metadata.LastExecuted = record.ExecutedAt
+	}
+	if err := fs.save(metadata); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", record.FunctionID).
+			Err(err).
+			Msg("Failed to persist execution statistics")
+		return err
+	}
+	fs.functions[record.FunctionID] = metadata
+
+	log.Debug().
+		Str("request_id", requestID).
+		Str("function_id", record.FunctionID).
+		Int64("invocation_count", metadata.InvocationCount).
+		Int64("last_executed", metadata.LastExecuted).
+		Msg("Function execution statistics updated")
+
+	return nil
+}
+
+// ListExecutions returns up to limit of the function's most recent
+// executions, newest first. A limit of zero returns every retained record.
+func (fs *functionStore) ListExecutions(ctx context.Context, functionID string, limit int) ([]models.ExecutionRecord, error) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	if _, ok := fs.functions[functionID]; !ok {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Msg("Function not found for execution history")
+		return nil, fmt.Errorf("%w: %s", ErrFunctionNotFound, functionID)
+	}
+
+	ring, ok := fs.history[functionID]
+	if !ok {
+		return []models.ExecutionRecord{}, nil
+	}
+	return ring.newest(limit), nil
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// Sort fields accepted by ListFunctionsFiltered
+const (
+	SortByCreatedAt    = "createdAt"
+	SortByLastExecuted = "lastExecuted"
+	SortByName         = "name"
+)
+
+// Sort orders accepted by ListFunctionsFiltered
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// ListOptions filters, sorts and paginates a function listing
+type ListOptions struct {
+	Language string   // exact match; empty matches all
+	Name     string   // case-insensitive substring match; empty matches all
+	Tags     []string // tag keys or key:value pairs, all of which must match
+	SortBy   string   // one of the SortBy constants; defaults to createdAt
+	Order    string   // asc or desc; defaults to asc
+	Limit    int      // zero or less returns every match
+	Offset   int
+}
+
+// Matches reports whether a function passes the language, name and tag
+// filters of opts
+func (opts ListOptions) Matches(metadata models.FunctionMetadata) bool {
+	if opts.Language != "" && metadata.Language != opts.Language {
+		return false
+	}
+	if opts.Name != "" && !strings.Contains(strings.ToLower(metadata.Name), strings.ToLower(opts.Name)) {
+		return false
+	}
+	for _, filter := range opts.Tags {
+		key, value, hasValue := strings.Cut(filter, ":")
+		tag, ok := metadata.Tags[key]
+		if !ok || (hasValue && tag != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListFunctionsFiltered returns one page of the functions matching opts along
+// with the total number of matches. Ties are broken by function ID so the
+// order is stable across calls.
+func (fs *functionStore) ListFunctionsFiltered(ctx context.Context, opts ListOptions) ([]models.FunctionMetadata, int) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	candidates := fs.functions
+	if len(opts.Tags) > 0 {
+		// Only look at the functions with the tags rather than scanning all
+		candidates = make(map[string]models.FunctionMetadata)
+		for id := range fs.tags.match(opts.Tags) {
+			candidates[id] = fs.functions[id]
+		}
+	}
+	matches := make([]models.FunctionMetadata, 0, len(candidates))
+	for _, metadata := range candidates {
+		if opts.Matches(metadata) {
+			matches = append(matches, metadata)
+		}
+	}
+	fs.mutex.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if opts.Order == OrderDesc {
+			a, b = b, a
+		}
+		switch opts.SortBy {
+		case SortByLastExecuted:
+			if a.LastExecuted != b.LastExecuted {
+				return a.LastExecuted < b.LastExecuted
+			}
+		case SortByName:
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		default:
+			if a.CreatedAt != b.CreatedAt {
+				return a.CreatedAt < b.CreatedAt
+			}
+		}
+		return a.FunctionID < b.FunctionID
+	})
+
+	total := len(matches)
+	start := min(max(opts.Offset, 0), total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+
+	log.Debug().
+		Str("request_id", requestID).
+		Int("total", total).
+		Int("offset", start).
+		Int("count", end-start).
+		Msg("Listed filtered functions")
+
+	return matches[start:end], total
+}
+
+// ForEachFunction calls fn with every stored function, oldest first, without
+// holding the store's lock while fn runs, so a slow consumer such as a
+// streaming response doesn't block writes. Only the IDs are collected up
+// front; functions deleted before their turn are skipped and changes made
+// meanwhile are seen. It stops at the first error from fn or once ctx ends,
+// and returns that error.
+func (fs *functionStore) ForEachFunction(ctx context.Context, fn func(models.FunctionMetadata) error) error {
+	type entry struct {
+		id        string
+		createdAt int64
+	}
+
+	fs.mutex.RLock()
+	entries := make([]entry, 0, len(fs.functions))
+	for id, metadata := range fs.functions {
+		entries = append(entries, entry{id: id, createdAt: metadata.CreatedAt})
+	}
+	fs.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].createdAt != entries[j].createdAt {
+			return entries[i].createdAt < entries[j].createdAt
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fs.mutex.RLock()
+		metadata, ok := fs.functions[entry.id]
+		fs.mutex.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := fn(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
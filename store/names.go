@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// Errors returned for function names
+var (
+	// ErrNameTaken is returned when unique names are enforced and another
+	// function already has the name
+	ErrNameTaken = errors.New("function name already in use")
+
+	// ErrAmbiguousName is returned by GetFunctionByName when several functions
+	// share the name
+	ErrAmbiguousName = errors.New("function name is ambiguous")
+)
+
+// nameIndex maps function names to the IDs of the functions that have them
+type nameIndex map[string]map[string]struct{}
+
+// add records that the function has its name
+func (idx nameIndex) add(metadata models.FunctionMetadata) {
+	if metadata.Name == "" {
+		return
+	}
+	ids, ok := idx[metadata.Name]
+	if !ok {
+		ids = make(map[string]struct{})
+		idx[metadata.Name] = ids
+	}
+	ids[metadata.FunctionID] = struct{}{}
+}
+
+// remove forgets the function's name
+func (idx nameIndex) remove(metadata models.FunctionMetadata) {
+	ids := idx[metadata.Name]
+	delete(ids, metadata.FunctionID)
+	if len(ids) == 0 {
+		delete(idx, metadata.Name)
+	}
+}
+
+// checkName returns ErrNameTaken if unique names are enforced and a function
+// other than functionID has the name. Functions left with the default name
+// never collide. Callers must hold the lock.
+func (fs *functionStore) checkName(functionID, name string) error {
+	if !fs.uniqueNames || name == "" || name == models.DefaultFunctionName {
+		return nil
+	}
+	for id := range fs.names[name] {
+		if id != functionID {
+			return fmt.Errorf("%w: %s", ErrNameTaken, name)
+		}
+	}
+	return nil
+}
+
+// UpdateName renames a function, keeping its ID and image. The collision
+// check for unique names and the rename happen under one lock, so of two
+// concurrent renames to the same name only one succeeds.
+func (fs *functionStore) UpdateName(ctx context.Context, functionID, name string) (models.FunctionMetadata, error) {
+	return fs.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+		metadata.Name = name
+		return nil
+	})
+}
+
+// GetFunctionByName retrieves the function with the given name. It returns
+// ErrFunctionNotFound if no function has the name, and ErrAmbiguousName if
+// more than one does.
+func (fs *functionStore) GetFunctionByName(ctx context.Context, name string) (models.FunctionMetadata, error) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	ids := fs.names[name]
+	switch len(ids) {
+	case 0:
+		log.Warn().
+			Str("request_id", requestID).
+			Str("name", name).
+			Msg("Function not found by name")
+		return models.FunctionMetadata{}, fmt.Errorf("%w: name %s", ErrFunctionNotFound, name)
+	case 1:
+		for id := range ids {
+			return fs.functions[id], nil
+		}
+	}
+
+	matches := make([]string, 0, len(ids))
+	for id := range ids {
+		matches = append(matches, id)
+	}
+	sort.Strings(matches)
+	return models.FunctionMetadata{}, fmt.Errorf("%w: %s is used by functions %s", ErrAmbiguousName, name, strings.Join(matches, ", "))
+}
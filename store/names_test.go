@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+func TestUpdateNameConcurrentRenameToSameTarget(t *testing.T) {
+	const rounds = 20
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			fs := newTestStore(t, backend, func(cfg *config.StoreConfig) {
+				cfg.UniqueNames = true
+			})
+			ctx := context.Background()
+
+			for round := 0; round < rounds; round++ {
+				target := fmt.Sprintf("target-%d", round)
+				ids := []string{fmt.Sprintf("a-%d", round), fmt.Sprintf("b-%d", round)}
+				for _, id := range ids {
+					if err := fs.StoreFunction(ctx, models.FunctionMetadata{FunctionID: id, Name: id, ImageID: "image-" + id}); err != nil {
+						t.Fatalf("StoreFunction: %v", err)
+					}
+				}
+
+				// Both renames start together
+				start := make(chan struct{})
+				errs := make([]error, len(ids))
+				var wg sync.WaitGroup
+				for i, id := range ids {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						<-start
+						_, errs[i] = fs.UpdateName(ctx, id, target)
+					}()
+				}
+				close(start)
+				wg.Wait()
+
+				renamed := ""
+				for i, err := range errs {
+					switch {
+					case err == nil:
+						if renamed != "" {
+							t.Fatalf("round %d: both functions renamed to %s", round, target)
+						}
+						renamed = ids[i]
+					case !errors.Is(err, ErrNameTaken):
+						t.Fatalf("round %d: UpdateName(%s) = %v, want ErrNameTaken for the loser", round, ids[i], err)
+					}
+				}
+				if renamed == "" {
+					t.Fatalf("round %d: neither rename succeeded: %v", round, errs)
+				}
+
+				// The winner keeps its ID and image; the loser is unchanged
+				got, err := fs.GetFunctionByName(ctx, target)
+				if err != nil || got.FunctionID != renamed || got.ImageID != "image-"+renamed {
+					t.Errorf("round %d: %s resolves to %+v, %v; want %s", round, target, got, err, renamed)
+				}
+				for _, id := range ids {
+					if id == renamed {
+						continue
+					}
+					if got, err := fs.GetFunction(ctx, id); err != nil || got.Name != id {
+						t.Errorf("round %d: loser %s has name %q, %v; want it unchanged", round, id, got.Name, err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// ErrFunctionLimitReached is returned when a new function would take the store
+// past its maximum number of functions
+var ErrFunctionLimitReached = errors.New("function limit reached")
+
+// checkLimit returns ErrFunctionLimitReached if storing functionID would add a
+// function to a store that already holds its maximum. Replacing an existing
+// function is always allowed. Callers must hold the lock.
+func (fs *functionStore) checkLimit(functionID string) error {
+	if fs.maxFunctions <= 0 || len(fs.functions) < fs.maxFunctions {
+		return nil
+	}
+	if _, ok := fs.functions[functionID]; ok {
+		return nil
+	}
+	return fmt.Errorf("%w: at most %d functions may be deployed", ErrFunctionLimitReached, fs.maxFunctions)
+}
+
+// StoreFunctionEvicting stores function metadata like StoreFunction, but when
+// the store already holds its maximum number of functions it first deletes the
+// least recently executed ones until the new function fits. The function being
+// stored is never evicted to make room for itself. It returns the evicted
+// functions, which are gone even if storing then fails, so the caller can clean
+// up after them.
+func (fs *functionStore) StoreFunctionEvicting(ctx context.Context, metadata models.FunctionMetadata) ([]models.FunctionMetadata, error) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	// A name conflict fails the store anyway, so don't evict anything for it
+	if err := fs.checkName(metadata.FunctionID, metadata.Name); err != nil {
+		return nil, fs.store(ctx, metadata)
+	}
+
+	var evicted []models.FunctionMetadata
+	for fs.checkLimit(metadata.FunctionID) != nil {
+		victim, ok := fs.leastRecentlyExecuted(metadata.FunctionID)
+		if !ok {
+			break
+		}
+		if err := fs.remove(ctx, victim); err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, victim)
+
+		event := log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", victim.FunctionID).
+			Str("name", victim.Name).
+			Str("image_id", victim.ImageID).
+			Int("max_functions", fs.maxFunctions)
+		if victim.LastExecuted > 0 {
+			event = event.Time("last_executed", time.Unix(victim.LastExecuted, 0))
+		} else {
+			event = event.Time("created_at", time.Unix(victim.CreatedAt, 0))
+		}
+		event.Msg("Evicted least recently executed function to stay within the function limit")
+	}
+
+	return evicted, fs.store(ctx, metadata)
+}
+
+// leastRecentlyExecuted returns the function other than exclude that has gone
+// longest without executing, counting functions that never executed from their
+// creation. Ties go to the lowest function ID. Callers must hold the lock.
+func (fs *functionStore) leastRecentlyExecuted(exclude string) (models.FunctionMetadata, bool) {
+	var oldest models.FunctionMetadata
+	var oldestAt int64
+	found := false
+	for _, metadata := range fs.functions {
+		if metadata.FunctionID == exclude {
+			continue
+		}
+		at := metadata.LastExecuted
+		if at == 0 {
+			at = metadata.CreatedAt
+		}
+		if !found || at < oldestAt || (at == oldestAt && metadata.FunctionID < oldest.FunctionID) {
+			oldest, oldestAt, found = metadata, at, true
+		}
+	}
+	return oldest, found
+}
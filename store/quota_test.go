@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+func TestStoreFunctionLimit(t *testing.T) {
+	const limit = 3
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			fs := newTestStore(t, backend, func(cfg *config.StoreConfig) {
+				cfg.MaxFunctions = limit
+			})
+			ctx := context.Background()
+
+			// Functions up to the limit are stored, the one past it isn't
+			for i := 1; i <= limit+1; i++ {
+				err := fs.StoreFunction(ctx, models.FunctionMetadata{FunctionID: fmt.Sprintf("function-%d", i), CreatedAt: int64(i)})
+				if i <= limit && err != nil {
+					t.Fatalf("storing function %d of %d: %v", i, limit, err)
+				}
+				if i > limit && !errors.Is(err, ErrFunctionLimitReached) {
+					t.Fatalf("storing function %d of %d: %v, want ErrFunctionLimitReached", i, limit, err)
+				}
+			}
+
+			// Replacing a stored function at the limit is allowed
+			if err := fs.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function-1", ImageID: "image"}); err != nil {
+				t.Errorf("replacing a function at the limit: %v", err)
+			}
+			if n := len(fs.ListFunctions(ctx)); n != limit {
+				t.Errorf("%d functions stored, want %d", n, limit)
+			}
+		})
+	}
+}
+
+func TestStoreFunctionEvicting(t *testing.T) {
+	const limit = 3
+
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			fs := newTestStore(t, backend, func(cfg *config.StoreConfig) {
+				cfg.MaxFunctions = limit
+			})
+			ctx := context.Background()
+
+			// function-2 executed most recently, function-3 never executed but
+			// was created after function-1 last executed
+			stored := []models.FunctionMetadata{
+				{FunctionID: "function-1", Name: "first", CreatedAt: 1, LastExecuted: 5},
+				{FunctionID: "function-2", CreatedAt: 2, LastExecuted: 20},
+				{FunctionID: "function-3", CreatedAt: 10},
+			}
+			for i, metadata := range stored {
+				evicted, err := fs.StoreFunctionEvicting(ctx, metadata)
+				if err != nil || len(evicted) != 0 {
+					t.Fatalf("storing function %d of %d evicted %v: %v", i+1, limit, evicted, err)
+				}
+				if err := fs.RecordExecution(ctx, models.ExecutionRecord{FunctionID: metadata.FunctionID}); err != nil {
+					t.Fatalf("RecordExecution: %v", err)
+				}
+			}
+
+			// Replacing a stored function at the limit evicts nothing, not
+			// even the function itself when it is the least recent
+			evicted, err := fs.StoreFunctionEvicting(ctx, models.FunctionMetadata{FunctionID: "function-1", Name: "first", CreatedAt: 1, LastExecuted: 5, ImageID: "image"})
+			if err != nil || len(evicted) != 0 {
+				t.Fatalf("replacing a function at the limit evicted %v: %v", evicted, err)
+			}
+
+			// One past the limit evicts the least recently executed function
+			evicted, err = fs.StoreFunctionEvicting(ctx, models.FunctionMetadata{FunctionID: "function-4", CreatedAt: 30})
+			if err != nil {
+				t.Fatalf("storing past the limit: %v", err)
+			}
+			if len(evicted) != 1 || evicted[0].FunctionID != "function-1" || evicted[0].ImageID != "image" {
+				t.Fatalf("evicted %+v, want function-1", evicted)
+			}
+			if _, err := fs.GetFunction(ctx, "function-1"); !errors.Is(err, ErrFunctionNotFound) {
+				t.Errorf("evicted function is still stored: %v", err)
+			}
+			if _, err := fs.GetFunctionByName(ctx, "first"); !errors.Is(err, ErrFunctionNotFound) {
+				t.Errorf("evicted function's name still resolves: %v", err)
+			}
+			if history, _ := fs.ListExecutions(ctx, "function-1", 0); len(history) != 0 {
+				t.Errorf("evicted function's history kept: %v", history)
+			}
+			if _, err := fs.GetFunction(ctx, "function-4"); err != nil {
+				t.Errorf("new function not stored: %v", err)
+			}
+
+			// Then the function that never executed, counting from its creation
+			evicted, err = fs.StoreFunctionEvicting(ctx, models.FunctionMetadata{FunctionID: "function-5", CreatedAt: 40})
+			if err != nil || len(evicted) != 1 || evicted[0].FunctionID != "function-3" {
+				t.Errorf("second eviction = %+v, %v; want function-3", evicted, err)
+			}
+			if n := len(fs.ListFunctions(ctx)); n != limit {
+				t.Errorf("%d functions stored, want %d", n, limit)
+			}
+		})
+	}
+}
+
+func TestStoreFunctionEvictingNameConflictEvictsNothing(t *testing.T) {
+	fs := newTestStore(t, "memory", func(cfg *config.StoreConfig) {
+		cfg.MaxFunctions = 1
+		cfg.UniqueNames = true
+	})
+	ctx := context.Background()
+
+	if err := fs.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function-1", Name: "greeter"}); err != nil {
+		t.Fatalf("StoreFunction: %v", err)
+	}
+	evicted, err := fs.StoreFunctionEvicting(ctx, models.FunctionMetadata{FunctionID: "function-2", Name: "greeter"})
+	if !errors.Is(err, ErrNameTaken) {
+		t.Errorf("StoreFunctionEvicting = %v, want ErrNameTaken", err)
+	}
+	if len(evicted) != 0 {
+		t.Errorf("evicted %v for a submission that can't be stored", evicted)
+	}
+	if _, err := fs.GetFunction(ctx, "function-1"); err != nil {
+		t.Errorf("existing function was removed: %v", err)
+	}
+}
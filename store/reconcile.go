@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// ImageSource gives Reconcile access to the Docker images functions run on
+type ImageSource interface {
+	// ImageExists reports whether an image ID or tag is present
+	ImageExists(ctx context.Context, imageID string) (bool, error)
+	// ListImages returns the images built by the platform
+	ListImages(ctx context.Context) ([]image.Summary, error)
+}
+
+// Reconcile brings stored metadata in line with the images present in
+// Docker. Functions whose image no longer exists are deleted, and platform
+// images that no function uses are logged as orphaned but left in place.
+func (fs *functionStore) Reconcile(ctx context.Context, images ImageSource) (models.ReconcileReport, error) {
+	requestID := requestctx.ID(ctx)
+	report := models.ReconcileReport{
+		RemovedFunctions: []string{},
+		OrphanedImages:   []string{},
+	}
+
+	// Each image is checked once, since identical code shares an image
+	exists := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, metadata := range fs.ListFunctions(ctx) {
+		found, checked := exists[metadata.ImageID]
+		if !checked {
+			var err error
+			found, err = images.ImageExists(ctx, metadata.ImageID)
+			if err != nil {
+				return report, fmt.Errorf("failed to check image %s: %v", metadata.ImageID, err)
+			}
+			exists[metadata.ImageID] = found
+		}
+		if found {
+			used[metadata.ImageID] = true
+			continue
+		}
+
+		if err := fs.DeleteFunction(ctx, metadata.FunctionID); err != nil {
+			return report, err
+		}
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", metadata.FunctionID).
+			Str("image_id", metadata.ImageID).
+			Msg("Removed function whose image no longer exists")
+		report.RemovedFunctions = append(report.RemovedFunctions, metadata.FunctionID)
+	}
+
+	summaries, err := images.ListImages(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to list images: %v", err)
+	}
+	for _, summary := range summaries {
+		// Functions refer to images by ID, or by tag if the build didn't report one
+		inUse := used[summary.ID]
+		for _, tag := range summary.RepoTags {
+			inUse = inUse || used[tag]
+		}
+		if inUse {
+			continue
+		}
+
+		log.Warn().
+			Str("request_id", requestID).
+			Str("image_id", summary.ID).
+			Strs("tags", summary.RepoTags).
+			Msg("Found image with no function")
+		report.OrphanedImages = append(report.OrphanedImages, summary.ID)
+	}
+
+	log.Info().
+		Str("request_id", requestID).
+		Int("removed_functions", len(report.RemovedFunctions)).
+		Int("orphaned_images", len(report.OrphanedImages)).
+		Msg("Reconciled functions with Docker images")
+
+	return report, nil
+}
@@ -0,0 +1,355 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// ErrStoreUnavailable is returned without calling the wrapped store while the
+// circuit breaker of a ResilientFunctionStore is open
+var ErrStoreUnavailable = errors.New("function store unavailable")
+
+// Circuit breaker states reported by BreakerState
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ResilientFunctionStore wraps a FunctionStore whose backend can fail
+// transiently, such as a database dropping a connection. Failed writes that
+// are safe to repeat are retried with exponential backoff, and once threshold
+// writes in a row have failed a circuit breaker opens so writes fail fast
+// with ErrStoreUnavailable for the cooldown. After it, one write is let
+// through to probe the store: success closes the breaker and failure opens
+// it again.
+//
+// Only writes are covered, because only writes reach the backend. The
+// persistent stores load every function and alias into memory when they
+// open, keep execution history only there and serve reads from memory, so GetFunction, GetFunctionByName,
+// GetByContentHash, ListExecutions, GetAlias, ResolveAlias and the listings
+// can't fail transiently. They are passed straight through, neither retried
+// nor counted by the breaker, and keep working while it is open. A backend
+// that reads from the database would need them wrapped too.
+//
+// Errors that describe the request rather than the store, such as
+// ErrFunctionNotFound, are neither retried nor counted as failures.
+type ResilientFunctionStore struct {
+	FunctionStore
+
+	retries   int           // retries after a failed attempt
+	backoff   time.Duration // delay before the first retry, doubled for each one after
+	threshold int           // consecutive failures that open the breaker; 0 disables it
+	cooldown  time.Duration // how long the breaker stays open
+
+	mutex     sync.Mutex
+	failures  int       // consecutive failed operations
+	openUntil time.Time // zero while closed
+	probing   bool      // a half-open probe is in flight
+}
+
+// NewResilientFunctionStore creates a ResilientFunctionStore that retries
+// operations on store up to retries times and opens its breaker for cooldown
+// after threshold consecutive failures
+func NewResilientFunctionStore(store FunctionStore, retries int, backoff time.Duration, threshold int, cooldown time.Duration) *ResilientFunctionStore {
+	return &ResilientFunctionStore{
+		FunctionStore: store,
+		retries:       retries,
+		backoff:       backoff,
+		threshold:     threshold,
+		cooldown:      cooldown,
+	}
+}
+
+// Unwrap returns the wrapped store
+func (s *ResilientFunctionStore) Unwrap() FunctionStore {
+	return s.FunctionStore
+}
+
+// StoreFunction stores a function, retrying transient failures. Storing is
+// keyed by function ID, so repeating it is safe.
+func (s *ResilientFunctionStore) StoreFunction(ctx context.Context, metadata models.FunctionMetadata) error {
+	return s.do(ctx, "StoreFunction", true, func() error {
+		return s.FunctionStore.StoreFunction(ctx, metadata)
+	})
+}
+
+// StoreFunctionEvicting stores a function, evicting others to make room,
+// retrying transient failures. Functions evicted by a failed attempt stay
+// evicted and make room for the next, so every attempt's evictions are
+// returned.
+func (s *ResilientFunctionStore) StoreFunctionEvicting(ctx context.Context, metadata models.FunctionMetadata) ([]models.FunctionMetadata, error) {
+	var evicted []models.FunctionMetadata
+	err := s.do(ctx, "StoreFunctionEvicting", true, func() error {
+		victims, err := s.FunctionStore.StoreFunctionEvicting(ctx, metadata)
+		evicted = append(evicted, victims...)
+		return err
+	})
+	return evicted, err
+}
+
+// UpdateFunction updates a function, retrying transient failures. A failed
+// update changes nothing, so apply can safely run again; errors from apply
+// itself are returned without retrying.
+func (s *ResilientFunctionStore) UpdateFunction(ctx context.Context, functionID string, apply func(*models.FunctionMetadata) error) (models.FunctionMetadata, error) {
+	var metadata models.FunctionMetadata
+	var applyErr error
+	err := s.do(ctx, "UpdateFunction", true, func() error {
+		var err error
+		applyErr = nil
+		metadata, err = s.FunctionStore.UpdateFunction(ctx, functionID, func(metadata *models.FunctionMetadata) error {
+			applyErr = apply(metadata)
+			return applyErr
+		})
+		if applyErr != nil {
+			return permanentError{applyErr}
+		}
+		return err
+	})
+	if applyErr != nil {
+		return models.FunctionMetadata{}, applyErr
+	}
+	return metadata, err
+}
+
+// UpdateName renames a function, retrying transient failures. A failed
+// rename changes nothing, so repeating it is safe.
+func (s *ResilientFunctionStore) UpdateName(ctx context.Context, functionID, name string) (models.FunctionMetadata, error) {
+	var metadata models.FunctionMetadata
+	err := s.do(ctx, "UpdateName", true, func() error {
+		var err error
+		metadata, err = s.FunctionStore.UpdateName(ctx, functionID, name)
+		return err
+	})
+	return metadata, err
+}
+
+// DeleteFunction deletes a function, retrying transient failures. A failed
+// deletion leaves the function in place, so repeating it is safe.
+func (s *ResilientFunctionStore) DeleteFunction(ctx context.Context, functionID string) error {
+	return s.do(ctx, "DeleteFunction", true, func() error {
+		return s.FunctionStore.DeleteFunction(ctx, functionID)
+	})
+}
+
+// RecordExecution records an execution. It is not retried, since a repeat
+// could count the execution twice.
+func (s *ResilientFunctionStore) RecordExecution(ctx context.Context, record models.ExecutionRecord) error {
+	return s.do(ctx, "RecordExecution", false, func() error {
+		return s.FunctionStore.RecordExecution(ctx, record)
+	})
+}
+
+// Reconcile reconciles the wrapped store. It is not retried, since it
+// removes functions as it goes.
+func (s *ResilientFunctionStore) Reconcile(ctx context.Context, images ImageSource) (models.ReconcileReport, error) {
+	var report models.ReconcileReport
+	err := s.do(ctx, "Reconcile", false, func() error {
+		var err error
+		report, err = s.FunctionStore.Reconcile(ctx, images)
+		return err
+	})
+	return report, err
+}
+
+// ImportAll imports functions into the wrapped store. It is not retried,
+// since functions imported before a failure would be reported as skipped.
+func (s *ResilientFunctionStore) ImportAll(ctx context.Context, functions []models.FunctionMetadata, overwrite bool) (models.ImportReport, error) {
+	var report models.ImportReport
+	err := s.do(ctx, "ImportAll", false, func() error {
+		var err error
+		report, err = s.FunctionStore.ImportAll(ctx, functions, overwrite)
+		return err
+	})
+	return report, err
+}
+
+// SetAlias points an alias at a function, retrying transient failures.
+// Setting is keyed by alias name, so repeating it is safe.
+func (s *ResilientFunctionStore) SetAlias(ctx context.Context, name string, request models.AliasRequest) (models.Alias, error) {
+	var alias models.Alias
+	err := s.do(ctx, "SetAlias", true, func() error {
+		var err error
+		alias, err = s.FunctionStore.SetAlias(ctx, name, request)
+		return err
+	})
+	return alias, err
+}
+
+// DeleteAlias deletes an alias, retrying transient failures. A failed
+// deletion leaves the alias in place, so repeating it is safe.
+func (s *ResilientFunctionStore) DeleteAlias(ctx context.Context, name string) error {
+	return s.do(ctx, "DeleteAlias", true, func() error {
+		return s.FunctionStore.DeleteAlias(ctx, name)
+	})
+}
+
+// BreakerState returns the state of the circuit breaker: BreakerClosed,
+// BreakerOpen or BreakerHalfOpen
+func (s *ResilientFunctionStore) BreakerState() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case s.openUntil.IsZero():
+		return BreakerClosed
+	case time.Now().Before(s.openUntil):
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
+
+// permanentError marks an error that must not be retried or counted against
+// the store
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// transient reports whether err may be a passing failure of the store rather
+// than an answer about the request
+func transient(err error) bool {
+	var permanent permanentError
+	switch {
+	case errors.As(err, &permanent),
+		errors.Is(err, ErrFunctionNotFound),
+		errors.Is(err, ErrNameTaken),
+		errors.Is(err, ErrAmbiguousName),
+		errors.Is(err, ErrFunctionLimitReached),
+		errors.Is(err, ErrAliasNotFound),
+		errors.Is(err, ErrDanglingAlias):
+		return false
+	}
+	return true
+}
+
+// do runs op through the circuit breaker, retrying transient failures with
+// backoff when retry is set
+func (s *ResilientFunctionStore) do(ctx context.Context, operation string, retry bool, op func() error) error {
+	requestID := requestctx.ID(ctx)
+
+	if !s.allow() {
+		return fmt.Errorf("%w: circuit breaker is open", ErrStoreUnavailable)
+	}
+
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// The caller gave up, which says nothing about the store
+			s.abandoned()
+			return err
+		}
+		if err == nil || !transient(err) {
+			s.succeeded()
+			return err
+		}
+		if !retry || attempt >= s.retries {
+			s.failed(requestID, operation, err)
+			return err
+		}
+
+		log.Warn().
+			Str("request_id", requestID).
+			Str("operation", operation).
+			Int("attempt", attempt+1).
+			Dur("backoff", backoff).
+			Err(err).
+			Msg("Store operation failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			s.failed(requestID, operation, err)
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// allow reports whether a call may go to the store: always while the breaker
+// is closed, never while it is open, and one call at a time once the cooldown
+// has passed
+func (s *ResilientFunctionStore) allow() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(s.openUntil) || s.probing {
+		return false
+	}
+	s.probing = true
+	return true
+}
+
+// succeeded closes the breaker and resets the failure count
+func (s *ResilientFunctionStore) succeeded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.openUntil.IsZero() {
+		log.Info().Msg("Function store recovered, closing circuit breaker")
+	}
+	s.failures = 0
+	s.openUntil = time.Time{}
+	s.probing = false
+}
+
+// abandoned lets another call probe the store if this one was the probe
+func (s *ResilientFunctionStore) abandoned() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.probing = false
+}
+
+// failed counts a failed operation, opening the breaker once threshold of
+// them have failed in a row or when a half-open probe fails
+func (s *ResilientFunctionStore) failed(requestID, operation string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures++
+	if s.threshold <= 0 || (s.failures < s.threshold && !s.probing) {
+		return
+	}
+
+	s.openUntil = time.Now().Add(s.cooldown)
+	s.probing = false
+	log.Error().
+		Str("request_id", requestID).
+		Str("operation", operation).
+		Int("failures", s.failures).
+		Dur("cooldown", s.cooldown).
+		Err(err).
+		Msg("Function store is failing, opening circuit breaker")
+}
+
+// BreakerState returns the circuit breaker state of the ResilientFunctionStore
+// in fs or any store it wraps, or false if there is none
+func BreakerState(fs FunctionStore) (string, bool) {
+	for fs != nil {
+		if resilient, ok := fs.(*ResilientFunctionStore); ok {
+			return resilient.BreakerState(), true
+		}
+		unwrapper, ok := fs.(interface{ Unwrap() FunctionStore })
+		if !ok {
+			break
+		}
+		fs = unwrapper.Unwrap()
+	}
+	return "", false
+}
@@ -0,0 +1,294 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+// errConnectionReset stands in for a transient database failure
+var errConnectionReset = errors.New("connection reset by peer")
+
+// faultyStore is a FunctionStore whose writes fail with errConnectionReset
+// while it has failures left to inject
+type faultyStore struct {
+	FunctionStore
+
+	mutex    sync.Mutex
+	failures int // writes still to fail; negative fails every write
+	calls    int // writes that reached the store
+}
+
+func newFaultyStore(failures int) *faultyStore {
+	return &faultyStore{FunctionStore: NewFunctionStore(10, false, 0), failures: failures}
+}
+
+// inject counts a write and returns the failure to inject, if any
+func (f *faultyStore) inject() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls++
+	if f.failures == 0 {
+		return nil
+	}
+	if f.failures > 0 {
+		f.failures--
+	}
+	return errConnectionReset
+}
+
+// setFailures changes how many writes fail from now on
+func (f *faultyStore) setFailures(failures int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failures = failures
+}
+
+// writes returns how many writes reached the store
+func (f *faultyStore) writes() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls
+}
+
+func (f *faultyStore) StoreFunction(ctx context.Context, metadata models.FunctionMetadata) error {
+	if err := f.inject(); err != nil {
+		return err
+	}
+	return f.FunctionStore.StoreFunction(ctx, metadata)
+}
+
+func (f *faultyStore) DeleteFunction(ctx context.Context, functionID string) error {
+	if err := f.inject(); err != nil {
+		return err
+	}
+	return f.FunctionStore.DeleteFunction(ctx, functionID)
+}
+
+func (f *faultyStore) RecordExecution(ctx context.Context, record models.ExecutionRecord) error {
+	if err := f.inject(); err != nil {
+		return err
+	}
+	return f.FunctionStore.RecordExecution(ctx, record)
+}
+
+func TestResilientStoreRetriesThenSucceeds(t *testing.T) {
+	ctx := context.Background()
+	const retries = 2
+
+	tests := []struct {
+		name     string
+		failures int
+		wantErr  error
+	}{
+		{name: "succeeds first time", failures: 0},
+		{name: "succeeds on the last retry", failures: retries},
+		{name: "fails after every retry", failures: retries + 1, wantErr: errConnectionReset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faulty := newFaultyStore(tt.failures)
+			s := NewResilientFunctionStore(faulty, retries, time.Millisecond, 0, time.Minute)
+
+			err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StoreFunction = %v, want %v", err, tt.wantErr)
+			}
+			if want := min(tt.failures, retries) + 1; faulty.writes() != want {
+				t.Errorf("%d attempts, want %d", faulty.writes(), want)
+			}
+			_, getErr := s.GetFunction(ctx, "function")
+			if stored := getErr == nil; stored != (tt.wantErr == nil) {
+				t.Errorf("function stored = %t after StoreFunction returned %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestResilientStoreDoesNotRetryAnswers(t *testing.T) {
+	ctx := context.Background()
+	faulty := newFaultyStore(0)
+	s := NewResilientFunctionStore(faulty, 2, time.Millisecond, 1, time.Minute)
+
+	// A missing function is an answer about the request, not a store failure
+	if err := s.DeleteFunction(ctx, "missing"); !errors.Is(err, ErrFunctionNotFound) {
+		t.Fatalf("DeleteFunction = %v, want ErrFunctionNotFound", err)
+	}
+	if faulty.writes() != 1 {
+		t.Errorf("%d attempts, want 1", faulty.writes())
+	}
+	if state := s.BreakerState(); state != BreakerClosed {
+		t.Errorf("breaker %s after an answer, want closed", state)
+	}
+
+	// Recording an execution could count it twice, so it isn't retried
+	faulty.setFailures(1)
+	if err := s.RecordExecution(ctx, models.ExecutionRecord{FunctionID: "function"}); !errors.Is(err, errConnectionReset) {
+		t.Fatalf("RecordExecution = %v, want the injected failure", err)
+	}
+	if faulty.writes() != 2 {
+		t.Errorf("RecordExecution was attempted %d times, want once", faulty.writes()-1)
+	}
+}
+
+func TestResilientStoreBreakerOpensAtThreshold(t *testing.T) {
+	t.Setenv("STORE_BREAKER_THRESHOLD", "3")
+	t.Setenv("STORE_RETRIES", "0")
+	cfg := config.LoadConfig().Store
+
+	ctx := context.Background()
+	faulty := newFaultyStore(-1)
+	if err := faulty.FunctionStore.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "stored"}); err != nil {
+		t.Fatalf("StoreFunction: %v", err)
+	}
+	s := NewResilientFunctionStore(faulty, cfg.Retries, cfg.RetryBackoff, cfg.BreakerThreshold, cfg.BreakerCooldown)
+
+	// Failures up to one short of the threshold leave the breaker closed
+	for i := 1; i < cfg.BreakerThreshold; i++ {
+		if err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function"}); !errors.Is(err, errConnectionReset) {
+			t.Fatalf("write %d = %v, want the injected failure", i, err)
+		}
+		if state := s.BreakerState(); state != BreakerClosed {
+			t.Fatalf("breaker %s after %d failures, want closed", state, i)
+		}
+	}
+
+	// The threshold-th failure opens it
+	if err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function"}); !errors.Is(err, errConnectionReset) {
+		t.Fatalf("write %d = %v, want the injected failure", cfg.BreakerThreshold, err)
+	}
+	if state := s.BreakerState(); state != BreakerOpen {
+		t.Fatalf("breaker %s after %d failures, want open", state, cfg.BreakerThreshold)
+	}
+
+	// Writes now fail fast without reaching the store, while reads go on
+	writes := faulty.writes()
+	if err := s.DeleteFunction(ctx, "stored"); !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("write with the breaker open = %v, want ErrStoreUnavailable", err)
+	}
+	if faulty.writes() != writes {
+		t.Error("write reached the store with the breaker open")
+	}
+	if _, err := s.GetFunction(ctx, "stored"); err != nil {
+		t.Errorf("read with the breaker open: %v", err)
+	}
+	if state, ok := BreakerState(s); !ok || state != BreakerOpen {
+		t.Errorf("BreakerState = %q, %t; want open", state, ok)
+	}
+}
+
+func TestResilientStoreBreakerHalfOpensAfterCooldown(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	ctx := context.Background()
+	faulty := newFaultyStore(-1)
+	s := NewResilientFunctionStore(faulty, 0, time.Millisecond, 1, cooldown)
+
+	open := func() {
+		t.Helper()
+		if err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function"}); !errors.Is(err, errConnectionReset) {
+			t.Fatalf("StoreFunction = %v, want the injected failure", err)
+		}
+		if state := s.BreakerState(); state != BreakerOpen {
+			t.Fatalf("breaker %s, want open", state)
+		}
+	}
+	waitForCooldown := func() {
+		t.Helper()
+		time.Sleep(cooldown + 10*time.Millisecond)
+		if state := s.BreakerState(); state != BreakerHalfOpen {
+			t.Fatalf("breaker %s after the cooldown, want half-open", state)
+		}
+	}
+
+	// A failing probe opens the breaker again
+	open()
+	waitForCooldown()
+	writes := faulty.writes()
+	if err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function"}); !errors.Is(err, errConnectionReset) {
+		t.Fatalf("probe = %v, want the injected failure", err)
+	}
+	if faulty.writes() != writes+1 {
+		t.Errorf("probe made %d attempts, want 1", faulty.writes()-writes)
+	}
+	if state := s.BreakerState(); state != BreakerOpen {
+		t.Fatalf("breaker %s after a failed probe, want open", state)
+	}
+
+	// A succeeding probe closes it
+	waitForCooldown()
+	faulty.setFailures(0)
+	if err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "function"}); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if state := s.BreakerState(); state != BreakerClosed {
+		t.Errorf("breaker %s after a successful probe, want closed", state)
+	}
+}
+
+func TestResilientStoreReadsBypassOpenBreaker(t *testing.T) {
+	ctx := context.Background()
+	faulty := newFaultyStore(-1)
+	metadata := models.FunctionMetadata{FunctionID: "function", Name: "function", ContentHash: "hash", Language: "python"}
+	if err := faulty.FunctionStore.StoreFunction(ctx, metadata); err != nil {
+		t.Fatalf("StoreFunction: %v", err)
+	}
+	if _, err := faulty.FunctionStore.SetAlias(ctx, "live", models.AliasRequest{FunctionID: "function"}); err != nil {
+		t.Fatalf("SetAlias: %v", err)
+	}
+
+	s := NewResilientFunctionStore(faulty, 0, time.Millisecond, 1, time.Minute)
+	if err := s.StoreFunction(ctx, models.FunctionMetadata{FunctionID: "other"}); !errors.Is(err, errConnectionReset) {
+		t.Fatalf("StoreFunction = %v, want the injected failure", err)
+	}
+	if state := s.BreakerState(); state != BreakerOpen {
+		t.Fatalf("breaker %s, want open", state)
+	}
+
+	// Reads are served from memory, so the open breaker doesn't stop them
+	reads := map[string]func() error{
+		"GetFunction": func() error {
+			_, err := s.GetFunction(ctx, "function")
+			return err
+		},
+		"GetFunctionByName": func() error {
+			_, err := s.GetFunctionByName(ctx, "function")
+			return err
+		},
+		"GetByContentHash": func() error {
+			_, err := s.GetByContentHash(ctx, metadata.ContentHash, metadata.Language)
+			return err
+		},
+		"ListExecutions": func() error {
+			_, err := s.ListExecutions(ctx, "function", 10)
+			return err
+		},
+		"GetAlias": func() error {
+			_, err := s.GetAlias(ctx, "live")
+			return err
+		},
+		"ResolveAlias": func() error {
+			_, err := s.ResolveAlias(ctx, "live")
+			return err
+		},
+	}
+	for name, read := range reads {
+		if err := read(); err != nil {
+			t.Errorf("%s with the breaker open: %v", name, err)
+		}
+	}
+
+	// and answers from them don't count toward closing or opening it
+	if _, err := s.GetFunction(ctx, "missing"); !errors.Is(err, ErrFunctionNotFound) {
+		t.Errorf("GetFunction(missing) = %v, want ErrFunctionNotFound", err)
+	}
+	if state := s.BreakerState(); state != BreakerOpen {
+		t.Errorf("breaker %s after reads, want still open", state)
+	}
+}
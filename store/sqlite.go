@@ -0,0 +1,144 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+	"youtube_serverless/models"
+)
+
+// createFunctionsTable stores each function as a JSON document keyed by ID so
+// new metadata fields don't require schema migrations
+const createFunctionsTable = `
+CREATE TABLE IF NOT EXISTS functions (
+	function_id TEXT PRIMARY KEY,
+	metadata    TEXT NOT NULL
+)`
+
+// createAliasesTable stores each alias as a JSON document keyed by name
+const createAliasesTable = `
+CREATE TABLE IF NOT EXISTS aliases (
+	name  TEXT PRIMARY KEY,
+	alias TEXT NOT NULL
+)`
+
+// sqlitePersister persists function metadata to a SQLite database
+type sqlitePersister struct {
+	db *sql.DB
+}
+
+// NewSQLiteFunctionStore creates a FunctionStore backed by the SQLite database
+// at path, creating the schema if missing and loading existing functions. The
+// last historySize executions of each function are kept in memory, with
+// uniqueNames no two functions may share a name, and with a positive
+// maxFunctions no more than that many functions may be stored.
+func NewSQLiteFunctionStore(path string, historySize int, uniqueNames bool, maxFunctions int) (FunctionStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %v", err)
+	}
+	// SQLite allows a single writer; a single connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(createFunctionsTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create functions table: %v", err)
+	}
+	if _, err := db.Exec(createAliasesTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create aliases table: %v", err)
+	}
+
+	fs, err := newPersistentFunctionStore(&sqlitePersister{db: db}, historySize, uniqueNames, maxFunctions)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (p *sqlitePersister) load() ([]models.FunctionMetadata, error) {
+	rows, err := p.db.Query("SELECT metadata FROM functions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var functions []models.FunctionMetadata
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var metadata models.FunctionMetadata
+		if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode function metadata: %v", err)
+		}
+		functions = append(functions, metadata)
+	}
+	return functions, rows.Err()
+}
+
+func (p *sqlitePersister) save(metadata models.FunctionMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.Exec(
+		"INSERT INTO functions (function_id, metadata) VALUES (?, ?) "+
+			"ON CONFLICT(function_id) DO UPDATE SET metadata = excluded.metadata",
+		metadata.FunctionID, string(data),
+	)
+	return err
+}
+
+func (p *sqlitePersister) delete(functionID string) error {
+	_, err := p.db.Exec("DELETE FROM functions WHERE function_id = ?", functionID)
+	return err
+}
+
+func (p *sqlitePersister) loadAliases() ([]models.Alias, error) {
+	rows, err := p.db.Query("SELECT alias FROM aliases")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []models.Alias
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var alias models.Alias
+		if err := json.Unmarshal([]byte(data), &alias); err != nil {
+			return nil, fmt.Errorf("failed to decode alias: %v", err)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+func (p *sqlitePersister) saveAlias(alias models.Alias) error {
+	data, err := json.Marshal(alias)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.Exec(
+		"INSERT INTO aliases (name, alias) VALUES (?, ?) "+
+			"ON CONFLICT(name) DO UPDATE SET alias = excluded.alias",
+		alias.Name, string(data),
+	)
+	return err
+}
+
+func (p *sqlitePersister) deleteAlias(name string) error {
+	_, err := p.db.Exec("DELETE FROM aliases WHERE name = ?", name)
+	return err
+}
+
+func (p *sqlitePersister) close() error {
+	return p.db.Close()
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+)
+
+// Stats summarizes the stored functions. It reads each function in place
+// under a single read lock rather than copying them out.
+func (fs *functionStore) Stats(ctx context.Context) models.FunctionStats {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	stats := models.FunctionStats{
+		TotalFunctions: len(fs.functions),
+		ByLanguage:     make(map[string]int),
+	}
+	for _, metadata := range fs.functions {
+		stats.ByLanguage[metadata.Language]++
+		stats.TotalInvocations += metadata.InvocationCount
+
+		// Functions stored before build times were recorded were built when
+		// they were created
+		builtAt := metadata.BuiltAt
+		if builtAt == 0 {
+			builtAt = metadata.CreatedAt
+		}
+		stats.LastBuiltAt = max(stats.LastBuiltAt, builtAt)
+
+		if metadata.LastExecuted > 0 && (stats.LastExecuted == nil || metadata.LastExecuted > stats.LastExecuted.ExecutedAt) {
+			stats.LastExecuted = &models.FunctionActivity{
+				FunctionID: metadata.FunctionID,
+				Name:       metadata.Name,
+				ExecutedAt: metadata.LastExecuted,
+			}
+		}
+	}
+
+	log.Debug().
+		Str("request_id", requestID).
+		Int("count", stats.TotalFunctions).
+		Msg("Computed function stats")
+
+	return stats
+}
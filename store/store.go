@@ -2,35 +2,213 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
-	"time"
 	
 	"github.com/rs/zerolog/log"
+	"youtube_serverless/config"
 	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
 )
 
-// FunctionStore manages function metadata
-type FunctionStore struct {
-	functions map[string]models.FunctionMetadata
-	mutex     sync.RWMutex
+// ErrFunctionNotFound is returned when a function ID doesn't exist in the store
+var ErrFunctionNotFound = errors.New("function not found")
+
+// FunctionStore defines the operations for managing function metadata
+type FunctionStore interface {
+	StoreFunction(ctx context.Context, metadata models.FunctionMetadata) error
+	GetFunction(ctx context.Context, functionID string) (models.FunctionMetadata, error)
+	GetFunctionByName(ctx context.Context, name string) (models.FunctionMetadata, error)
+	GetByContentHash(ctx context.Context, contentHash, language string) (models.FunctionMetadata, error)
+	StoreFunctionEvicting(ctx context.Context, metadata models.FunctionMetadata) ([]models.FunctionMetadata, error)
+	RecordExecution(ctx context.Context, record models.ExecutionRecord) error
+	ListExecutions(ctx context.Context, functionID string, limit int) ([]models.ExecutionRecord, error)
+	UpdateFunction(ctx context.Context, functionID string, apply func(*models.FunctionMetadata) error) (models.FunctionMetadata, error)
+	UpdateName(ctx context.Context, functionID, name string) (models.FunctionMetadata, error)
+	ListFunctions(ctx context.Context) []models.FunctionMetadata
+	ListFunctionsFiltered(ctx context.Context, opts ListOptions) ([]models.FunctionMetadata, int)
+	ForEachFunction(ctx context.Context, fn func(models.FunctionMetadata) error) error
+	Stats(ctx context.Context) models.FunctionStats
+	DeleteFunction(ctx context.Context, functionID string) error
+	Reconcile(ctx context.Context, images ImageSource) (models.ReconcileReport, error)
+	ExportAll(ctx context.Context) []models.FunctionMetadata
+	ImportAll(ctx context.Context, functions []models.FunctionMetadata, overwrite bool) (models.ImportReport, error)
+	SetAlias(ctx context.Context, name string, request models.AliasRequest) (models.Alias, error)
+	GetAlias(ctx context.Context, name string) (models.Alias, error)
+	ResolveAlias(ctx context.Context, name string) (models.FunctionMetadata, error)
+	ListAliases(ctx context.Context) []models.Alias
+	DeleteAlias(ctx context.Context, name string) error
+	Close() error
+}
+
+// persister writes function metadata and aliases through to durable storage
+type persister interface {
+	load() ([]models.FunctionMetadata, error)
+	save(metadata models.FunctionMetadata) error
+	delete(functionID string) error
+	loadAliases() ([]models.Alias, error)
+	saveAlias(alias models.Alias) error
+	deleteAlias(name string) error
+	close() error
+}
+
+// functionStore manages function metadata in memory, optionally writing
+// every change through to a persister so it survives restarts. Aliases are
+// kept apart from the functions they point to, so deleting a function leaves
+// its aliases dangling rather than removing them. Execution history is only
+// kept in memory.
+type functionStore struct {
+	functions    map[string]models.FunctionMetadata
+	names        nameIndex
+	tags         tagIndex
+	aliases      map[string]models.Alias
+	uniqueNames  bool       // reject a function whose name another function has
+	maxFunctions int        // functions that may be stored at once; 0 means no limit
+	roll         func() int // picks a number in [0, 100) to split an alias's executions
+	history      map[string]*executionRing
+	historySize  int // executions retained per function; 0 disables history
+	mutex        sync.RWMutex
+	persister    persister
 }
 
-// NewFunctionStore creates a new FunctionStore
-func NewFunctionStore() *FunctionStore {
-	return &FunctionStore{
-		functions: make(map[string]models.FunctionMetadata),
+// New creates the FunctionStore selected by the store configuration, with
+// lookups cached when a cache size is set
+func New(cfg *config.StoreConfig) (FunctionStore, error) {
+	var fs FunctionStore
+	var err error
+	switch cfg.Backend {
+	case "memory":
+		fs = NewFunctionStore(cfg.ExecutionHistory, cfg.UniqueNames, cfg.MaxFunctions)
+	case "sqlite":
+		fs, err = NewSQLiteFunctionStore(cfg.Path, cfg.ExecutionHistory, cfg.UniqueNames, cfg.MaxFunctions)
+	case "bolt":
+		fs, err = NewBoltFunctionStore(cfg.Path, cfg.ExecutionHistory, cfg.UniqueNames, cfg.MaxFunctions)
+	default:
+		return nil, fmt.Errorf("unsupported store backend: %s", cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the database backends can fail transiently
+	if cfg.Backend != "memory" && (cfg.Retries > 0 || cfg.BreakerThreshold > 0) {
+		fs = NewResilientFunctionStore(fs, cfg.Retries, cfg.RetryBackoff, cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+
+	if cfg.CacheSize > 0 {
+		return NewCachingFunctionStore(fs, cfg.CacheSize), nil
 	}
+	return fs, nil
 }
 
-// StoreFunction stores function metadata
-func (fs *FunctionStore) StoreFunction(ctx context.Context, metadata models.FunctionMetadata) error {
-	requestID, _ := ctx.Value("requestID").(string)
+// NewFunctionStore creates a new in-memory FunctionStore that keeps the last
+// historySize executions of each function. With uniqueNames, no two
+// functions may share a name, and with a positive maxFunctions no more than
+// that many functions may be stored.
+func NewFunctionStore(historySize int, uniqueNames bool, maxFunctions int) FunctionStore {
+	return &functionStore{
+		functions:    make(map[string]models.FunctionMetadata),
+		names:        make(nameIndex),
+		tags:         make(tagIndex),
+		aliases:      make(map[string]models.Alias),
+		uniqueNames:  uniqueNames,
+		maxFunctions: maxFunctions,
+		roll:         rollPercent,
+		history:      make(map[string]*executionRing),
+		historySize:  historySize,
+	}
+}
+
+// newPersistentFunctionStore creates a FunctionStore backed by the given
+// persister, loading all previously persisted functions
+func newPersistentFunctionStore(p persister, historySize int, uniqueNames bool, maxFunctions int) (*functionStore, error) {
+	existing, err := p.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load functions: %v", err)
+	}
+	aliases, err := p.loadAliases()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aliases: %v", err)
+	}
+	
+	fs := &functionStore{
+		functions:    make(map[string]models.FunctionMetadata, len(existing)),
+		names:        make(nameIndex),
+		tags:         make(tagIndex),
+		aliases:      make(map[string]models.Alias),
+		uniqueNames:  uniqueNames,
+		maxFunctions: maxFunctions,
+		roll:         rollPercent,
+		history:      make(map[string]*executionRing),
+		historySize:  historySize,
+		persister:    p,
+	}
+	// Functions stored before unique names were enforced may share a name,
+	// and those stored before a limit was lowered may exceed it
+	for _, metadata := range existing {
+		fs.functions[metadata.FunctionID] = metadata
+		fs.names.add(metadata)
+		fs.tags.add(metadata)
+	}
+	for _, alias := range aliases {
+		fs.aliases[alias.Name] = alias
+	}
+	
+	log.Info().
+		Int("count", len(existing)).
+		Int("aliases", len(aliases)).
+		Msg("Loaded persisted functions")
 	
+	return fs, nil
+}
+
+// StoreFunction stores function metadata
+func (fs *functionStore) StoreFunction(ctx context.Context, metadata models.FunctionMetadata) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 	
+	return fs.store(ctx, metadata)
+}
+
+// store implements StoreFunction. Callers must hold the lock.
+func (fs *functionStore) store(ctx context.Context, metadata models.FunctionMetadata) error {
+	requestID := requestctx.ID(ctx)
+	
+	if err := fs.checkName(metadata.FunctionID, metadata.Name); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", metadata.FunctionID).
+			Str("name", metadata.Name).
+			Msg("Function name already in use")
+		return err
+	}
+	
+	if err := fs.checkLimit(metadata.FunctionID); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("function_id", metadata.FunctionID).
+			Int("max_functions", fs.maxFunctions).
+			Msg("Function limit reached")
+		return err
+	}
+	
+	if err := fs.save(metadata); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", metadata.FunctionID).
+			Err(err).
+			Msg("Failed to persist function")
+		return err
+	}
+	
+	if previous, ok := fs.functions[metadata.FunctionID]; ok {
+		fs.names.remove(previous)
+		fs.tags.remove(previous)
+	}
 	fs.functions[metadata.FunctionID] = metadata
+	fs.names.add(metadata)
+	fs.tags.add(metadata)
 	
 	log.Info().
 		Str("request_id", requestID).
@@ -43,8 +221,8 @@ func (fs *FunctionStore) StoreFunction(ctx context.Context, metadata models.Func
 }
 
 // GetFunction retrieves function metadata by ID
-func (fs *FunctionStore) GetFunction(ctx context.Context, functionID string) (models.FunctionMetadata, error) {
-	requestID, _ := ctx.Value("requestID").(string)
+func (fs *functionStore) GetFunction(ctx context.Context, functionID string) (models.FunctionMetadata, error) {
+	requestID := requestctx.ID(ctx)
 	
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
@@ -55,7 +233,7 @@ func (fs *FunctionStore) GetFunction(ctx context.Context, functionID string) (mo
 			Str("request_id", requestID).
 			Str("function_id", functionID).
 			Msg("Function not found")
-		return models.FunctionMetadata{}, fmt.Errorf("function not found: %s", functionID)
+		return models.FunctionMetadata{}, fmt.Errorf("%w: %s", ErrFunctionNotFound, functionID)
 	}
 	
 	log.Debug().
@@ -67,9 +245,32 @@ func (fs *FunctionStore) GetFunction(ctx context.Context, functionID string) (mo
 	return metadata, nil
 }
 
-// UpdateLastExecuted updates the last executed timestamp for a function
-func (fs *FunctionStore) UpdateLastExecuted(ctx context.Context, functionID string) error {
-	requestID, _ := ctx.Value("requestID").(string)
+// GetByContentHash retrieves a function built from identical code in the
+// given language, or returns ErrFunctionNotFound if there is none
+func (fs *functionStore) GetByContentHash(ctx context.Context, contentHash, language string) (models.FunctionMetadata, error) {
+	requestID := requestctx.ID(ctx)
+
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	for _, metadata := range fs.functions {
+		if metadata.ContentHash == contentHash && metadata.Language == language {
+			log.Debug().
+				Str("request_id", requestID).
+				Str("function_id", metadata.FunctionID).
+				Str("content_hash", contentHash).
+				Msg("Function found by content hash")
+			return metadata, nil
+		}
+	}
+
+	return models.FunctionMetadata{}, fmt.Errorf("%w: content hash %s", ErrFunctionNotFound, contentHash)
+}
+
+// UpdateFunction atomically applies a change to a function's metadata and
+// returns the updated metadata. Nothing is changed if apply returns an error.
+func (fs *functionStore) UpdateFunction(ctx context.Context, functionID string, apply func(*models.FunctionMetadata) error) (models.FunctionMetadata, error) {
+	requestID := requestctx.ID(ctx)
 	
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
@@ -79,25 +280,46 @@ func (fs *FunctionStore) UpdateLastExecuted(ctx context.Context, functionID stri
 		log.Warn().
 			Str("request_id", requestID).
 			Str("function_id", functionID).
-			Msg("Function not found for execution update")
-		return fmt.Errorf("function not found: %s", functionID)
+			Msg("Function not found for update")
+		return models.FunctionMetadata{}, fmt.Errorf("%w: %s", ErrFunctionNotFound, functionID)
+	}
+	
+	previous := metadata
+	if err := apply(&metadata); err != nil {
+		return models.FunctionMetadata{}, err
+	}
+	metadata.FunctionID = functionID
+	
+	if err := fs.checkName(functionID, metadata.Name); err != nil {
+		return models.FunctionMetadata{}, err
 	}
 	
-	metadata.LastExecuted = time.Now().Unix()
+	if err := fs.save(metadata); err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("function_id", functionID).
+			Err(err).
+			Msg("Failed to persist function update")
+		return models.FunctionMetadata{}, err
+	}
 	fs.functions[functionID] = metadata
+	fs.names.remove(previous)
+	fs.names.add(metadata)
+	fs.tags.remove(previous)
+	fs.tags.add(metadata)
 	
-	log.Debug().
+	log.Info().
 		Str("request_id", requestID).
 		Str("function_id", functionID).
-		Int64("last_executed", metadata.LastExecuted).
-		Msg("Function execution timestamp updated")
+		Str("image_id", metadata.ImageID).
+		Msg("Function updated")
 		
-	return nil
+	return metadata, nil
 }
 
 // ListFunctions returns all stored functions
-func (fs *FunctionStore) ListFunctions(ctx context.Context) []models.FunctionMetadata {
-	requestID, _ := ctx.Value("requestID").(string)
+func (fs *functionStore) ListFunctions(ctx context.Context) []models.FunctionMetadata {
+	requestID := requestctx.ID(ctx)
 	
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
@@ -116,21 +338,24 @@ func (fs *FunctionStore) ListFunctions(ctx context.Context) []models.FunctionMet
 }
 
 // DeleteFunction removes a function by ID
-func (fs *FunctionStore) DeleteFunction(ctx context.Context, functionID string) error {
-	requestID, _ := ctx.Value("requestID").(string)
+func (fs *functionStore) DeleteFunction(ctx context.Context, functionID string) error {
+	requestID := requestctx.ID(ctx)
 	
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 	
-	if _, ok := fs.functions[functionID]; !ok {
+	metadata, ok := fs.functions[functionID]
+	if !ok {
 		log.Warn().
 			Str("request_id", requestID).
 			Str("function_id", functionID).
 			Msg("Function not found for deletion")
-		return fmt.Errorf("function not found: %s", functionID)
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, functionID)
 	}
 	
-	delete(fs.functions, functionID)
+	if err := fs.remove(ctx, metadata); err != nil {
+		return err
+	}
 	
 	log.Info().
 		Str("request_id", requestID).
@@ -139,3 +364,40 @@ func (fs *FunctionStore) DeleteFunction(ctx context.Context, functionID string)
 		
 	return nil
 }
+
+// remove deletes a stored function along with its execution history.
+// Callers must hold the lock.
+func (fs *functionStore) remove(ctx context.Context, metadata models.FunctionMetadata) error {
+	if fs.persister != nil {
+		if err := fs.persister.delete(metadata.FunctionID); err != nil {
+			log.Error().
+				Str("request_id", requestctx.ID(ctx)).
+				Str("function_id", metadata.FunctionID).
+				Err(err).
+				Msg("Failed to delete persisted function")
+			return err
+		}
+	}
+	
+	delete(fs.functions, metadata.FunctionID)
+	fs.names.remove(metadata)
+	fs.tags.remove(metadata)
+	delete(fs.history, metadata.FunctionID)
+	return nil
+}
+
+// Close releases any resources held by the persister
+func (fs *functionStore) Close() error {
+	if fs.persister == nil {
+		return nil
+	}
+	return fs.persister.close()
+}
+
+// save writes metadata through to the persister, if any. Callers must hold the write lock.
+func (fs *functionStore) save(metadata models.FunctionMetadata) error {
+	if fs.persister == nil {
+		return nil
+	}
+	return fs.persister.save(metadata)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+// backends are the store backends every test runs against
+var backends = []string{"memory", "sqlite", "bolt"}
+
+// newTestStore creates a store with the backend and the default
+// configuration, after configure has changed it
+func newTestStore(t *testing.T, backend string, configure func(*config.StoreConfig)) FunctionStore {
+	t.Helper()
+
+	cfg := config.LoadConfig().Store
+	cfg.Backend = backend
+	cfg.Path = filepath.Join(t.TempDir(), "functions.db")
+	if configure != nil {
+		configure(&cfg)
+	}
+	fs, err := New(&cfg)
+	if err != nil {
+		t.Fatalf("New(%s): %v", backend, err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestGetFunctionIsFreshAfterUpdateAndDelete(t *testing.T) {
+	for _, backend := range backends {
+		for _, cacheSize := range []int{0, 10} {
+			t.Run(fmt.Sprintf("%s/cache=%d", backend, cacheSize), func(t *testing.T) {
+				testGetFunctionIsFreshAfterUpdateAndDelete(t, newTestStore(t, backend, func(cfg *config.StoreConfig) {
+					cfg.CacheSize = cacheSize
+				}))
+			})
+		}
+	}
+}
+
+// testGetFunctionIsFreshAfterUpdateAndDelete checks that lookups in fs see
+// every write before them
+func testGetFunctionIsFreshAfterUpdateAndDelete(t *testing.T, fs FunctionStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	metadata := models.FunctionMetadata{FunctionID: "function", Name: "greeter", ImageID: "image-1"}
+	if err := fs.StoreFunction(ctx, metadata); err != nil {
+		t.Fatalf("StoreFunction: %v", err)
+	}
+	// Read first, so any copy kept for lookups would be stale after
+	if _, err := fs.GetFunction(ctx, "function"); err != nil {
+		t.Fatalf("GetFunction: %v", err)
+	}
+	if _, err := fs.GetFunctionByName(ctx, "greeter"); err != nil {
+		t.Fatalf("GetFunctionByName: %v", err)
+	}
+
+	if _, err := fs.UpdateFunction(ctx, "function", func(metadata *models.FunctionMetadata) error {
+		metadata.ImageID = "image-2"
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+	if got, err := fs.GetFunction(ctx, "function"); err != nil || got.ImageID != "image-2" {
+		t.Errorf("GetFunction after update = %q, %v; want image-2", got.ImageID, err)
+	}
+
+	if _, err := fs.UpdateName(ctx, "function", "welcomer"); err != nil {
+		t.Fatalf("UpdateName: %v", err)
+	}
+	if got, err := fs.GetFunction(ctx, "function"); err != nil || got.Name != "welcomer" {
+		t.Errorf("GetFunction after rename = %q, %v; want welcomer", got.Name, err)
+	}
+	if got, err := fs.GetFunctionByName(ctx, "welcomer"); err != nil || got.FunctionID != "function" {
+		t.Errorf("GetFunctionByName(new name) = %q, %v", got.FunctionID, err)
+	}
+	if _, err := fs.GetFunctionByName(ctx, "greeter"); !errors.Is(err, ErrFunctionNotFound) {
+		t.Errorf("GetFunctionByName(old name) = %v, want ErrFunctionNotFound", err)
+	}
+
+	if err := fs.DeleteFunction(ctx, "function"); err != nil {
+		t.Fatalf("DeleteFunction: %v", err)
+	}
+	if _, err := fs.GetFunction(ctx, "function"); !errors.Is(err, ErrFunctionNotFound) {
+		t.Errorf("GetFunction after delete = %v, want ErrFunctionNotFound", err)
+	}
+	if _, err := fs.GetFunctionByName(ctx, "welcomer"); !errors.Is(err, ErrFunctionNotFound) {
+		t.Errorf("GetFunctionByName after delete = %v, want ErrFunctionNotFound", err)
+	}
+}
+
+func TestPersistentStoresReloadChanges(t *testing.T) {
+	for _, backend := range []string{"sqlite", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := config.LoadConfig().Store
+			cfg.Backend = backend
+			cfg.Path = filepath.Join(t.TempDir(), "functions.db")
+			ctx := context.Background()
+
+			fs, err := New(&cfg)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			for _, id := range []string{"kept", "deleted"} {
+				if err := fs.StoreFunction(ctx, models.FunctionMetadata{FunctionID: id, Name: id, ImageID: "image-1"}); err != nil {
+					t.Fatalf("StoreFunction: %v", err)
+				}
+			}
+			if _, err := fs.UpdateFunction(ctx, "kept", func(metadata *models.FunctionMetadata) error {
+				metadata.ImageID = "image-2"
+				return nil
+			}); err != nil {
+				t.Fatalf("UpdateFunction: %v", err)
+			}
+			if err := fs.DeleteFunction(ctx, "deleted"); err != nil {
+				t.Fatalf("DeleteFunction: %v", err)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			reopened, err := New(&cfg)
+			if err != nil {
+				t.Fatalf("New after reopening: %v", err)
+			}
+			defer reopened.Close()
+			if got, err := reopened.GetFunction(ctx, "kept"); err != nil || got.ImageID != "image-2" {
+				t.Errorf("reloaded function = %q, %v; want image-2", got.ImageID, err)
+			}
+			if _, err := reopened.GetFunction(ctx, "deleted"); !errors.Is(err, ErrFunctionNotFound) {
+				t.Errorf("reloaded deleted function = %v, want ErrFunctionNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import "youtube_serverless/models"
+
+// tagIndex maps tag filters to the IDs of the functions that match them.
+// Each tag is indexed under both "key" and "key:value", so a filter can ask
+// for a key with any value or for one value in particular.
+type tagIndex map[string]map[string]struct{}
+
+// add records the function's tags
+func (idx tagIndex) add(metadata models.FunctionMetadata) {
+	for key, value := range metadata.Tags {
+		for _, filter := range []string{key, key + ":" + value} {
+			ids, ok := idx[filter]
+			if !ok {
+				ids = make(map[string]struct{})
+				idx[filter] = ids
+			}
+			ids[metadata.FunctionID] = struct{}{}
+		}
+	}
+}
+
+// remove forgets the function's tags
+func (idx tagIndex) remove(metadata models.FunctionMetadata) {
+	for key, value := range metadata.Tags {
+		for _, filter := range []string{key, key + ":" + value} {
+			ids := idx[filter]
+			delete(ids, metadata.FunctionID)
+			if len(ids) == 0 {
+				delete(idx, filter)
+			}
+		}
+	}
+}
+
+// match returns the IDs of the functions that match every filter, each a tag
+// key or a "key:value" pair. It starts from the smallest set so the cost
+// depends on the matches rather than on the number of functions.
+func (idx tagIndex) match(filters []string) map[string]struct{} {
+	var smallest map[string]struct{}
+	for i, filter := range filters {
+		ids := idx[filter]
+		if i == 0 || len(ids) < len(smallest) {
+			smallest = ids
+		}
+	}
+
+	matches := make(map[string]struct{}, len(smallest))
+	for id := range smallest {
+		matched := true
+		for _, filter := range filters {
+			if _, ok := idx[filter][id]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches[id] = struct{}{}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,11 @@
+// Package templates embeds the default Dockerfile templates, one
+// <language>.yaml per supported language, so the binary builds functions
+// without any files beside it
+package templates
+
+import "embed"
+
+// FS holds the default templates
+//
+//go:embed *.yaml
+var FS embed.FS
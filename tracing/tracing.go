@@ -0,0 +1,84 @@
+// Package tracing sets up OpenTelemetry tracing for the platform. Until Setup
+// installs an exporter, spans are created by OpenTelemetry's no-op provider
+// and cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"youtube_serverless/config"
+)
+
+// tracerName identifies the platform's spans
+const tracerName = "youtube_serverless"
+
+// Setup exports spans over OTLP/HTTP to the configured endpoint and returns a
+// function that flushes and stops the exporter. Tracing stays a no-op when no
+// endpoint is configured. The exporter also honours the standard
+// OTEL_EXPORTER_OTLP_* variables, such as OTEL_EXPORTER_OTLP_HEADERS.
+func Setup(ctx context.Context, cfg *config.TracingConfig) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads OTEL_EXPORTER_OTLP_ENDPOINT itself, appending the
+	// traces path as the specification requires
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Info().
+		Str("endpoint", cfg.Endpoint).
+		Str("service_name", cfg.ServiceName).
+		Msg("Tracing enabled")
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer for the platform's spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start starts a span as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Attribute keys shared by the platform's spans, named like the matching log fields
+const (
+	RequestIDKey  = attribute.Key("request_id")
+	FunctionIDKey = attribute.Key("function_id")
+	LanguageKey   = attribute.Key("language")
+	ImageIDKey    = attribute.Key("image_id")
+	ExitCodeKey   = attribute.Key("exit_code")
+)
+
+// End records err, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"youtube_serverless/models"
+)
+
+// errorWriter carries what RespondWithError needs to know about the request
+// it answers, since its callers only pass the ResponseWriter
+type errorWriter struct {
+	http.ResponseWriter
+	plainText bool // the client prefers text/plain to JSON
+}
+
+// NewErrorWriter wraps w so that RespondWithError, called with w or with any
+// writer wrapping it, answers r in the error format r's Accept header
+// prefers. Writers in between must implement Unwrap, as they do for
+// http.ResponseController.
+func NewErrorWriter(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	return &errorWriter{
+		ResponseWriter: w,
+		plainText:      prefersPlainText(r.Header.Values("Accept")),
+	}
+}
+
+// Flush sends buffered data to the client, for streaming responses
+func (ew *errorWriter) Flush() {
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (ew *errorWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// findErrorWriter returns the errorWriter that w is or wraps, or nil if
+// there is none
+func findErrorWriter(w http.ResponseWriter) *errorWriter {
+	for {
+		switch rw := w.(type) {
+		case *errorWriter:
+			return rw
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// prefersPlainText reports whether the Accept header ranks text/plain above
+// JSON. JSON wins ties, so clients that send no Accept header or accept
+// anything get JSON.
+func prefersPlainText(accept []string) bool {
+	return acceptQuality(accept, "text", "plain") > acceptQuality(accept, "application", "json")
+}
+
+// acceptQuality returns the quality the Accept header gives a media type,
+// taken from its most specific matching range, or 1 if there is no header
+func acceptQuality(accept []string, typ, subtype string) float64 {
+	if len(accept) == 0 {
+		return 1
+	}
+
+	quality, specificity := 0.0, -1
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			rangeType, rangeSubtype, _ := strings.Cut(mediaRange, "/")
+
+			var match int
+			switch {
+			case rangeType == typ && rangeSubtype == subtype:
+				match = 2
+			case rangeType == typ && rangeSubtype == "*":
+				match = 1
+			case rangeType == "*" && rangeSubtype == "*":
+				match = 0
+			default:
+				continue
+			}
+			if match <= specificity {
+				continue
+			}
+
+			q := 1.0
+			if value, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(value, 64); err != nil {
+					continue
+				}
+			}
+			quality, specificity = q, match
+		}
+	}
+	return quality
+}
+
+// respondWithPlainTextError sends an error response as text: the message,
+// then the details and the request ID if there are any
+func respondWithPlainTextError(w http.ResponseWriter, errorResponse models.ErrorResponse) {
+	text := errorResponse.Error
+	if errorResponse.Details != "" {
+		text += ": " + errorResponse.Details
+	}
+	if errorResponse.RequestID != "" {
+		text += "\nRequest ID: " + errorResponse.RequestID
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(errorResponse.Code)
+	fmt.Fprintln(w, text)
+}
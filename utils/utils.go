@@ -1,19 +1,73 @@
 package utils
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go/parser"
+	"go/token"
 	"io"
+	"math"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 	"youtube_serverless/config"
+	"youtube_serverless/models"
+	"youtube_serverless/requestctx"
+	"youtube_serverless/schema"
+	"youtube_serverless/tracing"
 )
 
+// ErrLanguageNotEnabled is returned when code is detected as a language that
+// is not in the configured allowlist
+var ErrLanguageNotEnabled = errors.New("language is not enabled")
+
+// Errors returned when an archive exceeds the configured extraction limits
+var (
+	ErrArchiveTooLarge     = errors.New("archive too large")
+	ErrTooManyArchiveFiles = errors.New("archive has too many entries")
+)
+
+// ErrAmbiguousHandler is returned when an upload has several possible handler
+// files, none with a conventional name, and the handler wasn't specified
+var ErrAmbiguousHandler = errors.New("ambiguous handler file")
+
+// handlerLanguages maps handler file extensions to languages
+var handlerLanguages = map[string]string{
+	".py": "python",
+	".go": "golang",
+	".rb": "ruby",
+}
+
+// conventionalHandlers are the handler file names preferred, in order, when
+// an upload has several candidate files
+var conventionalHandlers = []string{
+	"main.py", "handler.py",
+	"main.go", "handler.go",
+	"main.rb", "handler.rb",
+}
+
+// ErrUnsupportedArchive is returned when an upload is not a zip or gzip
+// archive, judging by its declared content type or its first bytes
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// ErrFileTooLarge is returned when an uploaded file exceeds the maximum file size
+var ErrFileTooLarge = errors.New("file too large")
+
 // FileHandler manages file operations with proper error handling
 type FileHandler struct {
 	config *config.FileOpsConfig
@@ -35,9 +89,70 @@ func (fh *FileHandler) CreateTempDir(ctx context.Context) (string, error) {
 	return os.MkdirTemp(baseDir, "serverless-")
 }
 
+// SweepOrphans removes temporary directories left behind by a crash: those
+// under the temp base that nothing inside has modified for maxAge. Directories
+// of in-flight requests are kept as long as maxAge exceeds the longest build
+// or run. It returns the number of directories removed.
+func (fh *FileHandler) SweepOrphans(ctx context.Context, maxAge time.Duration) (int, error) {
+	baseDir := fh.config.TempDirBase
+	if baseDir == "" {
+		baseDir = os.TempDir()
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(baseDir, "serverless-*"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list temp directories: %v", err)
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, dir := range dirs {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+		modified, err := newestModTime(dir)
+		if err != nil || !modified.Before(cutoff) {
+			continue
+		}
+		fh.CleanupTempDir(ctx, dir)
+		removed++
+	}
+
+	if removed > 0 {
+		log.Info().
+			Str("dir", baseDir).
+			Int("removed", removed).
+			Msg("Removed orphaned temp directories")
+	}
+	return removed, nil
+}
+
+// newestModTime returns the latest modification time of dir or anything in
+// it, or an error if dir is not a directory
+func newestModTime(dir string) (time.Time, error) {
+	var newest time.Time
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir && !entry.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
+
 // CleanupTempDir removes a temporary directory with proper error handling
 func (fh *FileHandler) CleanupTempDir(ctx context.Context, path string) {
-	requestID, _ := ctx.Value("requestID").(string)
+	requestID := requestctx.ID(ctx)
 	err := os.RemoveAll(path)
 	if err != nil {
 		log.Error().
@@ -53,10 +168,21 @@ func (fh *FileHandler) CleanupTempDir(ctx context.Context, path string) {
 	}
 }
 
-// SaveZipFile saves a zip file to the temporary directory
+// SaveZipFile saves a zip or tar.gz archive to the temporary directory. Files
+// that don't start like an archive are rejected before anything is written.
 func (fh *FileHandler) SaveZipFile(ctx context.Context, tempDir, filename string, file io.Reader) (string, error) {
-	requestID, _ := ctx.Value("requestID").(string)
+	requestID := requestctx.ID(ctx)
 	zipPath := filepath.Join(tempDir, sanitizeFilename(filename))
+
+	reader := bufio.NewReader(file)
+	header, _ := reader.Peek(archiveMagicSize)
+	if SniffArchiveType(header) == "" {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("filename", filename).
+			Msg("Upload is not an archive")
+		return "", fmt.Errorf("%w: %s is not a zip or tar.gz archive", ErrUnsupportedArchive, filepath.Base(filename))
+	}
 	
 	outFile, err := os.Create(zipPath)
 	if err != nil {
@@ -69,24 +195,26 @@ func (fh *FileHandler) SaveZipFile(ctx context.Context, tempDir, filename string
 	}
 	defer outFile.Close()
 
-	written, err := io.Copy(outFile, io.LimitReader(file, fh.config.MaxFileSize))
+	// Read one byte past the limit so a file of exactly the maximum size passes
+	written, err := io.Copy(outFile, io.LimitReader(reader, fh.config.MaxFileSize+1))
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
 			Str("path", zipPath).
 			Err(err).
 			Msg("Failed to write zip file")
+		removePartialFile(outFile)
 		return "", err
 	}
 	
-	if written >= fh.config.MaxFileSize {
+	if written > fh.config.MaxFileSize {
 		log.Warn().
 			Str("request_id", requestID).
 			Str("path", zipPath).
-			Int64("size", written).
 			Int64("limit", fh.config.MaxFileSize).
 			Msg("File size limit reached")
-		return "", fmt.Errorf("file too large: maximum size is %d bytes", fh.config.MaxFileSize)
+		removePartialFile(outFile)
+		return "", fmt.Errorf("%w: %s must not exceed %s", ErrFileTooLarge, filepath.Base(zipPath), FormatMB(fh.config.MaxFileSize))
 	}
 
 	log.Debug().
@@ -98,12 +226,315 @@ func (fh *FileHandler) SaveZipFile(ctx context.Context, tempDir, filename string
 	return zipPath, nil
 }
 
-// ExtractZip extracts a zip file to the temporary directory
+// Supported archive types
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// archiveMagicSize is how many leading bytes SniffArchiveType needs
+const archiveMagicSize = 4
+
+// archiveContentTypes are the declared content types accepted for uploaded
+// archives. Clients that can't tell send application/octet-stream.
+var archiveContentTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-tar":            true,
+	"application/x-compressed-tar": true,
+	"application/octet-stream":     true,
+}
+
+// SniffArchiveType identifies an archive from its first bytes, returning an
+// empty string if they match no supported archive
+func SniffArchiveType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return ArchiveZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return ArchiveTarGz
+	}
+	return ""
+}
+
+// CheckArchiveContentType returns ErrUnsupportedArchive if an upload declares
+// a content type that no supported archive has. A missing content type is
+// allowed, since the contents are sniffed anyway.
+func CheckArchiveContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !archiveContentTypes[mediaType] {
+		return fmt.Errorf("%w: content type %q is not a zip or tar.gz archive", ErrUnsupportedArchive, contentType)
+	}
+	return nil
+}
+
+// DetectArchiveType identifies an archive from its magic bytes, falling back
+// to the filename extension
+func DetectArchiveType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, archiveMagicSize)
+	n, _ := io.ReadFull(file, header)
+	if archiveType := SniffArchiveType(header[:n]); archiveType != "" {
+		return archiveType, nil
+	}
+
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, nil
+	}
+
+	return "", fmt.Errorf("unsupported archive format (expected .zip or .tar.gz)")
+}
+
+// ExtractArchive extracts a zip or tar.gz archive to the temporary directory
+func (fh *FileHandler) ExtractArchive(ctx context.Context, archivePath, tempDir string) (extractDir string, err error) {
+	requestID := requestctx.ID(ctx)
+
+	ctx, span := tracing.Start(ctx, "extract")
+	defer func() { tracing.End(span, err) }()
+
+	archiveType, err := DetectArchiveType(archivePath)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("path", archivePath).
+			Err(err).
+			Msg("Unsupported archive")
+		return "", err
+	}
+
+	log.Debug().
+		Str("request_id", requestID).
+		Str("path", archivePath).
+		Str("type", archiveType).
+		Msg("Archive type detected")
+	span.SetAttributes(attribute.String("archive_type", archiveType))
+
+	if archiveType == ArchiveTarGz {
+		extractDir, err = fh.ExtractTarGz(ctx, archivePath, tempDir)
+	} else {
+		extractDir, err = fh.ExtractZip(ctx, archivePath, tempDir)
+	}
+	if err != nil {
+		// Free the disk space taken by a partial extraction right away
+		os.RemoveAll(filepath.Join(tempDir, "extracted"))
+		return "", err
+	}
+	return extractDir, nil
+}
+
+// checkEntryCount returns an error if an archive has more entries than allowed
+func (fh *FileHandler) checkEntryCount(entries int) error {
+	if entries > fh.config.MaxArchiveEntries {
+		return fmt.Errorf("%w: maximum is %d", ErrTooManyArchiveFiles, fh.config.MaxArchiveEntries)
+	}
+	return nil
+}
+
+// extractLimitError describes an archive that exceeded the extracted size limit
+func (fh *FileHandler) extractLimitError() error {
+	return fmt.Errorf("%w: maximum uncompressed size is %d bytes", ErrArchiveTooLarge, fh.config.MaxExtractedSize)
+}
+
+// ExtractTarGz extracts a gzip-compressed tar archive to the temporary directory.
+// Symlinks and hard links are rejected, and the uncompressed total and number
+// of entries are limited by the configured maximums.
+func (fh *FileHandler) ExtractTarGz(ctx context.Context, archivePath, tempDir string) (string, error) {
+	requestID := requestctx.ID(ctx)
+	extractDir := filepath.Join(tempDir, "extracted")
+
+	err := os.Mkdir(extractDir, fh.config.ExtractDirMode)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("path", extractDir).
+			Err(err).
+			Msg("Failed to create extraction directory")
+		return "", err
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("path", archivePath).
+			Err(err).
+			Msg("Failed to open archive")
+		return "", err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("path", archivePath).
+			Err(err).
+			Msg("Failed to open gzip stream")
+		return "", err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	var totalSize int64
+	var entries int
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("path", archivePath).
+				Err(err).
+				Msg("Failed to read tar entry")
+			return "", err
+		}
+
+		// The entry count is only known by reading, so stop at the first one over
+		entries++
+		if err := fh.checkEntryCount(entries); err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("path", archivePath).
+				Err(err).
+				Msg("Archive rejected")
+			return "", err
+		}
+
+		// Validate file path to prevent zip slip vulnerability
+		path, err := validateZipPath(extractDir, header.Name)
+		if err != nil {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("file", header.Name).
+				Err(err).
+				Msg("Invalid tar entry path")
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, fh.dirMode(os.FileMode(header.Mode))); err != nil {
+				log.Error().
+					Str("request_id", requestID).
+					Str("path", path).
+					Err(err).
+					Msg("Failed to create directory")
+				return "", err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), fh.config.ExtractDirMode); err != nil {
+				log.Error().
+					Str("request_id", requestID).
+					Str("path", filepath.Dir(path)).
+					Err(err).
+					Msg("Failed to create parent directories")
+				return "", err
+			}
+
+			written, err := writeFile(path, fh.fileMode(os.FileMode(header.Mode)), tarReader, fh.config.MaxExtractedSize-totalSize)
+			totalSize += written
+			if errors.Is(err, ErrArchiveTooLarge) {
+				err = fh.extractLimitError()
+			}
+			if err != nil {
+				log.Error().
+					Str("request_id", requestID).
+					Str("path", path).
+					Int64("total_size", totalSize).
+					Err(err).
+					Msg("Failed to extract file")
+				return "", err
+			}
+
+		case tar.TypeSymlink, tar.TypeLink:
+			log.Warn().
+				Str("request_id", requestID).
+				Str("file", header.Name).
+				Str("target", header.Linkname).
+				Msg("Link in tar archive rejected")
+			return "", fmt.Errorf("links are not allowed in archives: %s", header.Name)
+
+		default:
+			log.Debug().
+				Str("request_id", requestID).
+				Str("file", header.Name).
+				Msg("Skipping unsupported tar entry type")
+		}
+	}
+
+	log.Debug().
+		Str("request_id", requestID).
+		Str("path", extractDir).
+		Int64("size", totalSize).
+		Msg("Tar archive extracted")
+
+	return extractDir, nil
+}
+
+// dirMode returns the mode to extract a directory with: its mode in the
+// archive limited to EXTRACT_DIR_MODE. The owner keeps full access so the
+// directory can be filled and later removed.
+func (fh *FileHandler) dirMode(mode os.FileMode) os.FileMode {
+	return mode.Perm()&fh.config.ExtractDirMode | 0700
+}
+
+// fileMode returns the mode to extract a file with: its mode in the archive
+// limited to EXTRACT_DIR_MODE if it is executable and to EXTRACT_FILE_MODE
+// otherwise. Setuid, setgid and sticky bits are always dropped, so a crafted
+// archive can't plant them.
+func (fh *FileHandler) fileMode(mode os.FileMode) os.FileMode {
+	if mode&0111 != 0 {
+		return mode.Perm() & fh.config.ExtractDirMode
+	}
+	return mode.Perm() & fh.config.ExtractFileMode
+}
+
+// writeFile copies at most limit bytes from r into a new file at path,
+// failing with ErrArchiveTooLarge if the content exceeds the limit
+func writeFile(path string, mode os.FileMode, r io.Reader, limit int64) (int64, error) {
+	outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, err
+	}
+	defer outFile.Close()
+
+	written, err := io.Copy(outFile, io.LimitReader(r, limit+1))
+	if err != nil {
+		return written, err
+	}
+	if written > limit {
+		return written, ErrArchiveTooLarge
+	}
+	return written, nil
+}
+
+// ExtractZip extracts a zip file to the temporary directory. The number of
+// entries and the uncompressed total are limited by the configured maximums;
+// sizes are counted as data is written, since the sizes in a zip's headers
+// can't be trusted.
 func (fh *FileHandler) ExtractZip(ctx context.Context, zipPath, tempDir string) (string, error) {
-	requestID, _ := ctx.Value("requestID").(string)
+	requestID := requestctx.ID(ctx)
 	extractDir := filepath.Join(tempDir, "extracted")
 	
-	err := os.Mkdir(extractDir, 0755)
+	err := os.Mkdir(extractDir, fh.config.ExtractDirMode)
 	if err != nil {
 		log.Error().
 			Str("request_id", requestID).
@@ -124,14 +555,16 @@ func (fh *FileHandler) ExtractZip(ctx context.Context, zipPath, tempDir string)
 	}
 	defer reader.Close()
 
-	// Track open files to ensure they're all closed
-	var openFiles []*os.File
-	defer func() {
-		for _, f := range openFiles {
-			f.Close()
-		}
-	}()
+	if err := fh.checkEntryCount(len(reader.File)); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("path", zipPath).
+			Err(err).
+			Msg("Archive rejected")
+		return "", err
+	}
 
+	var totalSize int64
 	for _, file := range reader.File {
 		// Validate file path to prevent zip slip vulnerability
 		path, err := validateZipPath(extractDir, file.Name)
@@ -145,7 +578,7 @@ func (fh *FileHandler) ExtractZip(ctx context.Context, zipPath, tempDir string)
 		}
 
 		if file.FileInfo().IsDir() {
-			err = os.MkdirAll(path, file.Mode())
+			err = os.MkdirAll(path, fh.dirMode(file.Mode()))
 			if err != nil {
 				log.Error().
 					Str("request_id", requestID).
@@ -158,7 +591,7 @@ func (fh *FileHandler) ExtractZip(ctx context.Context, zipPath, tempDir string)
 		}
 
 		// Create parent directories if they don't exist
-		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		if err = os.MkdirAll(filepath.Dir(path), fh.config.ExtractDirMode); err != nil {
 			log.Error().
 				Str("request_id", requestID).
 				Str("path", filepath.Dir(path)).
@@ -167,17 +600,6 @@ func (fh *FileHandler) ExtractZip(ctx context.Context, zipPath, tempDir string)
 			return "", err
 		}
 
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			log.Error().
-				Str("request_id", requestID).
-				Str("path", path).
-				Err(err).
-				Msg("Failed to create file")
-			return "", err
-		}
-		openFiles = append(openFiles, outFile)
-
 		zipFile, err := file.Open()
 		if err != nil {
 			log.Error().
@@ -188,38 +610,138 @@ func (fh *FileHandler) ExtractZip(ctx context.Context, zipPath, tempDir string)
 			return "", err
 		}
 
-		_, err = io.Copy(outFile, zipFile)
+		written, err := writeFile(path, fh.fileMode(file.Mode()), zipFile, fh.config.MaxExtractedSize-totalSize)
 		zipFile.Close()
+		totalSize += written
+		if errors.Is(err, ErrArchiveTooLarge) {
+			err = fh.extractLimitError()
+		}
 		if err != nil {
 			log.Error().
 				Str("request_id", requestID).
 				Str("path", path).
+				Int64("total_size", totalSize).
 				Err(err).
 				Msg("Failed to extract file")
 			return "", err
 		}
-		
-		outFile.Close()
-		// Remove the file from the tracking slice
-		for i, f := range openFiles {
-			if f == outFile {
-				openFiles = append(openFiles[:i], openFiles[i+1:]...)
-				break
-			}
-		}
 	}
 
 	log.Debug().
 		Str("request_id", requestID).
 		Str("path", extractDir).
+		Int64("size", totalSize).
 		Msg("Zip file extracted")
 		
 	return extractDir, nil
 }
 
-// DetectHandlerFile detects the handler file and language in the extracted directory
-func (fh *FileHandler) DetectHandlerFile(ctx context.Context, dir string) (string, string, error) {
-	requestID, _ := ctx.Value("requestID").(string)
+// LoadManifest reads the optional serverless.json manifest from the extracted
+// directory. An empty manifest is returned when the file doesn't exist.
+func (fh *FileHandler) LoadManifest(ctx context.Context, dir string) (*models.Manifest, error) {
+	requestID := requestctx.ID(ctx)
+	manifestPath := filepath.Join(dir, "serverless.json")
+
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return &models.Manifest{}, nil
+	}
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("path", manifestPath).
+			Err(err).
+			Msg("Failed to read manifest")
+		return nil, err
+	}
+
+	var manifest models.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("path", manifestPath).
+			Err(err).
+			Msg("Invalid manifest")
+		return nil, fmt.Errorf("invalid serverless.json: %v", err)
+	}
+
+	switch manifest.InputMode {
+	case "", models.InputModeEnv, models.InputModeStdin:
+	default:
+		return nil, fmt.Errorf("invalid input mode in serverless.json: %q (expected %q or %q)",
+			manifest.InputMode, models.InputModeEnv, models.InputModeStdin)
+	}
+
+	if err := schema.Check(manifest.Schema); err != nil {
+		return nil, fmt.Errorf("invalid schema in serverless.json: %v", err)
+	}
+
+	if manifest.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(manifest.ContentType); err != nil {
+			return nil, fmt.Errorf("invalid content type in serverless.json: %q", manifest.ContentType)
+		}
+	}
+
+	if manifest.OutputFormat != "" && manifest.OutputFormat != models.OutputFormatJSON {
+		return nil, fmt.Errorf("invalid output format in serverless.json: %q (expected %q)", manifest.OutputFormat, models.OutputFormatJSON)
+	}
+
+	return &manifest, nil
+}
+
+// DetectHandlerFile detects the handler file and language in the extracted
+// directory. When custom Dockerfiles are enabled, a Dockerfile at the root
+// makes the function's language config.CustomLanguage and is its handler.
+// Otherwise an explicit handler takes precedence, then the manifest, then a
+// Go module (go.mod), then the only .py, .go or .rb file or, when there are
+// several, the first with a conventional name such as main.py. For Go modules
+// the handler is the main package path. Languages that are not enabled in the
+// configuration are rejected.
+func (fh *FileHandler) DetectHandlerFile(ctx context.Context, dir string, manifest *models.Manifest, handler string) (string, string, error) {
+	requestID := requestctx.ID(ctx)
+
+	if fh.config.CustomDockerfiles {
+		if info, err := os.Lstat(filepath.Join(dir, "Dockerfile")); err == nil && info.Mode().IsRegular() {
+			log.Info().
+				Str("request_id", requestID).
+				Str("language", config.CustomLanguage).
+				Msg("Custom Dockerfile detected")
+			return "Dockerfile", config.CustomLanguage, nil
+		}
+	}
+
+	var handlerFile, language string
+	var err error
+	if handler != "" {
+		handlerFile, language, err = explicitHandler(dir, handler)
+		if err == nil {
+			log.Info().
+				Str("request_id", requestID).
+				Str("handler", handlerFile).
+				Str("language", language).
+				Msg("Handler specified explicitly")
+		}
+	} else {
+		handlerFile, language, err = fh.detectHandlerFile(ctx, dir, manifest)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if !fh.config.Languages.Allows(language) {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("language", language).
+			Msg("Language not enabled")
+		return "", "", fmt.Errorf("%w: %s", ErrLanguageNotEnabled, language)
+	}
+
+	return handlerFile, language, nil
+}
+
+// detectHandlerFile finds the handler file and language for DetectHandlerFile
+func (fh *FileHandler) detectHandlerFile(ctx context.Context, dir string, manifest *models.Manifest) (string, string, error) {
+	requestID := requestctx.ID(ctx)
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		log.Error().
@@ -230,67 +752,291 @@ func (fh *FileHandler) DetectHandlerFile(ctx context.Context, dir string) (strin
 		return "", "", err
 	}
 
-	// First look for a manifest file that specifies the handler
-	for _, file := range files {
-		if file.Name() == "serverless.json" {
-			manifestPath := filepath.Join(dir, file.Name())
-			data, err := os.ReadFile(manifestPath)
-			if err == nil {
-				var manifest struct {
-					Handler   string `json:"handler"`
-					Language  string `json:"language"`
-				}
-				if err := json.Unmarshal(data, &manifest); err == nil {
-					if manifest.Handler != "" && manifest.Language != "" {
-						// Verify the handler file exists
-						handlerPath := filepath.Join(dir, manifest.Handler)
-						if _, err := os.Stat(handlerPath); err == nil {
-							log.Info().
-								Str("request_id", requestID).
-								Str("handler", manifest.Handler).
-								Str("language", manifest.Language).
-								Msg("Handler detected from manifest")
-							return manifest.Handler, manifest.Language, nil
-						}
-					}
-				}
-			}
+	// First use the manifest if it specifies the handler
+	if manifest != nil && manifest.Handler != "" && manifest.Language != "" {
+		// Verify the handler file exists
+		handlerPath := filepath.Join(dir, manifest.Handler)
+		if _, err := os.Stat(handlerPath); err == nil {
+			log.Info().
+				Str("request_id", requestID).
+				Str("handler", manifest.Handler).
+				Str("language", manifest.Language).
+				Msg("Handler detected from manifest")
+			return manifest.Handler, manifest.Language, nil
+		}
+	}
+
+	// Next, a go.mod at the root means the whole directory is a Go module;
+	// the handler is the path of its main package
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		mainPackage, err := findMainPackage(dir)
+		if err != nil {
+			log.Error().
+				Str("request_id", requestID).
+				Str("dir", dir).
+				Err(err).
+				Msg("Failed to find main package in Go module")
+			return "", "", err
 		}
+		log.Info().
+			Str("request_id", requestID).
+			Str("handler", mainPackage).
+			Str("language", "golang").
+			Msg("Go module detected")
+		return mainPackage, "golang", nil
 	}
 
-	// If no manifest or invalid manifest, try to detect automatically
+	// If no manifest or invalid manifest, try to detect automatically.
+	// os.ReadDir sorts entries by name, so the result doesn't depend on the
+	// order files were archived in.
+	var candidates []string
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
-
-		switch filepath.Ext(file.Name()) {
-		case ".py":
-			log.Info().
-				Str("request_id", requestID).
-				Str("handler", file.Name()).
-				Str("language", "python").
-				Msg("Python handler detected")
-			return file.Name(), "python", nil
-		case ".go":
-			log.Info().
-				Str("request_id", requestID).
-				Str("handler", file.Name()).
-				Str("language", "golang").
-				Msg("Go handler detected")
-			return file.Name(), "golang", nil
+		if _, ok := handlerLanguages[filepath.Ext(file.Name())]; ok {
+			candidates = append(candidates, file.Name())
 		}
 	}
 
-	log.Warn().
+	handlerFile, err := chooseHandler(candidates)
+	if err != nil {
+		log.Warn().
+			Str("request_id", requestID).
+			Str("dir", dir).
+			Strs("candidates", candidates).
+			Err(err).
+			Msg("No valid handler file found")
+		return "", "", err
+	}
+
+	language := handlerLanguages[filepath.Ext(handlerFile)]
+	log.Info().
 		Str("request_id", requestID).
-		Str("dir", dir).
-		Msg("No valid handler file found")
-	return "", "", fmt.Errorf("no valid handler file found (expected .py or .go)")
+		Str("handler", handlerFile).
+		Str("language", language).
+		Msg("Handler detected")
+	return handlerFile, language, nil
+}
+
+// chooseHandler picks the handler among candidate files: the only one, or the
+// first with a conventional name
+func chooseHandler(candidates []string) (string, error) {
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no valid handler file found (expected .py, .go or .rb)")
+	case 1:
+		return candidates[0], nil
+	}
+
+	for _, name := range conventionalHandlers {
+		for _, candidate := range candidates {
+			if candidate == name {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: found %s; name one main or handler, or set the handler form field",
+		ErrAmbiguousHandler, strings.Join(candidates, ", "))
+}
+
+// explicitHandler validates a handler named on submission and returns it with
+// its language. It must be a .py, .go or .rb file in the upload or, in a Go
+// module, a main package directory.
+func explicitHandler(dir, handler string) (string, string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(handler))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("handler %q is outside the upload", handler)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, cleaned))
+	if err != nil {
+		return "", "", fmt.Errorf("handler %q not found in the upload", handler)
+	}
+
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+			return "", "", fmt.Errorf("handler %q is a directory, which is only allowed in a Go module", handler)
+		}
+		if cleaned == "." {
+			return ".", "golang", nil
+		}
+		return "./" + filepath.ToSlash(cleaned), "golang", nil
+	}
+
+	language, ok := handlerLanguages[filepath.Ext(cleaned)]
+	if !ok {
+		return "", "", fmt.Errorf("handler %q is not a .py, .go or .rb file", handler)
+	}
+	return filepath.ToSlash(cleaned), language, nil
+}
+
+// HashDirectory computes a SHA-256 over the relative paths, executable bits
+// and contents of every regular file in dir. It depends only on the extracted
+// code, so the same files hash the same regardless of archive format or
+// timestamps.
+func (fh *FileHandler) HashDirectory(ctx context.Context, dir string) (string, error) {
+	requestID := requestctx.ID(ctx)
+
+	hash := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		fmt.Fprintf(hash, "%s\x00%t\x00%d\x00", filepath.ToSlash(relPath), info.Mode()&0111 != 0, info.Size())
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		log.Error().
+			Str("request_id", requestID).
+			Str("dir", dir).
+			Err(err).
+			Msg("Failed to hash directory")
+		return "", fmt.Errorf("failed to hash directory: %v", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ArchiveDirectory writes every regular file in dir to a new zip archive at
+// dest, keeping relative paths and permissions. A partial archive is removed
+// if writing fails.
+func (fh *FileHandler) ArchiveDirectory(ctx context.Context, dir, dest string) (err error) {
+	requestID := requestctx.ID(ctx)
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dest)
+			log.Error().
+				Str("request_id", requestID).
+				Str("dir", dir).
+				Str("dest", dest).
+				Err(err).
+				Msg("Failed to archive directory")
+		}
+	}()
+
+	archive := zip.NewWriter(out)
+	err = filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		header.Method = zip.Deflate
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(writer, file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive directory: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to archive directory: %v", err)
+	}
+	return nil
 }
 
 // Helper functions
 
+// findMainPackage returns the path, relative to dir, of the first directory
+// holding a main package, preferring dir itself
+func findMainPackage(dir string) (string, error) {
+	var mainPackage string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// Skip vendored and hidden directories
+			if path != dir && (entry.Name() == "vendor" || strings.HasPrefix(entry.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".go" || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly)
+		if err != nil || file.Name.Name != "main" {
+			return nil
+		}
+
+		relDir, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if relDir == "." {
+			mainPackage = "."
+			return filepath.SkipAll
+		}
+		if mainPackage == "" {
+			mainPackage = "./" + filepath.ToSlash(relDir)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if mainPackage == "" {
+		return "", fmt.Errorf("no main package found in Go module")
+	}
+	return mainPackage, nil
+}
+
 // validateZipPath prevents zip slip vulnerability by validating file paths
 func validateZipPath(destDir, filePath string) (string, error) {
 	destPath := filepath.Join(destDir, filePath)
@@ -303,6 +1049,29 @@ func validateZipPath(destDir, filePath string) (string, error) {
 	return destPath, nil
 }
 
+// removePartialFile closes and deletes a file that was only partly written,
+// rather than leaving it until its temp directory is cleaned up
+func removePartialFile(file *os.File) {
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		log.Warn().
+			Str("path", file.Name()).
+			Err(err).
+			Msg("Failed to remove partial file")
+	}
+}
+
+// SaveInputFile saves a file uploaded with an execution to the temporary
+// directory so it can be mounted into the function's container. The name is
+// reduced to a plain file name, so it can't point outside the directory.
+func (fh *FileHandler) SaveInputFile(ctx context.Context, tempDir, filename string, file io.Reader) (string, error) {
+	name := sanitizeFilename(filename)
+	if name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid input file name: %q", filename)
+	}
+	return fh.SaveZipFile(ctx, tempDir, name, file)
+}
+
 // sanitizeFilename removes potentially dangerous characters from filenames
 func sanitizeFilename(filename string) string {
 	// Keep only the base filename, not any directory path
@@ -336,17 +1105,61 @@ func RespondWithJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	}
 }
 
-// RespondWithError sends an error response with the given status code
+// RespondWithError sends an error response with the given status code. When
+// w is or wraps a writer from NewErrorWriter, the response carries the
+// request ID and is plain text if the client prefers it to JSON.
 func RespondWithError(w http.ResponseWriter, statusCode int, message string, details string) {
-	errorResponse := struct {
-		Error   string `json:"error"`
-		Code    int    `json:"code"`
-		Details string `json:"details,omitempty"`
-	}{
+	errorResponse := models.ErrorResponse{
 		Error:   message,
 		Code:    statusCode,
 		Details: details,
 	}
 	
+	if ew := findErrorWriter(w); ew != nil {
+		errorResponse.RequestID = ew.Header().Get(requestctx.Header)
+		if ew.plainText {
+			respondWithPlainTextError(w, errorResponse)
+			return
+		}
+	}
+	
 	RespondWithJSON(w, statusCode, errorResponse)
 }
+
+// RespondWithUploadError responds to a multipart upload whose body could not
+// be read: 413 giving the size limits if it was larger than the limit,
+// otherwise 400 with the given message. fileLimit is the maximum file size.
+func RespondWithUploadError(w http.ResponseWriter, message string, err error, fileLimit int64) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		RespondWithError(w, http.StatusRequestEntityTooLarge, "Upload too large", UploadLimitDetails(tooLarge.Limit, fileLimit))
+		return
+	}
+	RespondWithError(w, http.StatusBadRequest, message, err.Error())
+}
+
+// UploadLimitDetails describes the size limits of a multipart upload whose
+// body may be up to bodyLimit bytes, holding files of up to fileLimit bytes
+func UploadLimitDetails(bodyLimit, fileLimit int64) string {
+	return fmt.Sprintf("Uploads must not exceed %s in total, and the uploaded file %s (MAX_FILE_SIZE)", FormatMB(bodyLimit), FormatMB(fileLimit))
+}
+
+// FormatMB formats a size in bytes as megabytes, such as "10 MB" or "2.5 MB".
+// Sizes too small to show as megabytes are given in bytes.
+func FormatMB(size int64) string {
+	if size < 1<<20/100 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	return strconv.FormatFloat(math.Round(float64(size)/(1<<20)*100)/100, 'f', -1, 64) + " MB"
+}
+
+// RespondWithBodyError responds to a request whose body could not be read:
+// 413 if it was larger than the limit, otherwise 400 with the given message
+func RespondWithBodyError(w http.ResponseWriter, message string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		RespondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large", fmt.Sprintf("The request body must not exceed %d bytes", tooLarge.Limit))
+		return
+	}
+	RespondWithError(w, http.StatusBadRequest, message, err.Error())
+}
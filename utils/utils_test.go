@@ -0,0 +1,458 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"youtube_serverless/config"
+	"youtube_serverless/models"
+)
+
+// archiveEntry is a file or directory written to a test archive
+type archiveEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+// newTestFileHandler creates a FileHandler with the default configuration,
+// after configure has changed it
+func newTestFileHandler(t *testing.T, configure func(*config.FileOpsConfig)) *FileHandler {
+	t.Helper()
+
+	cfg := config.LoadConfig().FileOps
+	cfg.TempDirBase = t.TempDir()
+	if configure != nil {
+		configure(&cfg)
+	}
+	return NewFileHandler(&cfg)
+}
+
+// writeZip writes the entries as a zip archive in dir and returns its path
+func writeZip(t *testing.T, dir string, entries []archiveEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		f, err := archive.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", entry.name, err)
+		}
+		if _, err := f.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("failed to write %s: %v", entry.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	path := filepath.Join(dir, "code.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	return path
+}
+
+// writeTarGz writes the entries as a tar.gz archive in dir and returns its
+// path. Entries with a name ending in / are directories.
+func writeTarGz(t *testing.T, dir string, entries []archiveEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		if strings.HasSuffix(entry.name, "/") {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+			header.Size = 0
+		}
+		if entry.mode != 0 {
+			header.Mode = int64(entry.mode.Perm())
+			// tar stores the special bits as in the C headers
+			if entry.mode&os.ModeSetuid != 0 {
+				header.Mode |= 04000
+			}
+			if entry.mode&os.ModeSetgid != 0 {
+				header.Mode |= 02000
+			}
+			if entry.mode&os.ModeSticky != 0 {
+				header.Mode |= 01000
+			}
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatalf("failed to add %s: %v", entry.name, err)
+		}
+		if _, err := archive.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("failed to write %s: %v", entry.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+
+	path := filepath.Join(dir, "code.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write tar.gz: %v", err)
+	}
+	return path
+}
+
+// archiveWriters write test archives of each supported format
+var archiveWriters = []struct {
+	name  string
+	write func(*testing.T, string, []archiveEntry) string
+}{
+	{name: "zip", write: writeZip},
+	{name: "tar.gz", write: writeTarGz},
+}
+
+// numberedFiles returns n small files
+func numberedFiles(n int) []archiveEntry {
+	entries := make([]archiveEntry, n)
+	for i := range entries {
+		entries[i] = archiveEntry{name: fmt.Sprintf("file%d.txt", i), content: "x"}
+	}
+	return entries
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	const maxEntries = 5
+	const maxSize = 64 << 10
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		err     error
+	}{
+		{name: "entries at the limit", entries: numberedFiles(maxEntries)},
+		{name: "entries just over the limit", entries: numberedFiles(maxEntries + 1), err: ErrTooManyArchiveFiles},
+		{
+			// A highly compressible file expands far beyond its compressed size
+			name:    "size at the limit",
+			entries: []archiveEntry{{name: "bomb.txt", content: strings.Repeat("0", maxSize)}},
+		},
+		{
+			name:    "size just over the limit",
+			entries: []archiveEntry{{name: "bomb.txt", content: strings.Repeat("0", maxSize+1)}},
+			err:     ErrArchiveTooLarge,
+		},
+		{
+			name: "size over the limit across files",
+			entries: []archiveEntry{
+				{name: "a.txt", content: strings.Repeat("0", maxSize/2)},
+				{name: "b.txt", content: strings.Repeat("0", maxSize/2+1)},
+			},
+			err: ErrArchiveTooLarge,
+		},
+	}
+
+	for _, format := range archiveWriters {
+		for _, tt := range tests {
+			t.Run(format.name+"/"+tt.name, func(t *testing.T) {
+				fh := newTestFileHandler(t, func(cfg *config.FileOpsConfig) {
+					cfg.MaxArchiveEntries = maxEntries
+					cfg.MaxExtractedSize = maxSize
+				})
+				tempDir := t.TempDir()
+				archivePath := format.write(t, tempDir, tt.entries)
+
+				extractDir, err := fh.ExtractArchive(context.Background(), archivePath, tempDir)
+				if tt.err == nil {
+					if err != nil {
+						t.Fatalf("ExtractArchive: %v", err)
+					}
+					if _, err := os.Stat(filepath.Join(extractDir, tt.entries[0].name)); err != nil {
+						t.Errorf("extracted file missing: %v", err)
+					}
+					return
+				}
+
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("ExtractArchive error = %v, want %v", err, tt.err)
+				}
+				// Nothing of a rejected archive is left on disk
+				if _, err := os.Stat(filepath.Join(tempDir, "extracted")); !os.IsNotExist(err) {
+					t.Errorf("partial extraction left behind: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveSkipsPathsOutsideTheDirectory(t *testing.T) {
+	for _, format := range archiveWriters {
+		t.Run(format.name, func(t *testing.T) {
+			fh := newTestFileHandler(t, nil)
+			tempDir := t.TempDir()
+			archivePath := format.write(t, tempDir, []archiveEntry{
+				{name: "main.py", content: "print('hello')"},
+				{name: "../escaped.txt", content: "outside"},
+				{name: "nested/../../escaped-nested.txt", content: "outside"},
+				{name: "nested/../inside.txt", content: "inside"},
+			})
+
+			extractDir, err := fh.ExtractArchive(context.Background(), archivePath, tempDir)
+			if err != nil {
+				t.Fatalf("ExtractArchive: %v", err)
+			}
+
+			for _, name := range []string{"escaped.txt", "escaped-nested.txt"} {
+				if _, err := os.Stat(filepath.Join(tempDir, name)); !os.IsNotExist(err) {
+					t.Errorf("%s was written outside the extraction directory", name)
+				}
+			}
+			for _, name := range []string{"main.py", "inside.txt"} {
+				if _, err := os.Stat(filepath.Join(extractDir, name)); err != nil {
+					t.Errorf("%s was not extracted: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateZipPath(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "extracted")
+
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{path: "main.py", valid: true},
+		{path: "src/lib/util.py", valid: true},
+		{path: "src/../main.py", valid: true},
+		{path: "../main.py"},
+		{path: "src/../../main.py"},
+		{path: "../extracted-sibling/main.py"},
+		{path: "."},
+	}
+
+	for _, tt := range tests {
+		path, err := validateZipPath(dest, tt.path)
+		if tt.valid && err != nil {
+			t.Errorf("validateZipPath(%q): %v", tt.path, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("validateZipPath(%q) = %q, want an error", tt.path, path)
+		}
+	}
+}
+
+func TestExtractArchiveMasksPermissions(t *testing.T) {
+	// Set the umask out of the way, so the modes on disk are the extractor's
+	umask := syscall.Umask(0)
+	t.Cleanup(func() { syscall.Umask(umask) })
+
+	tests := []struct {
+		name     string
+		dirMode  os.FileMode
+		fileMode os.FileMode
+		entry    archiveEntry
+		want     os.FileMode
+	}{
+		{
+			name:  "setuid executable",
+			entry: archiveEntry{name: "run.sh", content: "#!/bin/sh", mode: os.ModeSetuid | 0777},
+			want:  0755,
+		},
+		{
+			name:  "setgid and sticky file",
+			entry: archiveEntry{name: "data.txt", content: "data", mode: os.ModeSetgid | os.ModeSticky | 0666},
+			want:  0644,
+		},
+		{
+			name:  "world-writable directory",
+			entry: archiveEntry{name: "lib/", mode: os.ModeDir | 0777},
+			want:  0755,
+		},
+		{
+			name:  "owner-only directory keeps owner access",
+			entry: archiveEntry{name: "private/", mode: os.ModeDir | 0500},
+			want:  0700,
+		},
+		{
+			name:  "modes below the maximum are kept",
+			entry: archiveEntry{name: "secret.txt", content: "data", mode: 0600},
+			want:  0600,
+		},
+		{
+			name:     "configured maximum for files",
+			fileMode: 0640,
+			entry:    archiveEntry{name: "data.txt", content: "data", mode: 0666},
+			want:     0640,
+		},
+		{
+			name:    "configured maximum for executables",
+			dirMode: 0750,
+			entry:   archiveEntry{name: "run.sh", content: "#!/bin/sh", mode: os.ModeSetuid | 0777},
+			want:    0750,
+		},
+		{
+			name:    "configured maximum for directories",
+			dirMode: 0700,
+			entry:   archiveEntry{name: "lib/", mode: os.ModeDir | 0777},
+			want:    0700,
+		},
+	}
+
+	for _, format := range archiveWriters {
+		for _, tt := range tests {
+			t.Run(format.name+"/"+tt.name, func(t *testing.T) {
+				fh := newTestFileHandler(t, func(cfg *config.FileOpsConfig) {
+					if tt.dirMode != 0 {
+						cfg.ExtractDirMode = tt.dirMode
+					}
+					if tt.fileMode != 0 {
+						cfg.ExtractFileMode = tt.fileMode
+					}
+				})
+				tempDir := t.TempDir()
+				archivePath := format.write(t, tempDir, []archiveEntry{tt.entry})
+
+				extractDir, err := fh.ExtractArchive(context.Background(), archivePath, tempDir)
+				if err != nil {
+					t.Fatalf("ExtractArchive: %v", err)
+				}
+				info, err := os.Stat(filepath.Join(extractDir, tt.entry.name))
+				if err != nil {
+					t.Fatalf("extracted entry missing: %v", err)
+				}
+
+				if special := info.Mode() & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky); special != 0 {
+					t.Errorf("mode %v keeps %v", info.Mode(), special)
+				}
+				if info.Mode().Perm() != tt.want {
+					t.Errorf("mode = %#o, want %#o", info.Mode().Perm(), tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDetectHandlerFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		manifest *models.Manifest
+		handler  string
+		want     string
+		language string
+		err      error
+		invalid  bool
+	}{
+		{name: "only candidate", files: []string{"app.py", "README.md"}, want: "app.py", language: "python"},
+		{name: "conventional name among several", files: []string{"helpers.py", "main.py", "util.py"}, want: "main.py", language: "python"},
+		{name: "main before handler", files: []string{"handler.py", "main.py"}, want: "main.py", language: "python"},
+		{name: "python conventions before go", files: []string{"handler.py", "main.go"}, want: "handler.py", language: "python"},
+		{name: "several without a conventional name", files: []string{"a.py", "b.py"}, err: ErrAmbiguousHandler},
+		{name: "several languages without a conventional name", files: []string{"app.py", "app.rb"}, err: ErrAmbiguousHandler},
+		{name: "no candidates", files: []string{"README.md"}, invalid: true},
+		{name: "override resolves ambiguity", files: []string{"a.py", "b.py"}, handler: "b.py", want: "b.py", language: "python"},
+		{name: "override beats a conventional name", files: []string{"main.py", "job.rb"}, handler: "job.rb", want: "job.rb", language: "ruby"},
+		{name: "override in a subdirectory", files: []string{"main.py", "jobs/run.py"}, handler: "jobs/run.py", want: "jobs/run.py", language: "python"},
+		{name: "override beats the manifest", files: []string{"a.py", "b.py"}, manifest: &models.Manifest{Handler: "a.py", Language: "python"}, handler: "b.py", want: "b.py", language: "python"},
+		{name: "override not in the upload", files: []string{"main.py"}, handler: "missing.py", invalid: true},
+		{name: "override outside the upload", files: []string{"main.py"}, handler: "../main.py", invalid: true},
+		{name: "override with an unsupported extension", files: []string{"main.py", "README.md"}, handler: "README.md", invalid: true},
+		{name: "manifest resolves ambiguity", files: []string{"a.py", "b.py"}, manifest: &models.Manifest{Handler: "b.py", Language: "python"}, want: "b.py", language: "python"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh := newTestFileHandler(t, nil)
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(path, []byte("code"), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			handlerFile, language, err := fh.DetectHandlerFile(context.Background(), dir, tt.manifest, tt.handler)
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("DetectHandlerFile error = %v, want %v", err, tt.err)
+				}
+			case tt.invalid:
+				if err == nil {
+					t.Errorf("DetectHandlerFile = %q, want an error", handlerFile)
+				}
+			case err != nil:
+				t.Errorf("DetectHandlerFile: %v", err)
+			case handlerFile != tt.want || language != tt.language:
+				t.Errorf("DetectHandlerFile = %q, %q; want %q, %q", handlerFile, language, tt.want, tt.language)
+			}
+		})
+	}
+}
+
+func TestSaveZipFileSizeLimit(t *testing.T) {
+	const maxFileSize = 1 << 10
+	// A zip's first bytes, so the upload sniffs as an archive
+	zipMagic := "PK\x03\x04"
+
+	tests := []struct {
+		name    string
+		content string
+		err     error
+	}{
+		{name: "just under the limit", content: zipMagic + strings.Repeat("x", maxFileSize-len(zipMagic)-1)},
+		{name: "at the limit", content: zipMagic + strings.Repeat("x", maxFileSize-len(zipMagic))},
+		{name: "just over the limit", content: zipMagic + strings.Repeat("x", maxFileSize-len(zipMagic)+1), err: ErrFileTooLarge},
+		{name: "not an archive", content: "hello", err: ErrUnsupportedArchive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh := newTestFileHandler(t, func(cfg *config.FileOpsConfig) {
+				cfg.MaxFileSize = maxFileSize
+			})
+			tempDir := t.TempDir()
+
+			path, err := fh.SaveZipFile(context.Background(), tempDir, "code.zip", strings.NewReader(tt.content))
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("SaveZipFile: %v", err)
+				}
+				if info, err := os.Stat(path); err != nil || info.Size() != int64(len(tt.content)) {
+					t.Errorf("saved file = %v, %v; want %d bytes", info, err, len(tt.content))
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("SaveZipFile error = %v, want %v", err, tt.err)
+			}
+			if errors.Is(err, ErrFileTooLarge) && !strings.Contains(err.Error(), FormatMB(maxFileSize)) {
+				t.Errorf("error %q doesn't give the limit", err)
+			}
+			// Nothing of a rejected upload is left behind
+			if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+				t.Errorf("left %d files behind", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package version holds build metadata, set at link time with
+//
+//	go build -ldflags "-X youtube_serverless/version.Version=v1.2.3 \
+//		-X youtube_serverless/version.Commit=abc1234 \
+//		-X youtube_serverless/version.Date=2024-01-01T00:00:00Z"
+package version
+
+import "time"
+
+// Build metadata; the defaults identify a build made without ldflags
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// startTime is when the process started, for reporting uptime
+var startTime = time.Now()
+
+// Uptime returns how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(startTime)
+}
@@ -0,0 +1,152 @@
+// Package webhook delivers signed callbacks to URLs given by clients when
+// their asynchronous executions complete
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"youtube_serverless/config"
+	"youtube_serverless/netsafe"
+	"youtube_serverless/requestctx"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the callback body, keyed with
+// the configured secret, as "sha256=<hex>"
+const SignatureHeader = "X-Serverless-Signature"
+
+// ErrInvalidURL is returned for callback URLs that are malformed or point at
+// an address callbacks may not be sent to
+var ErrInvalidURL = errors.New("invalid callback URL")
+
+// ErrDisabled is returned when a callback is requested but no signing secret
+// is configured
+var ErrDisabled = errors.New("callbacks are disabled")
+
+// Notifier POSTs JSON payloads to callback URLs, signing each one and
+// retrying failed deliveries
+type Notifier struct {
+	config *config.CallbackConfig
+	guard  *netsafe.Guard
+	client *http.Client
+}
+
+// NewNotifier creates a Notifier whose client only connects to addresses
+// its guard allows: public addresses and the allowed networks, or any
+// address when private addresses are allowed.
+func NewNotifier(cfg *config.CallbackConfig, allowed []string) (*Notifier, error) {
+	guard, err := netsafe.NewGuard(allowed, cfg.AllowPrivate)
+	if err != nil {
+		return nil, err
+	}
+
+	client := guard.Client(cfg.Timeout)
+	// A redirect could point anywhere, so don't follow them
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Notifier{config: cfg, guard: guard, client: client}, nil
+}
+
+// Validate checks that callbacks are enabled and that rawURL is an http or
+// https URL whose host resolves only to addresses callbacks may be sent to
+func (n *Notifier) Validate(ctx context.Context, rawURL string) error {
+	if n.config.Secret == "" {
+		return ErrDisabled
+	}
+	if err := n.guard.CheckURL(ctx, rawURL); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	return nil
+}
+
+// Notify POSTs payload as JSON to rawURL, retrying with exponential backoff
+// after network errors, 429 and 5xx responses. Other responses end delivery.
+func (n *Notifier) Notify(ctx context.Context, rawURL string, payload interface{}) error {
+	requestID := requestctx.ID(ctx)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode callback: %v", err)
+	}
+	signature := Sign(n.config.Secret, body)
+
+	backoff := n.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.deliver(ctx, rawURL, body, signature)
+		if err == nil {
+			log.Info().
+				Str("request_id", requestID).
+				Int("attempt", attempt+1).
+				Msg("Callback delivered")
+			return nil
+		}
+		if !retry || attempt >= n.config.MaxRetries {
+			log.Error().
+				Str("request_id", requestID).
+				Int("attempts", attempt+1).
+				Err(err).
+				Msg("Callback delivery failed")
+			return err
+		}
+
+		log.Warn().
+			Str("request_id", requestID).
+			Int("attempt", attempt+1).
+			Dur("backoff", backoff).
+			Err(err).
+			Msg("Callback delivery failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// deliver makes one delivery attempt and reports whether a failure is worth
+// retrying
+func (n *Notifier) deliver(ctx context.Context, rawURL string, body []byte, signature string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create callback request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Blocked addresses won't become allowed on a retry
+		return !errors.Is(err, netsafe.ErrBlocked), fmt.Errorf("failed to send callback: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return true, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("callback returned status %d", resp.StatusCode)
+}
+
+// Sign returns the value of SignatureHeader for body: the hex HMAC-SHA256 of
+// the body keyed with secret, prefixed with "sha256="
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}